@@ -0,0 +1,430 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	// defaultAdminPageSize размер страницы списка пользователей по умолчанию
+	defaultAdminPageSize = 20
+	// maxAdminPageSize максимальный размер страницы
+	maxAdminPageSize = 100
+)
+
+// AdminUsersHandler маршрутизирует запросы /admin/users/{id}/...
+// Каждый маршрут требует своего разрешения
+func (s *Server) AdminUsersHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/users/"), "/"), "/")
+	userID, err := parsePositiveInt(parts[0])
+	if err != nil {
+		sendErrorResponse(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	// GET /admin/users/{id}, DELETE /admin/users/{id}
+	case len(parts) == 1:
+		switch r.Method {
+		case http.MethodGet:
+			RequirePermission(permUsersRead)(func(w http.ResponseWriter, r *http.Request) {
+				s.adminGetUserHandler(w, r, userID)
+			})(w, r)
+		case http.MethodDelete:
+			RequirePermission(permUsersWrite)(func(w http.ResponseWriter, r *http.Request) {
+				s.adminDeleteUserHandler(w, r, userID)
+			})(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+
+	// POST /admin/users/{id}/lock, /unlock, /password-reset
+	case len(parts) == 2 && (parts[1] == "lock" || parts[1] == "unlock" || parts[1] == "password-reset"):
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		action := parts[1]
+		RequirePermission(permUsersWrite)(func(w http.ResponseWriter, r *http.Request) {
+			switch action {
+			case "lock":
+				s.adminLockUserHandler(w, r, userID)
+			case "unlock":
+				s.adminUnlockUserHandler(w, r, userID)
+			default:
+				s.adminForcePasswordResetHandler(w, r, userID)
+			}
+		})(w, r)
+
+	// POST /admin/users/{id}/roles
+	case len(parts) == 2 && parts[1] == "roles":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		RequirePermission(permRolesManage)(func(w http.ResponseWriter, r *http.Request) {
+			s.grantRoleHandler(w, r, userID)
+		})(w, r)
+
+	// DELETE /admin/users/{id}/roles/{role}
+	case len(parts) == 3 && parts[1] == "roles":
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		RequirePermission(permRolesManage)(func(w http.ResponseWriter, r *http.Request) {
+			s.revokeRoleHandler(w, r, userID, parts[2])
+		})(w, r)
+
+	default:
+		sendErrorResponse(w, "Not found", http.StatusNotFound)
+	}
+}
+
+// AdminListUsersHandler возвращает страницу пользователей (GET /admin/users).
+// Параметры: email и username (поиск по началу строки), limit и offset
+func (s *Server) AdminListUsersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 1. Разбираем параметры
+	query := r.URL.Query()
+	filter := UserFilter{
+		EmailPrefix:    strings.TrimSpace(query.Get("email")),
+		UsernamePrefix: strings.TrimSpace(query.Get("username")),
+	}
+	limit, offset, err := parsePagination(query)
+	if err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.Limit, filter.Offset = limit, offset
+
+	// 2. Загружаем страницу
+	users, total, err := s.users.ListUsers(r.Context(), filter)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"users":  users,
+		"total":  total,
+		"limit":  filter.Limit,
+		"offset": filter.Offset,
+	}
+	sendJSONResponse(w, response, http.StatusOK)
+}
+
+// parsePagination разбирает параметры limit и offset списков администратора
+func parsePagination(query url.Values) (int, int, error) {
+	limit, offset := defaultAdminPageSize, 0
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxAdminPageSize {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", maxAdminPageSize)
+		}
+		limit = n
+	}
+	if v := query.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, 0, errors.New("offset must be a non-negative integer")
+		}
+		offset = n
+	}
+	return limit, offset, nil
+}
+
+// parsePositiveInt разбирает положительное целое (например, ID из пути или параметра)
+func parsePositiveInt(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	if n <= 0 {
+		return 0, errors.New("must be positive")
+	}
+	return n, nil
+}
+
+// adminGetUserHandler возвращает данные пользователя вместе с его ролями
+func (s *Server) adminGetUserHandler(w http.ResponseWriter, r *http.Request, userID int) {
+	user, ok := s.loadUserForAdmin(w, r, userID)
+	if !ok {
+		return
+	}
+
+	roles, err := s.roles.GetUserRoles(r.Context(), userID)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	user.Roles = roles
+
+	sendJSONResponse(w, user, http.StatusOK)
+}
+
+// adminLockUserHandler блокирует аккаунт и завершает все его сессии
+func (s *Server) adminLockUserHandler(w http.ResponseWriter, r *http.Request, userID int) {
+	// 1. Причина блокировки необязательна
+	var req LockUserRequest
+	if r.ContentLength != 0 {
+		if err := parseJSONRequest(r, &req); err != nil {
+			sendErrorResponse(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+	}
+	if adminID, _ := GetUserIDFromContext(r); adminID == userID {
+		sendErrorResponse(w, "Cannot lock your own account", http.StatusBadRequest)
+		return
+	}
+
+	// 2. Блокируем
+	err := s.users.SetUserLocked(r.Context(), userID, true)
+	if errors.Is(err, ErrNotFound) {
+		sendErrorResponse(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+
+	// 3. Выданные токены перестают действовать сразу
+	if err := s.revokeAllUserSessions(r.Context(), userID); err != nil {
+		log.Printf("Revoke user sessions error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+
+	s.auditAdmin(r, "lock_user", userID, map[string]interface{}{"reason": req.Reason})
+	sendJSONResponse(w, map[string]string{"message": "User locked"}, http.StatusOK)
+}
+
+// adminUnlockUserHandler снимает блокировку аккаунта, в том числе
+// временную блокировку после неудачных попыток входа
+func (s *Server) adminUnlockUserHandler(w http.ResponseWriter, r *http.Request, userID int) {
+	user, ok := s.loadUserForAdmin(w, r, userID)
+	if !ok {
+		return
+	}
+
+	// Пользователь мог быть удален после загрузки
+	if err := s.users.SetUserLocked(r.Context(), userID, false); errors.Is(err, ErrNotFound) {
+		sendErrorResponse(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	if err := s.throttle.Reset(r.Context(), user.Email); err != nil {
+		log.Printf("Login throttle error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+
+	s.auditAdmin(r, "unlock_user", userID, nil)
+	sendJSONResponse(w, map[string]string{"message": "User unlocked"}, http.StatusOK)
+}
+
+// adminForcePasswordResetHandler запрещает вход до смены пароля, завершает
+// все сессии и отправляет пользователю письмо для сброса пароля
+func (s *Server) adminForcePasswordResetHandler(w http.ResponseWriter, r *http.Request, userID int) {
+	// 1. Проверяем, что пользователь существует
+	user, ok := s.loadUserForAdmin(w, r, userID)
+	if !ok {
+		return
+	}
+
+	// 2. Запрещаем вход со старым паролем и завершаем сессии
+	// Пользователь мог быть удален после загрузки
+	if err := s.users.SetPasswordResetRequired(r.Context(), userID); errors.Is(err, ErrNotFound) {
+		sendErrorResponse(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	if err := s.revokeAllUserSessions(r.Context(), userID); err != nil {
+		log.Printf("Revoke user sessions error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+
+	// 3. Отправляем письмо со ссылкой сброса
+	if err := s.requestPasswordReset(r.Context(), user.Email); err != nil {
+		log.Printf("Password reset request error: %v", err)
+		sendErrorResponse(w, "Password reset required, but the email could not be sent", http.StatusInternalServerError)
+		return
+	}
+
+	s.auditAdmin(r, "force_password_reset", userID, nil)
+	sendJSONResponse(w, map[string]string{"message": "Password reset required"}, http.StatusOK)
+}
+
+// adminDeleteUserHandler удаляет аккаунт пользователя (режим из ACCOUNT_DELETION_MODE)
+func (s *Server) adminDeleteUserHandler(w http.ResponseWriter, r *http.Request, userID int) {
+	if adminID, _ := GetUserIDFromContext(r); adminID == userID {
+		sendErrorResponse(w, "Use DELETE /profile to delete your own account", http.StatusBadRequest)
+		return
+	}
+
+	// Пользователь загружается, чтобы на несуществующий ID ответить 404
+	if _, ok := s.loadUserForAdmin(w, r, userID); !ok {
+		return
+	}
+
+	// ErrNotFound - аккаунт уже удален параллельным запросом
+	if err := s.deleteAccount(r.Context(), userID); errors.Is(err, ErrNotFound) {
+		sendErrorResponse(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Delete user error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+
+	s.auditAdmin(r, "delete_user", userID, nil)
+	sendJSONResponse(w, map[string]string{"message": "User deleted"}, http.StatusOK)
+}
+
+// loadUserForAdmin загружает пользователя по ID из пути. При ошибке ответ уже отправлен
+func (s *Server) loadUserForAdmin(w http.ResponseWriter, r *http.Request, userID int) (*User, bool) {
+	user, err := s.users.GetUserByID(r.Context(), userID)
+	if errors.Is(err, ErrNotFound) {
+		sendErrorResponse(w, "User not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return nil, false
+	}
+	return user, true
+}
+
+// AdminRolesHandler возвращает список ролей с их разрешениями (GET /admin/roles)
+func AdminRolesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	roles, err := ListRoles(r.Context())
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	sendJSONResponse(w, map[string]interface{}{"roles": roles}, http.StatusOK)
+}
+
+// grantRoleHandler назначает роль пользователю.
+// Новая роль появится в токене после обновления через /token/refresh
+func (s *Server) grantRoleHandler(w http.ResponseWriter, r *http.Request, userID int) {
+	// 1. Парсим JSON
+	var req RoleRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		sendErrorResponse(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Role == "" {
+		sendErrorResponse(w, "role is required", http.StatusBadRequest)
+		return
+	}
+
+	// 2. Проверяем, что пользователь существует
+	user, err := s.users.GetUserByID(r.Context(), userID)
+	if errors.Is(err, ErrNotFound) {
+		sendErrorResponse(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+
+	// 3. Назначаем роль
+	adminID, _ := GetUserIDFromContext(r)
+	found, err := GrantRole(r.Context(), user.ID, req.Role, adminID)
+	if err != nil {
+		log.Printf("Grant role error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	if !found {
+		sendErrorResponse(w, "Role not found", http.StatusNotFound)
+		return
+	}
+
+	s.auditAdmin(r, "grant_role", userID, map[string]interface{}{"role": req.Role})
+	s.sendRolesResponse(r.Context(), w, userID, "Role granted")
+}
+
+// revokeRoleHandler снимает роль с пользователя.
+// Его access токены отзываются, чтобы снятая роль перестала действовать сразу
+func (s *Server) revokeRoleHandler(w http.ResponseWriter, r *http.Request, userID int, role string) {
+	// 1. Снимаем роль
+	revoked, err := RevokeRole(r.Context(), userID, role)
+	if err != nil {
+		log.Printf("Revoke role error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	if !revoked {
+		sendErrorResponse(w, "User does not have this role", http.StatusNotFound)
+		return
+	}
+
+	// 2. Токены со старым набором ролей больше не принимаются;
+	// refresh токены остаются, и клиент получит токен с новыми ролями
+	if err := s.revocations.RevokeAllForUser(r.Context(), userID); err != nil {
+		log.Printf("Revoke user tokens error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+
+	s.auditAdmin(r, "revoke_role", userID, map[string]interface{}{"role": role})
+	s.sendRolesResponse(r.Context(), w, userID, "Role revoked")
+}
+
+// sendRolesResponse отправляет текущий список ролей пользователя
+func (s *Server) sendRolesResponse(ctx context.Context, w http.ResponseWriter, userID int, message string) {
+	roles, err := s.roles.GetUserRoles(ctx, userID)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	response := map[string]interface{}{
+		"message": message,
+		"user_id": userID,
+		"roles":   roles,
+	}
+	sendJSONResponse(w, response, http.StatusOK)
+}
+
+// AdminDBStatsHandler возвращает статистику пула соединений с БД (GET /admin/db/stats).
+// Рост wait_count и wait_duration_ms означает, что DB_MAX_OPEN_CONNS не хватает
+func AdminDBStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sendJSONResponse(w, DBPoolStats(), http.StatusOK)
+}
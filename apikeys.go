@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// apiKeyScheme схема заголовка Authorization для API ключей
+	apiKeyScheme = "ApiKey "
+	// apiKeyTokenPrefix начало каждого ключа: по нему ключ легко найти в логах и коде
+	apiKeyTokenPrefix = "sk_"
+	// apiKeyPrefixBytes длина случайной видимой части ключа
+	apiKeyPrefixBytes = 6
+	// apiKeyTouchInterval минимальный интервал обновления last_used_at
+	apiKeyTouchInterval = time.Minute
+	// maxAPIKeysPerUser ограничение числа действующих ключей пользователя
+	maxAPIKeysPerUser = 20
+	// maxAPIKeyNameLength ограничение длины названия ключа (колонка api_keys.name)
+	maxAPIKeyNameLength = 100
+)
+
+// Scope API ключей
+const (
+	apiKeyScopeProfileRead = "profile:read"
+	apiKeyScopeAdminRead   = "admin:read"
+	apiKeyScopeAdminWrite  = "admin:write"
+)
+
+// apiKeyScopeDescriptions допустимые scope API ключей
+var apiKeyScopeDescriptions = map[string]string{
+	apiKeyScopeProfileRead: "Read the profile, its export and the list of sessions",
+	apiKeyScopeAdminRead:   "Read-only admin endpoints (subject to the user's permissions)",
+	apiKeyScopeAdminWrite:  "All admin endpoints (subject to the user's permissions)",
+}
+
+// apiKeyRoute запрос, доступный по API ключу со scope
+type apiKeyRoute struct {
+	method string // пустой - любой метод
+	path   string // с "/" на конце - префикс пути
+	scope  string
+}
+
+// apiKeyRoutes запросы, доступные по API ключу. Все остальное (изменение профиля, смена
+// пароля, удаление аккаунта, управление ключами, MFA, согласие OAuth) требует входа:
+// утекший ключ не должен давать захватить аккаунт или выпустить новые ключи
+var apiKeyRoutes = []apiKeyRoute{
+	{http.MethodGet, "/profile", apiKeyScopeProfileRead},
+	{http.MethodGet, "/profile/export", apiKeyScopeProfileRead},
+	{http.MethodGet, "/sessions", apiKeyScopeProfileRead},
+	{http.MethodGet, "/admin/", apiKeyScopeAdminRead},
+	{"", "/admin/", apiKeyScopeAdminWrite},
+}
+
+// generateAPIKey создает ключ вида sk_<prefix>_<secret> и возвращает его вместе с видимым префиксом
+func generateAPIKey() (string, string, error) {
+	b := make([]byte, apiKeyPrefixBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	secret, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	prefix := apiKeyTokenPrefix + hex.EncodeToString(b)
+	return prefix + "_" + secret, prefix, nil
+}
+
+// apiKeyPrefix извлекает видимый префикс из ключа; false - ключ не в нашем формате
+func apiKeyPrefix(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, apiKeyTokenPrefix)
+	if !ok {
+		return "", false
+	}
+	random, secret, ok := strings.Cut(rest, "_")
+	if !ok || secret == "" || len(random) != 2*apiKeyPrefixBytes {
+		return "", false
+	}
+	if _, err := hex.DecodeString(random); err != nil {
+		return "", false
+	}
+	return apiKeyTokenPrefix + random, true
+}
+
+// authenticateAPIKey проверяет ключ из заголовка Authorization и строит claims его владельца.
+// Роли попадают в claims только при admin scope. При отказе claims = nil, а reason
+// объясняет причину для журнала аудита
+func (s *Server) authenticateAPIKey(ctx context.Context, raw string) (*Claims, string, error) {
+	// 1. Ключ находится по префиксу, секрет сравнивается по хешу за постоянное время
+	prefix, ok := apiKeyPrefix(raw)
+	if !ok {
+		return nil, "malformed_api_key", nil
+	}
+	key, err := GetAPIKeyByPrefix(ctx, prefix)
+	if err != nil {
+		return nil, "", err
+	}
+	if key == nil || subtle.ConstantTimeCompare([]byte(hashToken(raw)), []byte(key.KeyHash)) != 1 {
+		return nil, "invalid_api_key", nil
+	}
+
+	// 2. Ключ не отозван и не истек
+	if key.RevokedAt != nil {
+		return nil, "api_key_revoked", nil
+	}
+	if key.ExpiresAt != nil && !time.Now().Before(*key.ExpiresAt) {
+		return nil, "api_key_expired", nil
+	}
+
+	// 3. Владелец существует и может входить
+	user, err := s.users.GetUserByID(ctx, key.UserID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, "user_not_found", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if user.LockedAt != nil {
+		return nil, "account_locked", nil
+	}
+	if user.PasswordResetRequired {
+		return nil, "password_reset_required", nil
+	}
+
+	claims := &Claims{
+		UserID:   user.ID,
+		Email:    user.Email,
+		Username: user.Username,
+		Scope:    strings.Join(key.Scopes, " "),
+		APIKeyID: key.ID,
+	}
+	if containsString(key.Scopes, apiKeyScopeAdminRead) || containsString(key.Scopes, apiKeyScopeAdminWrite) {
+		roles, err := s.roles.GetUserRoles(ctx, user.ID)
+		if err != nil {
+			return nil, "", err
+		}
+		claims.Roles = roles
+	}
+
+	// 4. Отметка использования не должна мешать запросу
+	if err := TouchAPIKey(ctx, key.ID); err != nil {
+		log.Printf("Touch API key error: %v", err)
+	}
+	return claims, "", nil
+}
+
+// apiKeyAllows проверяет, что scope ключа разрешают запрос r (см. apiKeyRoutes)
+func apiKeyAllows(claims *Claims, r *http.Request) bool {
+	scopes := strings.Fields(claims.Scope)
+	for _, route := range apiKeyRoutes {
+		if route.method != "" && route.method != r.Method {
+			continue
+		}
+		if strings.HasSuffix(route.path, "/") {
+			if !strings.HasPrefix(r.URL.Path, route.path) {
+				continue
+			}
+		} else if r.URL.Path != route.path {
+			continue
+		}
+		if containsString(scopes, route.scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateAPIKeyRequest проверяет запрос создания ключа и убирает повторы scope
+func validateAPIKeyRequest(req *CreateAPIKeyRequest) error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if utf8.RuneCountInString(req.Name) > maxAPIKeyNameLength {
+		return fmt.Errorf("name must be at most %d characters long", maxAPIKeyNameLength)
+	}
+
+	if len(req.Scopes) == 0 {
+		return fmt.Errorf("at least one scope is required")
+	}
+	scopes := make([]string, 0, len(req.Scopes))
+	for _, scope := range req.Scopes {
+		if _, ok := apiKeyScopeDescriptions[scope]; !ok {
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+		if !containsString(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	req.Scopes = scopes
+
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return fmt.Errorf("expires_at must be in the future")
+	}
+	return nil
+}
+
+// APIKeysHandler возвращает (GET) или создает (POST) API ключи пользователя: /profile/api-keys
+func (s *Server) APIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.listAPIKeysHandler(w, r)
+	case http.MethodPost:
+		s.createAPIKeyHandler(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// listAPIKeysHandler возвращает неотозванные ключи пользователя без секретов
+func (s *Server) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserIDFromContext(r)
+	if !ok {
+		sendErrorResponse(w, "User ID not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	list, err := ListUserAPIKeys(r.Context(), userID)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	sendJSONResponse(w, map[string]interface{}{"api_keys": list}, http.StatusOK)
+}
+
+// createAPIKeyHandler создает ключ. Сам ключ возвращается один раз - сохранить его нужно сразу
+func (s *Server) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserIDFromContext(r)
+	if !ok {
+		sendErrorResponse(w, "User ID not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	// 1. Парсим и проверяем запрос
+	var req CreateAPIKeyRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		sendErrorResponse(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := validateAPIKeyRequest(&req); err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// 2. Проверяем ограничение числа ключей
+	count, err := CountActiveAPIKeys(r.Context(), userID)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	if count >= maxAPIKeysPerUser {
+		sendErrorResponse(w, fmt.Sprintf("API key limit reached (%d). Revoke unused keys first", maxAPIKeysPerUser), http.StatusConflict)
+		return
+	}
+
+	// 3. Создаем ключ и сохраняем только его хеш
+	raw, prefix, err := generateAPIKey()
+	if err != nil {
+		log.Printf("Generate API key error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	key := &APIKey{
+		UserID:    userID,
+		Name:      req.Name,
+		Prefix:    prefix,
+		KeyHash:   hashToken(raw),
+		Scopes:    req.Scopes,
+		ExpiresAt: req.ExpiresAt,
+	}
+	if err := CreateAPIKey(r.Context(), key); err != nil {
+		log.Printf("Create API key error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+
+	s.audit(r, auditAPIKeyCreate, userID, auditSuccess, map[string]interface{}{"key_id": key.ID, "prefix": key.Prefix, "scopes": key.Scopes})
+	sendJSONResponse(w, map[string]interface{}{
+		"message": "API key created. Store it now: it will not be shown again",
+		"key":     raw,
+		"api_key": key,
+	}, http.StatusCreated)
+}
+
+// RevokeAPIKeyHandler отзывает API ключ пользователя (DELETE /profile/api-keys/{id})
+func (s *Server) RevokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	keyID, err := parsePositiveInt(strings.Trim(strings.TrimPrefix(r.URL.Path, "/profile/api-keys/"), "/"))
+	if err != nil {
+		sendErrorResponse(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	userID, ok := GetUserIDFromContext(r)
+	if !ok {
+		sendErrorResponse(w, "User ID not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	revoked, err := RevokeAPIKey(r.Context(), userID, keyID)
+	if err != nil {
+		log.Printf("Revoke API key error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	if !revoked {
+		sendErrorResponse(w, "API key not found", http.StatusNotFound)
+		return
+	}
+
+	s.audit(r, auditAPIKeyRevoke, userID, auditSuccess, map[string]interface{}{"key_id": keyID})
+	sendJSONResponse(w, map[string]string{"message": "API key revoked"}, http.StatusOK)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mustCreateAPIKey выпускает пользователю ключ со scope profile:read и возвращает сам ключ
+func mustCreateAPIKey(t *testing.T, userID int) string {
+	t.Helper()
+	raw, prefix, err := generateAPIKey()
+	if err != nil {
+		t.Fatalf("generateAPIKey: %v", err)
+	}
+	key := &APIKey{
+		UserID:  userID,
+		Name:    "ci",
+		Prefix:  prefix,
+		KeyHash: hashToken(raw),
+		Scopes:  []string{apiKeyScopeProfileRead},
+	}
+	if err := CreateAPIKey(context.Background(), key); err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	return raw
+}
+
+// requestAs вызывает обработчик от имени userID, как после AuthMiddleware
+func requestAs(handler http.HandlerFunc, userID int, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	handler(rec, withClaims(req, &Claims{UserID: userID}))
+	return rec
+}
+
+// TestAPIKeysRevokedWithAllSessions проверяет, что ключ, выпущенный до смены или сброса пароля
+// и выхода на всех устройствах, после них отклоняется. Ключи и отметки об отзыве хранятся
+// в PostgreSQL, поэтому нужна тестовая БД
+func TestAPIKeysRevokedWithAllSessions(t *testing.T) {
+	conn := requireTestDB(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		revoke func(t *testing.T, server *Server, userID int) *httptest.ResponseRecorder
+	}{
+		{"password change", func(t *testing.T, server *Server, userID int) *httptest.ResponseRecorder {
+			return requestAs(server.ChangePasswordHandler, userID, "/profile/password",
+				`{"current_password":"Correct-horse-1","new_password":"Battery-staple-2"}`)
+		}},
+		{"password reset", func(t *testing.T, server *Server, userID int) *httptest.ResponseRecorder {
+			if err := server.users.CreatePasswordResetToken(ctx, userID, hashToken("reset-token"), time.Now().Add(time.Hour)); err != nil {
+				t.Fatalf("CreatePasswordResetToken: %v", err)
+			}
+			return requestAs(server.ResetPasswordHandler, 0, "/password/reset",
+				`{"token":"reset-token","new_password":"Battery-staple-2"}`)
+		}},
+		{"logout all", func(t *testing.T, server *Server, userID int) *httptest.ResponseRecorder {
+			return requestAs(server.LogoutAllHandler, userID, "/logout/all", "")
+		}},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := conn.Exec(`TRUNCATE users RESTART IDENTITY CASCADE`); err != nil {
+				t.Fatalf("truncate: %v", err)
+			}
+			ts := newTestServer(t)
+			server := NewServer(NewPostgresUserStore(conn), ts.sessions, NewRevocationStore(), staticRoles{"user"}, ts.auditor, ts.throttle)
+
+			hash, err := HashPassword("Correct-horse-1")
+			if err != nil {
+				t.Fatalf("HashPassword: %v", err)
+			}
+			user, err := server.users.CreateUser(ctx, "alice@example.com", "alice", hash)
+			if err != nil {
+				t.Fatalf("CreateUser: %v", err)
+			}
+			key := mustCreateAPIKey(t, user.ID)
+
+			profile := func() int {
+				req := httptest.NewRequest(http.MethodGet, "/profile", nil)
+				req.Header.Set("Authorization", apiKeyScheme+key)
+				rec := httptest.NewRecorder()
+				server.AuthMiddleware(server.ProfileHandler)(rec, req)
+				return rec.Code
+			}
+			if code := profile(); code != http.StatusOK {
+				t.Fatalf("GET /profile with a fresh key = %d, want 200", code)
+			}
+
+			if rec := tc.revoke(t, server, user.ID); rec.Code != http.StatusOK {
+				t.Fatalf("%s = %d %s, want 200", tc.name, rec.Code, rec.Body.String())
+			}
+
+			if code := profile(); code != http.StatusUnauthorized {
+				t.Fatalf("GET /profile after %s = %d, want 401", tc.name, code)
+			}
+			if e, _ := ts.auditor.last(auditTokenValidation); e.Details["reason"] != "api_key_revoked" {
+				t.Fatalf("audit event = %+v, want reason api_key_revoked", e)
+			}
+		})
+	}
+}
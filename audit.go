@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Типы событий аудита
+const (
+	auditRegister         = "register"
+	auditLogin            = "login"
+	auditLoginMFA         = "login.mfa"
+	auditLoginOIDC        = "login.oidc"
+	auditLoginWebAuthn    = "login.webauthn"
+	auditLoginMagicLink   = "login.magic_link"
+	auditTokenValidation  = "token.validation"
+	auditPasswordChange   = "password.change"
+	auditPasswordReset    = "password.reset"
+	auditAccountDelete    = "account.delete"
+	auditSessionRevoke    = "session.revoke"
+	auditAPIKeyCreate     = "api_key.create"
+	auditAPIKeyRevoke     = "api_key.revoke"
+	auditWebAuthnRegister = "webauthn.register"
+	auditWebAuthnDelete   = "webauthn.delete"
+	auditOAuthAuthorize   = "oauth.authorize"
+	auditOAuthToken       = "oauth.token"
+	auditOAuthRevoke      = "oauth.revoke"
+	// auditAdminPrefix префикс действий администратора ("admin.lock_user" и т.п.)
+	auditAdminPrefix = "admin."
+)
+
+// Результаты событий аудита
+const (
+	auditSuccess = "success"
+	auditFailure = "failure"
+)
+
+// auditor глобальный журнал событий безопасности
+var auditor *Auditor
+
+// AuditRecorder журнал событий безопасности. Реализация - Auditor
+type AuditRecorder interface {
+	// Record сохраняет событие; ошибки записи только логируются
+	Record(event AuditEvent)
+}
+
+// Auditor записывает события безопасности в таблицу audit_log (только добавление,
+// изменение и удаление запрещены триггером) и, если задан AUDIT_LOG_FILE, в JSONL файл
+type Auditor struct {
+	filePath string
+	mu       sync.Mutex
+}
+
+// InitAudit настраивает журнал аудита
+func InitAudit(cfg AuditConfig) {
+	auditor = &Auditor{filePath: cfg.File}
+}
+
+// auditEmail обезличенный email для деталей события (SHA-256 нормализованного адреса).
+// Журнал только дополняется, поэтому сырой адрес в нем нельзя было бы стереть вместе с аккаунтом,
+// а по хешу все еще можно найти события известного адреса
+func auditEmail(email string) string {
+	return hashToken(strings.ToLower(strings.TrimSpace(email)))
+}
+
+// Record сохраняет событие. Ошибка записи не прерывает обработку запроса
+// и только логируется. Событие записывается и после того, как клиент закрыл соединение,
+// поэтому контекст запроса не используется (таймаут DB_QUERY_TIMEOUT действует)
+func (a *Auditor) Record(event AuditEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	if err := InsertAuditEvent(context.Background(), &event); err != nil {
+		log.Printf("Audit log error: %v", err)
+	}
+	if a.filePath != "" {
+		if err := a.appendToFile(event); err != nil {
+			log.Printf("Audit file error: %v", err)
+		}
+	}
+}
+
+// appendToFile дописывает событие в файл в формате JSON Lines
+func (a *Auditor) appendToFile(event AuditEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit event: %w", err)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	f, err := os.OpenFile(a.filePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit event: %w", err)
+	}
+	return nil
+}
+
+// audit записывает событие запроса r в журнал сервера
+func (s *Server) audit(r *http.Request, event string, userID int, outcome string, details map[string]interface{}) {
+	s.auditor.Record(requestAuditEvent(r, event, userID, outcome, details))
+}
+
+// auditAdmin записывает действие администратора в журнал сервера
+func (s *Server) auditAdmin(r *http.Request, action string, targetUserID int, details map[string]interface{}) {
+	s.auditor.Record(adminAuditEvent(r, action, targetUserID, details))
+}
+
+// requestAuditEvent строит событие запроса r: IP и User-Agent берутся из запроса.
+// userID = 0 означает, что пользователь неизвестен
+func requestAuditEvent(r *http.Request, event string, userID int, outcome string, details map[string]interface{}) AuditEvent {
+	e := AuditEvent{
+		Event:     event,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+		Outcome:   outcome,
+		Details:   details,
+	}
+	if userID != 0 {
+		e.UserID = &userID
+	}
+	return e
+}
+
+// adminAuditEvent строит событие действия администратора из контекста запроса над пользователем
+// targetUserID. targetUserID = 0 - действие не над пользователем (например, регистрация OAuth клиента)
+func adminAuditEvent(r *http.Request, action string, targetUserID int, details map[string]interface{}) AuditEvent {
+	adminID, _ := GetUserIDFromContext(r)
+	e := AuditEvent{
+		Event:     auditAdminPrefix + action,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+		Outcome:   auditSuccess,
+		Details:   details,
+	}
+	if adminID != 0 {
+		e.UserID = &adminID
+	}
+	if targetUserID != 0 {
+		e.TargetUserID = &targetUserID
+	}
+	// Действие выполнено скриптом по API ключу
+	if claims, ok := GetClaimsFromContext(r); ok && claims.APIKeyID != 0 {
+		if e.Details == nil {
+			e.Details = map[string]interface{}{}
+		}
+		e.Details["api_key_id"] = claims.APIKeyID
+	}
+	return e
+}
+
+// AdminAuditHandler возвращает события аудита (GET /admin/audit).
+// Параметры: user_id (инициатор или цель события), event, from и to (RFC 3339), limit и offset
+func AdminAuditHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 1. Разбираем фильтры
+	query := r.URL.Query()
+	filter := AuditFilter{
+		Event: query.Get("event"),
+		Limit: defaultAdminPageSize,
+	}
+	if v := query.Get("user_id"); v != "" {
+		userID, err := parsePositiveInt(v)
+		if err != nil {
+			sendErrorResponse(w, "user_id must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		filter.UserID = userID
+	}
+	for name, target := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if v := query.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				sendErrorResponse(w, name+" must be an RFC 3339 timestamp", http.StatusBadRequest)
+				return
+			}
+			*target = t
+		}
+	}
+	limit, offset, err := parsePagination(query)
+	if err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.Limit, filter.Offset = limit, offset
+
+	// 2. Загружаем события, новые первыми
+	events, err := ListAuditEvents(r.Context(), filter)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"events": events,
+		"limit":  filter.Limit,
+		"offset": filter.Offset,
+	}
+	sendJSONResponse(w, response, http.StatusOK)
+}
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
-	"os"
+	"strconv"
+	"strings"
 
 	// TODO: Добавьте необходимые импорты:
 	"time"
@@ -11,14 +16,39 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
-var jwtSecret []byte
+const (
+	// accessTokenTTL время жизни access токена (JWT)
+	accessTokenTTL = 24 * time.Hour
+	// refreshTokenTTL время жизни refresh токена
+	refreshTokenTTL = 30 * 24 * time.Hour
+	// mfaPendingTTL время на ввод второго фактора после проверки пароля
+	mfaPendingTTL = 5 * time.Minute
+	// emailVerificationTTL время жизни ссылки подтверждения email
+	emailVerificationTTL = 24 * time.Hour
+	// passwordResetTTL время жизни токена сброса пароля
+	passwordResetTTL = time.Hour
+	// magicLinkTTL время жизни ссылки входа без пароля
+	magicLinkTTL = 15 * time.Minute
+)
+
+// Назначения служебных токенов (Claims.Purpose)
+const (
+	tokenPurposeMFAPending        = "mfa_pending"
+	tokenPurposeEmailVerification = "email_verification"
+	tokenPurposeMagicLink         = "magic_link"
+)
 
-// InitAuth инициализирует секретный ключ для JWT
-func InitAuth() {
-	jwtSecret = []byte(os.Getenv("JWT_SECRET"))
-	if len(jwtSecret) < 32 {
-		panic("JWT_SECRET must be at least 32 characters long")
+// InitAuth загружает ключи подписи JWT из источника cfg (см. Keyring.Reload)
+func InitAuth(cfg AuthConfig) error {
+	// iat с точностью до микросекунды: иначе "выйти везде" не отличит токены,
+	// выданные в ту же секунду до отзыва, от выданных после (см. RevocationStore.IsRevoked)
+	jwt.TimePrecision = time.Microsecond
+
+	keyring = NewKeyring(cfg)
+	if err := keyring.Reload(); err != nil {
+		return fmt.Errorf("failed to load JWT signing keys: %w", err)
 	}
+	return nil
 }
 
 // HashPassword хеширует пароль с использованием bcrypt
@@ -52,8 +82,8 @@ func CheckPassword(password, hash string) bool {
 	// return false // Временная заглушка
 }
 
-// GenerateToken создает JWT токен для пользователя
-func GenerateToken(user User) (string, error) {
+// GenerateToken создает JWT токен для пользователя в рамках сессии sessionID
+func GenerateToken(user User, sessionID string) (string, error) {
 	// TODO: Реализуйте генерацию JWT токена
 	//
 	// Что нужно сделать:
@@ -66,18 +96,69 @@ func GenerateToken(user User) (string, error) {
 	// 4. Подпишите токен с помощью token.SignedString(jwtSecret)
 	//
 	// Документация: https://pkg.go.dev/github.com/golang-jwt/jwt/v5
-	claims := Claims{
+	claims := userClaims(user)
+	claims.SessionID = sessionID
+	return signClaims(claims, accessTokenTTL)
+}
+
+// GenerateClientToken создает access токен, выданный OAuth клиенту clientID с правами scopes.
+// Для client_credentials user = nil и субъект токена - сам клиент. Email и имя пользователя
+// попадают в токен только с соответствующими scope, роли - никогда.
+// iss - адрес сервиса (oauthIssuer), aud - клиент, которому выдан токен
+func GenerateClientToken(user *User, clientID string, scopes []string) (string, error) {
+	claims := Claims{ClientID: clientID, Scope: strings.Join(scopes, " ")}
+	claims.Subject = clientID
+	claims.Issuer = oauthIssuer()
+	claims.Audience = jwt.ClaimStrings{clientID}
+	if user != nil {
+		claims.UserID = user.ID
+		claims.Subject = strconv.Itoa(user.ID)
+		if containsString(scopes, oauthScopeProfile) {
+			claims.Username = user.Username
+		}
+		if containsString(scopes, oauthScopeEmail) {
+			claims.Email = user.Email
+		}
+	}
+	return signClaims(claims, oauthAccessTokenTTL)
+}
+
+// generatePurposeToken создает короткоживущий служебный токен (например, "mfa_pending").
+// AuthMiddleware такие токены не принимает
+func generatePurposeToken(user User, purpose string, ttl time.Duration) (string, error) {
+	claims := userClaims(user)
+	claims.Purpose = purpose
+	return signClaims(claims, ttl)
+}
+
+// userClaims заполняет claims данными пользователя
+func userClaims(user User) Claims {
+	return Claims{
 		UserID:   user.ID,
 		Email:    user.Email,
 		Username: user.Username,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-		},
+		Roles:    user.Roles,
+	}
+}
+
+// signClaims задает jti, время выдачи и срок действия и подписывает claims активным ключом
+func signClaims(claims Claims, ttl time.Duration) (string, error) {
+	// jti нужен для отзыва отдельного токена при выходе
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", err
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtSecret)
+	now := time.Now()
+	claims.RegisteredClaims.ID = jti
+	claims.RegisteredClaims.ExpiresAt = jwt.NewNumericDate(now.Add(ttl))
+	claims.RegisteredClaims.IssuedAt = jwt.NewNumericDate(now)
+
+	key := keyring.Active()
+	token := jwt.NewWithClaims(key.method, claims)
+	// kid позволяет проверяющей стороне выбрать ключ из JWKS
+	token.Header["kid"] = key.kid
+	tokenString, err := token.SignedString(key.private)
 	if err != nil {
 		return "", fmt.Errorf("failed to sign token: %w", err)
 	}
@@ -94,14 +175,23 @@ func ValidateToken(tokenString string) (*Claims, error) {
 	// 1. Создайте пустую структуру claims := &Claims{}
 	claims := &Claims{}
 
-	// 3. В keyFunc проверьте, что алгоритм подписи HMAC (*jwt.SigningMethodHMAC)
+	// 3. В keyFunc выбираем ключ по kid и проверяем, что алгоритм совпадает с ключом
 	keyFunc := func(token *jwt.Token) (interface{}, error) {
+		// Токены без kid выданы до его появления и подписаны активным ключом
+		key := keyring.Active()
+		if rawKid, ok := token.Header["kid"]; ok {
+			kid, _ := rawKid.(string)
+			if key, ok = keyring.Lookup(kid); !ok {
+				return nil, fmt.Errorf("unknown key id: %v", rawKid)
+			}
+		}
+
 		// Проверяем алгоритм подписи
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		if token.Method.Alg() != key.method.Alg() {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		// 4. Возвращаем jwtSecret как ключ для проверки подписи
-		return jwtSecret, nil
+		// 4. Возвращаем публичный ключ (или секрет для HMAC) для проверки подписи
+		return key.public, nil
 	}
 
 	// 2. Используйте jwt.ParseWithClaims() для парсинга токена
@@ -121,6 +211,40 @@ func ValidateToken(tokenString string) (*Claims, error) {
 	return claims, nil
 }
 
+// ValidatePurposeToken проверяет служебный токен с заданным назначением
+func ValidatePurposeToken(tokenString, purpose string) (*Claims, error) {
+	claims, err := ValidateToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != purpose {
+		return nil, fmt.Errorf("token is not valid for %s", purpose)
+	}
+	return claims, nil
+}
+
+// GenerateRefreshToken создает непрозрачный refresh токен.
+// В БД сохраняется только его хеш (см. hashToken)
+func GenerateRefreshToken() (string, error) {
+	return randomToken(32)
+}
+
+// randomToken возвращает n криптографически случайных байт в base64url
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate random token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken возвращает SHA-256 хеш токена в hex для хранения в БД.
+// bcrypt здесь не нужен: токены случайные и имеют высокую энтропию
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 // ValidatePassword проверяет требования к паролю
 func ValidatePassword(password string) error {
 	if len(password) < 8 {
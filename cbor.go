@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// maxCBORDepth ограничение вложенности: структуры WebAuthn неглубокие
+const maxCBORDepth = 16
+
+var errCBORTruncated = errors.New("cbor: unexpected end of data")
+
+// cborDecode разбирает одно значение CBOR (RFC 8949) в начале data и возвращает его
+// вместе с числом прочитанных байт. Поддерживается подмножество, которое используют
+// WebAuthn и COSE: целые (int64), байтовые и текстовые строки, массивы, карты с целыми
+// или строковыми ключами (map[interface{}]interface{}), теги (значение возвращается без тега),
+// false, true и null. Значения неопределенной длины и числа с плавающей точкой не поддерживаются
+func cborDecode(data []byte) (interface{}, int, error) {
+	d := &cborDecoder{data: data}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, 0, err
+	}
+	return v, d.pos, nil
+}
+
+// cborDecodeAll разбирает data, которая должна содержать ровно одно значение
+func cborDecodeAll(data []byte) (interface{}, error) {
+	v, n, err := cborDecode(data)
+	if err != nil {
+		return nil, err
+	}
+	if n != len(data) {
+		return nil, errors.New("cbor: trailing data")
+	}
+	return v, nil
+}
+
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+// head читает начальный байт и аргумент элемента
+func (d *cborDecoder) head() (byte, uint64, error) {
+	if d.pos >= len(d.data) {
+		return 0, 0, errCBORTruncated
+	}
+	initial := d.data[d.pos]
+	d.pos++
+	major, info := initial>>5, initial&0x1f
+
+	var size int
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default:
+		return 0, 0, fmt.Errorf("cbor: unsupported additional info %d", info)
+	}
+	if len(d.data)-d.pos < size {
+		return 0, 0, errCBORTruncated
+	}
+	b := d.data[d.pos : d.pos+size]
+	d.pos += size
+	switch size {
+	case 1:
+		return major, uint64(b[0]), nil
+	case 2:
+		return major, uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return major, uint64(binary.BigEndian.Uint32(b)), nil
+	}
+	return major, binary.BigEndian.Uint64(b), nil
+}
+
+// bytes читает n байт содержимого строки
+func (d *cborDecoder) bytes(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, errCBORTruncated
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+func (d *cborDecoder) value(depth int) (interface{}, error) {
+	if depth > maxCBORDepth {
+		return nil, errors.New("cbor: nesting too deep")
+	}
+	major, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case 0: // беззнаковое целое
+		if arg > 1<<63-1 {
+			return nil, errors.New("cbor: integer overflow")
+		}
+		return int64(arg), nil
+	case 1: // отрицательное целое: -1 - arg
+		if arg > 1<<63-1 {
+			return nil, errors.New("cbor: integer overflow")
+		}
+		return -1 - int64(arg), nil
+	case 2: // байтовая строка
+		b, err := d.bytes(arg)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), b...), nil
+	case 3: // текстовая строка
+		b, err := d.bytes(arg)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	case 4: // массив
+		// Каждый элемент занимает хотя бы байт - это отсекает огромные длины до выделения памяти
+		if arg > uint64(len(d.data)-d.pos) {
+			return nil, errCBORTruncated
+		}
+		items := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			item, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case 5: // карта
+		if arg > uint64(len(d.data)-d.pos)/2 {
+			return nil, errCBORTruncated
+		}
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			key, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, errors.New("cbor: unsupported map key type")
+			}
+			if _, dup := m[key]; dup {
+				return nil, fmt.Errorf("cbor: duplicate map key %v", key)
+			}
+			value, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = value
+		}
+		return m, nil
+	case 6: // тег: значение возвращается без него
+		return d.value(depth + 1)
+	}
+
+	// major 7: простые значения
+	switch arg {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23: // null и undefined
+		return nil, nil
+	}
+	return nil, fmt.Errorf("cbor: unsupported simple value %d", arg)
+}
@@ -0,0 +1,838 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Источники настроек в порядке возрастания приоритета
+const (
+	configSourceDefault = "default"
+	configSourceFile    = "file"
+	configSourceEnv     = "env"
+	configSourceFlag    = "flag"
+)
+
+// Config настройки сервиса. Значения применяются по возрастанию приоритета:
+// значения по умолчанию, файл конфигурации (-config или CONFIG_FILE), переменные окружения, флаги.
+// Компоненты получают свою секцию при инициализации и сами окружение не читают
+type Config struct {
+	Server    ServerConfig
+	Database  DatabaseConfig
+	Auth      AuthConfig
+	MFA       MFAConfig
+	Mail      MailConfig
+	Account   AccountConfig
+	Audit     AuditConfig
+	Login     LoginConfig
+	RateLimit RateLimitConfig
+	OAuth     OAuthConfig
+	WebAuthn  WebAuthnConfig
+	OIDC      OIDCConfig
+
+	// sources откуда взято значение каждой настройки (по имени переменной окружения)
+	sources map[string]string
+}
+
+// ServerConfig настройки HTTP сервера
+type ServerConfig struct {
+	Port string
+	// BaseURL адрес сервиса для ссылок в письмах и WebAuthn, по умолчанию http://localhost:<Port>
+	BaseURL           string
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// TrustProxyHeaders брать IP клиента из X-Forwarded-For / X-Real-IP (см. clientIP)
+	TrustProxyHeaders bool
+	TrustedProxyCount int
+}
+
+// DatabaseConfig подключение к PostgreSQL и пул соединений
+type DatabaseConfig struct {
+	// URL строка подключения целиком; если задана, Host..SSLRootCert не используются
+	URL             string
+	Host            string
+	Port            string
+	User            string
+	Password        string
+	Name            string
+	SSLMode         string
+	SSLRootCert     string
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	QueryTimeout    time.Duration
+	ConnectAttempts int
+	ConnectBackoff  time.Duration
+}
+
+// AuthConfig источник ключей подписи JWT (см. Keyring)
+type AuthConfig struct {
+	Algorithm         string
+	Secret            string
+	PrivateKeyFile    string
+	KeyDir            string
+	KeyReloadInterval time.Duration
+}
+
+// MFAConfig ключ шифрования TOTP секретов (32 байта в base64) и издатель в otpauth:// URI
+type MFAConfig struct {
+	EncryptionKey string
+	TOTPIssuer    string
+}
+
+// MailConfig отправка писем: Mailer - smtp, file или log
+type MailConfig struct {
+	Mailer       string
+	File         string
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	From         string
+}
+
+// AccountConfig правила для аккаунтов и ссылки в письмах
+// (PasswordResetURL и MagicLinkURL по умолчанию - страницы самого сервиса)
+type AccountConfig struct {
+	EmailVerificationRequired bool
+	DeletionMode              string // soft или hard
+	AdminEmail                string
+	PasswordResetURL          string
+	MagicLinkURL              string
+}
+
+// AuditConfig журнал аудита: File - дополнительный JSONL файл
+type AuditConfig struct {
+	File string
+}
+
+// LoginConfig пороги защиты входа от перебора (см. LoginThrottle)
+type LoginConfig struct {
+	MaxAccountFailures int
+	MaxIPFailures      int
+	MaxMFAFailures     int
+	FailureWindow      time.Duration
+	LockoutDuration    time.Duration
+	BaseDelay          time.Duration
+	MaxDelay           time.Duration
+}
+
+// RateLimitConfig хранилище счетчиков (memory или postgres) и лимиты маршрутов
+// в формате parseRateLimitRule по имени маршрута (RATE_LIMIT_<ROUTE>)
+type RateLimitConfig struct {
+	Store  string
+	Routes map[string]*string
+}
+
+// OAuthConfig время жизни access токенов OAuth клиентов
+type OAuthConfig struct {
+	AccessTokenTTL time.Duration
+}
+
+// OIDCConfig внешние OIDC провайдеры: имена из OIDC_PROVIDERS и настройки каждого
+// из OIDC_<NAME>_* (только файл и окружение, флагов для них нет)
+type OIDCConfig struct {
+	Providers []string
+	Provider  map[string]*OIDCProviderConfig
+}
+
+// OIDCProviderConfig настройки одного OIDC провайдера
+type OIDCProviderConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       string // через пробел
+	LinkByEmail  bool
+}
+
+// configField одна настройка: переменная окружения, указатель на поле Config
+// (*string, *int, *bool, *time.Duration, *[]string через запятую) и способ скрыть значение при выводе.
+// Ключ в файле - имя переменной в нижнем регистре (db_host), флаг - ключ через дефис (-db-host)
+type configField struct {
+	env    string
+	usage  string
+	value  interface{}
+	redact func(string) string
+}
+
+// key имя настройки в файле конфигурации
+func (f configField) key() string {
+	return strings.ToLower(f.env)
+}
+
+// flagName имя флага командной строки
+func (f configField) flagName() string {
+	return strings.ReplaceAll(f.key(), "_", "-")
+}
+
+// fields описывает все настройки Config
+func (c *Config) fields() []configField {
+	fields := []configField{
+		{env: "SERVER_PORT", usage: "HTTP port", value: &c.Server.Port},
+		{env: "APP_BASE_URL", usage: "public base URL for links in emails and WebAuthn", value: &c.Server.BaseURL},
+		{env: "SERVER_READ_HEADER_TIMEOUT", usage: "time to read request headers", value: &c.Server.ReadHeaderTimeout},
+		{env: "SERVER_READ_TIMEOUT", usage: "time to read the whole request", value: &c.Server.ReadTimeout},
+		{env: "SERVER_WRITE_TIMEOUT", usage: "time to write the response", value: &c.Server.WriteTimeout},
+		{env: "SERVER_IDLE_TIMEOUT", usage: "keep-alive idle timeout", value: &c.Server.IdleTimeout},
+		{env: "TRUST_PROXY_HEADERS", usage: "take the client IP from X-Forwarded-For / X-Real-IP", value: &c.Server.TrustProxyHeaders},
+		{env: "TRUSTED_PROXY_COUNT", usage: "number of trusted proxies appending to X-Forwarded-For", value: &c.Server.TrustedProxyCount},
+
+		{env: "DATABASE_URL", usage: "full PostgreSQL DSN, overrides DB_*", value: &c.Database.URL, redact: redactDSN},
+		{env: "DB_HOST", usage: "PostgreSQL host", value: &c.Database.Host},
+		{env: "DB_PORT", usage: "PostgreSQL port", value: &c.Database.Port},
+		{env: "DB_USER", usage: "PostgreSQL user", value: &c.Database.User},
+		{env: "DB_PASSWORD", usage: "PostgreSQL password", value: &c.Database.Password, redact: redactSecret},
+		{env: "DB_NAME", usage: "PostgreSQL database", value: &c.Database.Name},
+		{env: "DB_SSLMODE", usage: "disable, require, verify-ca or verify-full", value: &c.Database.SSLMode},
+		{env: "DB_SSLROOTCERT", usage: "CA certificate of the database server", value: &c.Database.SSLRootCert},
+		{env: "DB_MAX_OPEN_CONNS", usage: "max open connections, 0 - unlimited", value: &c.Database.MaxOpenConns},
+		{env: "DB_MAX_IDLE_CONNS", usage: "max idle connections", value: &c.Database.MaxIdleConns},
+		{env: "DB_CONN_MAX_LIFETIME", usage: "max connection lifetime, 0 - unlimited", value: &c.Database.ConnMaxLifetime},
+		{env: "DB_CONN_MAX_IDLE_TIME", usage: "max connection idle time, 0 - unlimited", value: &c.Database.ConnMaxIdleTime},
+		{env: "DB_QUERY_TIMEOUT", usage: "per-query timeout, 0 - none", value: &c.Database.QueryTimeout},
+		{env: "DB_CONNECT_ATTEMPTS", usage: "connection attempts on startup", value: &c.Database.ConnectAttempts},
+		{env: "DB_CONNECT_BACKOFF", usage: "first delay between connection attempts", value: &c.Database.ConnectBackoff},
+
+		{env: "JWT_ALGORITHM", usage: "HS256, RS256, ES256 or EdDSA", value: &c.Auth.Algorithm},
+		{env: "JWT_SECRET", usage: "HS256 secret, at least 32 characters", value: &c.Auth.Secret, redact: redactSecret},
+		{env: "JWT_PRIVATE_KEY_FILE", usage: "PEM private key for RS256, ES256 or EdDSA", value: &c.Auth.PrivateKeyFile},
+		{env: "JWT_KEY_DIR", usage: "directory with rotated signing keys", value: &c.Auth.KeyDir},
+		{env: "JWT_KEY_RELOAD_INTERVAL", usage: "JWT_KEY_DIR reload interval", value: &c.Auth.KeyReloadInterval},
+
+		{env: "MFA_ENCRYPTION_KEY", usage: "base64 AES-256 key for TOTP secrets", value: &c.MFA.EncryptionKey, redact: redactSecret},
+		{env: "TOTP_ISSUER", usage: "issuer shown by authenticator apps", value: &c.MFA.TOTPIssuer},
+
+		{env: "MAILER", usage: "smtp, file or log", value: &c.Mail.Mailer},
+		{env: "MAIL_FILE", usage: "JSON Lines file for MAILER=file", value: &c.Mail.File},
+		{env: "MAIL_FROM", usage: "sender address", value: &c.Mail.From},
+		{env: "SMTP_HOST", usage: "SMTP server host", value: &c.Mail.SMTPHost},
+		{env: "SMTP_PORT", usage: "SMTP server port", value: &c.Mail.SMTPPort},
+		{env: "SMTP_USERNAME", usage: "SMTP user", value: &c.Mail.SMTPUsername},
+		{env: "SMTP_PASSWORD", usage: "SMTP password", value: &c.Mail.SMTPPassword, redact: redactSecret},
+
+		{env: "EMAIL_VERIFICATION_REQUIRED", usage: "deny login until the email is verified", value: &c.Account.EmailVerificationRequired},
+		{env: "ACCOUNT_DELETION_MODE", usage: "soft or hard", value: &c.Account.DeletionMode},
+		{env: "ADMIN_EMAIL", usage: "user granted the admin role on startup", value: &c.Account.AdminEmail},
+		{env: "PASSWORD_RESET_URL", usage: "password reset page linked from emails", value: &c.Account.PasswordResetURL},
+		{env: "MAGIC_LINK_URL", usage: "login link page linked from emails", value: &c.Account.MagicLinkURL},
+
+		{env: "AUDIT_LOG_FILE", usage: "also write the audit log to this JSON Lines file", value: &c.Audit.File},
+
+		{env: "LOGIN_MAX_FAILURES", usage: "failures before an email is locked", value: &c.Login.MaxAccountFailures},
+		{env: "LOGIN_IP_MAX_FAILURES", usage: "failures before an IP is locked", value: &c.Login.MaxIPFailures},
+		{env: "LOGIN_MFA_MAX_FAILURES", usage: "wrong second factor codes per login", value: &c.Login.MaxMFAFailures},
+		{env: "LOGIN_FAILURE_WINDOW", usage: "failure counter window", value: &c.Login.FailureWindow},
+		{env: "LOGIN_LOCKOUT_DURATION", usage: "temporary lockout duration", value: &c.Login.LockoutDuration},
+		{env: "LOGIN_DELAY_BASE", usage: "delay after the first failure", value: &c.Login.BaseDelay},
+		{env: "LOGIN_DELAY_MAX", usage: "maximum delay after failures", value: &c.Login.MaxDelay},
+
+		{env: "RATE_LIMIT_STORE", usage: "memory or postgres", value: &c.RateLimit.Store},
+	}
+	for _, route := range sortedKeys(c.RateLimit.Routes) {
+		fields = append(fields, configField{
+			env:   rateLimitEnv(route),
+			usage: "<limit>/<window>[:ip|user|route] or off",
+			value: c.RateLimit.Routes[route],
+		})
+	}
+	fields = append(fields,
+		configField{env: "OAUTH_ACCESS_TOKEN_TTL", usage: "lifetime of OAuth client access tokens", value: &c.OAuth.AccessTokenTTL},
+
+		configField{env: "WEBAUTHN_RP_ID", usage: "WebAuthn relying party ID, defaults to the APP_BASE_URL host", value: &c.WebAuthn.RPID},
+		configField{env: "WEBAUTHN_RP_NAME", usage: "service name shown by the browser", value: &c.WebAuthn.RPName},
+		configField{env: "WEBAUTHN_ORIGINS", usage: "comma-separated allowed origins", value: &c.WebAuthn.Origins},
+		configField{env: "WEBAUTHN_ATTESTATION", usage: "none or direct", value: &c.WebAuthn.Attestation},
+
+		configField{env: "OIDC_PROVIDERS", usage: "comma-separated OIDC provider names", value: &c.OIDC.Providers},
+	)
+	return append(fields, c.oidcProviderFields()...)
+}
+
+// oidcProviderFields описывает настройки провайдеров из OIDC_PROVIDERS
+func (c *Config) oidcProviderFields() []configField {
+	var fields []configField
+	for _, name := range c.OIDC.Providers {
+		p := c.OIDC.Provider[name]
+		if p == nil {
+			continue
+		}
+		prefix := oidcProviderEnvPrefix(name)
+		fields = append(fields,
+			configField{env: prefix + "ISSUER", value: &p.Issuer},
+			configField{env: prefix + "CLIENT_ID", value: &p.ClientID},
+			configField{env: prefix + "CLIENT_SECRET", value: &p.ClientSecret, redact: redactSecret},
+			configField{env: prefix + "REDIRECT_URL", value: &p.RedirectURL},
+			configField{env: prefix + "SCOPES", value: &p.Scopes},
+			configField{env: prefix + "LINK_BY_EMAIL", value: &p.LinkByEmail},
+		)
+	}
+	return fields
+}
+
+// addOIDCProviders создает настройки по умолчанию для провайдеров из OIDC_PROVIDERS
+func (c *Config) addOIDCProviders() error {
+	var errs []error
+	c.OIDC.Provider = make(map[string]*OIDCProviderConfig)
+	names := c.OIDC.Providers[:0]
+	for _, name := range c.OIDC.Providers {
+		name = strings.ToLower(name)
+		if !oidcProviderName.MatchString(name) {
+			errs = append(errs, fmt.Errorf("OIDC_PROVIDERS: invalid provider name %q", name))
+			continue
+		}
+		if c.OIDC.Provider[name] != nil {
+			errs = append(errs, fmt.Errorf("OIDC_PROVIDERS: duplicate provider %q", name))
+			continue
+		}
+		c.OIDC.Provider[name] = &OIDCProviderConfig{Scopes: "openid email profile", LinkByEmail: true}
+		names = append(names, name)
+	}
+	c.OIDC.Providers = names
+	return errors.Join(errs...)
+}
+
+// oidcProviderEnvPrefix префикс переменных провайдера: OIDC_<NAME>_
+func oidcProviderEnvPrefix(name string) string {
+	return "OIDC_" + strings.ToUpper(name) + "_"
+}
+
+// rateLimitEnv имя переменной лимита маршрута: RATE_LIMIT_<ROUTE>
+func rateLimitEnv(route string) string {
+	return "RATE_LIMIT_" + strings.ToUpper(route)
+}
+
+// sortedKeys ключи map по возрастанию (порядок вывода настроек не должен меняться)
+func sortedKeys(m map[string]*string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// defaultConfig возвращает настройки по умолчанию
+func defaultConfig() *Config {
+	cfg := &Config{
+		Server: ServerConfig{
+			Port:              "8080",
+			ReadHeaderTimeout: 10 * time.Second,
+			ReadTimeout:       30 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			TrustedProxyCount: 1,
+		},
+		Database: DatabaseConfig{
+			Host:            "localhost",
+			Port:            "5432",
+			User:            "postgres",
+			Password:        "postgres",
+			Name:            "secure_service",
+			SSLMode:         "disable",
+			MaxOpenConns:    defaultDBMaxOpenConns,
+			MaxIdleConns:    defaultDBMaxIdleConns,
+			ConnMaxLifetime: defaultDBConnMaxLifetime,
+			ConnMaxIdleTime: defaultDBConnMaxIdleTime,
+			QueryTimeout:    defaultDBQueryTimeout,
+			ConnectAttempts: defaultDBConnectAttempts,
+			ConnectBackoff:  defaultDBConnectBackoff,
+		},
+		Auth: AuthConfig{
+			Algorithm:         algHS256,
+			KeyReloadInterval: defaultKeyReloadInterval,
+		},
+		MFA: MFAConfig{
+			TOTPIssuer: "secure-service",
+		},
+		Mail: MailConfig{
+			Mailer:   "log",
+			File:     "mail.jsonl",
+			SMTPPort: "587",
+			From:     "no-reply@localhost",
+		},
+		Account: AccountConfig{
+			DeletionMode: "soft",
+		},
+		Login: LoginConfig{
+			MaxAccountFailures: 5,
+			MaxIPFailures:      50,
+			MaxMFAFailures:     5,
+			FailureWindow:      15 * time.Minute,
+			LockoutDuration:    15 * time.Minute,
+			BaseDelay:          250 * time.Millisecond,
+			MaxDelay:           5 * time.Second,
+		},
+		RateLimit: RateLimitConfig{
+			Store:  "memory",
+			Routes: make(map[string]*string, len(defaultRateLimits)),
+		},
+		OAuth: OAuthConfig{
+			AccessTokenTTL: time.Hour,
+		},
+		WebAuthn: WebAuthnConfig{
+			RPName:      "Secure Service",
+			Attestation: "none",
+		},
+		sources: make(map[string]string),
+	}
+	for route, rule := range defaultRateLimits {
+		rule := rule
+		cfg.RateLimit.Routes[route] = &rule
+	}
+	return cfg
+}
+
+// LoadConfig собирает настройки из всех источников. args - аргументы командной строки
+// без имени программы; возвращаются аргументы после флагов (подкоманда).
+// Ошибки разбора и проверки всех настроек возвращаются вместе (errors.Join)
+func LoadConfig(args []string) (*Config, []string, error) {
+	cfg := defaultConfig()
+	fields := cfg.fields()
+
+	// 1. Флаги разбираются первыми, чтобы узнать путь к файлу, но применяются последними.
+	// Секреты флагами не задаются: аргументы процесса видны в ps
+	flags := flag.NewFlagSet("secure-service", flag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "config file (.yaml, .yml or .toml)")
+	flagValues := make(map[string]string)
+	for _, f := range fields {
+		if f.redact != nil {
+			continue
+		}
+		f := f
+		flags.Func(f.flagName(), f.usage+" ("+f.env+")", func(value string) error {
+			flagValues[f.env] = value
+			return nil
+		})
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	var errs []error
+
+	// 2. Файл конфигурации
+	var fileValues map[string]string
+	if *configFile != "" {
+		values, err := parseConfigFile(*configFile)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		fileValues = values
+	}
+
+	// 3. Переменные окружения (в том числе из .env), 4. флаги
+	apply := func(fields []configField) {
+		for _, f := range fields {
+			if value, ok := fileValues[f.key()]; ok {
+				errs = append(errs, cfg.set(f, value, configSourceFile, *configFile+": "+f.key()))
+			}
+		}
+		for _, f := range fields {
+			if value := os.Getenv(f.env); value != "" {
+				errs = append(errs, cfg.set(f, value, configSourceEnv, f.env))
+			}
+		}
+		for _, f := range fields {
+			if value, ok := flagValues[f.env]; ok {
+				errs = append(errs, cfg.set(f, value, configSourceFlag, "-"+f.flagName()))
+			}
+		}
+	}
+	apply(fields)
+
+	// Настройки OIDC провайдеров известны только после OIDC_PROVIDERS
+	errs = append(errs, cfg.addOIDCProviders())
+	apply(cfg.oidcProviderFields())
+
+	known := make(map[string]bool)
+	for _, f := range cfg.fields() {
+		known[f.key()] = true
+	}
+	for key := range fileValues {
+		if !known[key] {
+			errs = append(errs, fmt.Errorf("%s: unknown setting %q", *configFile, key))
+		}
+	}
+
+	cfg.setDerivedDefaults()
+	errs = append(errs, cfg.Validate())
+	if err := errors.Join(errs...); err != nil {
+		return nil, nil, err
+	}
+	return cfg, flags.Args(), nil
+}
+
+// setDerivedDefaults задает значения по умолчанию, которые зависят от других настроек:
+// адрес сервиса, ссылки в письмах, RP ID и origin WebAuthn, redirect URL провайдеров OIDC
+func (c *Config) setDerivedDefaults() {
+	if c.Server.BaseURL == "" {
+		c.Server.BaseURL = "http://localhost:" + c.Server.Port
+	}
+	baseURL := strings.TrimRight(c.Server.BaseURL, "/")
+	if c.Account.PasswordResetURL == "" {
+		c.Account.PasswordResetURL = baseURL + "/password/reset"
+	}
+	if c.Account.MagicLinkURL == "" {
+		c.Account.MagicLinkURL = baseURL + "/login/magic/callback"
+	}
+	for _, name := range c.OIDC.Providers {
+		if p := c.OIDC.Provider[name]; p.RedirectURL == "" {
+			p.RedirectURL = baseURL + "/oidc/" + name + "/callback"
+		}
+	}
+
+	if base, err := url.Parse(c.Server.BaseURL); err == nil && base.Host != "" {
+		if c.WebAuthn.RPID == "" {
+			c.WebAuthn.RPID = base.Hostname()
+		}
+		if len(c.WebAuthn.Origins) == 0 {
+			c.WebAuthn.Origins = []string{base.Scheme + "://" + base.Host}
+		}
+	}
+	c.WebAuthn.RPID = strings.ToLower(c.WebAuthn.RPID)
+	for i, origin := range c.WebAuthn.Origins {
+		c.WebAuthn.Origins[i] = strings.TrimRight(origin, "/")
+	}
+}
+
+// set разбирает значение настройки и запоминает источник. name - как настройка названа в ошибке
+func (c *Config) set(f configField, value, source, name string) error {
+	switch target := f.value.(type) {
+	case *string:
+		*target = value
+	case *int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s: invalid integer %q", name, value)
+		}
+		*target = n
+	case *bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s: invalid boolean %q", name, value)
+		}
+		*target = b
+	case *[]string:
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		*target = list
+	case *time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%s: invalid duration %q", name, value)
+		}
+		*target = d
+	default:
+		return fmt.Errorf("%s: unsupported setting type %T", name, f.value)
+	}
+	c.sources[f.env] = source
+	return nil
+}
+
+// Validate проверяет все настройки и возвращает все найденные ошибки разом
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	// HTTP сервер
+	port, err := strconv.Atoi(c.Server.Port)
+	check(err == nil && port > 0 && port <= 65535, "SERVER_PORT must be a port number, got %q", c.Server.Port)
+	check(isHTTPURL(c.Server.BaseURL),
+		"APP_BASE_URL must be an absolute http(s) URL, got %q", c.Server.BaseURL)
+	check(c.Server.ReadHeaderTimeout >= 0, "SERVER_READ_HEADER_TIMEOUT must not be negative")
+	check(c.Server.ReadTimeout >= 0, "SERVER_READ_TIMEOUT must not be negative")
+	check(c.Server.WriteTimeout >= 0, "SERVER_WRITE_TIMEOUT must not be negative")
+	check(c.Server.IdleTimeout >= 0, "SERVER_IDLE_TIMEOUT must not be negative")
+
+	// База данных
+	d := c.Database
+	if d.URL == "" {
+		check(d.Host != "", "DB_HOST is required")
+		check(d.Name != "", "DB_NAME is required")
+		check(dbSSLModes[d.SSLMode], "DB_SSLMODE must be disable, require, verify-ca or verify-full, got %q", d.SSLMode)
+		if d.SSLRootCert != "" {
+			_, err := os.Stat(d.SSLRootCert)
+			check(err == nil, "DB_SSLROOTCERT: %v", err)
+		}
+	}
+	check(d.MaxOpenConns >= 0, "DB_MAX_OPEN_CONNS must not be negative")
+	check(d.MaxIdleConns >= 0, "DB_MAX_IDLE_CONNS must not be negative")
+	check(d.MaxOpenConns == 0 || d.MaxIdleConns <= d.MaxOpenConns,
+		"DB_MAX_IDLE_CONNS (%d) must not exceed DB_MAX_OPEN_CONNS (%d)", d.MaxIdleConns, d.MaxOpenConns)
+	check(d.ConnMaxLifetime >= 0, "DB_CONN_MAX_LIFETIME must not be negative")
+	check(d.ConnMaxIdleTime >= 0, "DB_CONN_MAX_IDLE_TIME must not be negative")
+	check(d.QueryTimeout >= 0, "DB_QUERY_TIMEOUT must not be negative")
+	check(d.ConnectAttempts >= 1, "DB_CONNECT_ATTEMPTS must be at least 1")
+	check(d.ConnectBackoff > 0, "DB_CONNECT_BACKOFF must be positive")
+
+	// Ключи подписи JWT (из JWT_KEY_DIR алгоритм определяется по файлам)
+	a := c.Auth
+	if a.KeyDir == "" {
+		switch a.Algorithm {
+		case algHS256:
+			check(len(a.Secret) >= 32, "JWT_SECRET must be at least 32 characters long")
+		case algRS256, algES256, algEdDSA:
+			check(a.PrivateKeyFile != "", "JWT_PRIVATE_KEY_FILE is required for %s", a.Algorithm)
+		default:
+			check(false, "JWT_ALGORITHM must be HS256, RS256, ES256 or EdDSA, got %q", a.Algorithm)
+		}
+	}
+	check(a.KeyReloadInterval >= 0, "JWT_KEY_RELOAD_INTERVAL must not be negative")
+
+	// Прокси и второй фактор
+	check(c.Server.TrustedProxyCount >= 1, "TRUSTED_PROXY_COUNT must be at least 1, got %d", c.Server.TrustedProxyCount)
+	if c.MFA.EncryptionKey != "" {
+		key, err := base64.StdEncoding.DecodeString(c.MFA.EncryptionKey)
+		check(err == nil, "MFA_ENCRYPTION_KEY must be base64")
+		check(err != nil || len(key) == 32, "MFA_ENCRYPTION_KEY must be 32 bytes long, got %d", len(key))
+	}
+
+	// Почта и аккаунты
+	switch c.Mail.Mailer {
+	case "smtp":
+		check(c.Mail.SMTPHost != "", "SMTP_HOST is required for MAILER=smtp")
+	case "file":
+		check(c.Mail.File != "", "MAIL_FILE is required for MAILER=file")
+	case "log":
+	default:
+		check(false, "MAILER must be smtp, file or log, got %q", c.Mail.Mailer)
+	}
+	check(c.Account.DeletionMode == "soft" || c.Account.DeletionMode == "hard",
+		"ACCOUNT_DELETION_MODE must be soft or hard, got %q", c.Account.DeletionMode)
+	check(isHTTPURL(c.Account.PasswordResetURL), "PASSWORD_RESET_URL must be an absolute http(s) URL, got %q", c.Account.PasswordResetURL)
+	check(isHTTPURL(c.Account.MagicLinkURL), "MAGIC_LINK_URL must be an absolute http(s) URL, got %q", c.Account.MagicLinkURL)
+
+	// Защита входа: тихий возврат к значениям по умолчанию мог бы незаметно ослабить защиту
+	l := c.Login
+	check(l.MaxAccountFailures >= 0, "LOGIN_MAX_FAILURES must not be negative")
+	check(l.MaxIPFailures >= 0, "LOGIN_IP_MAX_FAILURES must not be negative")
+	check(l.MaxMFAFailures >= 0, "LOGIN_MFA_MAX_FAILURES must not be negative")
+	check(l.FailureWindow >= time.Second, "LOGIN_FAILURE_WINDOW must be at least 1s")
+	check(l.LockoutDuration >= time.Second, "LOGIN_LOCKOUT_DURATION must be at least 1s")
+	check(l.BaseDelay >= 0, "LOGIN_DELAY_BASE must not be negative")
+	check(l.MaxDelay >= l.BaseDelay, "LOGIN_DELAY_MAX (%s) must not be less than LOGIN_DELAY_BASE (%s)", l.MaxDelay, l.BaseDelay)
+
+	// Ограничение частоты запросов
+	check(c.RateLimit.Store == "memory" || c.RateLimit.Store == "postgres",
+		"RATE_LIMIT_STORE must be memory or postgres, got %q", c.RateLimit.Store)
+	for _, route := range sortedKeys(c.RateLimit.Routes) {
+		_, err := parseRateLimitRule(*c.RateLimit.Routes[route])
+		check(err == nil, "%s: %v", rateLimitEnv(route), err)
+	}
+
+	// OAuth: отзыв токена хранится не дольше maxTokenLifetime
+	check(c.OAuth.AccessTokenTTL > 0 && c.OAuth.AccessTokenTTL <= maxTokenLifetime,
+		"OAUTH_ACCESS_TOKEN_TTL must be positive and at most %s, got %s", maxTokenLifetime, c.OAuth.AccessTokenTTL)
+
+	// WebAuthn: RP ID должен совпадать с доменом страницы или быть его родительским доменом
+	w := c.WebAuthn
+	check(w.RPID != "", "WEBAUTHN_RP_ID is required")
+	check(w.Attestation == "none" || w.Attestation == "direct", "WEBAUTHN_ATTESTATION must be none or direct, got %q", w.Attestation)
+	for _, origin := range w.Origins {
+		u, err := url.Parse(origin)
+		if err != nil || u.Host == "" || u.Path != "" {
+			check(false, "WEBAUTHN_ORIGINS: invalid origin %q", origin)
+			continue
+		}
+		host := strings.ToLower(u.Hostname())
+		check(host == w.RPID || strings.HasSuffix(host, "."+w.RPID),
+			"WEBAUTHN_ORIGINS: origin %q does not belong to RP ID %q", origin, w.RPID)
+	}
+
+	// OIDC провайдеры
+	for _, name := range c.OIDC.Providers {
+		p, prefix := c.OIDC.Provider[name], oidcProviderEnvPrefix(name)
+		check(p.Issuer != "", "%sISSUER is required", prefix)
+		check(p.ClientID != "", "%sCLIENT_ID is required", prefix)
+		check(isHTTPURL(p.RedirectURL), "%sREDIRECT_URL must be an absolute http(s) URL, got %q", prefix, p.RedirectURL)
+	}
+
+	return errors.Join(errs...)
+}
+
+// isHTTPURL проверяет, что value - абсолютный http(s) URL
+func isHTTPURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// Print выводит действующие настройки и их источники. Секреты скрываются
+func (c *Config) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SETTING\tVALUE\tSOURCE")
+	for _, f := range c.fields() {
+		value := formatConfigValue(f.value)
+		if f.redact != nil {
+			value = f.redact(value)
+		}
+		source := c.sources[f.env]
+		if source == "" {
+			source = configSourceDefault
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", f.key(), value, source)
+	}
+	return tw.Flush()
+}
+
+// formatConfigValue преобразует значение поля Config в строку
+func formatConfigValue(value interface{}) string {
+	switch v := value.(type) {
+	case *string:
+		return *v
+	case *int:
+		return strconv.Itoa(*v)
+	case *bool:
+		return strconv.FormatBool(*v)
+	case *[]string:
+		return strings.Join(*v, ",")
+	case *time.Duration:
+		return v.String()
+	}
+	return fmt.Sprint(value)
+}
+
+// redactSecret скрывает секрет целиком; пустое значение показывается как есть
+func redactSecret(value string) string {
+	if value == "" {
+		return ""
+	}
+	return "******"
+}
+
+// redactDSN скрывает пароль в строке подключения. URL выводится без пароля,
+// строка "ключ=значение" с паролем скрывается целиком
+func redactDSN(value string) string {
+	if u, err := url.Parse(value); err == nil && u.Scheme != "" {
+		return u.Redacted()
+	}
+	if strings.Contains(value, "password") {
+		return redactSecret(value)
+	}
+	return value
+}
+
+// parseConfigFile читает плоский файл настроек: YAML ("ключ: значение") или TOML ("ключ = значение").
+// Формат определяется по расширению; вложенные секции не поддерживаются
+func parseConfigFile(path string) (map[string]string, error) {
+	var separator string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		separator = ":"
+	case ".toml":
+		separator = "="
+	default:
+		return nil, fmt.Errorf("config file %s: expected .yaml, .yml or .toml extension", path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	values := make(map[string]string)
+	var errs []error
+	for i, line := range strings.Split(string(data), "\n") {
+		lineErr := func(format string, args ...interface{}) {
+			errs = append(errs, fmt.Errorf("%s:%d: %s", path, i+1, fmt.Sprintf(format, args...)))
+		}
+
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || (separator == ":" && trimmed == "---") {
+			continue
+		}
+		if line != strings.TrimLeft(line, " \t") || strings.HasPrefix(trimmed, "[") {
+			lineErr("nested settings are not supported")
+			continue
+		}
+
+		key, raw, ok := strings.Cut(trimmed, separator)
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			lineErr("expected key%svalue", separator)
+			continue
+		}
+		value, err := parseConfigValue(strings.TrimSpace(raw))
+		if err != nil {
+			lineErr("%s: %v", key, err)
+			continue
+		}
+		if _, dup := values[key]; dup {
+			lineErr("duplicate setting %q", key)
+			continue
+		}
+		values[key] = value
+	}
+	return values, errors.Join(errs...)
+}
+
+// parseConfigValue снимает кавычки ("..." с экранированием или '...' без него)
+// и комментарий в конце строки
+func parseConfigValue(raw string) (string, error) {
+	var value, rest string
+	switch {
+	case strings.HasPrefix(raw, `"`):
+		end := 1
+		for end < len(raw) && raw[end] != '"' {
+			if raw[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(raw) {
+			return "", errors.New("unterminated string")
+		}
+		unquoted, err := strconv.Unquote(raw[:end+1])
+		if err != nil {
+			return "", fmt.Errorf("invalid string %s", raw[:end+1])
+		}
+		value, rest = unquoted, raw[end+1:]
+	case strings.HasPrefix(raw, `'`):
+		end := strings.Index(raw[1:], `'`)
+		if end < 0 {
+			return "", errors.New("unterminated string")
+		}
+		value, rest = raw[1:end+1], raw[end+2:]
+	default:
+		value = raw
+		if i := strings.Index(raw, " #"); i >= 0 {
+			value = strings.TrimSpace(raw[:i])
+		}
+		return value, nil
+	}
+
+	if rest = strings.TrimSpace(rest); rest != "" && !strings.HasPrefix(rest, "#") {
+		return "", fmt.Errorf("unexpected %q after string", rest)
+	}
+	return value, nil
+}
+
+// runConfigCommand выполняет подкоманду "config": выводит действующие настройки без секретов
+func runConfigCommand(cfg *Config, args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: config")
+	}
+	return cfg.Print(os.Stdout)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testJWTSecret секрет HS256, без которого конфигурация не проходит проверку
+var testJWTSecret = strings.Repeat("config-secret-", 3)
+
+func TestLoadConfigReportsAllErrors(t *testing.T) {
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	invalid := map[string]string{
+		"TRUST_PROXY_HEADERS":    "yes please",
+		"LOGIN_FAILURE_WINDOW":   "15",
+		"RATE_LIMIT_LOGIN":       "ten/1m",
+		"OAUTH_ACCESS_TOKEN_TTL": "48h",
+		"MFA_ENCRYPTION_KEY":     base64.StdEncoding.EncodeToString([]byte("too short")),
+		"MAILER":                 "pigeon",
+		"WEBAUTHN_ORIGINS":       "https://evil.example",
+		"OIDC_PROVIDERS":         "corp",
+	}
+	for key, value := range invalid {
+		t.Setenv(key, value)
+	}
+
+	_, _, err := LoadConfig(nil)
+	if err == nil {
+		t.Fatal("LoadConfig accepted invalid settings")
+	}
+	// Неверные значения - ошибка, а не тихий возврат к значению по умолчанию
+	for key := range invalid {
+		if key == "OIDC_PROVIDERS" {
+			key = "OIDC_CORP_ISSUER"
+		}
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("error does not mention %s:\n%v", key, err)
+		}
+	}
+}
+
+func TestLoadConfigResolvesComponentSettings(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	env := map[string]string{
+		"JWT_SECRET":                  testJWTSecret,
+		"APP_BASE_URL":                "https://auth.example.com/",
+		"MFA_ENCRYPTION_KEY":          key,
+		"SMTP_PASSWORD":               "smtp-password-value",
+		"EMAIL_VERIFICATION_REQUIRED": "true",
+		"RATE_LIMIT_LOGIN":            "off",
+		"LOGIN_DELAY_MAX":             "10s",
+		"OIDC_PROVIDERS":              "corp",
+		"OIDC_CORP_ISSUER":            "https://idp.example.com",
+		"OIDC_CORP_CLIENT_ID":         "service",
+		"OIDC_CORP_CLIENT_SECRET":     "oidc-secret-value",
+	}
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+
+	cfg, _, err := LoadConfig([]string{"-trusted-proxy-count", "2"})
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if !cfg.Account.EmailVerificationRequired || cfg.Login.MaxDelay != 10*time.Second || cfg.Server.TrustedProxyCount != 2 {
+		t.Fatalf("settings not applied: account %+v, login %+v, server %+v", cfg.Account, cfg.Login, cfg.Server)
+	}
+	if *cfg.RateLimit.Routes["login"] != "off" || *cfg.RateLimit.Routes["register"] != defaultRateLimits["register"] {
+		t.Fatalf("rate limits = login %q, register %q", *cfg.RateLimit.Routes["login"], *cfg.RateLimit.Routes["register"])
+	}
+
+	// Значения по умолчанию, зависящие от APP_BASE_URL
+	if cfg.Account.MagicLinkURL != "https://auth.example.com/login/magic/callback" {
+		t.Errorf("MagicLinkURL = %q", cfg.Account.MagicLinkURL)
+	}
+	if cfg.WebAuthn.RPID != "auth.example.com" || len(cfg.WebAuthn.Origins) != 1 || cfg.WebAuthn.Origins[0] != "https://auth.example.com" {
+		t.Errorf("WebAuthn = %+v", cfg.WebAuthn)
+	}
+	corp := cfg.OIDC.Provider["corp"]
+	if corp == nil || corp.RedirectURL != "https://auth.example.com/oidc/corp/callback" || !corp.LinkByEmail || corp.ClientSecret != "oidc-secret-value" {
+		t.Fatalf("OIDC provider corp = %+v", corp)
+	}
+
+	// Секреты не выводятся
+	var out bytes.Buffer
+	if err := cfg.Print(&out); err != nil {
+		t.Fatalf("Print: %v", err)
+	}
+	for _, secret := range []string{key, "smtp-password-value", "oidc-secret-value", testJWTSecret} {
+		if strings.Contains(out.String(), secret) {
+			t.Errorf("Print shows secret %q:\n%s", secret, out.String())
+		}
+	}
+	for _, setting := range []string{"mfa_encryption_key", "smtp_password", "oidc_corp_client_secret"} {
+		if !strings.Contains(out.String(), setting) {
+			t.Errorf("Print does not list %s", setting)
+		}
+	}
+
+	// Секреты не задаются флагами: аргументы процесса видны в ps
+	if _, _, err := LoadConfig([]string{"-smtp-password", "x"}); err == nil {
+		t.Fatal("-smtp-password flag accepted")
+	}
+}
+
+func TestLoadConfigFileWithOIDCProvider(t *testing.T) {
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	file := "oidc_providers: corp\n" +
+		"oidc_corp_issuer: https://idp.example.com\n" +
+		"oidc_corp_client_id: service\n" +
+		"oidc_corp_link_by_email: false\n" +
+		"oidc_other_client_id: unused\n"
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err := LoadConfig([]string{"-config", path})
+	if err == nil || !strings.Contains(err.Error(), `unknown setting "oidc_other_client_id"`) {
+		t.Fatalf("LoadConfig error = %v, want unknown oidc_other_client_id", err)
+	}
+	if strings.Contains(err.Error(), "oidc_corp") {
+		t.Fatalf("settings of a configured provider reported as unknown: %v", err)
+	}
+}
+
+func TestRateLimitUsesParsedRules(t *testing.T) {
+	previousLimiter, previousRules, previousRule := rateLimiter, rateLimitRules, magicLinkEmailLimit
+	t.Cleanup(func() {
+		rateLimiter, rateLimitRules, magicLinkEmailLimit = previousLimiter, previousRules, previousRule
+	})
+
+	cfg := defaultConfig()
+	off := "off"
+	cfg.RateLimit.Routes["login"] = &off
+	if err := InitRateLimiter(cfg.RateLimit); err != nil {
+		t.Fatalf("InitRateLimiter: %v", err)
+	}
+	if rateLimitRules["login"] != nil || rateLimitRules["register"] == nil || magicLinkEmailLimit == nil {
+		t.Fatalf("rules = %v, magic link limit %v", rateLimitRules, magicLinkEmailLimit)
+	}
+
+	invalid := "10/forever"
+	cfg.RateLimit.Routes["profile"] = &invalid
+	if err := InitRateLimiter(cfg.RateLimit); err == nil || !strings.Contains(err.Error(), "RATE_LIMIT_PROFILE") {
+		t.Fatalf("InitRateLimiter with an invalid rule = %v", err)
+	}
+}
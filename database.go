@@ -1,17 +1,53 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 // Глобальная переменная для подключения к БД
 var db *sql.DB
 
+// defaultDBQueryTimeout ограничение времени одного запроса по умолчанию
+const defaultDBQueryTimeout = 5 * time.Second
+
+// dbQueryTimeout ограничение времени каждого запроса (DB_QUERY_TIMEOUT), 0 - без ограничения
+var dbQueryTimeout = defaultDBQueryTimeout
+
+// Настройки пула соединений и подключения при старте по умолчанию
+const (
+	defaultDBMaxOpenConns    = 25
+	defaultDBMaxIdleConns    = 5
+	defaultDBConnMaxLifetime = 30 * time.Minute
+	defaultDBConnMaxIdleTime = 5 * time.Minute
+	defaultDBConnectAttempts = 5
+	defaultDBConnectBackoff  = 500 * time.Millisecond
+	// maxDBConnectBackoff предел задержки между попытками подключения
+	maxDBConnectBackoff = 30 * time.Second
+)
+
+// dbSSLModes режимы TLS (DB_SSLMODE), которые поддерживает lib/pq
+var dbSSLModes = map[string]bool{"disable": true, "require": true, "verify-ca": true, "verify-full": true}
+
+// dbPing и dbSleep проверка подключения и пауза между попытками в pingWithRetry.
+// Тесты подменяют их, чтобы проверить расписание попыток без сервера и ожидания
+var (
+	dbPing  = func(ctx context.Context) error { return db.PingContext(ctx) }
+	dbSleep = time.Sleep
+)
+
 // InitDB инициализирует подключение к базе данных
-func InitDB() error {
+func InitDB(cfg DatabaseConfig) error {
 	// TODO: Реализуйте подключение к PostgreSQL
 	//
 	// Что нужно сделать:
@@ -24,27 +60,132 @@ func InitDB() error {
 	//
 	// Переменные окружения: DB_HOST, DB_PORT, DB_USER, DB_PASSWORD, DB_NAME
 
-	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		getEnv("DB_HOST", "localhost"),
-		getEnv("DB_PORT", "5432"),
-		getEnv("DB_USER", "postgres"),
-		getEnv("DB_PASSWORD", "postgres"),
-		getEnv("DB_NAME", "secure_service"),
-	)
-
 	var err error
-	db, err = sql.Open("postgres", connStr)
+	db, err = sql.Open("postgres", databaseDSN(cfg))
 	if err != nil {
 		return fmt.Errorf("failed to open database: %v", err)
 	}
 
-	if err := db.Ping(); err != nil {
-		return fmt.Errorf("failed to ping database: %v", err)
+	// Пул соединений: без ограничения под нагрузкой открывается соединение на каждый запрос
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	dbQueryTimeout = cfg.QueryTimeout
+
+	if err := pingWithRetry(cfg.ConnectAttempts, cfg.ConnectBackoff); err != nil {
+		db.Close()
+		return err
 	}
 
 	return nil
 }
 
+// databaseDSN возвращает строку подключения: DATABASE_URL как есть
+// или собранную из отдельных настроек (адрес, учетные данные, режим TLS).
+// Настройки уже проверены Config.Validate
+func databaseDSN(cfg DatabaseConfig) string {
+	if cfg.URL != "" {
+		return cfg.URL
+	}
+
+	params := []string{
+		"host=" + dsnValue(cfg.Host),
+		"port=" + dsnValue(cfg.Port),
+		"user=" + dsnValue(cfg.User),
+		"password=" + dsnValue(cfg.Password),
+		"dbname=" + dsnValue(cfg.Name),
+		"sslmode=" + cfg.SSLMode,
+	}
+	if cfg.SSLRootCert != "" {
+		params = append(params, "sslrootcert="+dsnValue(cfg.SSLRootCert))
+	}
+	return strings.Join(params, " ")
+}
+
+// dsnValue экранирует значение для строки подключения "ключ=значение":
+// пустые значения и значения с пробелами, кавычками или \ заключаются в кавычки
+func dsnValue(value string) string {
+	if value != "" && !strings.ContainsAny(value, ` '\`) {
+		return value
+	}
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `'`, `\'`)
+	return "'" + value + "'"
+}
+
+// pingWithRetry проверяет подключение, повторяя попытки с экспоненциальной задержкой:
+// при одновременном запуске (docker-compose, Kubernetes) база может быть еще не готова.
+// Ошибки, которые вернул сам сервер (неверный пароль, нет базы), не повторяются
+func pingWithRetry(attempts int, backoff time.Duration) error {
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := withQueryTimeout(context.Background())
+		err := dbPing(ctx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+
+		var pqErr *pq.Error
+		// 57P03 cannot_connect_now - сервер еще запускается
+		permanent := errors.As(err, &pqErr) && pqErr.Code != "57P03"
+		if permanent || attempt >= attempts {
+			return fmt.Errorf("failed to ping database after %d attempt(s): %w", attempt, err)
+		}
+
+		log.Printf("Database is not ready (attempt %d/%d): %v; retrying in %s", attempt, attempts, err, backoff)
+		dbSleep(backoff)
+		backoff *= 2
+		if backoff > maxDBConnectBackoff {
+			backoff = maxDBConnectBackoff
+		}
+	}
+}
+
+// DBPoolStats статистика пула соединений для мониторинга
+func DBPoolStats() map[string]interface{} {
+	stats := db.Stats()
+	return map[string]interface{}{
+		"max_open_connections": stats.MaxOpenConnections,
+		"open_connections":     stats.OpenConnections,
+		"in_use":               stats.InUse,
+		"idle":                 stats.Idle,
+		"wait_count":           stats.WaitCount,
+		"wait_duration_ms":     stats.WaitDuration.Milliseconds(),
+		"max_idle_closed":      stats.MaxIdleClosed,
+		"max_idle_time_closed": stats.MaxIdleTimeClosed,
+		"max_lifetime_closed":  stats.MaxLifetimeClosed,
+		"query_timeout_ms":     dbQueryTimeout.Milliseconds(),
+	}
+}
+
+// withQueryTimeout ограничивает запрос временем DB_QUERY_TIMEOUT. Отмена родительского
+// контекста (клиент закрыл соединение) тоже прерывает запрос
+func withQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if dbQueryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, dbQueryTimeout)
+}
+
+// isQueryTimeout сообщает, что запрос не успел выполниться за отведенное время.
+// database/sql возвращает context.DeadlineExceeded, а если запрос уже был отправлен,
+// PostgreSQL отвечает ошибкой 57014 (query_canceled)
+func isQueryTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "57014"
+}
+
+// isDatabaseUnavailable сообщает, что до базы не удалось достучаться
+func isDatabaseUnavailable(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) || errors.As(err, &netErr)
+}
+
 // CloseDB закрывает соединение с базой данных
 func CloseDB() {
 	if db != nil {
@@ -52,15 +193,28 @@ func CloseDB() {
 	}
 }
 
+// PostgresUserStore хранит пользователей в таблице users
+type PostgresUserStore struct {
+	db *sql.DB
+}
+
+// NewPostgresUserStore создает хранилище поверх открытого подключения
+func NewPostgresUserStore(db *sql.DB) *PostgresUserStore {
+	return &PostgresUserStore{db: db}
+}
+
 // CreateUser создает нового пользователя в базе данных
-func CreateUser(email, username, passwordHash string) (*User, error) {
+func (s *PostgresUserStore) CreateUser(ctx context.Context, email, username, passwordHash string) (*User, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
 	// TODO: Реализуйте создание пользователя
 	// КРИТИЧЕСКИ ВАЖНО: Используйте параметризованный запрос для защиты от SQL-инъекций!
 	//
 	// Что нужно сделать:
 	// 1. Создайте SQL запрос с плейсхолдерами $1, $2, $3
 	//    INSERT INTO users (email, username, password_hash) VALUES ($1, $2, $3) RETURNING id, created_at
-	// 2. Выполните запрос с db.QueryRow(query, email, username, passwordHash)
+	// 2. Выполните запрос с db.QueryRowContext(ctx, query, email, username, passwordHash)
 	// 3. Считайте результат в переменные user.ID и user.CreatedAt
 	// 4. Заполните остальные поля структуры User
 	// 5. Обработайте ошибки
@@ -75,10 +229,13 @@ func CreateUser(email, username, passwordHash string) (*User, error) {
     `
 	// Инициализируем структуру User
 	user := &User{}
-	// 2. Выполняем запрос с db.QueryRow(query, email, username, passwordHash)
+	// 2. Выполняем запрос с db.QueryRowContext(ctx, query, email, username, passwordHash)
 	// 3. Считываем результат в переменные user.ID и user.CreatedAt
-	err := db.QueryRow(query, email, username, passwordHash).Scan(&user.ID, &user.CreatedAt)
+	err := s.db.QueryRowContext(ctx, query, email, username, passwordHash).Scan(&user.ID, &user.CreatedAt)
 	// 5. Обрабатываем ошибки
+	if field := uniqueViolationField(err); field != "" {
+		return nil, &DuplicateError{Field: field}
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to create user: %w", err)
 	}
@@ -91,14 +248,17 @@ func CreateUser(email, username, passwordHash string) (*User, error) {
 }
 
 // GetUserByEmail находит пользователя по email
-func GetUserByEmail(email string) (*User, error) {
+func (s *PostgresUserStore) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
 	// TODO: Реализуйте поиск пользователя по email
 	// КРИТИЧЕСКИ ВАЖНО: Используйте параметризованный запрос!
 	//
 	// Что нужно сделать:
 	// 1. Создайте SQL запрос с плейсхолдером $1
 	//    SELECT id, email, username, password_hash, created_at FROM users WHERE email = $1
-	// 2. Выполните запрос с db.QueryRow(query, email)
+	// 2. Выполните запрос с db.QueryRowContext(ctx, query, email)
 	// 3. Считайте все поля в структуру User с помощью Scan()
 	// 4. Обработайте случай sql.ErrNoRows (пользователь не найден)
 	//
@@ -106,27 +266,32 @@ func GetUserByEmail(email string) (*User, error) {
 
 	// 1. Создаем SQL запрос с плейсхолдером $1
 	query := `
-        SELECT id, email, username, password_hash, created_at 
+        SELECT id, email, username, password_hash, totp_enabled, email_verified_at,
+               locked_at, password_reset_required, created_at
         FROM users 
-        WHERE email = $1
+        WHERE email = $1 AND deleted_at IS NULL
     `
 	// Инициализируем структуру User
 	user := &User{}
 
-	// 2. Выполняем запрос с db.QueryRow(query, email)
+	// 2. Выполняем запрос с db.QueryRowContext(ctx, query, email)
 	// 3. Считываем все поля в структуру User с помощью Scan()
-	err := db.QueryRow(query, email).Scan(
+	err := s.db.QueryRowContext(ctx, query, email).Scan(
 		&user.ID,
 		&user.Email,
 		&user.Username,
 		&user.PasswordHash,
+		&user.TOTPEnabled,
+		&user.EmailVerifiedAt,
+		&user.LockedAt,
+		&user.PasswordResetRequired,
 		&user.CreatedAt,
 	)
 
 	if err != nil {
 		// 4. Обрабатываем случай sql.ErrNoRows (пользователь не найден)
 		if err == sql.ErrNoRows {
-			return nil, nil
+			return nil, ErrNotFound
 		}
 		return nil, fmt.Errorf("failed to get user by email: %w", err)
 	}
@@ -135,7 +300,10 @@ func GetUserByEmail(email string) (*User, error) {
 }
 
 // GetUserByID находит пользователя по ID
-func GetUserByID(userID int) (*User, error) {
+func (s *PostgresUserStore) GetUserByID(ctx context.Context, userID int) (*User, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
 	// TODO: Реализуйте поиск пользователя по ID
 	// КРИТИЧЕСКИ ВАЖНО: Используйте параметризованный запрос!
 	//
@@ -148,24 +316,29 @@ func GetUserByID(userID int) (*User, error) {
 
 	// 1. Создаем SQL запрос для поиска по ID
 	query := `
-        SELECT id, email, username, created_at 
+        SELECT id, email, username, totp_enabled, email_verified_at,
+               locked_at, password_reset_required, created_at
         FROM users 
-        WHERE id = $1
+        WHERE id = $1 AND deleted_at IS NULL
     `
 
 	user := &User{}
 	// 3. Выполняем запрос
-	err := db.QueryRow(query, userID).Scan(
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(
 		&user.ID,
 		&user.Email,
 		&user.Username,
+		&user.TOTPEnabled,
+		&user.EmailVerifiedAt,
+		&user.LockedAt,
+		&user.PasswordResetRequired,
 		&user.CreatedAt,
 	)
 
 	// Обрабатываем ошибку
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, nil // Пользователь не найден
+			return nil, ErrNotFound // Пользователь не найден
 		}
 		return nil, fmt.Errorf("failed to get user by ID %d: %w", userID, err)
 	}
@@ -173,8 +346,184 @@ func GetUserByID(userID int) (*User, error) {
 	return user, nil
 }
 
-// UserExistsByEmail проверяет, существует ли пользователь с данным email
-func UserExistsByEmail(email string) (bool, error) {
+// GetPasswordHashByUserID возвращает хеш пароля пользователя (для проверки текущего пароля)
+func (s *PostgresUserStore) GetPasswordHashByUserID(ctx context.Context, userID int) (string, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	var hash string
+	err := s.db.QueryRowContext(ctx, `SELECT password_hash FROM users WHERE id = $1 AND deleted_at IS NULL`, userID).Scan(&hash)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrNotFound
+		}
+		return "", fmt.Errorf("failed to get password hash: %w", err)
+	}
+	return hash, nil
+}
+
+// UpdateUserProfile меняет email и имя пользователя.
+// При смене email отметка о его подтверждении сбрасывается
+func (s *PostgresUserStore) UpdateUserProfile(ctx context.Context, userID int, email, username string) (*User, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        UPDATE users
+        SET email = $2,
+            username = $3,
+            email_verified_at = CASE WHEN email = $2 THEN email_verified_at ELSE NULL END
+        WHERE id = $1 AND deleted_at IS NULL
+        RETURNING id, email, username, totp_enabled, email_verified_at,
+                  locked_at, password_reset_required, created_at
+    `
+
+	user := &User{}
+	err := s.db.QueryRowContext(ctx, query, userID, email, username).Scan(
+		&user.ID,
+		&user.Email,
+		&user.Username,
+		&user.TOTPEnabled,
+		&user.EmailVerifiedAt,
+		&user.LockedAt,
+		&user.PasswordResetRequired,
+		&user.CreatedAt,
+	)
+	if field := uniqueViolationField(err); field != "" {
+		return nil, &DuplicateError{Field: field}
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to update user: %w", err)
+	}
+	return user, nil
+}
+
+// UpdateUserPassword сохраняет новый хеш пароля
+func (s *PostgresUserStore) UpdateUserPassword(ctx context.Context, userID int, passwordHash string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `UPDATE users SET password_hash = $2, password_reset_required = FALSE WHERE id = $1 AND deleted_at IS NULL`
+	res, err := s.db.ExecContext(ctx, query, userID, passwordHash)
+	if err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+	return expectOneRow(res, "failed to update password")
+}
+
+// expectOneRow возвращает ErrNotFound, если запрос не изменил ни одной строки
+func expectOneRow(res sql.Result, message string) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", message, err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SoftDeleteUser помечает пользователя удаленным и обезличивает его: email заменяется
+// на deleted-<id>@invalid, имя, пароль и TOTP стираются. Также аннулирует токены сброса пароля,
+// отвязывает внешние учетные записи и стирает устройства и IP адреса сессий
+func (s *PostgresUserStore) SoftDeleteUser(ctx context.Context, userID int) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Персональные данные обезличиваются: адрес освобождается для новой регистрации,
+	// а строка остается только ради ссылок из журнала аудита
+	query := `
+        UPDATE users SET
+            deleted_at = NOW(),
+            email = 'deleted-' || id || '@invalid',
+            username = NULL,
+            password_hash = '',
+            totp_secret_encrypted = NULL,
+            totp_enabled = FALSE,
+            email_verified_at = NULL
+        WHERE id = $1 AND deleted_at IS NULL
+    `
+	res, err := tx.ExecContext(ctx, query, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	if err := expectOneRow(res, "failed to delete user"); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE sessions SET device = '', user_agent = '', ip = '' WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to anonymize sessions: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE password_reset_tokens SET used_at = NOW() WHERE user_id = $1 AND used_at IS NULL`, userID); err != nil {
+		return fmt.Errorf("failed to invalidate password reset tokens: %w", err)
+	}
+	// Внешние учетные записи отвязываются, чтобы их можно было привязать к новому аккаунту
+	if _, err := tx.ExecContext(ctx, `DELETE FROM identities WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to unlink identities: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE api_keys SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`, userID); err != nil {
+		return fmt.Errorf("failed to revoke API keys: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM webauthn_credentials WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete WebAuthn credentials: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// DeleteUser безвозвратно удаляет пользователя.
+// Связанные записи удаляются каскадно (ON DELETE CASCADE)
+func (s *PostgresUserStore) DeleteUser(ctx context.Context, userID int) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	return expectOneRow(res, "failed to delete user")
+}
+
+// uniqueViolationField возвращает поле ("email", "username" или "identity"), на котором
+// сработало ограничение UNIQUE таблиц users и identities, или пустую строку
+func uniqueViolationField(err error) string {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != "23505" {
+		return ""
+	}
+
+	switch pqErr.Constraint {
+	case "users_email_key":
+		return "email"
+	case "users_username_key":
+		return "username"
+	case "identities_provider_subject_key":
+		return "identity"
+	default:
+		return ""
+	}
+}
+
+// UserExistsByEmail проверяет, существует ли пользователь с данным email.
+// Email мягко удаленных аккаунтов обезличен (см. SoftDeleteUser) и снова свободен
+func (s *PostgresUserStore) UserExistsByEmail(ctx context.Context, email string) (bool, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
 	// TODO: Реализуйте проверку существования пользователя
 	// КРИТИЧЕСКИ ВАЖНО: Используйте параметризованный запрос!
 	//
@@ -191,7 +540,7 @@ func UserExistsByEmail(email string) (bool, error) {
     `
 
 	var ifUserExists bool
-	err := db.QueryRow(query, email).Scan(&ifUserExists)
+	err := s.db.QueryRowContext(ctx, query, email).Scan(&ifUserExists)
 
 	if err != nil {
 		return false, fmt.Errorf("failed to check user exists: %w", err)
@@ -200,7 +549,1671 @@ func UserExistsByEmail(email string) (bool, error) {
 	return ifUserExists, nil
 }
 
-// GetDB возвращает подключение к базе данных (для тестирования)
-func GetDB() *sql.DB {
-	return db
+// CreateRefreshToken сохраняет хеш refresh токена в базе данных
+func CreateRefreshToken(ctx context.Context, userID int, tokenHash, familyID string, expiresAt time.Time) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        INSERT INTO refresh_tokens (user_id, token_hash, family_id, expires_at)
+        VALUES ($1, $2, $3, $4)
+    `
+	if _, err := db.ExecContext(ctx, query, userID, tokenHash, familyID, expiresAt); err != nil {
+		return fmt.Errorf("failed to create refresh token: %w", err)
+	}
+	return nil
+}
+
+// GetRefreshTokenByHash находит refresh токен по его хешу
+func GetRefreshTokenByHash(ctx context.Context, tokenHash string) (*RefreshToken, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        SELECT id, user_id, token_hash, family_id, expires_at, created_at, rotated_at, revoked_at
+        FROM refresh_tokens
+        WHERE token_hash = $1
+    `
+
+	rt := &RefreshToken{}
+	var rotatedAt, revokedAt sql.NullTime
+	err := db.QueryRowContext(ctx, query, tokenHash).Scan(
+		&rt.ID,
+		&rt.UserID,
+		&rt.TokenHash,
+		&rt.FamilyID,
+		&rt.ExpiresAt,
+		&rt.CreatedAt,
+		&rotatedAt,
+		&revokedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // Токен не найден
+		}
+		return nil, fmt.Errorf("failed to get refresh token: %w", err)
+	}
+
+	if rotatedAt.Valid {
+		rt.RotatedAt = &rotatedAt.Time
+	}
+	if revokedAt.Valid {
+		rt.RevokedAt = &revokedAt.Time
+	}
+	return rt, nil
+}
+
+// MarkRefreshTokenRotated помечает refresh токен как использованный.
+// Возвращает false, если токен уже был обменян (например, параллельным запросом)
+func MarkRefreshTokenRotated(ctx context.Context, id int) (bool, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        UPDATE refresh_tokens
+        SET rotated_at = NOW()
+        WHERE id = $1 AND rotated_at IS NULL AND revoked_at IS NULL
+    `
+	res, err := db.ExecContext(ctx, query, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+	return n == 1, nil
+}
+
+// RevokeRefreshTokenFamily отзывает все refresh токены семейства
+func RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        UPDATE refresh_tokens
+        SET revoked_at = NOW()
+        WHERE family_id = $1 AND revoked_at IS NULL
+    `
+	if _, err := db.ExecContext(ctx, query, familyID); err != nil {
+		return fmt.Errorf("failed to revoke refresh token family: %w", err)
+	}
+	return nil
+}
+
+// RevokeUserRefreshTokens отзывает все refresh токены пользователя
+func RevokeUserRefreshTokens(ctx context.Context, userID int) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        UPDATE refresh_tokens
+        SET revoked_at = NOW()
+        WHERE user_id = $1 AND revoked_at IS NULL
+    `
+	if _, err := db.ExecContext(ctx, query, userID); err != nil {
+		return fmt.Errorf("failed to revoke user refresh tokens: %w", err)
+	}
+	return nil
+}
+
+// InsertRevokedToken добавляет jti токена в список отозванных.
+// Возвращает false, если jti уже был в списке
+func InsertRevokedToken(ctx context.Context, jti string, userID int, expiresAt time.Time) (bool, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        INSERT INTO revoked_tokens (jti, user_id, expires_at)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (jti) DO NOTHING
+    `
+	res, err := db.ExecContext(ctx, query, jti, userID, expiresAt)
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke token: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke token: %w", err)
+	}
+	return n == 1, nil
+}
+
+// IsTokenRevoked проверяет, находится ли jti в списке отозванных
+func IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        SELECT EXISTS(SELECT 1 FROM revoked_tokens WHERE jti = $1)
+    `
+
+	var revoked bool
+	if err := db.QueryRowContext(ctx, query, jti).Scan(&revoked); err != nil {
+		return false, fmt.Errorf("failed to check revoked token: %w", err)
+	}
+	return revoked, nil
+}
+
+// SetUserTokensRevokedBefore отзывает все токены пользователя, выданные до cutoff
+func SetUserTokensRevokedBefore(ctx context.Context, userID int, cutoff time.Time) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        INSERT INTO user_token_revocations (user_id, revoked_before)
+        VALUES ($1, $2)
+        ON CONFLICT (user_id) DO UPDATE SET revoked_before = EXCLUDED.revoked_before
+    `
+	if _, err := db.ExecContext(ctx, query, userID, cutoff); err != nil {
+		return fmt.Errorf("failed to revoke user tokens: %w", err)
+	}
+	return nil
+}
+
+// GetUserTokensRevokedBefore возвращает момент отзыва всех токенов пользователя.
+// Нулевое время означает, что отзыва не было
+func GetUserTokensRevokedBefore(ctx context.Context, userID int) (time.Time, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        SELECT revoked_before
+        FROM user_token_revocations
+        WHERE user_id = $1
+    `
+
+	var cutoff time.Time
+	err := db.QueryRowContext(ctx, query, userID).Scan(&cutoff)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return time.Time{}, nil
+		}
+		return time.Time{}, fmt.Errorf("failed to get user token revocation: %w", err)
+	}
+	return cutoff, nil
+}
+
+// DeleteExpiredRevocations удаляет записи об отзыве, которые больше не влияют на проверку:
+// истекшие jti и отметки "выйти везде" старше staleBefore
+func DeleteExpiredRevocations(ctx context.Context, staleBefore time.Time) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	if _, err := db.ExecContext(ctx, `DELETE FROM revoked_tokens WHERE expires_at < NOW()`); err != nil {
+		return fmt.Errorf("failed to prune revoked tokens: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, `DELETE FROM user_token_revocations WHERE revoked_before < $1`, staleBefore); err != nil {
+		return fmt.Errorf("failed to prune user token revocations: %w", err)
+	}
+	return nil
+}
+
+// MarkEmailVerified отмечает email подтвержденным, если он не менялся с момента отправки ссылки.
+// Возвращает false, если адрес пользователя уже другой
+func (s *PostgresUserStore) MarkEmailVerified(ctx context.Context, userID int, email string) (bool, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        UPDATE users
+        SET email_verified_at = COALESCE(email_verified_at, NOW())
+        WHERE id = $1 AND email = $2 AND deleted_at IS NULL
+    `
+	res, err := s.db.ExecContext(ctx, query, userID, email)
+	if err != nil {
+		return false, fmt.Errorf("failed to mark email verified: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to mark email verified: %w", err)
+	}
+	return n == 1, nil
+}
+
+// CreatePasswordResetToken сохраняет хеш токена сброса пароля
+func (s *PostgresUserStore) CreatePasswordResetToken(ctx context.Context, userID int, tokenHash string, expiresAt time.Time) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        INSERT INTO password_reset_tokens (user_id, token_hash, expires_at)
+        VALUES ($1, $2, $3)
+    `
+	if _, err := s.db.ExecContext(ctx, query, userID, tokenHash, expiresAt); err != nil {
+		return fmt.Errorf("failed to create password reset token: %w", err)
+	}
+	return nil
+}
+
+// ResetPasswordWithToken одной транзакцией использует токен сброса и меняет пароль.
+// Остальные неиспользованные токены пользователя аннулируются.
+// Возвращает ID пользователя или 0, если токен не найден, истек или уже использован
+func (s *PostgresUserStore) ResetPasswordWithToken(ctx context.Context, tokenHash, passwordHash string) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+        UPDATE password_reset_tokens
+        SET used_at = NOW()
+        WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+        RETURNING user_id
+    `
+	var userID int
+	if err := tx.QueryRowContext(ctx, query, tokenHash).Scan(&userID); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to use password reset token: %w", err)
+	}
+
+	query = `UPDATE users SET password_hash = $2, password_reset_required = FALSE WHERE id = $1`
+	if _, err := tx.ExecContext(ctx, query, userID, passwordHash); err != nil {
+		return 0, fmt.Errorf("failed to update password: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE password_reset_tokens SET used_at = NOW() WHERE user_id = $1 AND used_at IS NULL`, userID); err != nil {
+		return 0, fmt.Errorf("failed to invalidate password reset tokens: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return userID, nil
+}
+
+// GetTOTPState возвращает состояние TOTP пользователя
+func (s *PostgresUserStore) GetTOTPState(ctx context.Context, userID int) (*TOTPState, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        SELECT COALESCE(totp_secret_encrypted, ''), totp_enabled, totp_last_step
+        FROM users
+        WHERE id = $1 AND deleted_at IS NULL
+    `
+
+	state := &TOTPState{}
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(&state.SecretEncrypted, &state.Enabled, &state.LastStep)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get TOTP state: %w", err)
+	}
+	return state, nil
+}
+
+// SetPendingTOTPSecret сохраняет зашифрованный секрет до подтверждения первым кодом.
+// Возвращает false, если TOTP уже подключен
+func (s *PostgresUserStore) SetPendingTOTPSecret(ctx context.Context, userID int, secretEncrypted string) (bool, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        UPDATE users
+        SET totp_secret_encrypted = $2, totp_last_step = 0
+        WHERE id = $1 AND totp_enabled = FALSE
+    `
+	res, err := s.db.ExecContext(ctx, query, userID, secretEncrypted)
+	if err != nil {
+		return false, fmt.Errorf("failed to store TOTP secret: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to store TOTP secret: %w", err)
+	}
+	return n == 1, nil
+}
+
+// EnableTOTP включает TOTP и заменяет коды восстановления одной транзакцией
+func (s *PostgresUserStore) EnableTOTP(ctx context.Context, userID int, step int64, recoveryCodeHashes []string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+        UPDATE users
+        SET totp_enabled = TRUE, totp_last_step = $2
+        WHERE id = $1 AND totp_enabled = FALSE AND totp_secret_encrypted IS NOT NULL
+    `
+	res, err := tx.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return fmt.Errorf("failed to enable TOTP: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return fmt.Errorf("failed to enable TOTP: enrollment is not pending")
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	for _, hash := range recoveryCodeHashes {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userID, hash); err != nil {
+			return fmt.Errorf("failed to store recovery code: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// AdvanceTOTPStep запоминает использованный временной шаг.
+// Возвращает false, если этот или более поздний шаг уже использован (повтор кода)
+func (s *PostgresUserStore) AdvanceTOTPStep(ctx context.Context, userID int, step int64) (bool, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        UPDATE users
+        SET totp_last_step = $2
+        WHERE id = $1 AND totp_last_step < $2
+    `
+	res, err := s.db.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return false, fmt.Errorf("failed to update TOTP step: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to update TOTP step: %w", err)
+	}
+	return n == 1, nil
+}
+
+// GetUnusedRecoveryCodes возвращает хеши неиспользованных кодов восстановления
+func (s *PostgresUserStore) GetUnusedRecoveryCodes(ctx context.Context, userID int) ([]RecoveryCode, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        SELECT id, code_hash
+        FROM mfa_recovery_codes
+        WHERE user_id = $1 AND used_at IS NULL
+    `
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recovery codes: %w", err)
+	}
+	defer rows.Close()
+
+	var codes []RecoveryCode
+	for rows.Next() {
+		var code RecoveryCode
+		if err := rows.Scan(&code.ID, &code.CodeHash); err != nil {
+			return nil, fmt.Errorf("failed to scan recovery code: %w", err)
+		}
+		codes = append(codes, code)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get recovery codes: %w", err)
+	}
+	return codes, nil
+}
+
+// MarkRecoveryCodeUsed помечает код восстановления использованным.
+// Возвращает false, если код уже использован параллельным запросом
+func (s *PostgresUserStore) MarkRecoveryCodeUsed(ctx context.Context, id int) (bool, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, `UPDATE mfa_recovery_codes SET used_at = NOW() WHERE id = $1 AND used_at IS NULL`, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+	return n == 1, nil
+}
+
+// PostgresRoleStore читает роли пользователей из таблиц user_roles и roles
+type PostgresRoleStore struct {
+	db *sql.DB
+}
+
+// NewPostgresRoleStore создает хранилище ролей поверх открытого подключения
+func NewPostgresRoleStore(db *sql.DB) *PostgresRoleStore {
+	return &PostgresRoleStore{db: db}
+}
+
+// GetUserRoles возвращает названия ролей пользователя
+func (s *PostgresRoleStore) GetUserRoles(ctx context.Context, userID int) ([]string, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        SELECT r.name
+        FROM user_roles ur
+        JOIN roles r ON r.id = ur.role_id
+        WHERE ur.user_id = $1
+        ORDER BY r.name
+    `
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user roles: %w", err)
+	}
+	defer rows.Close()
+
+	roles := []string{}
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, fmt.Errorf("failed to scan role: %w", err)
+		}
+		roles = append(roles, role)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get user roles: %w", err)
+	}
+	return roles, nil
+}
+
+// GrantRole назначает роль пользователю. grantedBy = 0 означает назначение системой.
+// Возвращает false, если такой роли не существует
+func GrantRole(ctx context.Context, userID int, role string, grantedBy int) (bool, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        INSERT INTO user_roles (user_id, role_id, granted_by)
+        SELECT $1, id, NULLIF($3, 0) FROM roles WHERE name = $2
+        ON CONFLICT (user_id, role_id) DO NOTHING
+    `
+	if _, err := db.ExecContext(ctx, query, userID, role, grantedBy); err != nil {
+		return false, fmt.Errorf("failed to grant role: %w", err)
+	}
+
+	var exists bool
+	if err := db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM roles WHERE name = $1)`, role).Scan(&exists); err != nil {
+		return false, fmt.Errorf("failed to check role: %w", err)
+	}
+	return exists, nil
+}
+
+// RevokeRole снимает роль с пользователя. Возвращает false, если роли у пользователя не было
+func RevokeRole(ctx context.Context, userID int, role string) (bool, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        DELETE FROM user_roles
+        WHERE user_id = $1 AND role_id = (SELECT id FROM roles WHERE name = $2)
+    `
+	res, err := db.ExecContext(ctx, query, userID, role)
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke role: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke role: %w", err)
+	}
+	return n == 1, nil
+}
+
+// ListRoles возвращает все роли с их разрешениями
+func ListRoles(ctx context.Context) ([]Role, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        SELECT r.id, r.name, r.description, p.name
+        FROM roles r
+        LEFT JOIN role_permissions rp ON rp.role_id = r.id
+        LEFT JOIN permissions p ON p.id = rp.permission_id
+        ORDER BY r.name, p.name
+    `
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list roles: %w", err)
+	}
+	defer rows.Close()
+
+	roles := []Role{}
+	for rows.Next() {
+		var role Role
+		var permission sql.NullString
+		if err := rows.Scan(&role.ID, &role.Name, &role.Description, &permission); err != nil {
+			return nil, fmt.Errorf("failed to scan role: %w", err)
+		}
+		if n := len(roles); n == 0 || roles[n-1].ID != role.ID {
+			role.Permissions = []string{}
+			roles = append(roles, role)
+		}
+		if permission.Valid {
+			last := &roles[len(roles)-1]
+			last.Permissions = append(last.Permissions, permission.String)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list roles: %w", err)
+	}
+	return roles, nil
+}
+
+// ListUsers возвращает страницу пользователей (без удаленных), отфильтрованных
+// по началу email и/или имени, и общее число подходящих пользователей
+func (s *PostgresUserStore) ListUsers(ctx context.Context, filter UserFilter) ([]User, int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        SELECT id, email, username, totp_enabled, email_verified_at,
+               locked_at, password_reset_required, created_at,
+               COUNT(*) OVER ()
+        FROM users
+        WHERE deleted_at IS NULL
+          AND ($1 = '' OR email ILIKE $1 || '%')
+          AND ($2 = '' OR username ILIKE $2 || '%')
+        ORDER BY id
+        LIMIT $3 OFFSET $4
+    `
+	rows, err := s.db.QueryContext(ctx, query, escapeLike(filter.EmailPrefix), escapeLike(filter.UsernamePrefix), filter.Limit, filter.Offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []User{}
+	total := 0
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(
+			&user.ID,
+			&user.Email,
+			&user.Username,
+			&user.TOTPEnabled,
+			&user.EmailVerifiedAt,
+			&user.LockedAt,
+			&user.PasswordResetRequired,
+			&user.CreatedAt,
+			&total,
+		); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to list users: %w", err)
+	}
+
+	// За пределами последней страницы оконная функция не вернет ни одной строки
+	if len(users) == 0 && filter.Offset > 0 {
+		countQuery := `
+            SELECT COUNT(*) FROM users
+            WHERE deleted_at IS NULL
+              AND ($1 = '' OR email ILIKE $1 || '%')
+              AND ($2 = '' OR username ILIKE $2 || '%')
+        `
+		if err := s.db.QueryRowContext(ctx, countQuery, escapeLike(filter.EmailPrefix), escapeLike(filter.UsernamePrefix)).Scan(&total); err != nil {
+			return nil, 0, fmt.Errorf("failed to count users: %w", err)
+		}
+	}
+	return users, total, nil
+}
+
+// escapeLike экранирует спецсимволы шаблона LIKE, чтобы строка искалась буквально
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// SetUserLocked блокирует или разблокирует пользователя
+func (s *PostgresUserStore) SetUserLocked(ctx context.Context, userID int, locked bool) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        UPDATE users
+        SET locked_at = CASE WHEN $2 THEN COALESCE(locked_at, NOW()) ELSE NULL END
+        WHERE id = $1 AND deleted_at IS NULL
+    `
+	res, err := s.db.ExecContext(ctx, query, userID, locked)
+	if err != nil {
+		return fmt.Errorf("failed to update user lock: %w", err)
+	}
+	return expectOneRow(res, "failed to update user lock")
+}
+
+// SetPasswordResetRequired запрещает вход пользователя до смены пароля
+func (s *PostgresUserStore) SetPasswordResetRequired(ctx context.Context, userID int) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `UPDATE users SET password_reset_required = TRUE WHERE id = $1 AND deleted_at IS NULL`
+	res, err := s.db.ExecContext(ctx, query, userID)
+	if err != nil {
+		return fmt.Errorf("failed to require password reset: %w", err)
+	}
+	return expectOneRow(res, "failed to require password reset")
+}
+
+// GetLoginFailures возвращает счетчики неудачных попыток входа по ключам.
+// Неудачи, последняя из которых раньше staleBefore, не учитываются
+func GetLoginFailures(ctx context.Context, keys []string, staleBefore time.Time) (map[string]LoginFailureCounter, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        SELECT key,
+               CASE WHEN last_failure_at >= $2 THEN failures ELSE 0 END,
+               last_failure_at,
+               locked_until
+        FROM login_failures
+        WHERE key = ANY($1)
+    `
+	rows, err := db.QueryContext(ctx, query, pq.Array(keys), staleBefore)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get login failures: %w", err)
+	}
+	defer rows.Close()
+
+	counters := make(map[string]LoginFailureCounter, len(keys))
+	for rows.Next() {
+		var c LoginFailureCounter
+		if err := rows.Scan(&c.Key, &c.Failures, &c.LastFailureAt, &c.LockedUntil); err != nil {
+			return nil, fmt.Errorf("failed to scan login failures: %w", err)
+		}
+		counters[c.Key] = c
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get login failures: %w", err)
+	}
+	return counters, nil
+}
+
+// IncrementLoginFailures атомарно увеличивает счетчик неудач и блокирует ключ на lockout,
+// когда счетчик достигает maxFailures (0 - без блокировки). Если с последней неудачи
+// прошло больше window, счетчик начинается заново. Возвращает новое значение счетчика
+func IncrementLoginFailures(ctx context.Context, key string, maxFailures int, window, lockout time.Duration) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	// Выражение нового значения счетчика повторяется в SET: там видны только старые значения строки
+	query := `
+        INSERT INTO login_failures (key, failures, last_failure_at, locked_until)
+        VALUES ($1, 1, NOW(), CASE WHEN $2 = 1 THEN NOW() + $4 * INTERVAL '1 second' END)
+        ON CONFLICT (key) DO UPDATE SET
+            failures = CASE
+                WHEN login_failures.last_failure_at < NOW() - $3 * INTERVAL '1 second' THEN 1
+                ELSE login_failures.failures + 1
+            END,
+            last_failure_at = NOW(),
+            locked_until = CASE
+                WHEN $2 > 0 AND (CASE
+                    WHEN login_failures.last_failure_at < NOW() - $3 * INTERVAL '1 second' THEN 1
+                    ELSE login_failures.failures + 1
+                END) >= $2 THEN NOW() + $4 * INTERVAL '1 second'
+                ELSE login_failures.locked_until
+            END
+        RETURNING failures
+    `
+	var failures int
+	if err := db.QueryRowContext(ctx, query, key, maxFailures, window.Seconds(), lockout.Seconds()).Scan(&failures); err != nil {
+		return 0, fmt.Errorf("failed to record login failure: %w", err)
+	}
+	return failures, nil
+}
+
+// DeleteLoginFailures сбрасывает счетчик и блокировку ключа
+func DeleteLoginFailures(ctx context.Context, key string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	if _, err := db.ExecContext(ctx, `DELETE FROM login_failures WHERE key = $1`, key); err != nil {
+		return fmt.Errorf("failed to reset login failures: %w", err)
+	}
+	return nil
+}
+
+// ResetLoginFailures удаляет счетчик accountKey и вычитает его неудачи (если они еще
+// в окне, т.е. после staleBefore) из счетчика ipKey - одним запросом
+func ResetLoginFailures(ctx context.Context, accountKey, ipKey string, staleBefore time.Time) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        WITH account AS (
+            DELETE FROM login_failures WHERE key = $1
+            RETURNING failures, last_failure_at
+        )
+        UPDATE login_failures
+        SET failures = GREATEST(failures - COALESCE(
+            (SELECT failures FROM account WHERE last_failure_at >= $3), 0), 0)
+        WHERE key = $2
+    `
+	if _, err := db.ExecContext(ctx, query, accountKey, ipKey, staleBefore); err != nil {
+		return fmt.Errorf("failed to reset login failures: %w", err)
+	}
+	return nil
+}
+
+// DeleteStaleLoginFailures удаляет счетчики без свежих неудач и без действующей блокировки
+func DeleteStaleLoginFailures(ctx context.Context, staleBefore time.Time) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        DELETE FROM login_failures
+        WHERE last_failure_at < $1 AND (locked_until IS NULL OR locked_until < NOW())
+    `
+	if _, err := db.ExecContext(ctx, query, staleBefore); err != nil {
+		return fmt.Errorf("failed to prune login failures: %w", err)
+	}
+	return nil
+}
+
+// GetRateLimitCount возвращает счетчик запросов ключа в окне, начавшемся в windowStart
+func GetRateLimitCount(ctx context.Context, key string, windowStart time.Time) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	var count int
+	err := db.QueryRowContext(ctx, `SELECT count FROM rate_limits WHERE key = $1 AND window_start = $2`, key, windowStart).Scan(&count)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to get rate limit counter: %w", err)
+	}
+	return count, nil
+}
+
+// IncrementRateLimit увеличивает счетчик текущего окна, только если вместе со взвешенным
+// счетчиком предыдущего окна он не превысит limit. Возвращает новый счетчик и false,
+// если запрос не укладывается в лимит
+func IncrementRateLimit(ctx context.Context, key string, windowStart, expiresAt time.Time, previousWeighted float64, limit int) (int, bool, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        INSERT INTO rate_limits (key, window_start, count, expires_at)
+        SELECT $1, $2, 1, $3
+        WHERE 1 + $4::float8 <= $5
+        ON CONFLICT (key, window_start) DO UPDATE
+        SET count = rate_limits.count + 1
+        WHERE rate_limits.count + 1 + $4::float8 <= $5
+        RETURNING count
+    `
+	var count int
+	err := db.QueryRowContext(ctx, query, key, windowStart, expiresAt, previousWeighted, limit).Scan(&count)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, false, nil
+		}
+		return 0, false, fmt.Errorf("failed to increment rate limit counter: %w", err)
+	}
+	return count, true, nil
+}
+
+// DeleteExpiredRateLimits удаляет окна, которые больше не влияют на оценку
+func DeleteExpiredRateLimits(ctx context.Context) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	if _, err := db.ExecContext(ctx, `DELETE FROM rate_limits WHERE expires_at < NOW()`); err != nil {
+		return fmt.Errorf("failed to prune rate limit counters: %w", err)
+	}
+	return nil
+}
+
+// InsertAuditEvent добавляет событие в журнал аудита и заполняет его ID
+func InsertAuditEvent(ctx context.Context, e *AuditEvent) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	details := []byte("{}")
+	if len(e.Details) > 0 {
+		data, err := json.Marshal(e.Details)
+		if err != nil {
+			return fmt.Errorf("failed to encode audit details: %w", err)
+		}
+		details = data
+	}
+
+	query := `
+        INSERT INTO audit_log (created_at, event, user_id, target_user_id, ip, user_agent, outcome, details)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+        RETURNING id
+    `
+	err := db.QueryRowContext(ctx, query, e.Time, e.Event, e.UserID, e.TargetUserID, e.IP, e.UserAgent, e.Outcome, string(details)).Scan(&e.ID)
+	if err != nil {
+		return fmt.Errorf("failed to insert audit event: %w", err)
+	}
+	return nil
+}
+
+// ListAuditEvents возвращает события аудита по фильтру, новые первыми
+func ListAuditEvents(ctx context.Context, filter AuditFilter) ([]AuditEvent, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        SELECT id, created_at, event, user_id, target_user_id, ip, user_agent, outcome, details
+        FROM audit_log
+        WHERE ($1 = 0 OR user_id = $1 OR target_user_id = $1)
+          AND ($2 = '' OR event = $2)
+          AND ($3::timestamptz IS NULL OR created_at >= $3)
+          AND ($4::timestamptz IS NULL OR created_at < $4)
+        ORDER BY id DESC
+        LIMIT $5 OFFSET $6
+    `
+	var from, to *time.Time
+	if !filter.From.IsZero() {
+		from = &filter.From
+	}
+	if !filter.To.IsZero() {
+		to = &filter.To
+	}
+
+	rows, err := db.QueryContext(ctx, query, filter.UserID, filter.Event, from, to, filter.Limit, filter.Offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []AuditEvent{}
+	for rows.Next() {
+		var e AuditEvent
+		var details []byte
+		if err := rows.Scan(&e.ID, &e.Time, &e.Event, &e.UserID, &e.TargetUserID, &e.IP, &e.UserAgent, &e.Outcome, &details); err != nil {
+			return nil, fmt.Errorf("failed to scan audit event: %w", err)
+		}
+		if err := json.Unmarshal(details, &e.Details); err != nil {
+			return nil, fmt.Errorf("failed to decode audit details: %w", err)
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list audit events: %w", err)
+	}
+	return events, nil
+}
+
+// CreateSession сохраняет новую сессию пользователя
+func CreateSession(ctx context.Context, sessionID string, userID int, device, userAgent, ip string, expiresAt time.Time) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        INSERT INTO sessions (id, user_id, device, user_agent, ip, expires_at)
+        VALUES ($1, $2, $3, $4, $5, $6)
+    `
+	if _, err := db.ExecContext(ctx, query, sessionID, userID, device, userAgent, ip, expiresAt); err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	return nil
+}
+
+// ExtendSession продлевает сессию до срока ее нового refresh токена.
+// Для семейств refresh токенов, выданных до появления сессий, строка создается
+func ExtendSession(ctx context.Context, sessionID string, userID int, expiresAt time.Time) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        INSERT INTO sessions (id, user_id, device, expires_at)
+        VALUES ($1, $2, 'Unknown device', $3)
+        ON CONFLICT (id) DO UPDATE SET expires_at = EXCLUDED.expires_at
+    `
+	if _, err := db.ExecContext(ctx, query, sessionID, userID, expiresAt); err != nil {
+		return fmt.Errorf("failed to extend session: %w", err)
+	}
+	return nil
+}
+
+// TouchSession обновляет last_seen_at активной сессии.
+// Возвращает false, если сессия отозвана, истекла или не существует
+func TouchSession(ctx context.Context, sessionID string) (bool, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        UPDATE sessions
+        SET last_seen_at = NOW()
+        WHERE id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+    `
+	res, err := db.ExecContext(ctx, query, sessionID)
+	if err != nil {
+		return false, fmt.Errorf("failed to touch session: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to touch session: %w", err)
+	}
+	return n == 1, nil
+}
+
+// RevokeSession одной транзакцией отзывает сессию пользователя и ее refresh токены.
+// Возвращает false, если у пользователя нет такой активной сессии
+func RevokeSession(ctx context.Context, userID int, sessionID string) (bool, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `UPDATE sessions SET revoked_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`, sessionID, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke session: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return false, fmt.Errorf("failed to revoke session: %w", err)
+	} else if n == 0 {
+		return false, nil
+	}
+
+	// Семейство refresh токенов сессии имеет тот же идентификатор
+	query := `UPDATE refresh_tokens SET revoked_at = NOW() WHERE family_id = $1 AND revoked_at IS NULL`
+	if _, err := tx.ExecContext(ctx, query, sessionID); err != nil {
+		return false, fmt.Errorf("failed to revoke session refresh tokens: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return true, nil
+}
+
+// RevokeUserSessions помечает все сессии пользователя завершенными
+func RevokeUserSessions(ctx context.Context, userID int) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	if _, err := db.ExecContext(ctx, `UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`, userID); err != nil {
+		return fmt.Errorf("failed to revoke user sessions: %w", err)
+	}
+	return nil
+}
+
+// ListUserSessions возвращает активные сессии пользователя, последние использованные первыми
+func ListUserSessions(ctx context.Context, userID int) ([]Session, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        SELECT id, device, user_agent, ip, created_at, last_seen_at, expires_at
+        FROM sessions
+        WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+        ORDER BY last_seen_at DESC
+    `
+	rows, err := db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	defer rows.Close()
+
+	list := []Session{}
+	for rows.Next() {
+		var s Session
+		if err := rows.Scan(&s.ID, &s.Device, &s.UserAgent, &s.IP, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		list = append(list, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	return list, nil
+}
+
+// DeleteExpiredSessions удаляет истекшие сессии и сессии, отозванные дольше,
+// чем живет access токен (их токены уже не могут прийти)
+func DeleteExpiredSessions(ctx context.Context) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        DELETE FROM sessions
+        WHERE expires_at < NOW() OR revoked_at < NOW() - $1 * INTERVAL '1 second'
+    `
+	if _, err := db.ExecContext(ctx, query, maxTokenLifetime.Seconds()); err != nil {
+		return fmt.Errorf("failed to prune sessions: %w", err)
+	}
+	return nil
+}
+
+// GetDB возвращает подключение к базе данных (для тестирования)
+func GetDB() *sql.DB {
+	return db
+}
+
+// CreateOIDCAuthRequest сохраняет незавершенный вход через внешнего провайдера.
+// Вместо state хранится его хеш
+func CreateOIDCAuthRequest(ctx context.Context, stateHash, provider, nonce, codeVerifier string, expiresAt time.Time) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        INSERT INTO oidc_auth_requests (state_hash, provider, nonce, code_verifier, expires_at)
+        VALUES ($1, $2, $3, $4, $5)
+    `
+	if _, err := db.ExecContext(ctx, query, stateHash, provider, nonce, codeVerifier, expiresAt); err != nil {
+		return fmt.Errorf("failed to create OIDC auth request: %w", err)
+	}
+	return nil
+}
+
+// ConsumeOIDCAuthRequest удаляет и возвращает незавершенный вход по хешу state.
+// Возвращает nil, если запроса нет или он истек: каждый state используется один раз
+func ConsumeOIDCAuthRequest(ctx context.Context, stateHash string) (*OIDCAuthRequest, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        DELETE FROM oidc_auth_requests
+        WHERE state_hash = $1 AND expires_at > NOW()
+        RETURNING provider, nonce, code_verifier
+    `
+	req := &OIDCAuthRequest{}
+	err := db.QueryRowContext(ctx, query, stateHash).Scan(&req.Provider, &req.Nonce, &req.CodeVerifier)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume OIDC auth request: %w", err)
+	}
+	return req, nil
+}
+
+// DeleteExpiredOIDCAuthRequests удаляет входы, которые так и не были завершены
+func DeleteExpiredOIDCAuthRequests(ctx context.Context) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	if _, err := db.ExecContext(ctx, `DELETE FROM oidc_auth_requests WHERE expires_at < NOW()`); err != nil {
+		return fmt.Errorf("failed to prune OIDC auth requests: %w", err)
+	}
+	return nil
+}
+
+// GetIdentityUserID возвращает ID пользователя, к которому привязана внешняя учетная запись
+func (s *PostgresUserStore) GetIdentityUserID(ctx context.Context, provider, subject string) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	var userID int
+	err := s.db.QueryRowContext(ctx, `SELECT user_id FROM identities WHERE provider = $1 AND subject = $2`, provider, subject).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get identity: %w", err)
+	}
+	return userID, nil
+}
+
+// LinkIdentity привязывает внешнюю учетную запись к пользователю
+func (s *PostgresUserStore) LinkIdentity(ctx context.Context, userID int, provider, subject, email string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	// Мягко удаленному пользователю учетная запись не привязывается
+	query := `
+        INSERT INTO identities (user_id, provider, subject, email, last_login_at)
+        SELECT id, $2, $3, $4, NOW() FROM users WHERE id = $1 AND deleted_at IS NULL
+    `
+	res, err := s.db.ExecContext(ctx, query, userID, provider, subject, email)
+	if field := uniqueViolationField(err); field != "" {
+		return &DuplicateError{Field: field}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to link identity: %w", err)
+	}
+	return expectOneRow(res, "failed to link identity")
+}
+
+// TouchIdentity запоминает email от провайдера и время последнего входа
+func (s *PostgresUserStore) TouchIdentity(ctx context.Context, provider, subject, email string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        UPDATE identities SET email = $3, last_login_at = NOW()
+        WHERE provider = $1 AND subject = $2
+    `
+	res, err := s.db.ExecContext(ctx, query, provider, subject, email)
+	if err != nil {
+		return fmt.Errorf("failed to update identity: %w", err)
+	}
+	return expectOneRow(res, "failed to update identity")
+}
+
+// CreateUserWithIdentity создает пользователя и сразу привязывает к нему внешнюю учетную запись.
+// emailVerified - провайдер подтвердил адрес, повторная проверка не нужна
+func (s *PostgresUserStore) CreateUserWithIdentity(ctx context.Context, email, username, passwordHash string, emailVerified bool, provider, subject string) (*User, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+        INSERT INTO users (email, username, password_hash, email_verified_at)
+        VALUES ($1, $2, $3, CASE WHEN $4 THEN NOW() END)
+        RETURNING id, created_at, email_verified_at
+    `
+	user := &User{Email: email, Username: username}
+	err = tx.QueryRowContext(ctx, query, email, username, passwordHash, emailVerified).Scan(&user.ID, &user.CreatedAt, &user.EmailVerifiedAt)
+	if field := uniqueViolationField(err); field != "" {
+		return nil, &DuplicateError{Field: field}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+
+	query = `
+        INSERT INTO identities (user_id, provider, subject, email, last_login_at)
+        VALUES ($1, $2, $3, $4, NOW())
+    `
+	_, err = tx.ExecContext(ctx, query, user.ID, provider, subject, email)
+	if field := uniqueViolationField(err); field != "" {
+		return nil, &DuplicateError{Field: field}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to link identity: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return user, nil
+}
+
+// CreateOAuthClient регистрирует OAuth клиента. createdBy = 0 сохраняется как NULL
+func CreateOAuthClient(ctx context.Context, client *OAuthClient, createdBy int) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        INSERT INTO oauth_clients (client_id, client_secret_hash, name, redirect_uris, scopes, grant_types, created_by)
+        VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6, NULLIF($7, 0))
+        RETURNING created_at
+    `
+	err := db.QueryRowContext(ctx, query, client.ClientID, client.SecretHash, client.Name,
+		pq.Array(client.RedirectURIs), pq.Array(client.Scopes), pq.Array(client.GrantTypes), createdBy,
+	).Scan(&client.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create OAuth client: %w", err)
+	}
+	return nil
+}
+
+// GetOAuthClient находит OAuth клиента по client_id. Возвращает nil, если клиента нет
+func GetOAuthClient(ctx context.Context, clientID string) (*OAuthClient, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        SELECT client_id, COALESCE(client_secret_hash, ''), name, redirect_uris, scopes, grant_types, created_at
+        FROM oauth_clients
+        WHERE client_id = $1
+    `
+	client := &OAuthClient{}
+	err := db.QueryRowContext(ctx, query, clientID).Scan(
+		&client.ClientID,
+		&client.SecretHash,
+		&client.Name,
+		pq.Array(&client.RedirectURIs),
+		pq.Array(&client.Scopes),
+		pq.Array(&client.GrantTypes),
+		&client.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get OAuth client: %w", err)
+	}
+	return client, nil
+}
+
+// ListOAuthClients возвращает всех зарегистрированных OAuth клиентов
+func ListOAuthClients(ctx context.Context) ([]OAuthClient, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        SELECT client_id, COALESCE(client_secret_hash, ''), name, redirect_uris, scopes, grant_types, created_at
+        FROM oauth_clients
+        ORDER BY created_at
+    `
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list OAuth clients: %w", err)
+	}
+	defer rows.Close()
+
+	clients := []OAuthClient{}
+	for rows.Next() {
+		var client OAuthClient
+		if err := rows.Scan(
+			&client.ClientID,
+			&client.SecretHash,
+			&client.Name,
+			pq.Array(&client.RedirectURIs),
+			pq.Array(&client.Scopes),
+			pq.Array(&client.GrantTypes),
+			&client.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan OAuth client: %w", err)
+		}
+		clients = append(clients, client)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list OAuth clients: %w", err)
+	}
+	return clients, nil
+}
+
+// DeleteOAuthClient удаляет OAuth клиента вместе с его кодами и согласиями пользователей.
+// Возвращает false, если клиента нет
+func DeleteOAuthClient(ctx context.Context, clientID string) (bool, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	res, err := db.ExecContext(ctx, `DELETE FROM oauth_clients WHERE client_id = $1`, clientID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete OAuth client: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to delete OAuth client: %w", err)
+	}
+	return n == 1, nil
+}
+
+// CreateOAuthAuthorizationCode сохраняет хеш выданного кода авторизации
+func CreateOAuthAuthorizationCode(ctx context.Context, codeHash string, code OAuthAuthorizationCode, expiresAt time.Time) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        INSERT INTO oauth_authorization_codes (code_hash, client_id, user_id, redirect_uri, scopes, code_challenge, expires_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+    `
+	if _, err := db.ExecContext(ctx, query, codeHash, code.ClientID, code.UserID, code.RedirectURI, pq.Array(code.Scopes), code.CodeChallenge, expiresAt); err != nil {
+		return fmt.Errorf("failed to create authorization code: %w", err)
+	}
+	return nil
+}
+
+// ConsumeOAuthAuthorizationCode удаляет и возвращает код авторизации по его хешу.
+// Возвращает nil, если кода нет или он истек: код используется один раз
+func ConsumeOAuthAuthorizationCode(ctx context.Context, codeHash string) (*OAuthAuthorizationCode, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        DELETE FROM oauth_authorization_codes
+        WHERE code_hash = $1 AND expires_at > NOW()
+        RETURNING client_id, user_id, redirect_uri, scopes, code_challenge
+    `
+	code := &OAuthAuthorizationCode{}
+	err := db.QueryRowContext(ctx, query, codeHash).Scan(&code.ClientID, &code.UserID, &code.RedirectURI, pq.Array(&code.Scopes), &code.CodeChallenge)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume authorization code: %w", err)
+	}
+	return code, nil
+}
+
+// DeleteExpiredOAuthAuthorizationCodes удаляет коды, которые так и не были обменяны
+func DeleteExpiredOAuthAuthorizationCodes(ctx context.Context) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	if _, err := db.ExecContext(ctx, `DELETE FROM oauth_authorization_codes WHERE expires_at < NOW()`); err != nil {
+		return fmt.Errorf("failed to prune authorization codes: %w", err)
+	}
+	return nil
+}
+
+// GetOAuthConsent возвращает scope, на которые пользователь уже согласился для клиента
+func GetOAuthConsent(ctx context.Context, userID int, clientID string) ([]string, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	var scopes []string
+	err := db.QueryRowContext(ctx, `SELECT scopes FROM oauth_consents WHERE user_id = $1 AND client_id = $2`, userID, clientID).Scan(pq.Array(&scopes))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get OAuth consent: %w", err)
+	}
+	return scopes, nil
+}
+
+// SaveOAuthConsent запоминает согласие пользователя, добавляя scopes к ранее выданным
+func SaveOAuthConsent(ctx context.Context, userID int, clientID string, scopes []string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        INSERT INTO oauth_consents (user_id, client_id, scopes)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (user_id, client_id) DO UPDATE
+        SET scopes = ARRAY(SELECT DISTINCT unnest(oauth_consents.scopes || EXCLUDED.scopes) ORDER BY 1),
+            granted_at = NOW()
+    `
+	if _, err := db.ExecContext(ctx, query, userID, clientID, pq.Array(scopes)); err != nil {
+		return fmt.Errorf("failed to save OAuth consent: %w", err)
+	}
+	return nil
+}
+
+// CountActiveAPIKeys возвращает число действующих API ключей пользователя
+func CountActiveAPIKeys(ctx context.Context, userID int) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        SELECT COUNT(*) FROM api_keys
+        WHERE user_id = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+    `
+	var count int
+	if err := db.QueryRowContext(ctx, query, userID).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count API keys: %w", err)
+	}
+	return count, nil
+}
+
+// CreateAPIKey сохраняет новый API ключ (только хеш) и заполняет его ID и время создания
+func CreateAPIKey(ctx context.Context, key *APIKey) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING id, created_at
+    `
+	err := db.QueryRowContext(ctx, query, key.UserID, key.Name, key.Prefix, key.KeyHash, pq.Array(key.Scopes), key.ExpiresAt).
+		Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create API key: %w", err)
+	}
+	return nil
+}
+
+// GetAPIKeyByPrefix находит API ключ по видимому префиксу.
+// Возвращает nil, если ключа нет; срок и отзыв проверяет вызывающий
+func GetAPIKeyByPrefix(ctx context.Context, prefix string) (*APIKey, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        SELECT id, user_id, name, prefix, key_hash, scopes, created_at, expires_at, last_used_at, revoked_at
+        FROM api_keys
+        WHERE prefix = $1
+    `
+	key := &APIKey{}
+	err := db.QueryRowContext(ctx, query, prefix).Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.KeyHash,
+		pq.Array(&key.Scopes), &key.CreatedAt, &key.ExpiresAt, &key.LastUsedAt, &key.RevokedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API key: %w", err)
+	}
+	return key, nil
+}
+
+// ListUserAPIKeys возвращает неотозванные API ключи пользователя (включая истекшие), новые первыми
+func ListUserAPIKeys(ctx context.Context, userID int) ([]APIKey, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        SELECT id, name, prefix, scopes, created_at, expires_at, last_used_at
+        FROM api_keys
+        WHERE user_id = $1 AND revoked_at IS NULL
+        ORDER BY created_at DESC, id DESC
+    `
+	rows, err := db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+	defer rows.Close()
+
+	list := []APIKey{}
+	for rows.Next() {
+		key := APIKey{UserID: userID}
+		if err := rows.Scan(&key.ID, &key.Name, &key.Prefix, pq.Array(&key.Scopes), &key.CreatedAt, &key.ExpiresAt, &key.LastUsedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan API key: %w", err)
+		}
+		list = append(list, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+	return list, nil
+}
+
+// RevokeAPIKey отзывает API ключ пользователя.
+// Возвращает false, если у пользователя нет такого неотозванного ключа
+func RevokeAPIKey(ctx context.Context, userID, keyID int) (bool, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	res, err := db.ExecContext(ctx, `UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`, keyID, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke API key: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke API key: %w", err)
+	}
+	return n == 1, nil
+}
+
+// RevokeUserAPIKeys отзывает все API ключи пользователя
+func RevokeUserAPIKeys(ctx context.Context, userID int) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	if _, err := db.ExecContext(ctx, `UPDATE api_keys SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`, userID); err != nil {
+		return fmt.Errorf("failed to revoke API keys: %w", err)
+	}
+	return nil
+}
+
+// TouchAPIKey обновляет last_used_at ключа не чаще раза в apiKeyTouchInterval
+func TouchAPIKey(ctx context.Context, keyID int) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        UPDATE api_keys SET last_used_at = NOW()
+        WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - $2 * INTERVAL '1 second')
+    `
+	if _, err := db.ExecContext(ctx, query, keyID, apiKeyTouchInterval.Seconds()); err != nil {
+		return fmt.Errorf("failed to touch API key: %w", err)
+	}
+	return nil
+}
+
+// CreateWebAuthnChallenge сохраняет хеш challenge церемонии. userID = 0 - вход
+func CreateWebAuthnChallenge(ctx context.Context, challengeHash, ceremony string, userID int, userHandle []byte, expiresAt time.Time) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        INSERT INTO webauthn_challenges (challenge_hash, ceremony, user_id, user_handle, expires_at)
+        VALUES ($1, $2, NULLIF($3, 0), $4, $5)
+    `
+	if _, err := db.ExecContext(ctx, query, challengeHash, ceremony, userID, userHandle, expiresAt); err != nil {
+		return fmt.Errorf("failed to create WebAuthn challenge: %w", err)
+	}
+	return nil
+}
+
+// ConsumeWebAuthnChallenge удаляет и возвращает challenge церемонии по его хешу.
+// Возвращает nil, если challenge нет или он истек: challenge используется один раз
+func ConsumeWebAuthnChallenge(ctx context.Context, challengeHash, ceremony string) (*WebAuthnChallenge, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        DELETE FROM webauthn_challenges
+        WHERE challenge_hash = $1 AND ceremony = $2 AND expires_at > NOW()
+        RETURNING COALESCE(user_id, 0), user_handle
+    `
+	challenge := &WebAuthnChallenge{}
+	err := db.QueryRowContext(ctx, query, challengeHash, ceremony).Scan(&challenge.UserID, &challenge.UserHandle)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume WebAuthn challenge: %w", err)
+	}
+	return challenge, nil
+}
+
+// DeleteExpiredWebAuthnChallenges удаляет challenge незавершенных церемоний
+func DeleteExpiredWebAuthnChallenges(ctx context.Context) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	if _, err := db.ExecContext(ctx, `DELETE FROM webauthn_challenges WHERE expires_at < NOW()`); err != nil {
+		return fmt.Errorf("failed to prune WebAuthn challenges: %w", err)
+	}
+	return nil
+}
+
+// ListWebAuthnCredentials возвращает ключи доступа пользователя в порядке регистрации
+func ListWebAuthnCredentials(ctx context.Context, userID int) ([]WebAuthnCredential, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        SELECT id, credential_id, user_handle, name, attestation_format, transports, backup_eligible, created_at, last_used_at
+        FROM webauthn_credentials
+        WHERE user_id = $1
+        ORDER BY id
+    `
+	rows, err := db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list WebAuthn credentials: %w", err)
+	}
+	defer rows.Close()
+
+	list := []WebAuthnCredential{}
+	for rows.Next() {
+		c := WebAuthnCredential{UserID: userID}
+		if err := rows.Scan(&c.ID, &c.CredentialID, &c.UserHandle, &c.Name, &c.AttestationFormat,
+			pq.Array(&c.Transports), &c.BackupEligible, &c.CreatedAt, &c.LastUsedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan WebAuthn credential: %w", err)
+		}
+		list = append(list, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list WebAuthn credentials: %w", err)
+	}
+	return list, nil
+}
+
+// GetWebAuthnCredential находит ключ доступа по его ID у аутентификатора. Возвращает nil, если ключа нет
+func GetWebAuthnCredential(ctx context.Context, credentialID []byte) (*WebAuthnCredential, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        SELECT id, user_id, credential_id, user_handle, public_key, algorithm, sign_count, aaguid,
+               name, attestation_format, transports, backup_eligible, created_at, last_used_at
+        FROM webauthn_credentials
+        WHERE credential_id = $1
+    `
+	c := &WebAuthnCredential{}
+	var signCount int64
+	err := db.QueryRowContext(ctx, query, credentialID).Scan(&c.ID, &c.UserID, &c.CredentialID, &c.UserHandle, &c.PublicKey,
+		&c.Algorithm, &signCount, &c.AAGUID, &c.Name, &c.AttestationFormat, pq.Array(&c.Transports),
+		&c.BackupEligible, &c.CreatedAt, &c.LastUsedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get WebAuthn credential: %w", err)
+	}
+	c.SignCount = uint32(signCount)
+	return c, nil
+}
+
+// CreateWebAuthnCredential сохраняет зарегистрированный ключ доступа и заполняет его ID и время создания
+func CreateWebAuthnCredential(ctx context.Context, c *WebAuthnCredential) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        INSERT INTO webauthn_credentials (user_id, credential_id, user_handle, public_key, algorithm, sign_count,
+                                          aaguid, name, attestation_format, transports, backup_eligible)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+        RETURNING id, created_at
+    `
+	err := db.QueryRowContext(ctx, query, c.UserID, c.CredentialID, c.UserHandle, c.PublicKey, c.Algorithm, int64(c.SignCount),
+		c.AAGUID, c.Name, c.AttestationFormat, pq.Array(c.Transports), c.BackupEligible).Scan(&c.ID, &c.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create WebAuthn credential: %w", err)
+	}
+	return nil
+}
+
+// UpdateWebAuthnSignCount сохраняет счетчик подписей после входа.
+// Счетчик должен расти; ноль допустим, только если аутентификатор счетчик не ведет
+// (оба значения нулевые). Возвращает false, если счетчик не вырос - ключ мог быть клонирован
+func UpdateWebAuthnSignCount(ctx context.Context, id int, signCount uint32) (bool, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+        UPDATE webauthn_credentials
+        SET sign_count = $2, last_used_at = NOW()
+        WHERE id = $1 AND (sign_count < $2 OR (sign_count = 0 AND $2 = 0))
+    `
+	res, err := db.ExecContext(ctx, query, id, int64(signCount))
+	if err != nil {
+		return false, fmt.Errorf("failed to update sign count: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to update sign count: %w", err)
+	}
+	return n == 1, nil
+}
+
+// DeleteWebAuthnCredential удаляет ключ доступа пользователя.
+// Возвращает false, если у пользователя нет такого ключа
+func DeleteWebAuthnCredential(ctx context.Context, userID, id int) (bool, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	res, err := db.ExecContext(ctx, `DELETE FROM webauthn_credentials WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete WebAuthn credential: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to delete WebAuthn credential: %w", err)
+	}
+	return n == 1, nil
 }
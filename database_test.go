@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestDatabaseDSN(t *testing.T) {
+	rootCert := filepath.Join(t.TempDir(), "root ca.pem")
+	if err := os.WriteFile(rootCert, []byte("certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		config  func(d *DatabaseConfig)
+		want    string
+		wantErr string
+	}{
+		{
+			name:   "defaults",
+			config: func(d *DatabaseConfig) {},
+			want:   "host=localhost port=5432 user=postgres password=postgres dbname=secure_service sslmode=disable",
+		},
+		{
+			name: "DATABASE_URL is used as is",
+			config: func(d *DatabaseConfig) {
+				d.URL = "postgres://app:secret@db:5432/app?sslmode=require"
+				d.Host = "ignored"
+			},
+			want: "postgres://app:secret@db:5432/app?sslmode=require",
+		},
+		{
+			name: "values with spaces, quotes and backslashes are quoted",
+			config: func(d *DatabaseConfig) {
+				d.User, d.Password, d.Name = "app user", `it's a \secret`, "app"
+			},
+			want: `host=localhost port=5432 user='app user' password='it\'s a \\secret' dbname=app sslmode=disable`,
+		},
+		{
+			name: "verify-full with root certificate",
+			config: func(d *DatabaseConfig) {
+				d.Host, d.SSLMode, d.SSLRootCert = "db.internal", "verify-full", rootCert
+			},
+			want: "host=db.internal port=5432 user=postgres password=postgres dbname=secure_service sslmode=verify-full sslrootcert='" + rootCert + "'",
+		},
+		{
+			name:    "unsupported sslmode",
+			config:  func(d *DatabaseConfig) { d.SSLMode = "prefer" },
+			wantErr: "DB_SSLMODE",
+		},
+		{
+			name: "missing root certificate",
+			config: func(d *DatabaseConfig) {
+				d.SSLMode, d.SSLRootCert = "verify-ca", filepath.Join(t.TempDir(), "missing.pem")
+			},
+			wantErr: "DB_SSLROOTCERT",
+		},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			tc.config(&cfg.Database)
+
+			// Недопустимые настройки отклоняет Config.Validate, до сборки строки подключения
+			err := cfg.Validate()
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("Validate() = %v, want error about %s", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil && strings.Contains(err.Error(), "DB_") {
+				t.Fatalf("Validate() rejected the database settings: %v", err)
+			}
+			if dsn := databaseDSN(cfg.Database); dsn != tc.want {
+				t.Fatalf("databaseDSN() = %q\nwant %q", dsn, tc.want)
+			}
+		})
+	}
+}
+
+// useDBPing на время теста подменяет проверку подключения ответами results по порядку
+// и записывает паузы между попытками вместо ожидания. Возвращает указатели на паузы и число попыток
+func useDBPing(t *testing.T, results ...error) (*[]time.Duration, *int) {
+	t.Helper()
+	previousPing, previousSleep := dbPing, dbSleep
+	t.Cleanup(func() { dbPing, dbSleep = previousPing, previousSleep })
+
+	var sleeps []time.Duration
+	pings := 0
+	dbPing = func(ctx context.Context) error {
+		pings++
+		if pings > len(results) {
+			return results[len(results)-1]
+		}
+		return results[pings-1]
+	}
+	dbSleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+	return &sleeps, &pings
+}
+
+func TestPingWithRetrySchedule(t *testing.T) {
+	refused := errors.New("dial tcp 127.0.0.1:5432: connect: connection refused")
+	starting := &pq.Error{Code: "57P03", Message: "the database system is starting up"}
+	wrongPassword := &pq.Error{Code: "28P01", Message: "password authentication failed"}
+
+	cases := []struct {
+		name       string
+		results    []error
+		attempts   int
+		backoff    time.Duration
+		wantSleeps []time.Duration
+		wantPings  int
+		wantErr    bool
+	}{
+		{
+			name:       "ready on the third attempt",
+			results:    []error{refused, refused, nil},
+			attempts:   5,
+			backoff:    time.Second,
+			wantSleeps: []time.Duration{time.Second, 2 * time.Second},
+			wantPings:  3,
+		},
+		{
+			name:       "server starting up is retried",
+			results:    []error{starting, nil},
+			attempts:   5,
+			backoff:    500 * time.Millisecond,
+			wantSleeps: []time.Duration{500 * time.Millisecond},
+			wantPings:  2,
+		},
+		{
+			name:       "backoff doubles up to the cap",
+			results:    []error{refused},
+			attempts:   7,
+			backoff:    5 * time.Second,
+			wantSleeps: []time.Duration{5 * time.Second, 10 * time.Second, 20 * time.Second, 30 * time.Second, 30 * time.Second, 30 * time.Second},
+			wantPings:  7,
+			wantErr:    true,
+		},
+		{
+			name:      "server error is not retried",
+			results:   []error{wrongPassword},
+			attempts:  5,
+			backoff:   time.Second,
+			wantPings: 1,
+			wantErr:   true,
+		},
+		{
+			name:      "single attempt",
+			results:   []error{refused},
+			attempts:  1,
+			backoff:   time.Second,
+			wantPings: 1,
+			wantErr:   true,
+		},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			sleeps, pings := useDBPing(t, tc.results...)
+
+			err := pingWithRetry(tc.attempts, tc.backoff)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("pingWithRetry error = %v, want error %v", err, tc.wantErr)
+			}
+			if *pings != tc.wantPings || !reflect.DeepEqual(*sleeps, tc.wantSleeps) {
+				t.Fatalf("pings = %d, sleeps = %v; want %d, %v", *pings, *sleeps, tc.wantPings, tc.wantSleeps)
+			}
+		})
+	}
+}
+
+func TestInitDBAppliesPoolSettings(t *testing.T) {
+	previousDB, previousTimeout := db, dbQueryTimeout
+	t.Cleanup(func() { db, dbQueryTimeout = previousDB, previousTimeout })
+	cfg := defaultConfig().Database
+	cfg.MaxOpenConns = 7
+	cfg.QueryTimeout = 2 * time.Second
+	cfg.ConnectAttempts = 3
+	cfg.ConnectBackoff = time.Second
+	sleeps, pings := useDBPing(t, errors.New("connection refused"), nil)
+
+	// sql.Open не подключается к серверу, а проверка подключения подменена
+	if err := InitDB(cfg); err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	defer db.Close()
+	if got := db.Stats().MaxOpenConnections; got != 7 {
+		t.Fatalf("MaxOpenConnections = %d, want 7", got)
+	}
+	if dbQueryTimeout != 2*time.Second {
+		t.Fatalf("dbQueryTimeout = %s, want 2s", dbQueryTimeout)
+	}
+	if *pings != 2 || !reflect.DeepEqual(*sleeps, []time.Duration{time.Second}) {
+		t.Fatalf("pings = %d, sleeps = %v", *pings, *sleeps)
+	}
+}
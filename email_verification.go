@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+// accountConfig правила для аккаунтов и ссылки в письмах (Config.Account, задается при запуске)
+var accountConfig = AccountConfig{DeletionMode: "soft"}
+
+// emailVerificationRequired возвращает true, если вход запрещен до подтверждения email
+func emailVerificationRequired() bool {
+	return accountConfig.EmailVerificationRequired
+}
+
+// sendVerificationEmail отправляет пользователю ссылку подтверждения email.
+// Ссылка содержит подписанный одноразовый токен, привязанный к текущему адресу
+func sendVerificationEmail(user User) error {
+	token, err := generatePurposeToken(user, tokenPurposeEmailVerification, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	link := appURL("/verify-email?token=" + url.QueryEscape(token))
+	sendMailAsync(Message{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Body: "Hello, " + user.Username + "!\n\n" +
+			"Please confirm your email address by opening the link below:\n\n" +
+			link + "\n\n" +
+			"The link expires in 24 hours. If you did not create an account, ignore this email.\n",
+	})
+	return nil
+}
+
+// VerifyEmailHandler подтверждает email по ссылке из письма
+func (s *Server) VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 1. Проверяем подпись, срок и назначение токена
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		sendErrorResponse(w, "token is required", http.StatusBadRequest)
+		return
+	}
+	claims, err := ValidatePurposeToken(token, tokenPurposeEmailVerification)
+	if err != nil {
+		sendErrorResponse(w, "Invalid or expired verification link", http.StatusBadRequest)
+		return
+	}
+
+	// 2. Токен одноразовый
+	consumed, err := s.revocations.Consume(r.Context(), claims.ID, claims.UserID, claims.ExpiresAt.Time)
+	if err != nil {
+		log.Printf("Consume token error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	if !consumed {
+		sendErrorResponse(w, "Verification link has already been used", http.StatusBadRequest)
+		return
+	}
+
+	// 3. Подтверждаем адрес, если он не менялся после отправки письма
+	verified, err := s.users.MarkEmailVerified(r.Context(), claims.UserID, claims.Email)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	if !verified {
+		sendErrorResponse(w, "Invalid or expired verification link", http.StatusBadRequest)
+		return
+	}
+
+	sendJSONResponse(w, map[string]string{"message": "Email verified"}, http.StatusOK)
+}
+
+// ResendVerificationHandler повторно отправляет ссылку подтверждения.
+// Всегда отвечает 202, чтобы не раскрывать существование аккаунта
+func (s *Server) ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req EmailRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		sendErrorResponse(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := ValidateEmail(req.Email); err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.users.GetUserByEmail(r.Context(), req.Email)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		log.Printf("Database error: %v", err)
+	}
+	if err == nil && user.EmailVerifiedAt == nil {
+		if err := sendVerificationEmail(*user); err != nil {
+			log.Printf("Send verification email error: %v", err)
+		}
+	}
+
+	response := map[string]string{
+		"message": "If the account exists and is not verified, a verification email has been sent",
+	}
+	sendJSONResponse(w, response, http.StatusAccepted)
+}
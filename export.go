@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// exportSection таблица, связанная с пользователем, для выгрузки данных.
+// Запрос принимает ID пользователя параметром $1
+type exportSection struct {
+	Name  string
+	Query string
+}
+
+// exportSections все связанные с пользователем записи, попадающие в выгрузку
+var exportSections = []exportSection{
+	{
+		Name:  "refresh_tokens",
+		Query: `SELECT id, family_id, created_at, expires_at, rotated_at, revoked_at FROM refresh_tokens WHERE user_id = $1 ORDER BY id`,
+	},
+	{
+		Name:  "revoked_tokens",
+		Query: `SELECT jti, expires_at, revoked_at FROM revoked_tokens WHERE user_id = $1 ORDER BY revoked_at`,
+	},
+	{
+		Name:  "token_revocations",
+		Query: `SELECT revoked_before FROM user_token_revocations WHERE user_id = $1`,
+	},
+	{
+		Name:  "mfa_recovery_codes",
+		Query: `SELECT id, created_at, used_at FROM mfa_recovery_codes WHERE user_id = $1 ORDER BY id`,
+	},
+	{
+		Name:  "password_reset_requests",
+		Query: `SELECT id, created_at, expires_at, used_at FROM password_reset_tokens WHERE user_id = $1 ORDER BY id`,
+	},
+	{
+		Name:  "roles",
+		Query: `SELECT r.name AS role, ur.granted_by, ur.granted_at FROM user_roles ur JOIN roles r ON r.id = ur.role_id WHERE ur.user_id = $1 ORDER BY r.name`,
+	},
+	{
+		Name:  "sessions",
+		Query: `SELECT id, device, user_agent, ip, created_at, last_seen_at, expires_at, revoked_at FROM sessions WHERE user_id = $1 ORDER BY created_at`,
+	},
+	{
+		Name:  "identities",
+		Query: `SELECT provider, subject, email, created_at, last_login_at FROM identities WHERE user_id = $1 ORDER BY id`,
+	},
+	{
+		Name:  "oauth_consents",
+		Query: `SELECT c.client_id, oc.name AS client_name, c.scopes, c.granted_at FROM oauth_consents c JOIN oauth_clients oc ON oc.client_id = c.client_id WHERE c.user_id = $1 ORDER BY c.granted_at`,
+	},
+	{
+		Name:  "api_keys",
+		Query: `SELECT id, name, prefix, scopes, created_at, expires_at, last_used_at, revoked_at FROM api_keys WHERE user_id = $1 ORDER BY id`,
+	},
+	{
+		Name:  "webauthn_credentials",
+		Query: `SELECT id, name, encode(aaguid, 'hex') AS aaguid, attestation_format, transports, backup_eligible, sign_count, created_at, last_used_at FROM webauthn_credentials WHERE user_id = $1 ORDER BY id`,
+	},
+	{
+		Name:  "audit_log",
+		Query: `SELECT id, created_at, event, user_id, target_user_id, ip, user_agent, outcome, details FROM audit_log WHERE user_id = $1 OR target_user_id = $1 ORDER BY id`,
+	},
+}
+
+// exportRedactedFields хранимые поля, которые не выгружаются, потому что
+// это секреты или их хеши; в выгрузке указывается только факт их хранения
+var exportRedactedFields = []string{
+	"users.password_hash",
+	"users.totp_secret_encrypted",
+	"refresh_tokens.token_hash",
+	"mfa_recovery_codes.code_hash",
+	"password_reset_tokens.token_hash",
+	"api_keys.key_hash",
+}
+
+// UserExport выгрузка всех данных пользователя
+type UserExport struct {
+	ExportedAt     time.Time                           `json:"exported_at"`
+	User           map[string]interface{}              `json:"user"`
+	Records        map[string][]map[string]interface{} `json:"records"`
+	RedactedFields []string                            `json:"redacted_fields"`
+}
+
+// ExportUserData собирает все хранимые поля пользователя и связанные записи
+func ExportUserData(userID int) (*UserExport, error) {
+	users, err := queryExportRows(`
+        SELECT id, email, username, created_at, email_verified_at, totp_enabled, totp_last_step,
+               locked_at, password_reset_required, deleted_at
+        FROM users
+        WHERE id = $1
+    `, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, nil
+	}
+
+	export := &UserExport{
+		ExportedAt:     time.Now().UTC(),
+		User:           users[0],
+		Records:        make(map[string][]map[string]interface{}, len(exportSections)),
+		RedactedFields: exportRedactedFields,
+	}
+	for _, section := range exportSections {
+		rows, err := queryExportRows(section.Query, userID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to export %s: %w", section.Name, err)
+		}
+		export.Records[section.Name] = rows
+	}
+	return export, nil
+}
+
+// queryExportRows выполняет запрос и возвращает строки в виде map "колонка -> значение"
+func queryExportRows(query string, userID int) ([]map[string]interface{}, error) {
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query export data: %w", err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read export columns: %w", err)
+	}
+
+	result := []map[string]interface{}{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, fmt.Errorf("failed to scan export data: %w", err)
+		}
+
+		row := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			// Некоторые текстовые типы драйвер возвращает как []byte
+			if b, ok := values[i].([]byte); ok {
+				row[column] = string(b)
+			} else {
+				row[column] = values[i]
+			}
+		}
+		result = append(result, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query export data: %w", err)
+	}
+	return result, nil
+}
+
+// ExportProfileHandler отдает все данные пользователя JSON файлом (GDPR)
+func ExportProfileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := GetUserIDFromContext(r)
+	if !ok {
+		sendErrorResponse(w, "User ID not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	export, err := ExportUserData(userID)
+	if err != nil {
+		log.Printf("Export user data error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	if export == nil {
+		sendErrorResponse(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="user-%d-export.json"`, userID))
+	w.Header().Set("Cache-Control", "no-store")
+	sendJSONResponse(w, export, http.StatusOK)
+}
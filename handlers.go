@@ -1,14 +1,45 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
+// Server обработчики HTTP. Пользователи, сессии, отзыв токенов, роли, журнал аудита и защита
+// входа передаются явно через NewServer, поэтому регистрацию и вход по паролю можно проверить
+// с MemoryUserStore без PostgreSQL. Остальные таблицы (токены, OAuth, WebAuthn и т.д.)
+// обработчики пока читают через функции database.go
+type Server struct {
+	users       UserStore
+	sessions    SessionManager
+	revocations TokenRevoker
+	roles       RoleStore
+	auditor     AuditRecorder
+	throttle    LoginLimiter
+}
+
+// NewServer создает обработчики поверх переданных хранилищ
+func NewServer(users UserStore, sessions SessionManager, revocations TokenRevoker, roles RoleStore, auditor AuditRecorder, throttle LoginLimiter) *Server {
+	return &Server{
+		users:       users,
+		sessions:    sessions,
+		revocations: revocations,
+		roles:       roles,
+		auditor:     auditor,
+		throttle:    throttle,
+	}
+}
+
 // RegisterHandler обрабатывает регистрацию нового пользователя
-func RegisterHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -46,11 +77,12 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 3. Проверяем существование email
-	if exists, err := UserExistsByEmail(req.Email); err != nil {
+	if exists, err := s.users.UserExistsByEmail(r.Context(), req.Email); err != nil {
 		log.Printf("Database error: %v", err)
-		sendErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		sendInternalError(w, err)
 		return
 	} else if exists {
+		s.audit(r, auditRegister, 0, auditFailure, map[string]interface{}{"email_sha256": auditEmail(req.Email), "reason": "email_taken"})
 		sendErrorResponse(w, "User with this email already exists", http.StatusConflict)
 		return
 	}
@@ -59,41 +91,51 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	passwordHash, err := HashPassword(req.Password)
 	if err != nil {
 		log.Printf("Hash password error: %v", err)
-		sendErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		sendInternalError(w, err)
 		return
 	}
 
 	// 5. Создаем пользователя
-	user, err := CreateUser(req.Email, req.Username, passwordHash)
+	user, err := s.users.CreateUser(r.Context(), req.Email, req.Username, passwordHash)
+	var dup *DuplicateError
+	if errors.As(err, &dup) {
+		s.audit(r, auditRegister, 0, auditFailure, map[string]interface{}{"email_sha256": auditEmail(req.Email), "reason": dup.Field + "_taken"})
+		sendErrorResponse(w, fmt.Sprintf("User with this %s already exists", dup.Field), http.StatusConflict)
+		return
+	}
 	if err != nil {
 		log.Printf("Create user error: %v", err)
 		sendErrorResponse(w, "Failed to create user", http.StatusInternalServerError)
 		return
 	}
 
-	// 6. Генерируем токен
-	token, err := GenerateToken(*user)
-	if err != nil {
-		log.Printf("Generate token error: %v", err)
-		sendErrorResponse(w, "Internal server error", http.StatusInternalServerError)
-		return
+	s.audit(r, auditRegister, user.ID, auditSuccess, nil)
+
+	// 6. Отправляем ссылку подтверждения email
+	if err := sendVerificationEmail(*user); err != nil {
+		log.Printf("Send verification email error: %v", err)
 	}
 
-	// 7. Успешный ответ
-	response := map[string]interface{}{
-		"message": "User registered successfully",
-		"user": map[string]interface{}{
-			"id":       user.ID,
-			"email":    user.Email,
-			"username": user.Username,
-		},
-		"token": token,
+	// Пока адрес не подтвержден, токены не выдаются
+	if emailVerificationRequired() {
+		response := map[string]interface{}{
+			"message": "User registered successfully. Check your email to verify the address",
+			"user": map[string]interface{}{
+				"id":       user.ID,
+				"email":    user.Email,
+				"username": user.Username,
+			},
+		}
+		sendJSONResponse(w, response, http.StatusCreated)
+		return
 	}
-	sendJSONResponse(w, response, http.StatusCreated)
+
+	// 7. Генерируем токены и отправляем успешный ответ
+	s.sendTokenResponse(w, r, user, "User registered successfully", http.StatusCreated)
 }
 
 // LoginHandler обрабатывает вход пользователя
-func LoginHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -128,47 +170,358 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 3. Находим пользователя
-	user, err := GetUserByEmail(req.Email)
+	// 3. Защита от перебора: временная блокировка email/IP и растущая задержка.
+	//    Ответ при блокировке такой же, как при неверном пароле
+	ip := clientIP(r)
+	throttle, err := s.throttle.Check(r.Context(), req.Email, ip)
 	if err != nil {
+		log.Printf("Login throttle error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	if throttle.Locked {
+		s.audit(r, auditLogin, 0, auditFailure, map[string]interface{}{"email_sha256": auditEmail(req.Email), "reason": "throttled"})
+		sendErrorResponse(w, "Invalid email or password", http.StatusUnauthorized)
+		return
+	}
+	s.throttle.Wait(r.Context(), throttle.Delay)
+
+	// 4. Находим пользователя и проверяем пароль.
+	//    Заблокированный администратором аккаунт получает тот же ответ, что и неверный пароль
+	user, err := s.users.GetUserByEmail(r.Context(), req.Email)
+	if err != nil && !errors.Is(err, ErrNotFound) {
 		log.Printf("Database error: %v", err)
+		if isQueryTimeout(err) || isDatabaseUnavailable(err) {
+			sendInternalError(w, err)
+			return
+		}
 		sendErrorResponse(w, "Invalid email or password", http.StatusUnauthorized)
 		return
 	}
-	if user == nil {
+	if user == nil || !CheckPassword(req.Password, user.PasswordHash) || user.LockedAt != nil {
+		// Неудача учитывается, даже если клиент уже закрыл соединение
+		if err := s.throttle.RecordFailure(context.Background(), req.Email, ip); err != nil {
+			log.Printf("Login throttle error: %v", err)
+		}
+		s.auditLoginFailure(r, req.Email, user)
 		sendErrorResponse(w, "Invalid email or password", http.StatusUnauthorized)
 		return
 	}
 
-	// 4. Проверяем пароль
-	if !CheckPassword(req.Password, user.PasswordHash) {
-		sendErrorResponse(w, "Invalid email or password", http.StatusUnauthorized)
+	// Пароль верный - счетчик неудач по email сбрасывается
+	if err := s.throttle.RecordSuccess(r.Context(), req.Email, ip); err != nil {
+		log.Printf("Login throttle error: %v", err)
+	}
+
+	// Администратор потребовал сменить пароль
+	if user.PasswordResetRequired {
+		s.audit(r, auditLogin, user.ID, auditFailure, map[string]interface{}{"reason": "password_reset_required"})
+		sendErrorResponse(w, "Password reset required", http.StatusForbidden)
+		return
+	}
+
+	// Пароль верный, но адрес еще не подтвержден
+	if emailVerificationRequired() && user.EmailVerifiedAt == nil {
+		s.audit(r, auditLogin, user.ID, auditFailure, map[string]interface{}{"reason": "email_not_verified"})
+		sendErrorResponse(w, "Email address is not verified", http.StatusForbidden)
+		return
+	}
+
+	// 5. При подключенном TOTP выдаем только токен ожидания второго фактора,
+	//    иначе генерируем токены и отправляем успешный ответ
+	s.completeLogin(w, r, user, auditLogin, nil)
+}
+
+// completeLogin завершает вход после проверки первого фактора: при подключенном TOTP
+// выдает токен ожидания второго фактора, иначе начинает сессию и выдает токены.
+// event и details записываются в журнал аудита
+func (s *Server) completeLogin(w http.ResponseWriter, r *http.Request, user *User, event string, details map[string]interface{}) {
+	if user.TOTPEnabled {
+		mfaToken, err := generatePurposeToken(*user, tokenPurposeMFAPending, mfaPendingTTL)
+		if err != nil {
+			log.Printf("Generate token error: %v", err)
+			sendInternalError(w, err)
+			return
+		}
+		response := map[string]interface{}{
+			"message":      "MFA code required",
+			"mfa_required": true,
+			"mfa_token":    mfaToken,
+		}
+		mfaDetails := map[string]interface{}{"mfa_required": true}
+		for k, v := range details {
+			mfaDetails[k] = v
+		}
+		s.audit(r, event, user.ID, auditSuccess, mfaDetails)
+		sendJSONResponse(w, response, http.StatusOK)
+		return
+	}
+
+	s.audit(r, event, user.ID, auditSuccess, details)
+	s.sendTokenResponse(w, r, user, "Login successful", http.StatusOK)
+}
+
+// auditLoginFailure записывает неудачный вход. Клиент получает одинаковый ответ,
+// а в журнале видна настоящая причина
+func (s *Server) auditLoginFailure(r *http.Request, email string, user *User) {
+	details := map[string]interface{}{"email_sha256": auditEmail(email), "reason": "unknown_email"}
+	userID := 0
+	if user != nil {
+		userID = user.ID
+		details["reason"] = "invalid_password"
+		if user.LockedAt != nil {
+			details["reason"] = "account_locked"
+		}
+	}
+	s.audit(r, auditLogin, userID, auditFailure, details)
+}
+
+// RefreshTokenHandler обменивает refresh токен на новую пару токенов (ротация)
+func (s *Server) RefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 1. Парсим JSON
+	var req RefreshRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		sendErrorResponse(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.RefreshToken == "" {
+		sendErrorResponse(w, "refresh_token is required", http.StatusBadRequest)
+		return
+	}
+
+	// 2. Находим токен по хешу
+	rt, err := GetRefreshTokenByHash(r.Context(), hashToken(req.RefreshToken))
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	if rt == nil || rt.RevokedAt != nil {
+		sendErrorResponse(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	// 3. Повторное использование уже обмененного токена означает,
+	//    что токен мог быть украден - отзываем все семейство
+	if rt.RotatedAt != nil {
+		s.revokeRefreshFamilyOnReuse(rt)
+		sendErrorResponse(w, "Invalid refresh token", http.StatusUnauthorized)
 		return
 	}
 
-	// 5. Генерируем токен
-	token, err := GenerateToken(*user)
+	if time.Now().After(rt.ExpiresAt) {
+		sendErrorResponse(w, "Refresh token expired", http.StatusUnauthorized)
+		return
+	}
+
+	// 4. Помечаем токен использованным. Если параллельный запрос
+	//    успел раньше - это тоже повторное использование
+	rotated, err := MarkRefreshTokenRotated(r.Context(), rt.ID)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	if !rotated {
+		s.revokeRefreshFamilyOnReuse(rt)
+		sendErrorResponse(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	// 5. Загружаем пользователя
+	user, err := s.users.GetUserByID(r.Context(), rt.UserID)
+	if errors.Is(err, ErrNotFound) {
+		sendErrorResponse(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	if user.LockedAt != nil {
+		sendErrorResponse(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	// 6. Выдаем новую пару токенов в той же сессии (семейство refresh токенов = сессия)
+	token, refreshToken, err := s.issueTokens(r.Context(), *user, rt.FamilyID)
 	if err != nil {
 		log.Printf("Generate token error: %v", err)
-		sendErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		sendInternalError(w, err)
 		return
 	}
 
-	// 6. Успешный ответ
 	response := map[string]interface{}{
-		"message": "Login successful",
+		"token":         token,
+		"refresh_token": refreshToken,
+	}
+	sendJSONResponse(w, response, http.StatusOK)
+}
+
+// LogoutHandler отзывает текущий access токен (и refresh токен, если передан)
+func (s *Server) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 1. Получаем claims текущего токена из контекста
+	claims, ok := GetClaimsFromContext(r)
+	if !ok {
+		sendErrorResponse(w, "Token claims not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	// 2. Тело запроса необязательно
+	var req LogoutRequest
+	if err := parseJSONRequest(r, &req); err != nil && !errors.Is(err, io.EOF) {
+		sendErrorResponse(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	// 3. Завершаем сессию токена: ее access и refresh токены перестают действовать
+	if claims.SessionID != "" {
+		if _, err := s.sessions.Revoke(r.Context(), claims.UserID, claims.SessionID); err != nil {
+			log.Printf("Revoke session error: %v", err)
+			sendInternalError(w, err)
+			return
+		}
+	}
+
+	// Access токен отзывается и по jti (токены, выданные до появления сессий)
+	if claims.ID != "" && claims.ExpiresAt != nil {
+		if err := s.revocations.RevokeToken(r.Context(), claims.ID, claims.UserID, claims.ExpiresAt.Time); err != nil {
+			log.Printf("Revoke token error: %v", err)
+			sendInternalError(w, err)
+			return
+		}
+	}
+
+	// 4. Отзываем семейство refresh токена, только если он принадлежит этому пользователю
+	if req.RefreshToken != "" {
+		rt, err := GetRefreshTokenByHash(r.Context(), hashToken(req.RefreshToken))
+		if err != nil {
+			log.Printf("Database error: %v", err)
+			sendInternalError(w, err)
+			return
+		}
+		if rt != nil && rt.UserID == claims.UserID {
+			if err := RevokeRefreshTokenFamily(r.Context(), rt.FamilyID); err != nil {
+				log.Printf("Revoke refresh token family error: %v", err)
+				sendInternalError(w, err)
+				return
+			}
+		}
+	}
+
+	sendJSONResponse(w, map[string]string{"message": "Logged out"}, http.StatusOK)
+}
+
+// LogoutAllHandler завершает все сессии пользователя на всех устройствах
+func (s *Server) LogoutAllHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := GetUserIDFromContext(r)
+	if !ok {
+		sendErrorResponse(w, "User ID not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.revokeAllUserSessions(r.Context(), userID); err != nil {
+		log.Printf("Revoke user sessions error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+
+	sendJSONResponse(w, map[string]string{"message": "Logged out from all devices"}, http.StatusOK)
+}
+
+// sendTokenResponse начинает новую сессию для устройства, с которого пришел запрос,
+// выдает пользователю новую пару токенов и отправляет ответ
+func (s *Server) sendTokenResponse(w http.ResponseWriter, r *http.Request, user *User, message string, statusCode int) {
+	sessionID, err := s.sessions.Start(r, user.ID)
+	if err != nil {
+		log.Printf("Create session error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+
+	token, refreshToken, err := s.issueTokens(r.Context(), *user, sessionID)
+	if err != nil {
+		log.Printf("Generate token error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"message": message,
 		"user": map[string]interface{}{
 			"id":       user.ID,
 			"email":    user.Email,
 			"username": user.Username,
 		},
-		"token": token,
+		"token":         token,
+		"refresh_token": refreshToken,
+	}
+	sendJSONResponse(w, response, statusCode)
+}
+
+// issueTokens создает access токен и refresh токен для пользователя в сессии sessionID.
+// ID сессии одновременно служит family_id ее refresh токенов
+func (s *Server) issueTokens(ctx context.Context, user User, sessionID string) (string, string, error) {
+	// Роли попадают в claims, поэтому загружаются заново при каждой выдаче
+	roles, err := s.roles.GetUserRoles(ctx, user.ID)
+	if err != nil {
+		return "", "", err
+	}
+	user.Roles = roles
+
+	token, err := GenerateToken(user, sessionID)
+	if err != nil {
+		return "", "", err
+	}
+
+	refreshToken, err := s.sessions.IssueRefreshToken(ctx, user.ID, sessionID)
+	if err != nil {
+		return "", "", err
+	}
+	return token, refreshToken, nil
+}
+
+// revokeRefreshFamilyOnReuse отзывает семейство токенов при повторном использовании
+// и завершает его сессию, чтобы перестали действовать и выданные в ней access токены.
+// Контекст запроса не используется: закрыв соединение, клиент не должен помешать отзыву
+func (s *Server) revokeRefreshFamilyOnReuse(rt *RefreshToken) {
+	log.Printf("Refresh token reuse detected for user %d, revoking family %s", rt.UserID, rt.FamilyID)
+	ctx := context.Background()
+	if err := RevokeRefreshTokenFamily(ctx, rt.FamilyID); err != nil {
+		log.Printf("Revoke refresh token family error: %v", err)
+	}
+	if _, err := s.sessions.Revoke(ctx, rt.UserID, rt.FamilyID); err != nil {
+		log.Printf("Revoke session error: %v", err)
 	}
-	sendJSONResponse(w, response, http.StatusOK)
 }
 
 // ProfileHandler возвращает профиль текущего пользователя
-func ProfileHandler(w http.ResponseWriter, r *http.Request) {
+// (PATCH - обновляет его, DELETE - удаляет аккаунт)
+func (s *Server) ProfileHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPatch:
+		s.UpdateProfileHandler(w, r)
+		return
+	case http.MethodDelete:
+		s.DeleteAccountHandler(w, r)
+		return
+	}
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -195,25 +548,49 @@ func ProfileHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 2. Загружаем пользователя
-	user, err := GetUserByID(userID)
+	user, err := s.users.GetUserByID(r.Context(), userID)
+	if errors.Is(err, ErrNotFound) {
+		sendErrorResponse(w, "User not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		log.Printf("Database error: %v", err)
-		sendErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		sendInternalError(w, err)
 		return
 	}
-	if user == nil {
-		sendErrorResponse(w, "User not found", http.StatusNotFound)
+
+	// 3. Отправляем профиль (без password_hash)
+	sendJSONResponse(w, profileResponse(user), http.StatusOK)
+}
+
+// profileResponse формирует ответ с профилем пользователя (без password_hash)
+func profileResponse(user *User) map[string]interface{} {
+	return map[string]interface{}{
+		"id":                user.ID,
+		"email":             user.Email,
+		"username":          user.Username,
+		"totp_enabled":      user.TOTPEnabled,
+		"email_verified_at": user.EmailVerifiedAt,
+		"created_at":        user.CreatedAt,
+	}
+}
+
+// JWKSHandler публикует публичные ключи подписи JWT (RFC 7517)
+func JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// 3. Отправляем профиль (без password_hash)
-	response := map[string]interface{}{
-		"id":         user.ID,
-		"email":      user.Email,
-		"username":   user.Username,
-		"created_at": user.CreatedAt,
+	jwks, err := keyring.JWKS()
+	if err != nil {
+		log.Printf("JWKS error: %v", err)
+		sendInternalError(w, err)
+		return
 	}
-	sendJSONResponse(w, response, http.StatusOK)
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	sendJSONResponse(w, jwks, http.StatusOK)
 }
 
 // HealthHandler проверяет состояние сервиса
@@ -253,6 +630,42 @@ func sendErrorResponse(w http.ResponseWriter, message string, statusCode int) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// sendInternalError отправляет ответ на внутреннюю ошибку (вызывающий уже записал ее в лог).
+// Запрос к БД не уложился в DB_QUERY_TIMEOUT - 504, база недоступна - 503, остальное - 500
+func sendInternalError(w http.ResponseWriter, err error) {
+	switch {
+	case isQueryTimeout(err):
+		sendErrorResponse(w, "Database timeout, try again later", http.StatusGatewayTimeout)
+	case isDatabaseUnavailable(err):
+		w.Header().Set("Retry-After", "5")
+		sendErrorResponse(w, "Service temporarily unavailable", http.StatusServiceUnavailable)
+	default:
+		sendErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// loadCurrentUser загружает пользователя из контекста запроса.
+// При ошибке отправляет ответ и возвращает false
+func (s *Server) loadCurrentUser(w http.ResponseWriter, r *http.Request) (*User, bool) {
+	userID, ok := GetUserIDFromContext(r)
+	if !ok {
+		sendErrorResponse(w, "User ID not found in context", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	user, err := s.users.GetUserByID(r.Context(), userID)
+	if errors.Is(err, ErrNotFound) {
+		sendErrorResponse(w, "User not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return nil, false
+	}
+	return user, true
+}
+
 // parseJSONRequest парсит JSON из тела запроса (вспомогательная функция)
 func parseJSONRequest(r *http.Request, v interface{}) error {
 	if r.Body == nil {
@@ -277,6 +690,9 @@ func validateRegisterRequest(req *RegisterRequest) error {
 	if req.Password == "" {
 		return fmt.Errorf("password is required")
 	}
+	if err := validateUsername(req.Username); err != nil {
+		return err
+	}
 
 	// TODO: Добавьте дополнительные проверки
 	// - Используйте ValidateEmail() и ValidatePassword() из auth.go
@@ -286,6 +702,17 @@ func validateRegisterRequest(req *RegisterRequest) error {
 	return nil
 }
 
+// validateUsername проверяет имя пользователя (длина ограничена колонкой users.username)
+func validateUsername(username string) error {
+	if strings.TrimSpace(username) == "" {
+		return fmt.Errorf("username is required")
+	}
+	if utf8.RuneCountInString(username) > 30 {
+		return fmt.Errorf("username must be at most 30 characters long")
+	}
+	return nil
+}
+
 // validateLoginRequest валидирует данные входа
 func validateLoginRequest(req *LoginRequest) error {
 	if req.Email == "" {
@@ -296,3 +723,13 @@ func validateLoginRequest(req *LoginRequest) error {
 	}
 	return nil
 }
+
+// containsString проверяет наличие строки в списке
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
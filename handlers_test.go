@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memorySessions SessionManager в памяти для тестов обработчиков
+type memorySessions struct {
+	mu       sync.Mutex
+	nextID   int
+	sessions map[string]int // ID сессии -> пользователь
+	revoked  map[string]bool
+}
+
+func newMemorySessions() *memorySessions {
+	return &memorySessions{sessions: make(map[string]int), revoked: make(map[string]bool)}
+}
+
+func (m *memorySessions) Start(r *http.Request, userID int) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextID++
+	sessionID := "session-" + strconv.Itoa(m.nextID)
+	m.sessions[sessionID] = userID
+	return sessionID, nil
+}
+
+func (m *memorySessions) IssueRefreshToken(ctx context.Context, userID int, sessionID string) (string, error) {
+	return GenerateRefreshToken()
+}
+
+func (m *memorySessions) IsActive(ctx context.Context, sessionID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.sessions[sessionID]
+	return ok && !m.revoked[sessionID], nil
+}
+
+func (m *memorySessions) Revoke(ctx context.Context, userID int, sessionID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if owner, ok := m.sessions[sessionID]; !ok || owner != userID || m.revoked[sessionID] {
+		return false, nil
+	}
+	m.revoked[sessionID] = true
+	return true, nil
+}
+
+// memoryRevocations TokenRevoker в памяти для тестов обработчиков
+type memoryRevocations struct {
+	mu      sync.Mutex
+	tokens  map[string]bool
+	cutoffs map[int]time.Time // пользователь -> токены, выданные раньше, отозваны
+}
+
+func newMemoryRevocations() *memoryRevocations {
+	return &memoryRevocations{tokens: make(map[string]bool), cutoffs: make(map[int]time.Time)}
+}
+
+func (m *memoryRevocations) RevokeToken(ctx context.Context, jti string, userID int, expiresAt time.Time) error {
+	_, err := m.Consume(ctx, jti, userID, expiresAt)
+	return err
+}
+
+func (m *memoryRevocations) Consume(ctx context.Context, jti string, userID int, expiresAt time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.tokens[jti] {
+		return false, nil
+	}
+	m.tokens[jti] = true
+	return true, nil
+}
+
+func (m *memoryRevocations) RevokeAllForUser(ctx context.Context, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cutoffs[userID] = time.Now()
+	return nil
+}
+
+func (m *memoryRevocations) IsRevoked(ctx context.Context, claims *Claims) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if claims.ID != "" && m.tokens[claims.ID] {
+		return true, nil
+	}
+	cutoff, ok := m.cutoffs[claims.UserID]
+	return ok && claims.IssuedAt != nil && claims.IssuedAt.Time.Before(cutoff), nil
+}
+
+// staticRoles RoleStore, выдающий всем пользователям одни и те же роли
+type staticRoles []string
+
+func (s staticRoles) GetUserRoles(ctx context.Context, userID int) ([]string, error) {
+	return append([]string{}, s...), nil
+}
+
+// recordingAuditor AuditRecorder, запоминающий события
+type recordingAuditor struct {
+	mu     sync.Mutex
+	events []AuditEvent
+}
+
+func (a *recordingAuditor) Record(event AuditEvent) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.events = append(a.events, event)
+}
+
+// last возвращает последнее событие с типом event
+func (a *recordingAuditor) last(event string) (AuditEvent, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i := len(a.events) - 1; i >= 0; i-- {
+		if a.events[i].Event == event {
+			return a.events[i], true
+		}
+	}
+	return AuditEvent{}, false
+}
+
+// countingLimiter LoginLimiter без блокировок, считающий неудачи
+type countingLimiter struct {
+	mu          sync.Mutex
+	failures    map[string]int
+	mfaFailures map[string]int
+	maxMFA      int
+}
+
+func newCountingLimiter() *countingLimiter {
+	return &countingLimiter{failures: make(map[string]int), mfaFailures: make(map[string]int), maxMFA: 5}
+}
+
+func (l *countingLimiter) Check(ctx context.Context, email, ip string) (LoginThrottleStatus, error) {
+	return LoginThrottleStatus{}, nil
+}
+
+func (l *countingLimiter) Wait(ctx context.Context, d time.Duration) {}
+
+func (l *countingLimiter) RecordFailure(ctx context.Context, email, ip string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.failures[accountKey(email)]++
+	return nil
+}
+
+func (l *countingLimiter) RecordMFAFailure(ctx context.Context, jti string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.mfaFailures[jti]++
+	return l.mfaFailures[jti] >= l.maxMFA, nil
+}
+
+func (l *countingLimiter) RecordSuccess(ctx context.Context, email, ip string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, accountKey(email))
+	return nil
+}
+
+func (l *countingLimiter) Reset(ctx context.Context, email string) error {
+	return l.RecordSuccess(ctx, email, "")
+}
+
+// testServer обработчики поверх хранилищ в памяти
+type testServer struct {
+	*Server
+	users       *MemoryUserStore
+	sessions    *memorySessions
+	revocations *memoryRevocations
+	auditor     *recordingAuditor
+	throttle    *countingLimiter
+	mailPath    string
+}
+
+// newTestServer создает Server без PostgreSQL: ключ подписи HS256, письма - в файл во временном каталоге,
+// настройки аккаунтов по умолчанию (подтверждение email не обязательно)
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	if err := InitAuth(AuthConfig{Algorithm: "HS256", Secret: strings.Repeat("test-secret-", 4)}); err != nil {
+		t.Fatalf("InitAuth: %v", err)
+	}
+	previousAccount := accountConfig
+	t.Cleanup(func() { accountConfig = previousAccount })
+	accountConfig = AccountConfig{
+		DeletionMode:     "soft",
+		PasswordResetURL: appURL("/password/reset"),
+		MagicLinkURL:     appURL("/login/magic/callback"),
+	}
+	mailPath := filepath.Join(t.TempDir(), "mail.jsonl")
+	mailer = &FileMailer{Path: mailPath}
+
+	ts := &testServer{
+		mailPath:    mailPath,
+		users:       NewMemoryUserStore(),
+		sessions:    newMemorySessions(),
+		revocations: newMemoryRevocations(),
+		auditor:     &recordingAuditor{},
+		throttle:    newCountingLimiter(),
+	}
+	ts.Server = NewServer(ts.users, ts.sessions, ts.revocations, staticRoles{"user"}, ts.auditor, ts.throttle)
+	return ts
+}
+
+// sentMail возвращает письма, записанные FileMailer
+func (ts *testServer) sentMail(t *testing.T) []Message {
+	t.Helper()
+	data, err := os.ReadFile(ts.mailPath)
+	if errors.Is(err, os.ErrNotExist) || len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+	if err != nil {
+		t.Fatalf("read mail file: %v", err)
+	}
+
+	var messages []Message
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var msg Message
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			t.Fatalf("invalid mail line %q: %v", line, err)
+		}
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+// postJSON вызывает обработчик с JSON телом и разбирает JSON ответ
+func postJSON(t *testing.T, handler http.HandlerFunc, path, body string) (int, map[string]interface{}) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("%s: invalid JSON response %q: %v", path, rec.Body.String(), err)
+	}
+	return rec.Code, response
+}
+
+func TestRegisterAndLoginWithoutDatabase(t *testing.T) {
+	ts := newTestServer(t)
+
+	// Регистрация
+	code, response := postJSON(t, ts.RegisterHandler, "/register", `{"email":"alice@example.com","username":"alice","password":"Correct-horse-1"}`)
+	if code != http.StatusCreated || response["token"] == nil || response["refresh_token"] == nil {
+		t.Fatalf("register = %d %v, want 201 with tokens", code, response)
+	}
+	if e, ok := ts.auditor.last(auditRegister); !ok || e.Outcome != auditSuccess {
+		t.Fatalf("register audit event = %+v, %v", e, ok)
+	}
+
+	code, _ = postJSON(t, ts.RegisterHandler, "/register", `{"email":"alice@example.com","username":"alice2","password":"Correct-horse-1"}`)
+	if code != http.StatusConflict {
+		t.Fatalf("duplicate register = %d, want 409", code)
+	}
+
+	// Неверный пароль
+	code, _ = postJSON(t, ts.LoginHandler, "/login", `{"email":"alice@example.com","password":"wrong-password-1"}`)
+	if code != http.StatusUnauthorized {
+		t.Fatalf("login with wrong password = %d, want 401", code)
+	}
+	if ts.throttle.failures[accountKey("alice@example.com")] != 1 {
+		t.Fatalf("failure not recorded: %v", ts.throttle.failures)
+	}
+	if e, _ := ts.auditor.last(auditLogin); e.Details["reason"] != "invalid_password" {
+		t.Fatalf("login failure audit details = %v", e.Details)
+	}
+
+	// Верный пароль: токен с ролями из RoleStore в новой сессии
+	code, response = postJSON(t, ts.LoginHandler, "/login", `{"email":"alice@example.com","password":"Correct-horse-1"}`)
+	if code != http.StatusOK {
+		t.Fatalf("login = %d %v, want 200", code, response)
+	}
+	claims, err := ValidateToken(response["token"].(string))
+	if err != nil {
+		t.Fatalf("ValidateToken: %v", err)
+	}
+	if len(claims.Roles) != 1 || claims.Roles[0] != "user" || claims.SessionID != "session-2" {
+		t.Fatalf("claims = %+v, want roles [user] in session-2", claims)
+	}
+	if len(ts.throttle.failures) != 0 {
+		t.Fatalf("failures not reset after login: %v", ts.throttle.failures)
+	}
+
+	// Заблокированный аккаунт получает тот же ответ, что и неверный пароль
+	user, err := ts.users.GetUserByEmail(context.Background(), "alice@example.com")
+	if err != nil {
+		t.Fatalf("GetUserByEmail: %v", err)
+	}
+	if err := ts.users.SetUserLocked(context.Background(), user.ID, true); err != nil {
+		t.Fatalf("SetUserLocked: %v", err)
+	}
+	code, _ = postJSON(t, ts.LoginHandler, "/login", `{"email":"alice@example.com","password":"Correct-horse-1"}`)
+	if code != http.StatusUnauthorized {
+		t.Fatalf("login to locked account = %d, want 401", code)
+	}
+	if e, _ := ts.auditor.last(auditLogin); e.Details["reason"] != "account_locked" {
+		t.Fatalf("locked login audit details = %v", e.Details)
+	}
+}
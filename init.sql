@@ -1,19 +0,0 @@
--- Создание таблицы пользователей
-CREATE TABLE IF NOT EXISTS users (
-    id SERIAL PRIMARY KEY,
-    email VARCHAR(255) UNIQUE NOT NULL,
-    username VARCHAR(30) UNIQUE NOT NULL,
-    password_hash VARCHAR(255) NOT NULL,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-);
-
--- Индексы для оптимизации поиска
-CREATE INDEX IF NOT EXISTS idx_users_email ON users(email);
-CREATE INDEX IF NOT EXISTS idx_users_username ON users(username);
-
--- Добавим комментарии к таблице для документации
-COMMENT ON TABLE users IS 'Таблица пользователей системы';
-COMMENT ON COLUMN users.email IS 'Email пользователя (уникальный)';
-COMMENT ON COLUMN users.username IS 'Имя пользователя (уникальное)';
-COMMENT ON COLUMN users.password_hash IS 'Хеш пароля (bcrypt)';
-COMMENT ON COLUMN users.created_at IS 'Дата и время регистрации';
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxTokenLifetime максимальное время жизни JWT, которые выдает сервис.
+// Выведенный из оборота ключ хранится столько же, чтобы подписанные им
+// токены оставались валидными до своего истечения
+const maxTokenLifetime = accessTokenTTL
+
+// defaultKeyReloadInterval период перечитывания JWT_KEY_DIR по умолчанию
+const defaultKeyReloadInterval = time.Minute
+
+// keyring глобальный набор ключей подписи JWT
+var keyring *Keyring
+
+// keyringEntry ключ в наборе и момент его вывода из оборота
+type keyringEntry struct {
+	key       *signingKey
+	retiredAt time.Time // нулевое значение - ключ не выводился из оборота
+}
+
+// Keyring хранит один активный ключ подписи и ключи, пригодные только для проверки.
+// Ключ для проверки выбирается по заголовку kid токена
+type Keyring struct {
+	mu     sync.RWMutex
+	config AuthConfig // источник ключей
+	active *signingKey
+	keys   map[string]keyringEntry // все ключи для проверки, включая активный
+}
+
+// NewKeyring создает пустой набор ключей с источником cfg
+func NewKeyring(cfg AuthConfig) *Keyring {
+	return &Keyring{config: cfg, keys: make(map[string]keyringEntry)}
+}
+
+// Active возвращает ключ, которым подписываются новые токены
+func (k *Keyring) Active() *signingKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.active
+}
+
+// Lookup находит ключ для проверки по kid.
+// Выведенные ключи перестают находиться через maxTokenLifetime
+func (k *Keyring) Lookup(kid string) (*signingKey, bool) {
+	k.mu.RLock()
+	entry, ok := k.keys[kid]
+	k.mu.RUnlock()
+	if !ok || entry.expired(time.Now()) {
+		return nil, false
+	}
+	return entry.key, true
+}
+
+// JWKS возвращает публичные части всех действующих асимметричных ключей
+func (k *Keyring) JWKS() (JWKS, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	now := time.Now()
+	kids := make([]string, 0, len(k.keys))
+	for kid, entry := range k.keys {
+		if !entry.expired(now) && !entry.key.isSymmetric() {
+			kids = append(kids, kid)
+		}
+	}
+	sort.Strings(kids)
+
+	jwks := JWKS{Keys: []JWK{}}
+	for _, kid := range kids {
+		jwk, err := k.keys[kid].key.jwk()
+		if err != nil {
+			return jwks, err
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return jwks, nil
+}
+
+// Reload перечитывает ключи из источника (JWT_KEY_DIR или JWT_SECRET/JWT_PRIVATE_KEY_FILE).
+// Прежний активный ключ, пропавший из источника, остается пригодным для проверки
+// еще maxTokenLifetime, поэтому смена ключа не разлогинивает пользователей
+func (k *Keyring) Reload() error {
+	k.mu.RLock()
+	cfg := k.config
+	k.mu.RUnlock()
+	return k.reloadWith(cfg)
+}
+
+// reloadWith загружает ключи из источника cfg. Источник заменяется, только если ключи загрузились
+func (k *Keyring) reloadWith(cfg AuthConfig) error {
+	active, entries, err := loadKeySet(cfg)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	next := make(map[string]keyringEntry, len(entries)+1)
+	for _, entry := range entries {
+		next[entry.key.kid] = entry
+	}
+	next[active.kid] = keyringEntry{key: active}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	for kid, entry := range k.keys {
+		wasActive := k.active != nil && kid == k.active.kid
+		if kept, ok := next[kid]; ok {
+			// Ключ выведен из оборота только что: отсчет начинается не раньше текущего момента
+			if wasActive && !kept.retiredAt.IsZero() && kept.retiredAt.Before(now) {
+				kept.retiredAt = now
+				next[kid] = kept
+			}
+			continue
+		}
+		if wasActive {
+			entry.retiredAt = now
+		}
+		if !entry.retiredAt.IsZero() {
+			next[kid] = entry
+		}
+	}
+
+	for kid, entry := range next {
+		if entry.expired(now) {
+			delete(next, kid)
+		}
+	}
+
+	if k.active != nil && k.active.kid != active.kid {
+		log.Printf("JWT signing key rotated: %s -> %s", k.active.kid, active.kid)
+	}
+	k.config = cfg
+	k.active = active
+	k.keys = next
+	return nil
+}
+
+// StartReloader перечитывает ключи по сигналу и, если задан JWT_KEY_DIR, периодически
+// (JWT_KEY_RELOAD_INTERVAL). По сигналу источник ключей берется из reloadConfig,
+// при ошибке конфигурации остаются прежние ключи
+func (k *Keyring) StartReloader(signals <-chan os.Signal, reloadConfig func() (AuthConfig, error)) {
+	k.mu.RLock()
+	cfg := k.config
+	k.mu.RUnlock()
+
+	var tick <-chan time.Time
+	if cfg.KeyDir != "" && cfg.KeyReloadInterval > 0 {
+		ticker := time.NewTicker(cfg.KeyReloadInterval)
+		tick = ticker.C
+	}
+
+	go func() {
+		for {
+			var err error
+			select {
+			case <-signals:
+				log.Println("Reloading JWT signing keys")
+				var next AuthConfig
+				if next, err = reloadConfig(); err == nil {
+					err = k.reloadWith(next)
+				}
+			case <-tick:
+				err = k.Reload()
+			}
+			if err != nil {
+				log.Printf("JWT key reload error: %v", err)
+			}
+		}
+	}()
+}
+
+// expired возвращает true, если выведенный ключ больше не нужен для проверки
+func (e keyringEntry) expired(now time.Time) bool {
+	return !e.retiredAt.IsZero() && now.Sub(e.retiredAt) >= maxTokenLifetime
+}
+
+// loadKeySet загружает активный ключ и ключи только для проверки из источника cfg
+func loadKeySet(cfg AuthConfig) (*signingKey, []keyringEntry, error) {
+	if cfg.KeyDir != "" {
+		return loadKeyDir(cfg.KeyDir)
+	}
+
+	key, err := loadConfiguredKey(cfg)
+	return key, nil, err
+}
+
+// loadConfiguredKey загружает единственный ключ из настроек.
+// JWT_ALGORITHM выбирает алгоритм: HS256 (по умолчанию, секрет из JWT_SECRET)
+// или RS256/ES256/EdDSA (приватный ключ из PEM файла JWT_PRIVATE_KEY_FILE)
+func loadConfiguredKey(cfg AuthConfig) (*signingKey, error) {
+	alg := cfg.Algorithm
+	if alg == algHS256 {
+		secret := []byte(cfg.Secret)
+		if len(secret) < 32 {
+			return nil, fmt.Errorf("JWT_SECRET must be at least 32 characters long")
+		}
+		return newHMACKey(secret), nil
+	}
+
+	keyFile := cfg.PrivateKeyFile
+	if keyFile == "" {
+		return nil, fmt.Errorf("JWT_PRIVATE_KEY_FILE is required for %s", alg)
+	}
+	return loadPrivateKeyFile(keyFile, alg)
+}
+
+// loadKeyDir загружает ключи из каталога: *.pem и *.key - приватные ключи в PEM
+// (алгоритм определяется по типу ключа), *.secret - секреты HS256.
+// Активным считается последний файл по имени, например 2024-06-01.pem.
+// Каждый предыдущий ключ считается выведенным из оборота с момента
+// появления следующего за ним файла (время его модификации)
+func loadKeyDir(dir string) (*signingKey, []keyringEntry, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read key directory: %w", err)
+	}
+
+	type loadedKey struct {
+		key     *signingKey
+		modTime time.Time
+	}
+	var loaded []loadedKey
+	seen := make(map[string]string)
+
+	// os.ReadDir возвращает файлы, отсортированные по имени
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, f.Name())
+
+		var key *signingKey
+		switch strings.ToLower(filepath.Ext(f.Name())) {
+		case ".pem", ".key":
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to read %s: %w", f.Name(), err)
+			}
+			if key, err = parsePrivateKeyPEM(data, ""); err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", f.Name(), err)
+			}
+		case ".secret":
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to read %s: %w", f.Name(), err)
+			}
+			secret := []byte(strings.TrimSpace(string(data)))
+			if len(secret) < 32 {
+				return nil, nil, fmt.Errorf("%s: secret must be at least 32 characters long", f.Name())
+			}
+			key = newHMACKey(secret)
+		default:
+			continue
+		}
+
+		if other, ok := seen[key.kid]; ok {
+			return nil, nil, fmt.Errorf("%s and %s contain the same key", other, f.Name())
+		}
+		seen[key.kid] = f.Name()
+
+		info, err := f.Info()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to stat %s: %w", f.Name(), err)
+		}
+		loaded = append(loaded, loadedKey{key: key, modTime: info.ModTime()})
+	}
+
+	if len(loaded) == 0 {
+		return nil, nil, fmt.Errorf("no keys found in %s", dir)
+	}
+
+	last := len(loaded) - 1
+	entries := make([]keyringEntry, 0, last)
+	for i := 0; i < last; i++ {
+		entries = append(entries, keyringEntry{key: loaded[i].key, retiredAt: loaded[i+1].modTime})
+	}
+	return loaded[last].key, entries, nil
+}
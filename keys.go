@@ -0,0 +1,281 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Поддерживаемые алгоритмы подписи JWT
+const (
+	algHS256 = "HS256"
+	algRS256 = "RS256"
+	algES256 = "ES256"
+	algEdDSA = "EdDSA"
+)
+
+// signingKey ключ подписи JWT вместе с его идентификатором (kid)
+type signingKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	private interface{} // []byte для HMAC, иначе *rsa.PrivateKey, *ecdsa.PrivateKey или ed25519.PrivateKey
+	public  interface{} // []byte для HMAC, иначе *rsa.PublicKey, *ecdsa.PublicKey или ed25519.PublicKey
+}
+
+// JWK публичный ключ в формате RFC 7517
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS набор публичных ключей для /.well-known/jwks.json
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// newHMACKey создает симметричный ключ из секрета.
+// kid - начало SHA-256 хеша секрета, сам секрет из него не восстановить
+func newHMACKey(secret []byte) *signingKey {
+	sum := sha256.Sum256(append([]byte("hmac:"), secret...))
+	return &signingKey{
+		kid:     hex.EncodeToString(sum[:8]),
+		method:  jwt.SigningMethodHS256,
+		private: secret,
+		public:  secret,
+	}
+}
+
+// loadPrivateKeyFile загружает приватный ключ из PEM файла для указанного алгоритма
+func loadPrivateKeyFile(path, alg string) (*signingKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %w", err)
+	}
+	return parsePrivateKeyPEM(data, alg)
+}
+
+// parsePrivateKeyPEM разбирает PEM (PKCS#8, PKCS#1 или SEC 1) и проверяет,
+// что тип ключа соответствует алгоритму. Пустой alg определяется по типу ключа
+func parsePrivateKeyPEM(data []byte, alg string) (*signingKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	if alg == "" {
+		if alg, err = algForKey(parsed); err != nil {
+			return nil, err
+		}
+	}
+
+	key := &signingKey{private: parsed}
+	switch alg {
+	case algRS256:
+		priv, ok := parsed.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s requires an RSA private key", alg)
+		}
+		if priv.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("RSA key must be at least 2048 bits")
+		}
+		key.method = jwt.SigningMethodRS256
+		key.public = &priv.PublicKey
+	case algES256:
+		priv, ok := parsed.(*ecdsa.PrivateKey)
+		if !ok || priv.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("%s requires an ECDSA P-256 private key", alg)
+		}
+		key.method = jwt.SigningMethodES256
+		key.public = &priv.PublicKey
+	case algEdDSA:
+		priv, ok := parsed.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s requires an Ed25519 private key", alg)
+		}
+		key.method = jwt.SigningMethodEdDSA
+		key.public = priv.Public()
+	default:
+		return nil, fmt.Errorf("unsupported JWT algorithm: %s", alg)
+	}
+
+	jwk, err := key.jwk()
+	if err != nil {
+		return nil, err
+	}
+	key.kid = jwk.Kid
+	return key, nil
+}
+
+// algForKey определяет алгоритм подписи по типу приватного ключа
+func algForKey(key interface{}) (string, error) {
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		return algRS256, nil
+	case *ecdsa.PrivateKey:
+		return algES256, nil
+	case ed25519.PrivateKey:
+		return algEdDSA, nil
+	default:
+		return "", fmt.Errorf("unsupported private key type %T", key)
+	}
+}
+
+// jwk возвращает публичную часть ключа в формате JWK.
+// kid вычисляется как отпечаток ключа по RFC 7638
+func (k *signingKey) jwk() (JWK, error) {
+	var jwk JWK
+	var thumbprintInput interface{}
+
+	switch pub := k.public.(type) {
+	case *rsa.PublicKey:
+		jwk = JWK{
+			Kty: "RSA",
+			N:   b64(pub.N.Bytes()),
+			E:   b64(big.NewInt(int64(pub.E)).Bytes()),
+		}
+		thumbprintInput = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk = JWK{
+			Kty: "EC",
+			Crv: pub.Curve.Params().Name,
+			X:   b64(pub.X.FillBytes(make([]byte, size))),
+			Y:   b64(pub.Y.FillBytes(make([]byte, size))),
+		}
+		thumbprintInput = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+	case ed25519.PublicKey:
+		jwk = JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   b64(pub),
+		}
+		thumbprintInput = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	default:
+		return JWK{}, fmt.Errorf("key type %T cannot be published as JWK", k.public)
+	}
+
+	canonical, err := json.Marshal(thumbprintInput)
+	if err != nil {
+		return JWK{}, fmt.Errorf("failed to compute key thumbprint: %w", err)
+	}
+	sum := sha256.Sum256(canonical)
+
+	jwk.Kid = b64(sum[:])
+	jwk.Use = "sig"
+	jwk.Alg = k.method.Alg()
+	return jwk, nil
+}
+
+// isSymmetric возвращает true для HMAC ключей, которые нельзя публиковать
+func (k *signingKey) isSymmetric() bool {
+	_, ok := k.public.([]byte)
+	return ok
+}
+
+// b64 кодирует байты в base64url без дополнения
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// parsePublicJWK восстанавливает публичный ключ из JWK (RSA, EC P-256/P-384/P-521 или Ed25519).
+// Используется для проверки подписей внешних провайдеров
+func parsePublicJWK(jwk JWK) (interface{}, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil || len(n) == 0 {
+			return nil, fmt.Errorf("invalid RSA modulus")
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid RSA exponent")
+		}
+		pub := &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+		if pub.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("RSA key is too short: %d bits", pub.N.BitLen())
+		}
+		return pub, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported EC curve %q", jwk.Crv)
+		}
+		x, errX := base64.RawURLEncoding.DecodeString(jwk.X)
+		y, errY := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if errX != nil || errY != nil {
+			return nil, fmt.Errorf("invalid EC coordinates")
+		}
+		pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, fmt.Errorf("EC point is not on curve %s", jwk.Crv)
+		}
+		return pub, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported OKP curve %q", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+	}
+}
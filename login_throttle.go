@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+)
+
+// loginFailuresPruneInterval период удаления устаревших счетчиков неудачных входов
+const loginFailuresPruneInterval = 10 * time.Minute
+
+// loginThrottle глобальная защита входа от перебора паролей
+var loginThrottle *LoginThrottle
+
+// LoginLimiter защита входа от перебора. Реализация - LoginThrottle
+type LoginLimiter interface {
+	// Check возвращает состояние счетчиков email и IP перед проверкой пароля
+	Check(ctx context.Context, email, ip string) (LoginThrottleStatus, error)
+	// Wait выдерживает задержку перед ответом (или до отмены ctx)
+	Wait(ctx context.Context, d time.Duration)
+	// RecordFailure учитывает неудачную попытку входа
+	RecordFailure(ctx context.Context, email, ip string) error
+	// RecordMFAFailure учитывает неверный код второго фактора; true - попытки токена исчерпаны
+	RecordMFAFailure(ctx context.Context, jti string) (bool, error)
+	// RecordSuccess сбрасывает счетчики после верного пароля
+	RecordSuccess(ctx context.Context, email, ip string) error
+	// Reset снимает временную блокировку email (разблокировка администратором)
+	Reset(ctx context.Context, email string) error
+}
+
+// LoginThrottle считает неудачные попытки входа по email и по IP адресу.
+// Счетчики хранятся в таблице login_failures, поэтому общие для всех реплик.
+// Каждая неудачная попытка по email увеличивает задержку ответа, а после
+// превышения порога ключ (email или IP) блокируется на LockoutDuration
+type LoginThrottle struct {
+	MaxAccountFailures int           // порог блокировки по email
+	MaxIPFailures      int           // порог блокировки по IP
+	FailureWindow      time.Duration // через сколько после последней неудачи счетчик начинается заново
+	LockoutDuration    time.Duration // длительность временной блокировки
+	BaseDelay          time.Duration // задержка после первой неудачи, дальше удваивается
+	MaxDelay           time.Duration // верхняя граница задержки
+	MaxMFAFailures     int           // неверных кодов второго фактора на один токен mfa_pending
+}
+
+// LoginThrottleStatus состояние счетчиков для одной попытки входа
+type LoginThrottleStatus struct {
+	Locked bool          // email или IP временно заблокирован
+	Delay  time.Duration // задержка перед проверкой пароля
+}
+
+// InitLoginThrottle настраивает защиту от перебора. Пороги проверены в Config.Validate:
+// тихий возврат к значениям по умолчанию мог бы незаметно ослабить защиту
+func InitLoginThrottle(cfg LoginConfig) {
+	loginThrottle = &LoginThrottle{
+		MaxAccountFailures: cfg.MaxAccountFailures,
+		MaxIPFailures:      cfg.MaxIPFailures,
+		MaxMFAFailures:     cfg.MaxMFAFailures,
+		FailureWindow:      cfg.FailureWindow,
+		LockoutDuration:    cfg.LockoutDuration,
+		BaseDelay:          cfg.BaseDelay,
+		MaxDelay:           cfg.MaxDelay,
+	}
+}
+
+// accountKey ключ счетчика для email. Счетчик ведется и для несуществующих
+// адресов, поэтому блокировка не раскрывает, есть ли такой аккаунт
+func accountKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// ipKey ключ счетчика для IP адреса
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// mfaTokenKey ключ счетчика неверных кодов для токена mfa_pending
+func mfaTokenKey(jti string) string {
+	return "mfa_token:" + jti
+}
+
+// Check возвращает, заблокирована ли попытка входа и какую задержку нужно выдержать
+func (t *LoginThrottle) Check(ctx context.Context, email, ip string) (LoginThrottleStatus, error) {
+	counters, err := GetLoginFailures(ctx, []string{accountKey(email), ipKey(ip)}, time.Now().Add(-t.FailureWindow))
+	if err != nil {
+		return LoginThrottleStatus{}, err
+	}
+
+	now := time.Now()
+	var status LoginThrottleStatus
+	for _, c := range counters {
+		if c.LockedUntil != nil && now.Before(*c.LockedUntil) {
+			status.Locked = true
+		}
+	}
+	status.Delay = t.delay(counters[accountKey(email)].Failures)
+	return status, nil
+}
+
+// delay растет экспоненциально с числом неудач: BaseDelay, 2*BaseDelay, ... до MaxDelay
+func (t *LoginThrottle) delay(failures int) time.Duration {
+	if failures <= 0 || t.BaseDelay <= 0 {
+		return 0
+	}
+	d := t.BaseDelay
+	for i := 1; i < failures && d < t.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > t.MaxDelay {
+		d = t.MaxDelay
+	}
+	return d
+}
+
+// Wait выдерживает задержку, прерываясь, если клиент закрыл соединение
+func (t *LoginThrottle) Wait(ctx context.Context, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// RecordFailure увеличивает счетчики email и IP и блокирует их при превышении порогов
+func (t *LoginThrottle) RecordFailure(ctx context.Context, email, ip string) error {
+	if _, err := IncrementLoginFailures(ctx, accountKey(email), t.MaxAccountFailures, t.FailureWindow, t.LockoutDuration); err != nil {
+		return err
+	}
+	_, err := IncrementLoginFailures(ctx, ipKey(ip), t.MaxIPFailures, t.FailureWindow, t.LockoutDuration)
+	return err
+}
+
+// RecordMFAFailure учитывает неверный код второго фактора для токена mfa_pending с jti.
+// Возвращает true, когда неудач набралось MaxMFAFailures и токен нужно аннулировать.
+// Счетчик живет не дольше самого токена
+func (t *LoginThrottle) RecordMFAFailure(ctx context.Context, jti string) (bool, error) {
+	failures, err := IncrementLoginFailures(ctx, mfaTokenKey(jti), 0, mfaPendingTTL, 0)
+	if err != nil {
+		return false, err
+	}
+	return t.MaxMFAFailures > 0 && failures >= t.MaxMFAFailures, nil
+}
+
+// RecordSuccess сбрасывает счетчик email после успешного входа и вычитает те же неудачи
+// из счетчика IP: опечатки пользователей за общим NAT не копятся до блокировки адреса.
+// Неудачи по чужим email остаются на счетчике IP, поэтому вход в собственный аккаунт
+// не дает продолжать перебор чужих паролей
+func (t *LoginThrottle) RecordSuccess(ctx context.Context, email, ip string) error {
+	return ResetLoginFailures(ctx, accountKey(email), ipKey(ip), time.Now().Add(-t.FailureWindow))
+}
+
+// Reset снимает блокировку email (например, при разблокировке администратором)
+func (t *LoginThrottle) Reset(ctx context.Context, email string) error {
+	return DeleteLoginFailures(ctx, accountKey(email))
+}
+
+// StartPruner запускает фоновое удаление устаревших счетчиков
+func (t *LoginThrottle) StartPruner(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := DeleteStaleLoginFailures(context.Background(), time.Now().Add(-t.FailureWindow)); err != nil {
+				log.Printf("Login failures prune error: %v", err)
+			}
+		}
+	}()
+}
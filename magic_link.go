@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// magicLinkEmailLimit лимит писем со ссылкой входа на один адрес (RATE_LIMIT_LOGIN_MAGIC_EMAIL,
+// задается в InitRateLimiter). nil - без ограничения
+var magicLinkEmailLimit *RateLimitRule
+
+// MagicLinkHandler отправляет письмо со ссылкой для входа без пароля (POST /login/magic).
+// Как и ForgotPasswordHandler, всегда отвечает 202, чтобы не раскрывать существование аккаунта
+func (s *Server) MagicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req EmailRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		sendErrorResponse(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := ValidateEmail(req.Email); err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Поиск пользователя и отправка письма выполняются в фоне,
+	// поэтому время ответа не зависит от существования аккаунта
+	go func() {
+		if err := s.requestMagicLink(context.Background(), req.Email); err != nil {
+			log.Printf("Magic link request error: %v", err)
+		}
+	}()
+
+	response := map[string]string{
+		"message": "If the account exists, a login link has been sent",
+	}
+	sendJSONResponse(w, response, http.StatusAccepted)
+}
+
+// requestMagicLink отправляет ссылку входа, если аккаунт существует и лимит адреса не исчерпан.
+// Лимит считается для любого адреса, чтобы по нему тоже нельзя было узнать о существовании аккаунта
+func (s *Server) requestMagicLink(ctx context.Context, email string) error {
+	if magicLinkEmailLimit != nil {
+		key := "login_magic:email:" + strings.ToLower(strings.TrimSpace(email))
+		result, err := rateLimiter.Allow(ctx, key, magicLinkEmailLimit.Limit, magicLinkEmailLimit.Window)
+		if err != nil {
+			return err
+		}
+		if !result.Allowed {
+			log.Printf("Magic link limit reached for %s", email)
+			return nil
+		}
+	}
+
+	user, err := s.users.GetUserByEmail(ctx, email)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil || user.LockedAt != nil {
+		return err
+	}
+
+	// Подписанный токен привязан к адресу и используется один раз (см. MagicLinkCallbackHandler)
+	token, err := generatePurposeToken(*user, tokenPurposeMagicLink, magicLinkTTL)
+	if err != nil {
+		return err
+	}
+
+	link := accountConfig.MagicLinkURL + "?token=" + url.QueryEscape(token)
+	return mailer.Send(Message{
+		To:      user.Email,
+		Subject: "Your login link",
+		Body: "Hello, " + user.Username + "!\n\n" +
+			"To sign in, open the link below:\n\n" +
+			link + "\n\n" +
+			"The link expires in 15 minutes and can be used once. If you did not try to sign in, ignore this email.\n",
+	})
+}
+
+// MagicLinkCallbackHandler обменивает ссылку из письма на токены (GET /login/magic/callback?token=...).
+// Ответ такой же, как у POST /login: при подключенном TOTP нужен второй фактор
+func (s *Server) MagicLinkCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// Ответ содержит токены, а адрес - одноразовую ссылку
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	// 1. Проверяем подпись, срок и назначение токена
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		sendErrorResponse(w, "token is required", http.StatusBadRequest)
+		return
+	}
+	claims, err := ValidatePurposeToken(token, tokenPurposeMagicLink)
+	if err != nil {
+		s.audit(r, auditLoginMagicLink, 0, auditFailure, map[string]interface{}{"reason": "invalid_token"})
+		sendErrorResponse(w, "Invalid or expired login link", http.StatusUnauthorized)
+		return
+	}
+
+	// 2. Ссылка одноразовая
+	consumed, err := s.revocations.Consume(r.Context(), claims.ID, claims.UserID, claims.ExpiresAt.Time)
+	if err != nil {
+		log.Printf("Consume token error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	if !consumed {
+		s.audit(r, auditLoginMagicLink, claims.UserID, auditFailure, map[string]interface{}{"reason": "link_reused"})
+		sendErrorResponse(w, "Login link has already been used", http.StatusUnauthorized)
+		return
+	}
+
+	// 3. Переход по ссылке подтверждает владение адресом, если он не менялся после отправки письма
+	verified, err := s.users.MarkEmailVerified(r.Context(), claims.UserID, claims.Email)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	if !verified {
+		s.audit(r, auditLoginMagicLink, claims.UserID, auditFailure, map[string]interface{}{"reason": "email_changed"})
+		sendErrorResponse(w, "Invalid or expired login link", http.StatusUnauthorized)
+		return
+	}
+
+	// 4. Загружаем пользователя и проверяем, что он может входить
+	user, err := s.users.GetUserByID(r.Context(), claims.UserID)
+	if errors.Is(err, ErrNotFound) {
+		sendErrorResponse(w, "Invalid or expired login link", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	if user.LockedAt != nil {
+		s.audit(r, auditLoginMagicLink, user.ID, auditFailure, map[string]interface{}{"reason": "account_locked"})
+		sendErrorResponse(w, "Account is locked", http.StatusForbidden)
+		return
+	}
+	if user.PasswordResetRequired {
+		s.audit(r, auditLoginMagicLink, user.ID, auditFailure, map[string]interface{}{"reason": "password_reset_required"})
+		sendErrorResponse(w, "Password reset required", http.StatusForbidden)
+		return
+	}
+
+	// 5. Второй фактор и выдача токенов - как при входе по паролю
+	s.completeLogin(w, r, user, auditLoginMagicLink, nil)
+}
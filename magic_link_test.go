@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// magicLinkPattern ссылка входа в тексте письма
+var magicLinkPattern = regexp.MustCompile(`https?://\S+\?token=\S+`)
+
+// useMagicLinkLimit задает лимит писем на адрес (как RATE_LIMIT_LOGIN_MAGIC_EMAIL)
+// со свежими счетчиками на время теста
+func useMagicLinkLimit(t *testing.T, value string) {
+	t.Helper()
+	previousLimiter, previousRule := rateLimiter, magicLinkEmailLimit
+	t.Cleanup(func() { rateLimiter, magicLinkEmailLimit = previousLimiter, previousRule })
+
+	rule, err := parseRateLimitRule(value)
+	if err != nil {
+		t.Fatalf("parseRateLimitRule(%q): %v", value, err)
+	}
+	rateLimiter = NewMemoryRateLimitStore(time.Minute)
+	magicLinkEmailLimit = rule
+}
+
+// magicLinkToken извлекает токен из письма со ссылкой входа
+func magicLinkToken(t *testing.T, msg Message) string {
+	t.Helper()
+	link := magicLinkPattern.FindString(msg.Body)
+	u, err := url.Parse(link)
+	if err != nil || u.Query().Get("token") == "" {
+		t.Fatalf("no login link in email body %q", msg.Body)
+	}
+	return u.Query().Get("token")
+}
+
+func TestMagicLinkRequest(t *testing.T) {
+	ctx := context.Background()
+
+	cases := []struct {
+		name     string
+		email    string
+		locked   bool
+		wantMail bool
+	}{
+		{name: "known address gets a link", email: "alice@example.com", wantMail: true},
+		{name: "unknown address gets nothing", email: "nobody@example.com"},
+		{name: "locked account gets nothing", email: "alice@example.com", locked: true},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			useMagicLinkLimit(t, "3/15m")
+			user, err := ts.users.CreateUser(ctx, "alice@example.com", "alice", "hash")
+			if err != nil {
+				t.Fatalf("CreateUser: %v", err)
+			}
+			if tc.locked {
+				if err := ts.users.SetUserLocked(ctx, user.ID, true); err != nil {
+					t.Fatalf("SetUserLocked: %v", err)
+				}
+			}
+
+			if err := ts.requestMagicLink(ctx, tc.email); err != nil {
+				t.Fatalf("requestMagicLink: %v", err)
+			}
+
+			mail := ts.sentMail(t)
+			if !tc.wantMail {
+				if len(mail) != 0 {
+					t.Fatalf("sent %d emails, want none", len(mail))
+				}
+				return
+			}
+			if len(mail) != 1 || mail[0].To != "alice@example.com" {
+				t.Fatalf("mail = %+v, want one email to alice", mail)
+			}
+			claims, err := ValidatePurposeToken(magicLinkToken(t, mail[0]), tokenPurposeMagicLink)
+			if err != nil || claims.UserID != user.ID || claims.Email != user.Email {
+				t.Fatalf("link token claims = %+v, %v", claims, err)
+			}
+			// Токен ссылки не подходит для других целей
+			if _, err := ValidatePurposeToken(magicLinkToken(t, mail[0]), tokenPurposeEmailVerification); err == nil {
+				t.Fatal("magic link token accepted as an email verification token")
+			}
+		})
+	}
+}
+
+// signalingRateLimitStore сообщает о каждом вызове Allow: по нему тест узнает,
+// что фоновая обработка запроса ссылки дошла до лимита
+type signalingRateLimitStore struct {
+	RateLimitStore
+	calls chan string
+}
+
+func (s *signalingRateLimitStore) Allow(ctx context.Context, key string, limit int, window time.Duration) (RateLimitResult, error) {
+	result, err := s.RateLimitStore.Allow(ctx, key, limit, window)
+	s.calls <- key
+	return result, err
+}
+
+func TestMagicLinkHandlerHidesUnknownAddress(t *testing.T) {
+	ts := newTestServer(t)
+	useMagicLinkLimit(t, "3/15m")
+	limiter := &signalingRateLimitStore{RateLimitStore: rateLimiter, calls: make(chan string, 1)}
+	rateLimiter = limiter
+
+	// Ответ не зависит от существования аккаунта; поиск и письмо - в фоне
+	code, response := postJSON(t, ts.MagicLinkHandler, "/login/magic", `{"email":"nobody@example.com"}`)
+	if code != http.StatusAccepted || response["message"] != "If the account exists, a login link has been sent" {
+		t.Fatalf("magic link for an unknown address = %d %v, want 202", code, response)
+	}
+	// Лимит считается и для несуществующего адреса
+	select {
+	case key := <-limiter.calls:
+		if key != "login_magic:email:nobody@example.com" {
+			t.Fatalf("rate limit key = %q", key)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("magic link request was not processed")
+	}
+	if mail := ts.sentMail(t); len(mail) != 0 {
+		t.Fatalf("sent %d emails for an unknown address, want none", len(mail))
+	}
+
+	code, _ = postJSON(t, ts.MagicLinkHandler, "/login/magic", `{"email":""}`)
+	if code != http.StatusBadRequest {
+		t.Fatalf("magic link without an address = %d, want 400", code)
+	}
+}
+
+func TestMagicLinkEmailLimit(t *testing.T) {
+	ctx := context.Background()
+	ts := newTestServer(t)
+	useMagicLinkLimit(t, "2/15m")
+	for _, name := range []string{"alice", "bob"} {
+		if _, err := ts.users.CreateUser(ctx, name+"@example.com", name, "hash"); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+	}
+	request := func(email string) {
+		t.Helper()
+		if err := ts.requestMagicLink(ctx, email); err != nil {
+			t.Fatalf("requestMagicLink(%q): %v", email, err)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		request("alice@example.com")
+	}
+	if mail := ts.sentMail(t); len(mail) != 2 {
+		t.Fatalf("sent %d emails, want 2 (RATE_LIMIT_LOGIN_MAGIC_EMAIL=2/15m)", len(mail))
+	}
+
+	// Лимит считается по адресу: другой адрес не затронут
+	request("bob@example.com")
+	if mail := ts.sentMail(t); len(mail) != 3 || mail[2].To != "bob@example.com" {
+		t.Fatalf("mail = %+v, want a third email to bob", mail)
+	}
+
+	// Регистр и пробелы в адресе не дают обойти лимит
+	request("Bob@Example.com")
+	request(" bob@example.com")
+	request("bob@example.com")
+	if mail := ts.sentMail(t); len(mail) != 3 {
+		t.Fatalf("sent %d emails, want the limit for bob to be reached", len(mail))
+	}
+}
+
+func TestMagicLinkCallbackRejectsInvalidToken(t *testing.T) {
+	ts := newTestServer(t)
+
+	for _, token := range []string{"", "garbage"} {
+		rec := httptest.NewRecorder()
+		ts.MagicLinkCallbackHandler(rec, httptest.NewRequest(http.MethodGet, "/login/magic/callback?token="+url.QueryEscape(token), nil))
+		if rec.Code != http.StatusBadRequest && rec.Code != http.StatusUnauthorized {
+			t.Fatalf("callback with token %q = %d, want 400 or 401", token, rec.Code)
+		}
+		if rec.Header().Get("Cache-Control") != "no-store" {
+			t.Fatalf("callback response is cacheable")
+		}
+	}
+}
+
+// TestMagicLinkSingleUse входит по ссылке дважды. Использованные ссылки и подтверждение
+// адреса хранятся в PostgreSQL, поэтому нужна тестовая БД
+func TestMagicLinkSingleUse(t *testing.T) {
+	conn := requireTestDB(t)
+	ctx := context.Background()
+	if _, err := conn.Exec(`TRUNCATE users RESTART IDENTITY CASCADE`); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+
+	ts := newTestServer(t)
+	useMagicLinkLimit(t, "3/15m")
+	server := NewServer(NewPostgresUserStore(conn), ts.sessions, NewRevocationStore(), staticRoles{"user"}, ts.auditor, ts.throttle)
+	if _, err := server.users.CreateUser(ctx, "alice@example.com", "alice", "hash"); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if err := server.requestMagicLink(ctx, "alice@example.com"); err != nil {
+		t.Fatalf("requestMagicLink: %v", err)
+	}
+	mail := ts.sentMail(t)
+	if len(mail) != 1 {
+		t.Fatalf("sent %d emails, want 1", len(mail))
+	}
+	callbackURL := "/login/magic/callback?token=" + url.QueryEscape(magicLinkToken(t, mail[0]))
+
+	rec := httptest.NewRecorder()
+	server.MagicLinkCallbackHandler(rec, httptest.NewRequest(http.MethodGet, callbackURL, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("first use = %d %s, want 200", rec.Code, rec.Body.String())
+	}
+	user, err := server.users.GetUserByEmail(ctx, "alice@example.com")
+	if err != nil || user.EmailVerifiedAt == nil {
+		t.Fatalf("email is not verified after login by link: %+v, %v", user, err)
+	}
+
+	rec = httptest.NewRecorder()
+	server.MagicLinkCallbackHandler(rec, httptest.NewRequest(http.MethodGet, callbackURL, nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("second use = %d, want 401", rec.Code)
+	}
+	if e, _ := ts.auditor.last(auditLoginMagicLink); e.Details["reason"] != "link_reused" {
+		t.Fatalf("audit event = %+v", e)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message электронное письмо
+type Message struct {
+	To      string    `json:"to"`
+	Subject string    `json:"subject"`
+	Body    string    `json:"body"`
+	SentAt  time.Time `json:"sent_at"`
+}
+
+// Mailer отправляет электронные письма
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// mailer глобальный отправитель писем
+var mailer Mailer
+
+// InitMailer создает отправителя писем по cfg.Mailer:
+// smtp - реальная отправка, file - запись в cfg.File (JSON Lines), log - вывод в лог
+func InitMailer(cfg MailConfig) error {
+	switch cfg.Mailer {
+	case "smtp":
+		mailer = &SMTPMailer{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.From,
+		}
+	case "file":
+		mailer = &FileMailer{Path: cfg.File}
+	case "log":
+		mailer = LogMailer{}
+	default:
+		return fmt.Errorf("unknown MAILER: %s", cfg.Mailer)
+	}
+	return nil
+}
+
+// SMTPMailer отправляет письма через SMTP сервер (STARTTLS, если сервер его поддерживает)
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// Send отправляет письмо через SMTP
+func (m *SMTPMailer) Send(msg Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	// Заголовки не должны содержать переводов строк (защита от header injection)
+	if strings.ContainsAny(msg.To+msg.Subject, "\r\n") {
+		return fmt.Errorf("invalid message headers")
+	}
+
+	var sb strings.Builder
+	sb.WriteString("From: " + m.From + "\r\n")
+	sb.WriteString("To: " + msg.To + "\r\n")
+	sb.WriteString("Subject: " + msg.Subject + "\r\n")
+	sb.WriteString("MIME-Version: 1.0\r\n")
+	sb.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	sb.WriteString("\r\n")
+	sb.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	addr := m.Host + ":" + m.Port
+	if err := smtp.SendMail(addr, auth, m.From, []string{msg.To}, []byte(sb.String())); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
+
+// FileMailer дописывает письма в файл в формате JSON Lines.
+// Используется для локальной разработки и тестов вместо SMTP
+type FileMailer struct {
+	Path string
+	mu   sync.Mutex
+}
+
+// Send дописывает письмо в файл
+func (m *FileMailer) Send(msg Message) error {
+	if msg.SentAt.IsZero() {
+		msg.SentAt = time.Now()
+	}
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to encode email: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := os.OpenFile(m.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open mail file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write email: %w", err)
+	}
+	return nil
+}
+
+// LogMailer выводит письма в лог вместо отправки
+type LogMailer struct{}
+
+// Send выводит письмо в лог
+func (LogMailer) Send(msg Message) error {
+	log.Printf("📧 Email to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// sendMailAsync отправляет письмо в фоне, чтобы не задерживать ответ и не
+// раскрывать временем ответа, было ли письмо отправлено
+func sendMailAsync(msg Message) {
+	go func() {
+		if err := mailer.Send(msg); err != nil {
+			log.Printf("Send email error: %v", err)
+		}
+	}()
+}
+
+// appBaseURL адрес сервиса для ссылок (Config.Server.BaseURL, задается при запуске)
+var appBaseURL = "http://localhost:8080"
+
+// appURL строит абсолютную ссылку на сервис для писем
+func appURL(path string) string {
+	return strings.TrimRight(appBaseURL, "/") + path
+}
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/joho/godotenv"
 )
@@ -14,38 +19,221 @@ func main() {
 		log.Println("Warning: .env file not found")
 	}
 
-	// Инициализация JWT секретного ключа
-	InitAuth()
+	// Настройки: значения по умолчанию, файл (-config), окружение, флаги
+	cfg, args, err := LoadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+
+	// Подкоманды: "migrate up|down|status" обновляет схему БД без запуска сервера,
+	// "config" выводит действующие настройки
+	if len(args) > 0 {
+		switch args[0] {
+		case "migrate":
+			if err := runMigrateCommand(cfg, args[1:]); err != nil {
+				log.Fatal("Migration failed:", err)
+			}
+		case "config":
+			if err := runConfigCommand(cfg, args[1:]); err != nil {
+				log.Fatal(err)
+			}
+		default:
+			log.Fatalf("Unknown command %q, expected migrate or config", args[0])
+		}
+		return
+	}
+	appBaseURL = cfg.Server.BaseURL
+	accountConfig = cfg.Account
+
+	// Инициализация ключей подписи JWT
+	if err := InitAuth(cfg.Auth); err != nil {
+		log.Fatal("Failed to initialize auth:", err)
+	}
+
+	// Перезагрузка ключей по SIGHUP (с повторным чтением .env и файла настроек) и периодически из JWT_KEY_DIR
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	keyring.StartReloader(sighup, func() (AuthConfig, error) {
+		if err := godotenv.Overload(); err != nil {
+			log.Println("Warning: .env file not reloaded:", err)
+		}
+		next, _, err := LoadConfig(os.Args[1:])
+		if err != nil {
+			return AuthConfig{}, err
+		}
+		return next.Auth, nil
+	})
+
+	// Ключ шифрования TOTP секретов
+	if err := InitMFA(cfg.MFA); err != nil {
+		log.Fatal("Failed to initialize MFA:", err)
+	}
+
+	// Отправка писем (подтверждение email и т.п.)
+	if err := InitMailer(cfg.Mail); err != nil {
+		log.Fatal("Failed to initialize mailer:", err)
+	}
 
 	// TODO: Инициализация подключения к базе данных
 	// Используйте функцию InitDB() из database.go
-	if err := InitDB(); err != nil {
+	if err := InitDB(cfg.Database); err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
 	defer CloseDB()
 
+	// Сервер не запускается, пока схема отстает от бинарника (см. "migrate up")
+	if err := CheckSchemaVersion(context.Background()); err != nil {
+		log.Fatal("Database schema check failed:", err)
+	}
+
+	// Журнал аудита событий безопасности
+	InitAudit(cfg.Audit)
+
+	// Защита входа от перебора паролей
+	InitClientIP(cfg.Server)
+	InitLoginThrottle(cfg.Login)
+	loginThrottle.StartPruner(loginFailuresPruneInterval)
+
+	// Хранилища передаются обработчикам явно
+	users := NewPostgresUserStore(db)
+	sessions := NewSessionStore()
+	revocations := NewRevocationStore()
+	server := NewServer(users, sessions, revocations, NewPostgresRoleStore(db), auditor, loginThrottle)
+
+	// Первый администратор из ADMIN_EMAIL
+	if err := BootstrapAdmin(context.Background(), users, cfg.Account.AdminEmail); err != nil {
+		log.Fatal("Failed to bootstrap admin:", err)
+	}
+
+	// Фоновая очистка истекших записей об отозванных токенах
+	revocations.StartPruner(revocationPruneInterval)
+
+	// Ограничение частоты запросов
+	if err := InitRateLimiter(cfg.RateLimit); err != nil {
+		log.Fatal("Failed to initialize rate limiter:", err)
+	}
+
+	// Фоновая очистка истекших сессий
+	sessions.StartPruner(sessionPruneInterval)
+
+	// OAuth 2.1 сервер авторизации для других приложений
+	InitOAuth(cfg.OAuth)
+
+	// Вход через внешних OIDC провайдеров
+	InitOIDC(cfg.OIDC)
+
+	// Вход по ключам доступа (WebAuthn / passkeys)
+	InitWebAuthn(cfg.WebAuthn)
+
 	// TODO: Настройка HTTP маршрутов
 	// Используйте обработчики из handlers.go
-	http.HandleFunc("/register", RegisterHandler)
-	http.HandleFunc("/login", LoginHandler)
-	http.HandleFunc("/profile", AuthMiddleware(ProfileHandler))
+	http.HandleFunc("/register", RateLimit("register", server.RegisterHandler))
+	http.HandleFunc("/login", RateLimit("login", server.LoginHandler))
+	http.HandleFunc("/login/mfa", RateLimit("login_mfa", server.LoginMFAHandler))
+	http.HandleFunc("/login/magic", RateLimit("login_magic", server.MagicLinkHandler))
+	http.HandleFunc("/login/magic/callback", server.MagicLinkCallbackHandler)
+	http.HandleFunc("/oidc/", RateLimit("oidc", server.OIDCHandler))
+	http.HandleFunc("/webauthn/login/begin", RateLimit("webauthn", WebAuthnLoginBeginHandler))
+	http.HandleFunc("/webauthn/login/finish", RateLimit("webauthn", server.WebAuthnLoginFinishHandler))
+	http.HandleFunc("/webauthn/register/begin", server.AuthMiddleware(server.WebAuthnRegisterBeginHandler))
+	http.HandleFunc("/webauthn/register/finish", server.AuthMiddleware(server.WebAuthnRegisterFinishHandler))
+	http.HandleFunc("/webauthn/credentials", server.AuthMiddleware(WebAuthnCredentialsHandler))
+	http.HandleFunc("/webauthn/credentials/", server.AuthMiddleware(server.DeleteWebAuthnCredentialHandler))
+	http.HandleFunc("/token/refresh", server.RefreshTokenHandler)
+	http.HandleFunc("/oauth/authorize", server.AuthMiddleware(server.OAuthAuthorizeHandler))
+	http.HandleFunc("/oauth/token", RateLimit("oauth_token", server.OAuthTokenHandler))
+	http.HandleFunc("/oauth/introspect", server.OAuthIntrospectHandler)
+	http.HandleFunc("/oauth/revoke", server.OAuthRevokeHandler)
+	http.HandleFunc("/oauth/userinfo", server.OAuthUserInfoHandler)
+	http.HandleFunc("/verify-email", server.VerifyEmailHandler)
+	http.HandleFunc("/verify-email/resend", RateLimit("verify_email_resend", server.ResendVerificationHandler))
+	http.HandleFunc("/password/forgot", RateLimit("password_forgot", server.ForgotPasswordHandler))
+	http.HandleFunc("/password/reset", server.ResetPasswordHandler)
+	http.HandleFunc("/logout", server.AuthMiddleware(server.LogoutHandler))
+	http.HandleFunc("/logout/all", server.AuthMiddleware(server.LogoutAllHandler))
+	http.HandleFunc("/sessions", server.AuthMiddleware(SessionsHandler))
+	http.HandleFunc("/sessions/", server.AuthMiddleware(server.RevokeSessionHandler))
+	http.HandleFunc("/profile", server.AuthMiddleware(RateLimit("profile", server.ProfileHandler)))
+	http.HandleFunc("/profile/password", server.AuthMiddleware(server.ChangePasswordHandler))
+	http.HandleFunc("/profile/export", server.AuthMiddleware(ExportProfileHandler))
+	http.HandleFunc("/profile/api-keys", server.AuthMiddleware(server.APIKeysHandler))
+	http.HandleFunc("/profile/api-keys/", server.AuthMiddleware(server.RevokeAPIKeyHandler))
+	http.HandleFunc("/mfa/totp/enroll", server.AuthMiddleware(server.TOTPEnrollHandler))
+	http.HandleFunc("/mfa/totp/confirm", server.AuthMiddleware(server.TOTPConfirmHandler))
+	http.HandleFunc("/admin/audit", server.AuthMiddleware(RequirePermission(permAuditRead)(AdminAuditHandler)))
+	http.HandleFunc("/admin/roles", server.AuthMiddleware(RequirePermission(permRolesManage)(AdminRolesHandler)))
+	http.HandleFunc("/admin/users", server.AuthMiddleware(RequirePermission(permUsersRead)(server.AdminListUsersHandler)))
+	http.HandleFunc("/admin/users/", server.AuthMiddleware(server.AdminUsersHandler))
+	http.HandleFunc("/admin/oauth/clients", server.AuthMiddleware(RequirePermission(permOAuthClientsManage)(server.AdminOAuthClientsHandler)))
+	http.HandleFunc("/admin/oauth/clients/", server.AuthMiddleware(RequirePermission(permOAuthClientsManage)(server.AdminOAuthClientHandler)))
+	http.HandleFunc("/admin/db/stats", server.AuthMiddleware(RequirePermission(permSystemRead)(AdminDBStatsHandler)))
 	http.HandleFunc("/health", HealthHandler)
+	http.HandleFunc("/.well-known/jwks.json", JWKSHandler)
 
 	// Запуск сервера
-	port := getEnv("SERVER_PORT", "8080")
+	port := cfg.Server.Port
 	log.Printf("🚀 Server starting on port %s", port)
 	log.Printf("📝 Register: POST http://localhost:%s/register", port)
 	log.Printf("🔐 Login: POST http://localhost:%s/login", port)
+	log.Printf("🔢 Login MFA: POST http://localhost:%s/login/mfa", port)
+	log.Printf("🪄 Magic link: POST http://localhost:%s/login/magic", port)
+	log.Printf("🪄 Magic link callback: GET http://localhost:%s/login/magic/callback?token=...", port)
+	log.Printf("🌐 OIDC login: GET http://localhost:%s/oidc/{provider}/login", port)
+	log.Printf("🌐 OIDC callback: GET http://localhost:%s/oidc/{provider}/callback", port)
+	log.Printf("🔏 Passkey login: POST http://localhost:%s/webauthn/login/begin|finish", port)
+	log.Printf("🔏 Passkey registration: POST http://localhost:%s/webauthn/register/begin|finish (requires token)", port)
+	log.Printf("🔏 Passkeys: GET http://localhost:%s/webauthn/credentials, DELETE /webauthn/credentials/{id} (requires token)", port)
+	log.Printf("🤝 OAuth consent: GET|POST http://localhost:%s/oauth/authorize (requires token)", port)
+	log.Printf("🤝 OAuth token: POST http://localhost:%s/oauth/token", port)
+	log.Printf("🤝 OAuth introspect/revoke: POST http://localhost:%s/oauth/introspect|revoke", port)
+	log.Printf("🤝 OAuth userinfo: GET http://localhost:%s/oauth/userinfo (requires client token)", port)
+	log.Printf("🔄 Refresh: POST http://localhost:%s/token/refresh", port)
+	log.Printf("✉️  Verify email: GET http://localhost:%s/verify-email?token=...", port)
+	log.Printf("✉️  Resend verification: POST http://localhost:%s/verify-email/resend", port)
+	log.Printf("🔑 Forgot password: POST http://localhost:%s/password/forgot", port)
+	log.Printf("🔑 Reset password: POST http://localhost:%s/password/reset", port)
+	log.Printf("🚪 Logout: POST http://localhost:%s/logout (requires token)", port)
+	log.Printf("🚪 Logout everywhere: POST http://localhost:%s/logout/all (requires token)", port)
+	log.Printf("💻 Sessions: GET http://localhost:%s/sessions (requires token)", port)
+	log.Printf("💻 Revoke session: DELETE http://localhost:%s/sessions/{id} (requires token)", port)
 	log.Printf("👤 Profile: GET http://localhost:%s/profile (requires token)", port)
+	log.Printf("✏️  Update profile: PATCH http://localhost:%s/profile (requires token)", port)
+	log.Printf("🔑 Change password: POST http://localhost:%s/profile/password (requires token)", port)
+	log.Printf("🗑️  Delete account: DELETE http://localhost:%s/profile (requires token)", port)
+	log.Printf("📦 Export data: GET http://localhost:%s/profile/export (requires token)", port)
+	log.Printf("🗝️  API keys: GET|POST http://localhost:%s/profile/api-keys (requires token)", port)
+	log.Printf("🗝️  Revoke API key: DELETE http://localhost:%s/profile/api-keys/{id} (requires token)", port)
+	log.Printf("📱 TOTP enroll: POST http://localhost:%s/mfa/totp/enroll (requires token)", port)
+	log.Printf("📱 TOTP confirm: POST http://localhost:%s/mfa/totp/confirm (requires token)", port)
+	log.Printf("🛡️  Users: GET http://localhost:%s/admin/users?email=...&limit=20&offset=0 (requires users:read)", port)
+	log.Printf("🛡️  User: GET|DELETE http://localhost:%s/admin/users/{id} (requires users:read / users:write)", port)
+	log.Printf("🛡️  Lock user: POST http://localhost:%s/admin/users/{id}/lock|unlock (requires users:write)", port)
+	log.Printf("🛡️  Force password reset: POST http://localhost:%s/admin/users/{id}/password-reset (requires users:write)", port)
+	log.Printf("📜 Audit log: GET http://localhost:%s/admin/audit?user_id=...&event=...&from=...&to=... (requires audit:read)", port)
+	log.Printf("🛡️  Roles: GET http://localhost:%s/admin/roles (requires roles:manage)", port)
+	log.Printf("🛡️  Grant role: POST http://localhost:%s/admin/users/{id}/roles (requires roles:manage)", port)
+	log.Printf("🛡️  Revoke role: DELETE http://localhost:%s/admin/users/{id}/roles/{role} (requires roles:manage)", port)
+	log.Printf("🛡️  OAuth clients: GET|POST http://localhost:%s/admin/oauth/clients (requires oauth_clients:manage)", port)
+	log.Printf("🛡️  Delete OAuth client: DELETE http://localhost:%s/admin/oauth/clients/{client_id} (requires oauth_clients:manage)", port)
+	log.Printf("📊 DB pool stats: GET http://localhost:%s/admin/db/stats (requires system:read)", port)
 	log.Printf("❤️  Health: GET http://localhost:%s/health", port)
+	log.Printf("🔑 JWKS: GET http://localhost:%s/.well-known/jwks.json", port)
 
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	log.Fatal(NewHTTPServer(cfg.Server, nil).ListenAndServe())
 }
 
-// getEnv получает значение переменной окружения или возвращает значение по умолчанию
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// NewHTTPServer создает HTTP сервер с таймаутами из cfg. handler = nil - http.DefaultServeMux.
+// Таймауты не дают медленным клиентам бесконечно удерживать соединения
+func NewHTTPServer(cfg ServerConfig, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
 	}
-	return defaultValue
 }
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// TOTPEnrollHandler начинает подключение TOTP: создает секрет и otpauth:// URI.
+// TOTP включается только после подтверждения первым кодом (TOTPConfirmHandler)
+func (s *Server) TOTPEnrollHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 1. Загружаем пользователя
+	user, ok := s.loadCurrentUser(w, r)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabled {
+		sendErrorResponse(w, "TOTP is already enabled", http.StatusConflict)
+		return
+	}
+
+	// 2. Генерируем и шифруем секрет
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		log.Printf("Generate TOTP secret error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	encrypted, err := encryptTOTPSecret(user.ID, secret)
+	if errors.Is(err, errMFANotConfigured) {
+		sendErrorResponse(w, "Two-factor authentication is not available", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		log.Printf("Encrypt TOTP secret error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+
+	// 3. Сохраняем секрет до подтверждения
+	stored, err := s.users.SetPendingTOTPSecret(r.Context(), user.ID, encrypted)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	if !stored {
+		sendErrorResponse(w, "TOTP is already enabled", http.StatusConflict)
+		return
+	}
+
+	response := map[string]interface{}{
+		"message":     "Scan the URI with an authenticator app and confirm with a code",
+		"secret":      secret,
+		"otpauth_uri": totpURI(secret, user.Email),
+	}
+	sendJSONResponse(w, response, http.StatusOK)
+}
+
+// TOTPConfirmHandler подтверждает подключение TOTP первым кодом
+// и возвращает одноразовые коды восстановления (показываются один раз)
+func (s *Server) TOTPConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := GetUserIDFromContext(r)
+	if !ok {
+		sendErrorResponse(w, "User ID not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	// 1. Парсим JSON
+	var req MFACodeRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		sendErrorResponse(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Code == "" {
+		sendErrorResponse(w, "code is required", http.StatusBadRequest)
+		return
+	}
+
+	// 2. Проверяем, что подключение начато
+	state, err := s.users.GetTOTPState(r.Context(), userID)
+	if errors.Is(err, ErrNotFound) {
+		sendErrorResponse(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	if state.Enabled {
+		sendErrorResponse(w, "TOTP is already enabled", http.StatusConflict)
+		return
+	}
+	if state.SecretEncrypted == "" {
+		sendErrorResponse(w, "TOTP enrollment has not been started", http.StatusBadRequest)
+		return
+	}
+
+	// 3. Проверяем код
+	secret, err := decryptTOTPSecret(userID, state.SecretEncrypted)
+	if err != nil {
+		log.Printf("Decrypt TOTP secret error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	step, valid := verifyTOTP(secret, req.Code, state.LastStep, time.Now())
+	if !valid {
+		sendErrorResponse(w, "Invalid code", http.StatusBadRequest)
+		return
+	}
+
+	// 4. Генерируем коды восстановления и включаем TOTP
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		log.Printf("Generate recovery codes error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		if hashes[i], err = HashPassword(code); err != nil {
+			log.Printf("Hash recovery code error: %v", err)
+			sendInternalError(w, err)
+			return
+		}
+	}
+
+	if err := s.users.EnableTOTP(r.Context(), userID, step, hashes); err != nil {
+		log.Printf("Enable TOTP error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+	}
+	sendJSONResponse(w, response, http.StatusOK)
+}
+
+// LoginMFAHandler завершает вход: обменивает токен "mfa_pending" и TOTP код
+// (или код восстановления) на обычную пару токенов
+func (s *Server) LoginMFAHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 1. Парсим JSON
+	var req MFALoginRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		sendErrorResponse(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.MFAToken == "" {
+		sendErrorResponse(w, "mfa_token is required", http.StatusBadRequest)
+		return
+	}
+	if req.Code == "" && req.RecoveryCode == "" {
+		sendErrorResponse(w, "code or recovery_code is required", http.StatusBadRequest)
+		return
+	}
+
+	// 2. Проверяем токен ожидания второго фактора
+	claims, err := ValidatePurposeToken(req.MFAToken, tokenPurposeMFAPending)
+	if err != nil {
+		sendErrorResponse(w, "Invalid or expired MFA token", http.StatusUnauthorized)
+		return
+	}
+	revoked, err := s.revocations.IsRevoked(r.Context(), claims)
+	if err != nil {
+		log.Printf("Revocation check error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	if revoked {
+		sendErrorResponse(w, "Invalid or expired MFA token", http.StatusUnauthorized)
+		return
+	}
+
+	// 3. Проверяем второй фактор
+	valid, err := s.verifySecondFactor(r.Context(), claims.UserID, req.Code, req.RecoveryCode)
+	if err != nil {
+		log.Printf("MFA verification error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	if !valid {
+		// После MaxMFAFailures неверных кодов токен аннулируется: перебор кодов
+		// требует заново вводить пароль, сколько бы IP адресов ни было у атакующего
+		exhausted, err := s.throttle.RecordMFAFailure(context.Background(), claims.ID)
+		if err != nil {
+			log.Printf("Record MFA failure error: %v", err)
+			sendInternalError(w, err)
+			return
+		}
+		if exhausted {
+			if err := s.revocations.RevokeToken(context.Background(), claims.ID, claims.UserID, claims.ExpiresAt.Time); err != nil {
+				log.Printf("Revoke token error: %v", err)
+			}
+			s.audit(r, auditLoginMFA, claims.UserID, auditFailure, map[string]interface{}{"reason": "too_many_attempts"})
+			sendErrorResponse(w, "Too many invalid MFA codes, log in again", http.StatusUnauthorized)
+			return
+		}
+		s.audit(r, auditLoginMFA, claims.UserID, auditFailure, map[string]interface{}{"reason": "invalid_code"})
+		sendErrorResponse(w, "Invalid MFA code", http.StatusUnauthorized)
+		return
+	}
+
+	// 4. Токен ожидания одноразовый: из параллельных запросов с верным кодом
+	// и после аннулирования за неверные коды проходит не больше одного
+	consumed, err := s.revocations.Consume(r.Context(), claims.ID, claims.UserID, claims.ExpiresAt.Time)
+	if err != nil {
+		log.Printf("Consume token error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	if !consumed {
+		sendErrorResponse(w, "Invalid or expired MFA token", http.StatusUnauthorized)
+		return
+	}
+
+	// 5. Загружаем пользователя и выдаем токены
+	user, err := s.users.GetUserByID(r.Context(), claims.UserID)
+	if errors.Is(err, ErrNotFound) {
+		sendErrorResponse(w, "Invalid or expired MFA token", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	if user.LockedAt != nil {
+		sendErrorResponse(w, "Invalid or expired MFA token", http.StatusUnauthorized)
+		return
+	}
+
+	method := "totp"
+	if req.Code == "" {
+		method = "recovery_code"
+	}
+	s.audit(r, auditLoginMFA, user.ID, auditSuccess, map[string]interface{}{"method": method})
+	s.sendTokenResponse(w, r, user, "Login successful", http.StatusOK)
+}
+
+// verifySecondFactor проверяет TOTP код или код восстановления.
+// Оба вида кодов одноразовые: повтор того же кода отклоняется
+func (s *Server) verifySecondFactor(ctx context.Context, userID int, code, recoveryCode string) (bool, error) {
+	if code != "" {
+		state, err := s.users.GetTOTPState(ctx, userID)
+		if errors.Is(err, ErrNotFound) {
+			return false, nil
+		}
+		if err != nil || !state.Enabled {
+			return false, err
+		}
+		secret, err := decryptTOTPSecret(userID, state.SecretEncrypted)
+		if err != nil {
+			return false, err
+		}
+		step, valid := verifyTOTP(secret, code, state.LastStep, time.Now())
+		if !valid {
+			return false, nil
+		}
+		return s.users.AdvanceTOTPStep(ctx, userID, step)
+	}
+
+	codes, err := s.users.GetUnusedRecoveryCodes(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	normalized := normalizeRecoveryCode(recoveryCode)
+	for _, c := range codes {
+		if CheckPassword(normalized, c.CodeHash) {
+			return s.users.MarkRecoveryCodeUsed(ctx, c.ID)
+		}
+	}
+	return false, nil
+}
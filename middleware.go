@@ -3,6 +3,8 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"log"
+	"net"
 	"net/http"
 
 	// 1. Импортируйте "context" и "strings"
@@ -13,11 +15,12 @@ import (
 type contextKey string
 
 const (
-	contextKeyUser = contextKey("user")
+	contextKeyUser   = contextKey("user")
+	contextKeyClaims = contextKey("claims")
 )
 
-// AuthMiddleware проверяет JWT токен и устанавливает контекст пользователя
-func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+// AuthMiddleware проверяет JWT токен (или API ключ) и устанавливает контекст пользователя
+func (s *Server) AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// TODO: Реализуйте проверку JWT токена
 		// Используйте:
@@ -34,6 +37,28 @@ func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
+		// Персональный API ключ вместо токена: "ApiKey <key>"
+		if strings.HasPrefix(authHeader, apiKeyScheme) {
+			claims, reason, err := s.authenticateAPIKey(r.Context(), strings.TrimPrefix(authHeader, apiKeyScheme))
+			if err != nil {
+				log.Printf("API key check error: %v", err)
+				sendInternalError(w, err)
+				return
+			}
+			if claims == nil {
+				s.audit(r, auditTokenValidation, 0, auditFailure, map[string]interface{}{"reason": reason})
+				sendAuthError(w, "Invalid API key")
+				return
+			}
+			if !apiKeyAllows(claims, r) {
+				s.audit(r, auditTokenValidation, claims.UserID, auditFailure, map[string]interface{}{"reason": "api_key_scope", "key_id": claims.APIKeyID})
+				sendErrorResponse(w, "API key does not allow this request", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, withClaims(r, claims))
+			return
+		}
+
 		// 4. Проверяем формат "Bearer <token>"
 		const bearerPrefix = "Bearer "
 		if !strings.HasPrefix(authHeader, bearerPrefix) {
@@ -47,18 +72,66 @@ func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 		// 5. Валидируем токен с помощью ValidateToken() из auth.go
 		claims, err := ValidateToken(tokenString)
 		if err != nil {
+			s.audit(r, auditTokenValidation, 0, auditFailure, map[string]interface{}{"reason": err.Error()})
 			sendAuthError(w, fmt.Sprintf("Invalid token: %v", err))
 			return
 		}
 
-		// 6. Добавьте данные пользователя в контекст запроса
-		ctx := context.WithValue(r.Context(), "userID", claims.UserID)
+		// Служебные токены (например, ожидающие второй фактор) не дают доступа к API
+		if claims.Purpose != "" {
+			s.audit(r, auditTokenValidation, claims.UserID, auditFailure, map[string]interface{}{"reason": "purpose_token", "purpose": claims.Purpose})
+			sendAuthError(w, "Invalid token: token cannot be used for API access")
+			return
+		}
+
+		// Токены OAuth клиентов дают доступ только к ресурсам из их scope, но не к API сервиса
+		if claims.ClientID != "" {
+			s.audit(r, auditTokenValidation, claims.UserID, auditFailure, map[string]interface{}{"reason": "client_token", "client_id": claims.ClientID})
+			sendAuthError(w, "Invalid token: token was issued to an OAuth client")
+			return
+		}
+
+		// 6. Проверяем, что токен не отозван (выход из системы)
+		revoked, err := s.revocations.IsRevoked(r.Context(), claims)
+		if err != nil {
+			log.Printf("Revocation check error: %v", err)
+			sendInternalError(w, err)
+			return
+		}
+		if revoked {
+			s.audit(r, auditTokenValidation, claims.UserID, auditFailure, map[string]interface{}{"reason": "revoked", "jti": claims.ID})
+			sendAuthError(w, "Token has been revoked")
+			return
+		}
+
+		// Сессия токена не должна быть завершена (DELETE /sessions/{id}, выход)
+		if claims.SessionID != "" {
+			active, err := s.sessions.IsActive(r.Context(), claims.SessionID)
+			if err != nil {
+				log.Printf("Session check error: %v", err)
+				sendInternalError(w, err)
+				return
+			}
+			if !active {
+				s.audit(r, auditTokenValidation, claims.UserID, auditFailure, map[string]interface{}{"reason": "session_revoked", "session_id": claims.SessionID})
+				sendAuthError(w, "Session has been revoked")
+				return
+			}
+		}
 
-		// 7. Передаем управление следующему обработчику
-		next.ServeHTTP(w, r.WithContext(ctx))
+		// 7. Добавляем данные пользователя в контекст запроса
+		// 8. Передаем управление следующему обработчику
+		next.ServeHTTP(w, withClaims(r, claims))
 	}
 }
 
+// withClaims возвращает запрос с ID пользователя и claims в контексте
+func withClaims(r *http.Request, claims *Claims) *http.Request {
+	ctx := context.WithValue(r.Context(), "userID", claims.UserID)
+	ctx = context.WithValue(ctx, contextKeyClaims, claims)
+	return r.WithContext(ctx)
+}
+
 // sendAuthError отправляет JSON ответ с ошибкой 401 Unauthorized
 func sendAuthError(w http.ResponseWriter, message string) {
 	w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
@@ -88,3 +161,48 @@ func GetUserIDFromContext(r *http.Request) (int, bool) {
 	// Возвращаем значение и булевый флаг успешности
 	return userID, ok
 }
+
+// GetClaimsFromContext извлекает claims проверенного токена из контекста
+func GetClaimsFromContext(r *http.Request) (*Claims, bool) {
+	claims, ok := r.Context().Value(contextKeyClaims).(*Claims)
+	return claims, ok
+}
+
+// trustedProxyCount число доверенных прокси перед сервисом, каждый из которых дописывает
+// адрес своего клиента в X-Forwarded-For. 0 - заголовки прокси не учитываются
+var trustedProxyCount int
+
+// InitClientIP задает доверенные прокси (TRUST_PROXY_HEADERS и TRUSTED_PROXY_COUNT) при запуске
+func InitClientIP(cfg ServerConfig) {
+	trustedProxyCount = 0
+	if cfg.TrustProxyHeaders {
+		trustedProxyCount = cfg.TrustedProxyCount
+	}
+}
+
+// clientIP возвращает IP адрес клиента. Заголовки X-Forwarded-For и X-Real-IP
+// учитываются только при TRUST_PROXY_HEADERS=true (сервис за доверенным прокси),
+// иначе клиент мог бы подставить любой адрес
+func clientIP(r *http.Request) string {
+	if trustedProxyCount > 0 {
+		// Левые адреса X-Forwarded-For может подставить сам клиент, поэтому берется
+		// адрес, дописанный первым доверенным прокси: N-й справа при N прокси
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			hops := strings.Split(strings.Join(forwarded, ","), ",")
+			if len(hops) >= trustedProxyCount {
+				if ip := net.ParseIP(strings.TrimSpace(hops[len(hops)-trustedProxyCount])); ip != nil {
+					return ip.String()
+				}
+			}
+		} else if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+			// X-Real-IP прокси выставляет целиком, а не дописывает
+			return ip.String()
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
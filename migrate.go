@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// migrationFiles файлы схемы migrations/<версия>_<имя>.up.sql и .down.sql, встроенные в бинарник
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID ключ pg_advisory_lock: пока миграции выполняет одна реплика, остальные ждут
+const migrationLockID = 7426001
+
+// Migration одна версия схемы
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus состояние версии в базе: AppliedAt = nil - еще не применена
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+	// Unknown - версия применена более новым бинарником и в этом отсутствует
+	Unknown bool
+}
+
+// loadMigrations читает встроенные миграции и возвращает их по возрастанию версии.
+// У каждой версии должны быть оба файла: up и down
+func loadMigrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		fileName := entry.Name()
+		base, up := strings.CutSuffix(fileName, ".up.sql")
+		if !up {
+			var down bool
+			if base, down = strings.CutSuffix(fileName, ".down.sql"); !down {
+				return nil, fmt.Errorf("migration %s: expected .up.sql or .down.sql suffix", fileName)
+			}
+		}
+		versionStr, name, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(versionStr)
+		if !ok || name == "" || err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: expected <version>_<name>", fileName)
+		}
+
+		content, err := fs.ReadFile(migrationFiles, "migrations/"+fileName)
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(string(content)) == "" {
+			return nil, fmt.Errorf("migration %s is empty", fileName)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if m.Name != name {
+			return nil, fmt.Errorf("migration version %d has two names: %s and %s", version, m.Name, name)
+		}
+		if up {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s must have both up and down files", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// withMigrationLock выполняет fn на отдельном соединении под advisory lock.
+// Блокировка держится соединением, поэтому все запросы миграций идут через conn
+func withMigrationLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockID); err != nil {
+			log.Printf("Release migration lock error: %v", err)
+		}
+	}()
+
+	query := `
+        CREATE TABLE IF NOT EXISTS schema_migrations (
+            version BIGINT PRIMARY KEY,
+            name VARCHAR(255) NOT NULL,
+            applied_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+        )
+    `
+	if _, err := conn.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	return fn(conn)
+}
+
+// migrationQuerier *sql.DB или *sql.Conn, на котором держится блокировка
+type migrationQuerier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// appliedMigrations возвращает время применения каждой версии из schema_migrations
+func appliedMigrations(ctx context.Context, q migrationQuerier) (map[int]MigrationStatus, error) {
+	rows, err := q.QueryContext(ctx, `SELECT version, name, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]MigrationStatus)
+	for rows.Next() {
+		var s MigrationStatus
+		var appliedAt time.Time
+		if err := rows.Scan(&s.Version, &s.Name, &appliedAt); err != nil {
+			return nil, err
+		}
+		s.AppliedAt = &appliedAt
+		applied[s.Version] = s
+	}
+	return applied, rows.Err()
+}
+
+// runMigration выполняет SQL миграции и обновляет schema_migrations в одной транзакции
+func runMigration(ctx context.Context, conn *sql.Conn, m Migration, up bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	script, record := m.Down, `DELETE FROM schema_migrations WHERE version = $1`
+	if up {
+		script, record = m.Up, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`
+	}
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return fmt.Errorf("migration %04d_%s failed: %w", m.Version, m.Name, err)
+	}
+	args := []interface{}{m.Version}
+	if up {
+		args = append(args, m.Name)
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MigrateUp применяет все еще не примененные миграции по возрастанию версии
+func MigrateUp(ctx context.Context) ([]Migration, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	err = withMigrationLock(ctx, func(conn *sql.Conn) error {
+		// Список читается под блокировкой: другая реплика могла успеть применить миграции
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+			if err := runMigration(ctx, conn, m, true); err != nil {
+				return err
+			}
+			done = append(done, m)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// MigrateDown откатывает последнюю примененную миграцию. nil - откатывать нечего
+func MigrateDown(ctx context.Context) (*Migration, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var reverted *Migration
+	err = withMigrationLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		latest := 0
+		for version := range applied {
+			if version > latest {
+				latest = version
+			}
+		}
+		if latest == 0 {
+			return nil
+		}
+
+		// Откатить можно только версию, SQL которой есть в этом бинарнике
+		for _, m := range migrations {
+			if m.Version == latest {
+				if err := runMigration(ctx, conn, m, false); err != nil {
+					return err
+				}
+				reverted = &m
+				return nil
+			}
+		}
+		return fmt.Errorf("migration %d (%s) is unknown to this binary", latest, applied[latest].Name)
+	})
+	return reverted, err
+}
+
+// MigrationStatuses возвращает все известные и примененные версии по возрастанию
+func MigrationStatuses(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := readAppliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		s := MigrationStatus{Version: m.Version, Name: m.Name}
+		if a, ok := applied[m.Version]; ok {
+			s.AppliedAt = a.AppliedAt
+			delete(applied, m.Version)
+		}
+		statuses = append(statuses, s)
+	}
+	for _, a := range applied {
+		a.Unknown = true
+		statuses = append(statuses, a)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// readAppliedMigrations читает schema_migrations без блокировки.
+// Если таблицы еще нет, ни одна миграция не применена
+func readAppliedMigrations(ctx context.Context) (map[int]MigrationStatus, error) {
+	var exists bool
+	if err := db.QueryRowContext(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("failed to check schema_migrations: %w", err)
+	}
+	if !exists {
+		return map[int]MigrationStatus{}, nil
+	}
+	return appliedMigrations(ctx, db)
+}
+
+// CheckSchemaVersion возвращает ошибку, если в базе применены не все миграции бинарника.
+// Версии новее бинарника (схему уже обновила следующая версия сервиса) допустимы
+func CheckSchemaVersion(ctx context.Context) error {
+	statuses, err := MigrationStatuses(ctx)
+	if err != nil {
+		return err
+	}
+
+	var pending []string
+	for _, s := range statuses {
+		switch {
+		case s.Unknown:
+			log.Printf("Warning: database has migration %04d_%s unknown to this binary", s.Version, s.Name)
+		case s.AppliedAt == nil:
+			pending = append(pending, fmt.Sprintf("%04d_%s", s.Version, s.Name))
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("database schema is behind, pending migrations: %s; run \"migrate up\"", strings.Join(pending, ", "))
+	}
+	return nil
+}
+
+// runMigrateCommand выполняет подкоманду "migrate up|down|status"
+func runMigrateCommand(cfg *Config, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: migrate up|down|status")
+	}
+
+	if err := InitDB(cfg.Database); err != nil {
+		return err
+	}
+	defer CloseDB()
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		applied, err := MigrateUp(ctx)
+		for _, m := range applied {
+			log.Printf("Applied migration %04d_%s", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			log.Println("Schema is up to date")
+		}
+		return nil
+
+	case "down":
+		reverted, err := MigrateDown(ctx)
+		if err != nil {
+			return err
+		}
+		if reverted == nil {
+			log.Println("No migrations to revert")
+			return nil
+		}
+		log.Printf("Reverted migration %04d_%s", reverted.Version, reverted.Name)
+		return nil
+
+	case "status":
+		statuses, err := MigrationStatuses(ctx)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED AT")
+		for _, s := range statuses {
+			appliedAt := "pending"
+			if s.AppliedAt != nil {
+				appliedAt = s.AppliedAt.Format(time.RFC3339)
+			}
+			if s.Unknown {
+				appliedAt += " (unknown to this binary)"
+			}
+			fmt.Fprintf(tw, "%04d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("unknown migrate command %q, expected up, down or status", args[0])
+}
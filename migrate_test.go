@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useMigrationSchema подключается к TEST_DATABASE_URL с отдельной пустой схемой в search_path
+// и на время теста подменяет ею глобальное подключение db, которое используют миграции
+func useMigrationSchema(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv(testDatabaseURLEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", testDatabaseURLEnv)
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("open test database: %v", err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	schema := "migrate_test_" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`); err != nil {
+			t.Errorf("drop schema: %v", err)
+		}
+	})
+
+	// search_path передается серверу как параметр соединения
+	if strings.Contains(dsn, "://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			t.Fatalf("parse %s: %v", testDatabaseURLEnv, err)
+		}
+		query := u.Query()
+		query.Set("search_path", schema)
+		u.RawQuery = query.Encode()
+		dsn = u.String()
+	} else {
+		dsn += " search_path=" + schema
+	}
+	conn, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("open test schema: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	previous := db
+	db = conn
+	t.Cleanup(func() { db = previous })
+	return conn
+}
+
+// tableExists проверяет наличие таблицы в схеме теста
+func tableExists(t *testing.T, conn *sql.DB, table string) bool {
+	t.Helper()
+	var exists bool
+	if err := conn.QueryRow(`SELECT to_regclass($1) IS NOT NULL`, table).Scan(&exists); err != nil {
+		t.Fatalf("check table %s: %v", table, err)
+	}
+	return exists
+}
+
+// wantMigrationStatus проверяет, что все миграции бинарника применены (applied = true) или нет
+func wantMigrationStatus(t *testing.T, applied bool) {
+	t.Helper()
+	statuses, err := MigrationStatuses(context.Background())
+	if err != nil {
+		t.Fatalf("MigrationStatuses: %v", err)
+	}
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatalf("loadMigrations: %v", err)
+	}
+	if len(statuses) != len(migrations) {
+		t.Fatalf("status has %d versions, want %d", len(statuses), len(migrations))
+	}
+	for _, s := range statuses {
+		if s.Unknown || (s.AppliedAt != nil) != applied {
+			t.Fatalf("migration %04d_%s: applied at %v, unknown %v; want applied = %v", s.Version, s.Name, s.AppliedAt, s.Unknown, applied)
+		}
+	}
+	if err := CheckSchemaVersion(context.Background()); (err == nil) != applied {
+		t.Fatalf("CheckSchemaVersion = %v with applied = %v", err, applied)
+	}
+}
+
+// migrateUpDownUp применяет все миграции, откатывает их по одной и применяет снова
+func migrateUpDownUp(t *testing.T, conn *sql.DB) {
+	t.Helper()
+	ctx := context.Background()
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatalf("loadMigrations: %v", err)
+	}
+
+	if _, err := MigrateUp(ctx); err != nil {
+		t.Fatalf("MigrateUp: %v", err)
+	}
+	wantMigrationStatus(t, true)
+	if applied, err := MigrateUp(ctx); err != nil || len(applied) != 0 {
+		t.Fatalf("second MigrateUp = %v, %v; want nothing to apply", applied, err)
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		reverted, err := MigrateDown(ctx)
+		if err != nil {
+			t.Fatalf("MigrateDown: %v", err)
+		}
+		if reverted == nil || reverted.Version != migrations[i].Version {
+			t.Fatalf("MigrateDown reverted %+v, want version %d", reverted, migrations[i].Version)
+		}
+	}
+	if reverted, err := MigrateDown(ctx); err != nil || reverted != nil {
+		t.Fatalf("MigrateDown on an empty schema = %+v, %v", reverted, err)
+	}
+	wantMigrationStatus(t, false)
+	if tableExists(t, conn, "users") {
+		t.Fatal("users table survived reverting all migrations")
+	}
+
+	applied, err := MigrateUp(ctx)
+	if err != nil {
+		t.Fatalf("MigrateUp after down: %v", err)
+	}
+	if len(applied) != len(migrations) {
+		t.Fatalf("MigrateUp after down applied %d migrations, want %d", len(applied), len(migrations))
+	}
+	wantMigrationStatus(t, true)
+	for _, table := range []string{"users", "audit_log", "sessions", "webauthn_credentials", "schema_migrations"} {
+		if !tableExists(t, conn, table) {
+			t.Fatalf("table %s is missing after migrate up", table)
+		}
+	}
+	if tableExists(t, conn, "admin_actions") {
+		t.Fatal("admin_actions must be dropped by migrate up")
+	}
+}
+
+func TestMigrationsOnEmptyDatabase(t *testing.T) {
+	conn := useMigrationSchema(t)
+	wantMigrationStatus(t, false)
+	migrateUpDownUp(t, conn)
+}
+
+func TestMigrationsOnLegacyInitSQLDatabase(t *testing.T) {
+	conn := useMigrationSchema(t)
+	ctx := context.Background()
+
+	// База, созданная init.sql, с данными и журналом действий администраторов
+	// (admin_actions - таблица init.sql до появления журнала аудита)
+	legacy, err := os.ReadFile("testdata/legacy_init.sql")
+	if err != nil {
+		t.Fatalf("read legacy schema: %v", err)
+	}
+	if _, err := conn.Exec(string(legacy)); err != nil {
+		t.Fatalf("apply legacy init.sql: %v", err)
+	}
+	adminActions := `
+        CREATE TABLE admin_actions (
+            id BIGSERIAL PRIMARY KEY,
+            admin_id INTEGER NOT NULL,
+            action VARCHAR(50) NOT NULL,
+            target_user_id INTEGER NOT NULL,
+            details JSONB NOT NULL DEFAULT '{}',
+            created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+        )
+    `
+	if _, err := conn.Exec(adminActions); err != nil {
+		t.Fatalf("create admin_actions: %v", err)
+	}
+	var userID int
+	if err := conn.QueryRow(`INSERT INTO users (email, username, password_hash) VALUES ('legacy@example.com', 'legacy', 'hash') RETURNING id`).Scan(&userID); err != nil {
+		t.Fatalf("insert legacy user: %v", err)
+	}
+	if _, err := conn.Exec(`INSERT INTO admin_actions (admin_id, action, target_user_id, details) VALUES ($1, 'lock', $1, '{"reason": "test"}')`, userID); err != nil {
+		t.Fatalf("insert admin action: %v", err)
+	}
+	wantMigrationStatus(t, false)
+
+	// Миграции принимают существующую схему и сохраняют данные
+	if _, err := MigrateUp(ctx); err != nil {
+		t.Fatalf("MigrateUp on legacy schema: %v", err)
+	}
+	wantMigrationStatus(t, true)
+	var email string
+	if err := conn.QueryRow(`SELECT email FROM users WHERE id = $1`, userID).Scan(&email); err != nil || email != "legacy@example.com" {
+		t.Fatalf("legacy user after migrate up = %q, %v", email, err)
+	}
+	var moved int
+	if err := conn.QueryRow(`SELECT COUNT(*) FROM audit_log WHERE event = 'admin.lock' AND target_user_id = $1`, userID).Scan(&moved); err != nil || moved != 1 {
+		t.Fatalf("admin_actions rows moved to audit_log = %d, %v; want 1", moved, err)
+	}
+
+	migrateUpDownUp(t, conn)
+}
@@ -0,0 +1,2 @@
+-- Связи с ролями удаляются каскадно
+DELETE FROM permissions WHERE name = 'system:read';
@@ -0,0 +1,8 @@
+-- Разрешение на просмотр состояния сервиса (статистика пула соединений с БД)
+INSERT INTO permissions (name, description) VALUES
+    ('system:read', 'View service and database pool statistics')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r CROSS JOIN permissions p WHERE r.name = 'admin'
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,5 @@
+-- Обезличенные данные не восстанавливаются: удаленным аккаунтам возвращается
+-- уникальное имя-заглушка, чтобы снова сделать username обязательным
+UPDATE users SET username = 'deleted-' || id WHERE username IS NULL;
+
+ALTER TABLE users ALTER COLUMN username SET NOT NULL;
@@ -0,0 +1,19 @@
+-- Мягко удаленный аккаунт обезличивается: email заменяется на deleted-<id>@invalid
+-- (адрес освобождается для новой регистрации), имя пользователя - на NULL
+ALTER TABLE users ALTER COLUMN username DROP NOT NULL;
+
+-- Аккаунты, удаленные до этой миграции
+UPDATE users SET
+    email = 'deleted-' || id || '@invalid',
+    username = NULL,
+    password_hash = '',
+    totp_secret_encrypted = NULL,
+    totp_enabled = FALSE,
+    email_verified_at = NULL
+WHERE deleted_at IS NOT NULL;
+
+DELETE FROM mfa_recovery_codes
+WHERE user_id IN (SELECT id FROM users WHERE deleted_at IS NOT NULL);
+
+UPDATE sessions SET device = '', user_agent = '', ip = ''
+WHERE user_id IN (SELECT id FROM users WHERE deleted_at IS NOT NULL);
@@ -8,11 +8,17 @@ import (
 
 // User представляет пользователя в системе
 type User struct {
-	ID           int       `json:"id"`
-	Email        string    `json:"email"`
-	Username     string    `json:"username"`
-	PasswordHash string    `json:"-"` // "-" исключает поле из JSON
-	CreatedAt    time.Time `json:"created_at"`
+	ID              int        `json:"id"`
+	Email           string     `json:"email"`
+	Username        string     `json:"username"`
+	PasswordHash    string     `json:"-"`               // "-" исключает поле из JSON
+	Roles           []string   `json:"roles,omitempty"` // заполняется перед выдачей токена
+	TOTPEnabled     bool       `json:"totp_enabled"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"` // nil - адрес не подтвержден
+	LockedAt        *time.Time `json:"locked_at"`         // не nil - аккаунт заблокирован администратором
+	CreatedAt       time.Time  `json:"created_at"`
+	// PasswordResetRequired вход запрещен до сброса пароля (выставляет администратор)
+	PasswordResetRequired bool `json:"password_reset_required"`
 }
 
 // RegisterRequest структура для запроса регистрации
@@ -30,14 +36,302 @@ type LoginRequest struct {
 
 // AuthResponse структура ответа с токеном
 type AuthResponse struct {
-	Token string `json:"token"`
-	User  User   `json:"user"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	User         User   `json:"user"`
+}
+
+// RefreshRequest структура для запроса обновления токенов
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// EmailRequest структура для запросов, содержащих только email
+type EmailRequest struct {
+	Email string `json:"email"`
+}
+
+// UpdateProfileRequest структура для частичного обновления профиля.
+// Отсутствующие поля не меняются
+type UpdateProfileRequest struct {
+	Email    *string `json:"email"`
+	Username *string `json:"username"`
+}
+
+// ChangePasswordRequest структура для смены пароля
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// RoleRequest структура для назначения роли
+type RoleRequest struct {
+	Role string `json:"role"`
+}
+
+// UserFilter параметры списка пользователей для администратора
+type UserFilter struct {
+	EmailPrefix    string
+	UsernamePrefix string
+	Limit          int
+	Offset         int
+}
+
+// LockUserRequest структура для блокировки пользователя
+type LockUserRequest struct {
+	Reason string `json:"reason"`
+}
+
+// LoginFailureCounter счетчик неудачных попыток входа для email или IP
+type LoginFailureCounter struct {
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+}
+
+// AuditEvent событие журнала аудита
+type AuditEvent struct {
+	ID           int64                  `json:"id"`
+	Time         time.Time              `json:"time"`
+	Event        string                 `json:"event"`
+	UserID       *int                   `json:"user_id"`                  // кто выполнил действие (nil - неизвестен)
+	TargetUserID *int                   `json:"target_user_id,omitempty"` // над кем (для действий администратора)
+	IP           string                 `json:"ip"`
+	UserAgent    string                 `json:"user_agent"`
+	Outcome      string                 `json:"outcome"`
+	Details      map[string]interface{} `json:"details,omitempty"`
+}
+
+// AuditFilter параметры выборки событий аудита
+type AuditFilter struct {
+	UserID int
+	Event  string
+	From   time.Time
+	To     time.Time
+	Limit  int
+	Offset int
+}
+
+// Session сессия пользователя на одном устройстве.
+// ID совпадает с family_id ее refresh токенов
+type Session struct {
+	ID         string    `json:"id"`
+	Device     string    `json:"device"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"` // сессия токена, с которым пришел запрос
+}
+
+// Role роль и ее разрешения
+type Role struct {
+	ID          int      `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// DeleteAccountRequest структура для удаления аккаунта (требует пароль)
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+// PasswordResetRequest структура для установки нового пароля по токену из письма
+type PasswordResetRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+// MFACodeRequest структура для подтверждения TOTP кода
+type MFACodeRequest struct {
+	Code string `json:"code"`
+}
+
+// MFALoginRequest структура для второго шага входа.
+// Нужен либо Code из приложения, либо одноразовый RecoveryCode
+type MFALoginRequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// TOTPState состояние двухфакторной аутентификации пользователя
+type TOTPState struct {
+	SecretEncrypted string // пусто, если пользователь не начинал подключение
+	Enabled         bool
+	LastStep        int64 // последний использованный временной шаг (защита от повтора)
+}
+
+// RecoveryCode хеш неиспользованного кода восстановления
+type RecoveryCode struct {
+	ID       int
+	CodeHash string
+}
+
+// LogoutRequest структура для запроса выхода.
+// RefreshToken необязателен: если передан, отзывается и его семейство
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// RefreshToken представляет сохраненный refresh токен.
+// Все токены одной цепочки ротации имеют общий FamilyID
+type RefreshToken struct {
+	ID        int
+	UserID    int
+	TokenHash string
+	FamilyID  string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	RotatedAt *time.Time // токен уже обменян на новый
+	RevokedAt *time.Time // семейство токенов отозвано
 }
 
 // Claims структура для JWT токена
 type Claims struct {
-	UserID   int    `json:"user_id"`
-	Email    string `json:"email"`
-	Username string `json:"username"`
+	UserID   int      `json:"user_id"`
+	Email    string   `json:"email"`
+	Username string   `json:"username"`
+	Roles    []string `json:"roles,omitempty"`
+	// SessionID сессия (устройство), в рамках которой выдан токен
+	SessionID string `json:"sid,omitempty"`
+	// Purpose задан у служебных токенов (например, "mfa_pending"),
+	// которые нельзя использовать для доступа к API
+	Purpose string `json:"purpose,omitempty"`
+	// ClientID и Scope заданы у токенов, выданных OAuth клиенту (/oauth/token).
+	// Такие токены не дают доступа к API сервиса, только к ресурсам из Scope
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	// APIKeyID задан, если запрос пришел с API ключом, а не с токеном.
+	// Claims такого запроса строятся по ключу и в токены не попадают
+	APIKeyID int `json:"-"`
 	jwt.RegisteredClaims
 }
+
+// OIDCAuthRequest незавершенный вход через внешнего провайдера (между редиректом и callback)
+type OIDCAuthRequest struct {
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+}
+
+// OAuthClient приложение, зарегистрированное для входа через сервис (OAuth 2.1)
+type OAuthClient struct {
+	ClientID     string    `json:"client_id"`
+	SecretHash   string    `json:"-"` // пустой у публичного клиента (SPA, мобильное приложение)
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	GrantTypes   []string  `json:"grant_types"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// CreateOAuthClientRequest запрос регистрации OAuth клиента
+type CreateOAuthClientRequest struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Scopes       []string `json:"scopes"`
+	GrantTypes   []string `json:"grant_types"`
+	Public       bool     `json:"public"`
+}
+
+// OAuthAuthorizeRequest параметры запроса авторизации. Приходят в query (GET /oauth/authorize)
+// или в JSON вместе с решением пользователя (POST /oauth/authorize)
+type OAuthAuthorizeRequest struct {
+	ResponseType        string `json:"response_type"`
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+	Approve             bool   `json:"approve"`
+}
+
+// OAuthAuthorizationCode выданный клиенту код авторизации
+type OAuthAuthorizationCode struct {
+	ClientID      string
+	UserID        int
+	RedirectURI   string
+	Scopes        []string
+	CodeChallenge string
+}
+
+// APIKey персональный API ключ пользователя. Сам ключ показывается один раз при создании
+type APIKey struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"-"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"` // nil - бессрочный
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"-"`
+}
+
+// CreateAPIKeyRequest запрос создания API ключа
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// WebAuthnCredential зарегистрированный ключ доступа (passkey) пользователя
+type WebAuthnCredential struct {
+	ID                int        `json:"id"`
+	UserID            int        `json:"-"`
+	CredentialID      []byte     `json:"-"`
+	UserHandle        []byte     `json:"-"`
+	PublicKey         []byte     `json:"-"` // ключ в формате COSE
+	Algorithm         int64      `json:"-"`
+	SignCount         uint32     `json:"-"`
+	AAGUID            []byte     `json:"-"`
+	Name              string     `json:"name"`
+	AttestationFormat string     `json:"attestation_format"`
+	Transports        []string   `json:"transports"`
+	BackupEligible    bool       `json:"backup_eligible"` // синхронизируемый ключ (например, iCloud Keychain)
+	CreatedAt         time.Time  `json:"created_at"`
+	LastUsedAt        *time.Time `json:"last_used_at"`
+}
+
+// WebAuthnChallenge выданный браузеру challenge незавершенной церемонии WebAuthn
+type WebAuthnChallenge struct {
+	UserID     int    // 0 - вход, пользователь еще неизвестен
+	UserHandle []byte // user.id, переданный при регистрации
+}
+
+// PublicKeyCredential ответ navigator.credentials.create() или get() в JSON
+// (PublicKeyCredential.toJSON()): двоичные поля закодированы в base64url
+type PublicKeyCredential struct {
+	ID                      string                 `json:"id"`
+	RawID                   string                 `json:"rawId"`
+	Type                    string                 `json:"type"`
+	AuthenticatorAttachment string                 `json:"authenticatorAttachment,omitempty"`
+	ClientExtensionResults  map[string]interface{} `json:"clientExtensionResults,omitempty"`
+	Response                struct {
+		ClientDataJSON     string   `json:"clientDataJSON"`
+		AttestationObject  string   `json:"attestationObject,omitempty"`
+		Transports         []string `json:"transports,omitempty"`
+		PublicKey          string   `json:"publicKey,omitempty"`
+		PublicKeyAlgorithm int64    `json:"publicKeyAlgorithm,omitempty"`
+		AuthenticatorData  string   `json:"authenticatorData,omitempty"`
+		Signature          string   `json:"signature,omitempty"`
+		UserHandle         string   `json:"userHandle,omitempty"`
+	} `json:"response"`
+}
+
+// WebAuthnRegisterRequest завершение регистрации ключа доступа
+type WebAuthnRegisterRequest struct {
+	Name       string              `json:"name"`
+	Credential PublicKeyCredential `json:"credential"`
+}
+
+// WebAuthnLoginRequest завершение входа по ключу доступа
+type WebAuthnLoginRequest struct {
+	Credential PublicKeyCredential `json:"credential"`
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Стандартные scope, дающие клиенту данные пользователя
+const (
+	oauthScopeProfile = "profile"
+	oauthScopeEmail   = "email"
+)
+
+// Поддерживаемые grant types
+const (
+	oauthGrantAuthorizationCode = "authorization_code"
+	oauthGrantClientCredentials = "client_credentials"
+)
+
+const (
+	// oauthCodeTTL время жизни кода авторизации: клиент обменивает его сразу
+	oauthCodeTTL = time.Minute
+	// oauthPruneInterval период удаления необмененных кодов
+	oauthPruneInterval = 10 * time.Minute
+	// maxOAuthFormSize ограничение размера тела запросов к /oauth/*
+	maxOAuthFormSize = 64 << 10
+	// maxOAuthClientNameLength ограничение длины названия клиента (колонка oauth_clients.name)
+	maxOAuthClientNameLength = 100
+)
+
+// oauthAccessTokenTTL время жизни access токенов OAuth клиентов (OAUTH_ACCESS_TOKEN_TTL)
+var oauthAccessTokenTTL = time.Hour
+
+// oauthScopeDescriptions описания стандартных scope для экрана согласия.
+// Клиентам можно разрешить и собственные scope - они показываются как есть
+var oauthScopeDescriptions = map[string]string{
+	oauthScopeProfile: "Your user ID and username",
+	oauthScopeEmail:   "Your email address",
+}
+
+// oauthScopeToken допустимый scope (RFC 6749, 3.3) и допустимые PKCE значения (RFC 7636, 4.1)
+var (
+	oauthScopeToken = regexp.MustCompile(`^[\x21\x23-\x5B\x5D-\x7E]+$`)
+	pkceValue       = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+)
+
+// oauthError ошибка в формате RFC 6749, раздел 5.2
+type oauthError struct {
+	Code        string
+	Description string
+	Status      int
+}
+
+func (e *oauthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+// InitOAuth задает время жизни access токенов (проверено в Config.Validate)
+// и запускает очистку необмененных кодов авторизации
+func InitOAuth(cfg OAuthConfig) {
+	oauthAccessTokenTTL = cfg.AccessTokenTTL
+
+	go func() {
+		ticker := time.NewTicker(oauthPruneInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := DeleteExpiredOAuthAuthorizationCodes(context.Background()); err != nil {
+				log.Printf("OAuth prune error: %v", err)
+			}
+		}
+	}()
+}
+
+// oauthIssuer издатель (iss) токенов OAuth клиентов - адрес сервиса (Config.Server.BaseURL)
+func oauthIssuer() string {
+	return strings.TrimRight(appBaseURL, "/")
+}
+
+// oauthServerError ошибка сервера в формате OAuth. Таймаут или недоступность БД -
+// temporarily_unavailable (503, RFC 6749), остальное - server_error
+func oauthServerError(err error) *oauthError {
+	if isQueryTimeout(err) || isDatabaseUnavailable(err) {
+		return &oauthError{"temporarily_unavailable", "Service temporarily unavailable", http.StatusServiceUnavailable}
+	}
+	return &oauthError{"server_error", "Internal server error", http.StatusInternalServerError}
+}
+
+// authenticateOAuthClient определяет клиента запроса к /oauth/*: client_secret_basic,
+// client_secret_post или только client_id для публичного клиента.
+// Форма запроса должна быть уже разобрана (r.ParseForm)
+func authenticateOAuthClient(r *http.Request) (*OAuthClient, *oauthError) {
+	invalidClient := &oauthError{"invalid_client", "Client authentication failed", http.StatusUnauthorized}
+
+	clientID, secret, basic := r.BasicAuth()
+	if basic {
+		// RFC 6749, 2.3.1: учетные данные закодированы как application/x-www-form-urlencoded
+		var errID, errSecret error
+		clientID, errID = url.QueryUnescape(clientID)
+		secret, errSecret = url.QueryUnescape(secret)
+		if errID != nil || errSecret != nil {
+			return nil, invalidClient
+		}
+	} else {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID == "" {
+		return nil, invalidClient
+	}
+
+	client, err := GetOAuthClient(r.Context(), clientID)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		return nil, oauthServerError(err)
+	}
+	if client == nil {
+		return nil, invalidClient
+	}
+	if client.SecretHash == "" {
+		return client, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(client.SecretHash)) != 1 {
+		return nil, invalidClient
+	}
+	return client, nil
+}
+
+// parseOAuthScopes разбирает scope запроса клиента. Пустой scope означает все разрешенные клиенту
+func parseOAuthScopes(scope string, client *OAuthClient) ([]string, *oauthError) {
+	requested := strings.Fields(scope)
+	if len(requested) == 0 {
+		return client.Scopes, nil
+	}
+
+	scopes := make([]string, 0, len(requested))
+	for _, s := range requested {
+		if !containsString(client.Scopes, s) {
+			return nil, &oauthError{"invalid_scope", fmt.Sprintf("Scope %q is not allowed for this client", s), http.StatusBadRequest}
+		}
+		if !containsString(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes, nil
+}
+
+// validateAuthorizeRequest проверяет запрос авторизации (OAuth 2.1: только code и PKCE S256)
+// и возвращает клиента, адрес возврата и запрошенные scope
+func validateAuthorizeRequest(ctx context.Context, req *OAuthAuthorizeRequest) (*OAuthClient, string, []string, *oauthError) {
+	invalidRequest := func(description string) (*OAuthClient, string, []string, *oauthError) {
+		return nil, "", nil, &oauthError{"invalid_request", description, http.StatusBadRequest}
+	}
+
+	// 1. Клиент и адрес возврата: при ошибке в них перенаправлять пользователя нельзя
+	client, err := GetOAuthClient(ctx, req.ClientID)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		return nil, "", nil, oauthServerError(err)
+	}
+	if client == nil {
+		return invalidRequest("Unknown client_id")
+	}
+	redirectURI := req.RedirectURI
+	if redirectURI == "" && len(client.RedirectURIs) == 1 {
+		redirectURI = client.RedirectURIs[0]
+	}
+	if !containsString(client.RedirectURIs, redirectURI) {
+		return invalidRequest("redirect_uri is not registered for this client")
+	}
+
+	// 2. Параметры запроса
+	if req.ResponseType != "code" {
+		return nil, "", nil, &oauthError{"unsupported_response_type", "Only response_type=code is supported", http.StatusBadRequest}
+	}
+	if !containsString(client.GrantTypes, oauthGrantAuthorizationCode) {
+		return nil, "", nil, &oauthError{"unauthorized_client", "Client is not allowed to use the authorization code grant", http.StatusBadRequest}
+	}
+	if req.CodeChallengeMethod != "S256" || !pkceValue.MatchString(req.CodeChallenge) {
+		return invalidRequest("PKCE with code_challenge_method=S256 is required")
+	}
+
+	scopes, oauthErr := parseOAuthScopes(req.Scope, client)
+	if oauthErr != nil {
+		return nil, "", nil, oauthErr
+	}
+	return client, redirectURI, scopes, nil
+}
+
+// authorizeRedirect добавляет параметры ответа к адресу возврата клиента
+func authorizeRedirect(redirectURI string, params url.Values) string {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return redirectURI
+	}
+	query := u.Query()
+	for key, values := range params {
+		for _, value := range values {
+			query.Add(key, value)
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// validateOAuthClientRequest проверяет запрос регистрации клиента
+func validateOAuthClientRequest(req *CreateOAuthClientRequest) error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if utf8.RuneCountInString(req.Name) > maxOAuthClientNameLength {
+		return fmt.Errorf("name must be at most %d characters long", maxOAuthClientNameLength)
+	}
+
+	if len(req.GrantTypes) == 0 {
+		req.GrantTypes = []string{oauthGrantAuthorizationCode}
+	}
+	for _, grant := range req.GrantTypes {
+		switch grant {
+		case oauthGrantAuthorizationCode:
+			if len(req.RedirectURIs) == 0 {
+				return fmt.Errorf("redirect_uris are required for the authorization_code grant")
+			}
+		case oauthGrantClientCredentials:
+			if req.Public {
+				return fmt.Errorf("public clients cannot use the client_credentials grant")
+			}
+		default:
+			return fmt.Errorf("unsupported grant type %q", grant)
+		}
+	}
+
+	for _, raw := range req.RedirectURIs {
+		if err := validateRedirectURI(raw); err != nil {
+			return err
+		}
+	}
+	for _, scope := range req.Scopes {
+		if !oauthScopeToken.MatchString(scope) {
+			return fmt.Errorf("invalid scope %q", scope)
+		}
+	}
+	return nil
+}
+
+// validateRedirectURI проверяет адрес возврата: абсолютный https (http только для
+// localhost) без фрагмента. При авторизации адрес сравнивается с зарегистрированным точно
+func validateRedirectURI(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return fmt.Errorf("redirect URI %q must be an absolute URL", raw)
+	}
+	if u.Fragment != "" {
+		return fmt.Errorf("redirect URI %q must not contain a fragment", raw)
+	}
+	switch host := u.Hostname(); {
+	case u.Scheme == "https":
+	case u.Scheme == "http" && (host == "localhost" || host == "127.0.0.1" || host == "::1"):
+	default:
+		return fmt.Errorf("redirect URI %q must use https", raw)
+	}
+	return nil
+}
+
+// activeTokenClaims проверяет токен так же, как при доступе к API: подпись, срок, отзыв
+// и сессию, а для токенов клиентов - издателя, получателя (aud = client_id) и что клиент
+// еще зарегистрирован. Если audience не пусто, токен должен быть выдан для него.
+// Служебные токены и токены заблокированных или удаленных пользователей неактивны
+func (s *Server) activeTokenClaims(ctx context.Context, tokenString, audience string) (*Claims, bool, error) {
+	claims, err := ValidateToken(tokenString)
+	if err != nil || claims.Purpose != "" {
+		return nil, false, nil
+	}
+	if claims.ClientID != "" && (claims.Issuer != oauthIssuer() || !containsString(claims.Audience, claims.ClientID)) {
+		return nil, false, nil
+	}
+	if audience != "" && !containsString(claims.Audience, audience) {
+		return nil, false, nil
+	}
+
+	revoked, err := s.revocations.IsRevoked(ctx, claims)
+	if err != nil || revoked {
+		return nil, false, err
+	}
+	if claims.SessionID != "" {
+		active, err := s.sessions.IsActive(ctx, claims.SessionID)
+		if err != nil || !active {
+			return nil, false, err
+		}
+	}
+	if claims.ClientID != "" {
+		client, err := GetOAuthClient(ctx, claims.ClientID)
+		if err != nil || client == nil {
+			return nil, false, err
+		}
+	}
+	if claims.UserID != 0 {
+		user, err := s.users.GetUserByID(ctx, claims.UserID)
+		if errors.Is(err, ErrNotFound) {
+			return nil, false, nil
+		}
+		if err != nil || user.LockedAt != nil {
+			return nil, false, err
+		}
+	}
+	return claims, true, nil
+}
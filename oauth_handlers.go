@@ -0,0 +1,521 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OAuthAuthorizeHandler экран согласия OAuth (требует токен пользователя).
+// Страница согласия приложения вызывает GET /oauth/authorize с параметрами запроса
+// авторизации, чтобы показать пользователю клиента и scope, и POST /oauth/authorize
+// с решением пользователя ("approve"). В ответ на POST приходит redirect_to - адрес
+// возврата к клиенту с кодом авторизации или ошибкой access_denied
+func (s *Server) OAuthAuthorizeHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		req := OAuthAuthorizeRequest{
+			ResponseType:        query.Get("response_type"),
+			ClientID:            query.Get("client_id"),
+			RedirectURI:         query.Get("redirect_uri"),
+			Scope:               query.Get("scope"),
+			State:               query.Get("state"),
+			CodeChallenge:       query.Get("code_challenge"),
+			CodeChallengeMethod: query.Get("code_challenge_method"),
+		}
+		s.describeAuthorizeRequest(w, r, &req)
+	case http.MethodPost:
+		var req OAuthAuthorizeRequest
+		if err := parseJSONRequest(r, &req); err != nil {
+			sendErrorResponse(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		s.decideAuthorizeRequest(w, r, &req)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// describeAuthorizeRequest возвращает данные для экрана согласия
+func (s *Server) describeAuthorizeRequest(w http.ResponseWriter, r *http.Request, req *OAuthAuthorizeRequest) {
+	client, _, scopes, oauthErr := validateAuthorizeRequest(r.Context(), req)
+	if oauthErr != nil {
+		sendOAuthError(w, oauthErr)
+		return
+	}
+
+	userID, ok := GetUserIDFromContext(r)
+	if !ok {
+		sendErrorResponse(w, "User ID not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	// Согласие не нужно повторно, если пользователь уже разрешил все запрошенные scope
+	granted, err := GetOAuthConsent(r.Context(), userID, client.ClientID)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	consentRequired := false
+	scopeList := make([]map[string]string, 0, len(scopes))
+	for _, scope := range scopes {
+		scopeList = append(scopeList, map[string]string{
+			"scope":       scope,
+			"description": oauthScopeDescriptions[scope],
+		})
+		if !containsString(granted, scope) {
+			consentRequired = true
+		}
+	}
+
+	response := map[string]interface{}{
+		"client": map[string]string{
+			"client_id": client.ClientID,
+			"name":      client.Name,
+		},
+		"scopes":           scopeList,
+		"consent_required": consentRequired,
+	}
+	sendJSONResponse(w, response, http.StatusOK)
+}
+
+// decideAuthorizeRequest применяет решение пользователя: выдает код авторизации
+// или возвращает клиенту access_denied
+func (s *Server) decideAuthorizeRequest(w http.ResponseWriter, r *http.Request, req *OAuthAuthorizeRequest) {
+	// 1. Проверяем запрос заново: параметры пришли от клиента через браузер
+	client, redirectURI, scopes, oauthErr := validateAuthorizeRequest(r.Context(), req)
+	if oauthErr != nil {
+		sendOAuthError(w, oauthErr)
+		return
+	}
+
+	userID, ok := GetUserIDFromContext(r)
+	if !ok {
+		sendErrorResponse(w, "User ID not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	response := url.Values{}
+	if req.State != "" {
+		response.Set("state", req.State)
+	}
+	details := map[string]interface{}{"client_id": client.ClientID, "scope": strings.Join(scopes, " ")}
+
+	// 2. Пользователь отказал
+	if !req.Approve {
+		response.Set("error", "access_denied")
+		s.audit(r, auditOAuthAuthorize, userID, auditFailure, details)
+		sendJSONResponse(w, map[string]string{"redirect_to": authorizeRedirect(redirectURI, response)}, http.StatusOK)
+		return
+	}
+
+	// 3. Запоминаем согласие и выдаем одноразовый код. В БД хранится только его хеш
+	if err := SaveOAuthConsent(r.Context(), userID, client.ClientID, scopes); err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	code, err := randomToken(32)
+	if err != nil {
+		log.Printf("Generate token error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	authCode := OAuthAuthorizationCode{
+		ClientID:      client.ClientID,
+		UserID:        userID,
+		RedirectURI:   redirectURI,
+		Scopes:        scopes,
+		CodeChallenge: req.CodeChallenge,
+	}
+	if err := CreateOAuthAuthorizationCode(r.Context(), hashToken(code), authCode, time.Now().Add(oauthCodeTTL)); err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+
+	response.Set("code", code)
+	s.audit(r, auditOAuthAuthorize, userID, auditSuccess, details)
+	sendJSONResponse(w, map[string]string{"redirect_to": authorizeRedirect(redirectURI, response)}, http.StatusOK)
+}
+
+// OAuthTokenHandler выдает access токены клиентам (POST /oauth/token, form-urlencoded).
+// Поддерживаются grant_type=authorization_code (с PKCE) и client_credentials
+func (s *Server) OAuthTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 1. Разбираем форму и аутентифицируем клиента
+	r.Body = http.MaxBytesReader(w, r.Body, maxOAuthFormSize)
+	if err := r.ParseForm(); err != nil {
+		sendOAuthError(w, &oauthError{"invalid_request", "Invalid form body", http.StatusBadRequest})
+		return
+	}
+	client, oauthErr := authenticateOAuthClient(r)
+	if oauthErr != nil {
+		sendOAuthError(w, oauthErr)
+		return
+	}
+
+	// 2. Выдаем токен по grant type
+	grantType := r.PostForm.Get("grant_type")
+	if !containsString(client.GrantTypes, grantType) {
+		if grantType != oauthGrantAuthorizationCode && grantType != oauthGrantClientCredentials {
+			sendOAuthError(w, &oauthError{"unsupported_grant_type", "Unsupported grant_type", http.StatusBadRequest})
+			return
+		}
+		sendOAuthError(w, &oauthError{"unauthorized_client", "Client is not allowed to use this grant type", http.StatusBadRequest})
+		return
+	}
+
+	var (
+		token  string
+		scopes []string
+		userID int
+	)
+	switch grantType {
+	case oauthGrantAuthorizationCode:
+		var user *User
+		user, scopes, oauthErr = s.exchangeAuthorizationCode(r, client)
+		if oauthErr != nil {
+			s.audit(r, auditOAuthToken, 0, auditFailure, map[string]interface{}{"client_id": client.ClientID, "grant_type": grantType, "reason": oauthErr.Code})
+			sendOAuthError(w, oauthErr)
+			return
+		}
+		userID = user.ID
+		token, oauthErr = issueClientToken(user, client, scopes)
+	case oauthGrantClientCredentials:
+		// Токен выдается самому клиенту, поэтому клиент должен доказать, что знает секрет
+		if client.SecretHash == "" {
+			sendOAuthError(w, &oauthError{"unauthorized_client", "Public clients cannot use client_credentials", http.StatusBadRequest})
+			return
+		}
+		scopes, oauthErr = parseOAuthScopes(r.PostForm.Get("scope"), client)
+		if oauthErr == nil {
+			token, oauthErr = issueClientToken(nil, client, scopes)
+		}
+	}
+	if oauthErr != nil {
+		sendOAuthError(w, oauthErr)
+		return
+	}
+
+	s.audit(r, auditOAuthToken, userID, auditSuccess, map[string]interface{}{"client_id": client.ClientID, "grant_type": grantType})
+
+	// RFC 6749, 5.1: ответ с токеном не кешируется
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	response := map[string]interface{}{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int(oauthAccessTokenTTL.Seconds()),
+		"scope":        strings.Join(scopes, " "),
+	}
+	sendJSONResponse(w, response, http.StatusOK)
+}
+
+// exchangeAuthorizationCode проверяет код авторизации и PKCE code_verifier
+// и возвращает пользователя и scope, на которые он согласился
+func (s *Server) exchangeAuthorizationCode(r *http.Request, client *OAuthClient) (*User, []string, *oauthError) {
+	invalidGrant := &oauthError{"invalid_grant", "Invalid or expired authorization code", http.StatusBadRequest}
+
+	code, verifier := r.PostForm.Get("code"), r.PostForm.Get("code_verifier")
+	if code == "" || !pkceValue.MatchString(verifier) {
+		return nil, nil, &oauthError{"invalid_request", "code and a valid code_verifier are required", http.StatusBadRequest}
+	}
+
+	// 1. Код одноразовый: удаляется при первой попытке обмена, даже неудачной
+	authCode, err := ConsumeOAuthAuthorizationCode(r.Context(), hashToken(code))
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		return nil, nil, oauthServerError(err)
+	}
+	if authCode == nil || authCode.ClientID != client.ClientID {
+		return nil, nil, invalidGrant
+	}
+
+	// 2. Адрес возврата (если передан) и PKCE должны совпасть с запросом авторизации
+	if redirectURI := r.PostForm.Get("redirect_uri"); redirectURI != "" && redirectURI != authCode.RedirectURI {
+		return nil, nil, invalidGrant
+	}
+	if subtle.ConstantTimeCompare([]byte(pkceChallenge(verifier)), []byte(authCode.CodeChallenge)) != 1 {
+		return nil, nil, invalidGrant
+	}
+
+	// 3. Пользователь мог быть заблокирован или удален после согласия
+	user, err := s.users.GetUserByID(r.Context(), authCode.UserID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil, invalidGrant
+	}
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		return nil, nil, oauthServerError(err)
+	}
+	if user.LockedAt != nil {
+		return nil, nil, invalidGrant
+	}
+	return user, authCode.Scopes, nil
+}
+
+// issueClientToken создает access токен клиента (см. GenerateClientToken)
+func issueClientToken(user *User, client *OAuthClient, scopes []string) (string, *oauthError) {
+	token, err := GenerateClientToken(user, client.ClientID, scopes)
+	if err != nil {
+		log.Printf("Generate token error: %v", err)
+		return "", oauthServerError(err)
+	}
+	return token, nil
+}
+
+// OAuthIntrospectHandler сообщает клиенту, действителен ли токен (RFC 7662).
+// Доступно только конфиденциальным клиентам
+func (s *Server) OAuthIntrospectHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxOAuthFormSize)
+	if err := r.ParseForm(); err != nil {
+		sendOAuthError(w, &oauthError{"invalid_request", "Invalid form body", http.StatusBadRequest})
+		return
+	}
+	client, oauthErr := authenticateOAuthClient(r)
+	if oauthErr == nil && client.SecretHash == "" {
+		oauthErr = &oauthError{"invalid_client", "Client authentication failed", http.StatusUnauthorized}
+	}
+	if oauthErr != nil {
+		sendOAuthError(w, oauthErr)
+		return
+	}
+
+	// Клиент видит только выданные ему токены (aud), чужие и токены первой стороны неактивны
+	claims, active, err := s.activeTokenClaims(r.Context(), r.PostForm.Get("token"), client.ClientID)
+	if err != nil {
+		log.Printf("Introspection error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	if !active {
+		sendJSONResponse(w, map[string]interface{}{"active": false}, http.StatusOK)
+		return
+	}
+
+	response := map[string]interface{}{
+		"active":     true,
+		"token_type": "Bearer",
+		"iss":        claims.Issuer,
+		"aud":        claims.Audience,
+		"sub":        claims.Subject,
+		"client_id":  claims.ClientID,
+		"scope":      claims.Scope,
+		"exp":        claims.ExpiresAt.Unix(),
+		"iat":        claims.IssuedAt.Unix(),
+		"jti":        claims.ID,
+	}
+	if claims.Username != "" {
+		response["username"] = claims.Username
+	}
+	sendJSONResponse(w, response, http.StatusOK)
+}
+
+// OAuthRevokeHandler отзывает access токен клиента (RFC 7009).
+// Ответ всегда 200, даже для неизвестного токена
+func (s *Server) OAuthRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxOAuthFormSize)
+	if err := r.ParseForm(); err != nil {
+		sendOAuthError(w, &oauthError{"invalid_request", "Invalid form body", http.StatusBadRequest})
+		return
+	}
+	client, oauthErr := authenticateOAuthClient(r)
+	if oauthErr != nil {
+		sendOAuthError(w, oauthErr)
+		return
+	}
+
+	// Клиент может отозвать только выданные ему токены
+	claims, err := ValidateToken(r.PostForm.Get("token"))
+	if err == nil && claims.ClientID == client.ClientID {
+		if err := s.revocations.RevokeToken(r.Context(), claims.ID, claims.UserID, claims.ExpiresAt.Time); err != nil {
+			log.Printf("Revoke token error: %v", err)
+			sendOAuthError(w, oauthServerError(err))
+			return
+		}
+		s.audit(r, auditOAuthRevoke, claims.UserID, auditSuccess, map[string]interface{}{"client_id": client.ClientID, "jti": claims.ID})
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// OAuthUserInfoHandler возвращает клиенту данные пользователя по его access токену
+// (GET /oauth/userinfo, Authorization: Bearer). Поля зависят от scope токена
+func (s *Server) OAuthUserInfoHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	const bearerPrefix = "Bearer "
+	authHeader := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authHeader, bearerPrefix) {
+		sendAuthError(w, "Authorization header missing")
+		return
+	}
+	claims, active, err := s.activeTokenClaims(r.Context(), strings.TrimPrefix(authHeader, bearerPrefix), "")
+	if err != nil {
+		log.Printf("Token check error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	if !active || claims.ClientID == "" || claims.UserID == 0 {
+		sendAuthError(w, "Invalid token")
+		return
+	}
+
+	user, err := s.users.GetUserByID(r.Context(), claims.UserID)
+	if errors.Is(err, ErrNotFound) {
+		sendAuthError(w, "Invalid token")
+		return
+	}
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+
+	scopes := strings.Fields(claims.Scope)
+	response := map[string]interface{}{"sub": strconv.Itoa(user.ID)}
+	if containsString(scopes, oauthScopeProfile) {
+		response["username"] = user.Username
+	}
+	if containsString(scopes, oauthScopeEmail) {
+		response["email"] = user.Email
+		response["email_verified"] = user.EmailVerifiedAt != nil
+	}
+	sendJSONResponse(w, response, http.StatusOK)
+}
+
+// sendOAuthError отправляет ошибку в формате RFC 6749, раздел 5.2
+func sendOAuthError(w http.ResponseWriter, e *oauthError) {
+	if e.Status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	sendJSONResponse(w, map[string]string{"error": e.Code, "error_description": e.Description}, e.Status)
+}
+
+// AdminOAuthClientsHandler список (GET) и регистрация (POST) OAuth клиентов
+func (s *Server) AdminOAuthClientsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		clients, err := ListOAuthClients(r.Context())
+		if err != nil {
+			log.Printf("Database error: %v", err)
+			sendInternalError(w, err)
+			return
+		}
+		sendJSONResponse(w, map[string]interface{}{"clients": clients}, http.StatusOK)
+	case http.MethodPost:
+		s.createOAuthClientHandler(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// createOAuthClientHandler регистрирует клиента. Секрет показывается только в этом ответе
+func (s *Server) createOAuthClientHandler(w http.ResponseWriter, r *http.Request) {
+	var req CreateOAuthClientRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		sendErrorResponse(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := validateOAuthClientRequest(&req); err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	clientID, err := randomToken(16)
+	if err != nil {
+		log.Printf("Generate token error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	client := &OAuthClient{
+		ClientID:     clientID,
+		Name:         req.Name,
+		RedirectURIs: req.RedirectURIs,
+		Scopes:       req.Scopes,
+		GrantTypes:   req.GrantTypes,
+	}
+	if client.RedirectURIs == nil {
+		client.RedirectURIs = []string{}
+	}
+	if client.Scopes == nil {
+		client.Scopes = []string{}
+	}
+
+	response := map[string]interface{}{"client": client}
+	if !req.Public {
+		secret, err := randomToken(32)
+		if err != nil {
+			log.Printf("Generate token error: %v", err)
+			sendInternalError(w, err)
+			return
+		}
+		client.SecretHash = hashToken(secret)
+		response["client_secret"] = secret
+	}
+
+	adminID, _ := GetUserIDFromContext(r)
+	if err := CreateOAuthClient(r.Context(), client, adminID); err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+
+	s.auditAdmin(r, "create_oauth_client", 0, map[string]interface{}{"client_id": client.ClientID, "name": client.Name})
+	sendJSONResponse(w, response, http.StatusCreated)
+}
+
+// AdminOAuthClientHandler удаляет OAuth клиента (DELETE /admin/oauth/clients/{client_id}).
+// Выданные клиенту токены перестают быть активными
+func (s *Server) AdminOAuthClientHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	clientID := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/oauth/clients/"), "/")
+	if clientID == "" || strings.Contains(clientID, "/") {
+		sendErrorResponse(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	deleted, err := DeleteOAuthClient(r.Context(), clientID)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	if !deleted {
+		sendErrorResponse(w, "Client not found", http.StatusNotFound)
+		return
+	}
+
+	s.auditAdmin(r, "delete_oauth_client", 0, map[string]interface{}{"client_id": clientID})
+	sendJSONResponse(w, map[string]string{"message": "Client deleted"}, http.StatusOK)
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// oidcAuthRequestTTL время на вход у провайдера: от редиректа до возврата в callback
+	oidcAuthRequestTTL = 10 * time.Minute
+	// oidcMetadataTTL как долго кешируются метаданные провайдера и его ключи
+	oidcMetadataTTL = time.Hour
+	// oidcKeysMinRefresh минимальный интервал перезагрузки JWKS при неизвестном kid
+	oidcKeysMinRefresh = time.Minute
+	// oidcPruneInterval период удаления незавершенных входов
+	oidcPruneInterval = 10 * time.Minute
+	// oidcMaxResponseSize ограничение размера ответов провайдера
+	oidcMaxResponseSize = 1 << 20
+	// oidcClockSkew допустимое расхождение часов с провайдером
+	oidcClockSkew = time.Minute
+)
+
+// oidcSigningMethods алгоритмы подписи ID токена, которые мы принимаем.
+// "none" и HMAC (подпись секретом клиента) не принимаются
+var oidcSigningMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// oidcProviderName допустимое имя провайдера: оно входит в URL и имена переменных окружения
+var oidcProviderName = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// oidcProviders настроенные провайдеры по имени
+var oidcProviders = map[string]*OIDCProvider{}
+
+// oidcHTTPClient клиент для запросов к провайдерам
+var oidcHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// OIDCProvider внешний OpenID Connect провайдер (Google, корпоративный IdP и т.п.).
+// Метаданные (discovery) и ключи подписи (JWKS) загружаются при первом входе и кешируются
+type OIDCProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string // пустой для публичного клиента: тогда защищает только PKCE
+	RedirectURL  string
+	Scopes       []string
+	// LinkByEmail разрешает привязать вход к существующему аккаунту с тем же email,
+	// если адрес подтвержден и у нас, и у провайдера
+	LinkByEmail bool
+
+	mu         sync.Mutex
+	metadata   *oidcMetadata
+	metadataAt time.Time
+	keys       map[string]oidcKey
+	keysAt     time.Time
+}
+
+// oidcMetadata нужные нам поля документа /.well-known/openid-configuration
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcKey разобранный ключ подписи провайдера
+type oidcKey struct {
+	alg    string // пустой, если провайдер не ограничил алгоритм ключа
+	public interface{}
+}
+
+// oidcTokenResponse ответ token endpoint провайдера
+type oidcTokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// IDTokenClaims claims ID токена (и ответа userinfo), которые нужны для входа
+type IDTokenClaims struct {
+	Nonce             string   `json:"nonce"`
+	AuthorizedParty   string   `json:"azp"`
+	Email             string   `json:"email"`
+	EmailVerified     oidcBool `json:"email_verified"`
+	Name              string   `json:"name"`
+	PreferredUsername string   `json:"preferred_username"`
+	jwt.RegisteredClaims
+}
+
+// oidcBool булево значение, которое некоторые провайдеры передают строкой ("true")
+type oidcBool bool
+
+// UnmarshalJSON принимает true и "true"
+func (b *oidcBool) UnmarshalJSON(data []byte) error {
+	*b = oidcBool(strings.Trim(string(data), `"`) == "true")
+	return nil
+}
+
+// InitOIDC создает провайдеров из cfg (OIDC_PROVIDERS и OIDC_<NAME>_ISSUER, _CLIENT_ID,
+// _CLIENT_SECRET, _SCOPES, _REDIRECT_URL, _LINK_BY_EMAIL; проверены в Config.Validate)
+func InitOIDC(cfg OIDCConfig) {
+	if len(cfg.Providers) == 0 {
+		return
+	}
+
+	for _, name := range cfg.Providers {
+		p := cfg.Provider[name]
+		provider := &OIDCProvider{
+			Name:         name,
+			Issuer:       p.Issuer,
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			RedirectURL:  p.RedirectURL,
+			Scopes:       strings.Fields(p.Scopes),
+			LinkByEmail:  p.LinkByEmail,
+		}
+		if !containsString(provider.Scopes, "openid") {
+			provider.Scopes = append([]string{"openid"}, provider.Scopes...)
+		}
+		oidcProviders[name] = provider
+		log.Printf("OIDC provider %s: %s", name, provider.Issuer)
+	}
+
+	go func() {
+		ticker := time.NewTicker(oidcPruneInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := DeleteExpiredOIDCAuthRequests(context.Background()); err != nil {
+				log.Printf("OIDC prune error: %v", err)
+			}
+		}
+	}()
+}
+
+// discover возвращает метаданные провайдера, загружая их не чаще раза в oidcMetadataTTL
+func (p *OIDCProvider) discover() (*oidcMetadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil && time.Since(p.metadataAt) < oidcMetadataTTL {
+		return p.metadata, nil
+	}
+
+	var metadata oidcMetadata
+	if err := oidcGetJSON(strings.TrimSuffix(p.Issuer, "/")+"/.well-known/openid-configuration", "", &metadata); err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed: %w", err)
+	}
+	// issuer в документе должен точно совпадать с настроенным (OpenID Connect Discovery, 4.3)
+	if metadata.Issuer != p.Issuer {
+		return nil, fmt.Errorf("OIDC discovery returned issuer %q, expected %q", metadata.Issuer, p.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, fmt.Errorf("OIDC discovery document of %s is incomplete", p.Issuer)
+	}
+
+	p.metadata, p.metadataAt = &metadata, time.Now()
+	return &metadata, nil
+}
+
+// AuthCodeURL строит ссылку на вход у провайдера: authorization code flow с PKCE (S256)
+func (p *OIDCProvider) AuthCodeURL(state, nonce, codeVerifier string) (string, error) {
+	metadata, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(metadata.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid authorization endpoint: %w", err)
+	}
+
+	query := u.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.ClientID)
+	query.Set("redirect_uri", p.RedirectURL)
+	query.Set("scope", strings.Join(p.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", pkceChallenge(codeVerifier))
+	query.Set("code_challenge_method", "S256")
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// Exchange обменивает код авторизации на токены провайдера
+func (p *OIDCProvider) Exchange(code, codeVerifier string) (*oidcTokenResponse, error) {
+	metadata, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	// Публичный клиент передает только client_id, конфиденциальный - client_secret_basic
+	if p.ClientSecret == "" {
+		form.Set("client_id", p.ClientID)
+	}
+	req, err := http.NewRequest(http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		// RFC 6749, 2.3.1: учетные данные кодируются как application/x-www-form-urlencoded
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	resp, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var tokens oidcTokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, oidcMaxResponseSize)).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("failed to decode token response (status %d): %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || tokens.Error != "" {
+		return nil, fmt.Errorf("token endpoint returned %d: %s %s", resp.StatusCode, tokens.Error, tokens.ErrorDescription)
+	}
+	if tokens.IDToken == "" {
+		return nil, fmt.Errorf("token response has no id_token")
+	}
+	return &tokens, nil
+}
+
+// VerifyIDToken проверяет подпись ID токена ключом провайдера, issuer, audience,
+// срок действия и nonce из нашего запроса авторизации
+func (p *OIDCProvider) VerifyIDToken(rawToken, nonce string) (*IDTokenClaims, error) {
+	claims := &IDTokenClaims{}
+
+	keyFunc := func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := p.publicKey(kid)
+		if err != nil {
+			return nil, err
+		}
+		if key.alg != "" && key.alg != token.Method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.public, nil
+	}
+
+	_, err := jwt.ParseWithClaims(rawToken, claims, keyFunc,
+		jwt.WithValidMethods(oidcSigningMethods),
+		jwt.WithIssuer(p.Issuer),
+		jwt.WithAudience(p.ClientID),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(oidcClockSkew),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+
+	if claims.ExpiresAt == nil || claims.Subject == "" {
+		return nil, fmt.Errorf("ID token has no exp or sub")
+	}
+	// Токен, выданный для нескольких клиентов, должен быть выдан именно нам (OpenID Connect Core, 3.1.3.7)
+	if claims.AuthorizedParty != "" && claims.AuthorizedParty != p.ClientID {
+		return nil, fmt.Errorf("ID token was issued to %q", claims.AuthorizedParty)
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty == "" {
+		return nil, fmt.Errorf("ID token with multiple audiences has no azp")
+	}
+	// nonce связывает ID токен с нашим запросом авторизации и защищает от его повтора
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, fmt.Errorf("ID token nonce mismatch")
+	}
+	return claims, nil
+}
+
+// UserInfo загружает профиль пользователя из userinfo endpoint.
+// Нужен провайдерам, которые не кладут email в ID токен
+func (p *OIDCProvider) UserInfo(accessToken string) (*IDTokenClaims, error) {
+	metadata, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+	if metadata.UserinfoEndpoint == "" {
+		return nil, fmt.Errorf("provider has no userinfo endpoint")
+	}
+
+	var info IDTokenClaims
+	if err := oidcGetJSON(metadata.UserinfoEndpoint, accessToken, &info); err != nil {
+		return nil, fmt.Errorf("userinfo request failed: %w", err)
+	}
+	return &info, nil
+}
+
+// publicKey находит ключ подписи провайдера по kid. Неизвестный kid (провайдер
+// сменил ключи) приводит к перезагрузке JWKS, но не чаще oidcKeysMinRefresh
+func (p *OIDCProvider) publicKey(kid string) (oidcKey, error) {
+	metadata, err := p.discover()
+	if err != nil {
+		return oidcKey{}, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key, ok := p.lookupKey(kid)
+	age := time.Since(p.keysAt)
+	if (!ok || age >= oidcMetadataTTL) && age >= oidcKeysMinRefresh {
+		var set JWKS
+		if err := oidcGetJSON(metadata.JWKSURI, "", &set); err != nil {
+			if ok {
+				// Провайдер временно недоступен, а ключ уже известен
+				log.Printf("OIDC JWKS refresh error for %s: %v", p.Name, err)
+				return key, nil
+			}
+			return oidcKey{}, fmt.Errorf("failed to load JWKS: %w", err)
+		}
+
+		p.keys = make(map[string]oidcKey, len(set.Keys))
+		for _, jwk := range set.Keys {
+			if jwk.Use != "" && jwk.Use != "sig" {
+				continue
+			}
+			public, err := parsePublicJWK(jwk)
+			if err != nil {
+				log.Printf("OIDC provider %s: skipping key %q: %v", p.Name, jwk.Kid, err)
+				continue
+			}
+			p.keys[jwk.Kid] = oidcKey{alg: jwk.Alg, public: public}
+		}
+		p.keysAt = time.Now()
+		key, ok = p.lookupKey(kid)
+	}
+	if !ok {
+		return oidcKey{}, fmt.Errorf("unknown key id: %q", kid)
+	}
+	return key, nil
+}
+
+// lookupKey ищет ключ в кеше. Токен без kid допустим, только если ключ один
+func (p *OIDCProvider) lookupKey(kid string) (oidcKey, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+// oidcGetJSON выполняет GET запрос к провайдеру (с bearer токеном, если он задан) и разбирает JSON ответ
+func oidcGetJSON(rawURL, bearerToken string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+bearerToken)
+	}
+
+	resp, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d", rawURL, resp.StatusCode)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, oidcMaxResponseSize)).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", rawURL, err)
+	}
+	return nil
+}
+
+// pkceChallenge вычисляет code_challenge = BASE64URL(SHA256(code_verifier)) (RFC 7636)
+func pkceChallenge(codeVerifier string) string {
+	sum := sha256.Sum256([]byte(codeVerifier))
+	return b64(sum[:])
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// oidcStateCookie cookie, привязывающая state к браузеру, который начал вход (защита от login CSRF)
+const oidcStateCookie = "oidc_state"
+
+// maxOIDCUsernameAttempts сколько вариантов имени пробуется, если имя от провайдера уже занято
+const maxOIDCUsernameAttempts = 5
+
+var (
+	errOIDCEmailRequired = errors.New("identity provider did not return an email address")
+	errOIDCEmailTaken    = errors.New("email belongs to an account that cannot be linked")
+)
+
+// OIDCHandler обрабатывает вход через внешних провайдеров:
+// GET /oidc/{provider}/login и GET /oidc/{provider}/callback
+func (s *Server) OIDCHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/oidc/"), "/"), "/")
+	if len(parts) != 2 {
+		sendErrorResponse(w, "Not found", http.StatusNotFound)
+		return
+	}
+	provider, ok := oidcProviders[parts[0]]
+	if !ok {
+		sendErrorResponse(w, "Unknown identity provider", http.StatusNotFound)
+		return
+	}
+
+	switch parts[1] {
+	case "login":
+		oidcLoginHandler(w, r, provider)
+	case "callback":
+		s.oidcCallbackHandler(w, r, provider)
+	default:
+		sendErrorResponse(w, "Not found", http.StatusNotFound)
+	}
+}
+
+// oidcLoginHandler перенаправляет пользователя на страницу входа провайдера
+func oidcLoginHandler(w http.ResponseWriter, r *http.Request, provider *OIDCProvider) {
+	// 1. Одноразовые значения: state против CSRF, nonce против повтора ID токена,
+	//    code_verifier для PKCE
+	var values [3]string
+	for i := range values {
+		value, err := randomToken(32)
+		if err != nil {
+			log.Printf("Generate token error: %v", err)
+			sendInternalError(w, err)
+			return
+		}
+		values[i] = value
+	}
+	state, nonce, codeVerifier := values[0], values[1], values[2]
+
+	// 2. Ссылка на вход (при первом обращении загружаются метаданные провайдера)
+	authURL, err := provider.AuthCodeURL(state, nonce, codeVerifier)
+	if err != nil {
+		log.Printf("OIDC provider %s error: %v", provider.Name, err)
+		sendErrorResponse(w, "Identity provider is unavailable", http.StatusBadGateway)
+		return
+	}
+
+	// 3. Сохраняем запрос до возврата пользователя
+	if err := CreateOIDCAuthRequest(r.Context(), hashToken(state), provider.Name, nonce, codeVerifier, time.Now().Add(oidcAuthRequestTTL)); err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+
+	setOIDCStateCookie(w, provider, state, int(oidcAuthRequestTTL.Seconds()))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// oidcCallbackHandler завершает вход: проверяет state, обменивает код на токены,
+// проверяет ID токен и выдает наши токены связанному пользователю
+func (s *Server) oidcCallbackHandler(w http.ResponseWriter, r *http.Request, provider *OIDCProvider) {
+	query := r.URL.Query()
+	auditDetails := map[string]interface{}{"provider": provider.Name}
+	fail := func(reason string, message string, statusCode int) {
+		auditDetails["reason"] = reason
+		s.audit(r, auditLoginOIDC, 0, auditFailure, auditDetails)
+		sendErrorResponse(w, message, statusCode)
+	}
+
+	// 1. Провайдер сообщил об ошибке (например, пользователь отказался от входа)
+	if providerError := query.Get("error"); providerError != "" {
+		auditDetails["error"] = providerError
+		fail("provider_error", "Identity provider returned an error", http.StatusUnauthorized)
+		return
+	}
+
+	// 2. state должен совпасть с cookie браузера и с сохраненным запросом
+	state, code := query.Get("state"), query.Get("code")
+	if state == "" || code == "" {
+		sendErrorResponse(w, "state and code are required", http.StatusBadRequest)
+		return
+	}
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		fail("state_mismatch", "Invalid or expired state", http.StatusBadRequest)
+		return
+	}
+	setOIDCStateCookie(w, provider, "", -1)
+
+	authRequest, err := ConsumeOIDCAuthRequest(r.Context(), hashToken(state))
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	if authRequest == nil || authRequest.Provider != provider.Name {
+		fail("invalid_state", "Invalid or expired state", http.StatusBadRequest)
+		return
+	}
+
+	// 3. Обмениваем код на токены провайдера и проверяем ID токен
+	tokens, err := provider.Exchange(code, authRequest.CodeVerifier)
+	if err != nil {
+		log.Printf("OIDC provider %s error: %v", provider.Name, err)
+		fail("code_exchange_failed", "Failed to complete sign-in with the identity provider", http.StatusBadGateway)
+		return
+	}
+	claims, err := provider.VerifyIDToken(tokens.IDToken, authRequest.Nonce)
+	if err != nil {
+		log.Printf("OIDC provider %s error: %v", provider.Name, err)
+		fail("invalid_id_token", "Invalid ID token", http.StatusUnauthorized)
+		return
+	}
+	auditDetails["subject"] = claims.Subject
+
+	// 4. Некоторые провайдеры не кладут email в ID токен - берем его из userinfo
+	if claims.Email == "" && tokens.AccessToken != "" {
+		info, err := provider.UserInfo(tokens.AccessToken)
+		if err != nil {
+			log.Printf("OIDC provider %s error: %v", provider.Name, err)
+		} else if info.Subject == claims.Subject {
+			claims.Email, claims.EmailVerified = info.Email, info.EmailVerified
+			if claims.PreferredUsername == "" && claims.Name == "" {
+				claims.PreferredUsername, claims.Name = info.PreferredUsername, info.Name
+			}
+		}
+	}
+
+	// 5. Находим привязанного пользователя, привязываем существующего или создаем нового
+	user, created, err := s.resolveOIDCUser(r.Context(), provider, claims)
+	switch {
+	case errors.Is(err, errOIDCEmailRequired):
+		fail("email_missing", "Identity provider did not return an email address", http.StatusBadRequest)
+		return
+	case errors.Is(err, errOIDCEmailTaken):
+		auditDetails["email_sha256"] = auditEmail(claims.Email)
+		fail("email_taken", "An account with this email already exists. Sign in with your password", http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("OIDC link error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	if created {
+		s.audit(r, auditRegister, user.ID, auditSuccess, map[string]interface{}{"provider": provider.Name})
+	}
+
+	if user.LockedAt != nil {
+		auditDetails["reason"] = "account_locked"
+		s.audit(r, auditLoginOIDC, user.ID, auditFailure, auditDetails)
+		sendErrorResponse(w, "Account is locked", http.StatusForbidden)
+		return
+	}
+
+	// Провайдер не подтвердил адрес: проверяем его сами, как при регистрации
+	if emailVerificationRequired() && user.EmailVerifiedAt == nil {
+		if created {
+			if err := sendVerificationEmail(*user); err != nil {
+				log.Printf("Send verification email error: %v", err)
+			}
+		}
+		auditDetails["reason"] = "email_not_verified"
+		s.audit(r, auditLoginOIDC, user.ID, auditFailure, auditDetails)
+		sendErrorResponse(w, "Email address is not verified", http.StatusForbidden)
+		return
+	}
+
+	// 6. Второй фактор и выдача наших токенов - как при входе по паролю
+	s.completeLogin(w, r, user, auditLoginOIDC, auditDetails)
+}
+
+// resolveOIDCUser возвращает пользователя внешней учетной записи: уже привязанного;
+// существующего с тем же email, если его можно привязать; или нового (created = true)
+func (s *Server) resolveOIDCUser(ctx context.Context, provider *OIDCProvider, claims *IDTokenClaims) (*User, bool, error) {
+	// 1. Учетная запись уже привязана
+	userID, err := s.users.GetIdentityUserID(ctx, provider.Name, claims.Subject)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, false, err
+	}
+	if err == nil {
+		if err := s.users.TouchIdentity(ctx, provider.Name, claims.Subject, claims.Email); err != nil {
+			return nil, false, err
+		}
+		user, err := s.users.GetUserByID(ctx, userID)
+		if errors.Is(err, ErrNotFound) {
+			return nil, false, errors.New("linked user not found")
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		return user, false, nil
+	}
+
+	if claims.Email == "" {
+		return nil, false, errOIDCEmailRequired
+	}
+
+	// 2. Аккаунт с тем же email привязывается, только если адрес подтвержден с обеих сторон.
+	//    Иначе владелец чужой учетной записи у провайдера получил бы доступ к аккаунту,
+	//    а зарегистрировавший чужой email заранее - доступ к входу через провайдера
+	existing, err := s.users.GetUserByEmail(ctx, claims.Email)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, false, err
+	}
+	if err == nil {
+		if !provider.LinkByEmail || !bool(claims.EmailVerified) || existing.EmailVerifiedAt == nil {
+			return nil, false, errOIDCEmailTaken
+		}
+		if err := s.users.LinkIdentity(ctx, existing.ID, provider.Name, claims.Subject, claims.Email); err != nil {
+			return nil, false, err
+		}
+		return existing, false, nil
+	}
+
+	// 3. Новый пользователь. Пароля у него нет: задать его можно через /password/forgot
+	password, err := randomToken(32)
+	if err != nil {
+		return nil, false, err
+	}
+	passwordHash, err := HashPassword(password)
+	if err != nil {
+		return nil, false, err
+	}
+
+	base := oidcUsername(claims)
+	username := base
+	for attempt := 1; ; attempt++ {
+		user, err := s.users.CreateUserWithIdentity(ctx, claims.Email, username, passwordHash, bool(claims.EmailVerified), provider.Name, claims.Subject)
+		var dup *DuplicateError
+		errors.As(err, &dup)
+		switch {
+		case dup != nil && dup.Field == "email":
+			// Email занят параллельной регистрацией
+			return nil, false, errOIDCEmailTaken
+		case dup != nil && dup.Field == "username" && attempt < maxOIDCUsernameAttempts:
+			suffix, err := randomToken(3)
+			if err != nil {
+				return nil, false, err
+			}
+			username = base + "_" + suffix
+			continue
+		case err != nil:
+			return nil, false, err
+		}
+		return user, true, nil
+	}
+}
+
+// oidcUsername выбирает имя нового пользователя по данным провайдера
+// и оставляет место для суффикса на случай, если имя занято
+func oidcUsername(claims *IDTokenClaims) string {
+	candidate := claims.PreferredUsername
+	if candidate == "" {
+		candidate = claims.Name
+	}
+	if candidate == "" {
+		candidate, _, _ = strings.Cut(claims.Email, "@")
+	}
+
+	var b strings.Builder
+	count := 0
+	for _, c := range strings.TrimSpace(candidate) {
+		if count == 25 {
+			break
+		}
+		if unicode.IsSpace(c) {
+			c = '_'
+		}
+		if unicode.IsControl(c) {
+			continue
+		}
+		b.WriteRune(c)
+		count++
+	}
+	if b.Len() == 0 {
+		return "user"
+	}
+	return b.String()
+}
+
+// setOIDCStateCookie устанавливает (или удаляет при maxAge < 0) cookie со state.
+// SameSite=Lax: cookie должна прийти при переходе со страницы провайдера
+func setOIDCStateCookie(w http.ResponseWriter, provider *OIDCProvider, state string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/oidc/" + provider.Name + "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(provider.RedirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockIdP OpenID Connect провайдер на httptest: discovery, JWKS и token endpoint.
+// Коды авторизации выдает сам тест через issueCode
+type mockIdP struct {
+	t            *testing.T
+	server       *httptest.Server
+	key          *ecdsa.PrivateKey
+	kid          string
+	clientID     string
+	clientSecret string
+
+	mu    sync.Mutex
+	codes map[string]mockIdPCode
+}
+
+// mockIdPCode выданный код: ожидаемый PKCE code_challenge и ID токен, который вернет token endpoint
+type mockIdPCode struct {
+	challenge string
+	idToken   string
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	m := &mockIdP{
+		t:            t,
+		key:          key,
+		kid:          "mock-key-1",
+		clientID:     "our-client",
+		clientSecret: "our-client-secret",
+		codes:        make(map[string]mockIdPCode),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		sendJSONResponse(w, oidcMetadata{
+			Issuer:                m.issuer(),
+			AuthorizationEndpoint: m.issuer() + "/authorize",
+			TokenEndpoint:         m.issuer() + "/token",
+			JWKSURI:               m.issuer() + "/jwks",
+		}, http.StatusOK)
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		size := (key.Curve.Params().BitSize + 7) / 8
+		sendJSONResponse(w, JWKS{Keys: []JWK{{
+			Kty: "EC",
+			Kid: m.kid,
+			Use: "sig",
+			Alg: "ES256",
+			Crv: "P-256",
+			X:   b64(key.X.FillBytes(make([]byte, size))),
+			Y:   b64(key.Y.FillBytes(make([]byte, size))),
+		}}}, http.StatusOK)
+	})
+	mux.HandleFunc("/token", m.tokenHandler)
+
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+func (m *mockIdP) issuer() string {
+	return m.server.URL
+}
+
+// tokenHandler проверяет учетные данные клиента и PKCE, как настоящий провайдер
+func (m *mockIdP) tokenHandler(w http.ResponseWriter, r *http.Request) {
+	clientID, secret, _ := r.BasicAuth()
+	clientID, _ = url.QueryUnescape(clientID)
+	secret, _ = url.QueryUnescape(secret)
+	if clientID != m.clientID || secret != m.clientSecret {
+		sendJSONResponse(w, map[string]string{"error": "invalid_client"}, http.StatusUnauthorized)
+		return
+	}
+
+	m.mu.Lock()
+	code, ok := m.codes[r.FormValue("code")]
+	delete(m.codes, r.FormValue("code"))
+	m.mu.Unlock()
+	if r.FormValue("grant_type") != "authorization_code" || !ok || pkceChallenge(r.FormValue("code_verifier")) != code.challenge {
+		sendJSONResponse(w, map[string]string{"error": "invalid_grant"}, http.StatusBadRequest)
+		return
+	}
+	sendJSONResponse(w, map[string]string{"access_token": "idp-access-token", "id_token": code.idToken}, http.StatusOK)
+}
+
+// issueCode регистрирует код авторизации, который можно обменять на idToken
+func (m *mockIdP) issueCode(challenge, idToken string) string {
+	code, err := randomToken(16)
+	if err != nil {
+		m.t.Fatalf("randomToken: %v", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.codes[code] = mockIdPCode{challenge: challenge, idToken: idToken}
+	return code
+}
+
+// provider настроенный на провайдера OIDCProvider
+func (m *mockIdP) provider() *OIDCProvider {
+	return &OIDCProvider{
+		Name:         "mock",
+		Issuer:       m.issuer(),
+		ClientID:     m.clientID,
+		ClientSecret: m.clientSecret,
+		RedirectURL:  "https://app.example.com/oidc/mock/callback",
+		Scopes:       []string{"openid", "email", "profile"},
+		LinkByEmail:  true,
+	}
+}
+
+// claims корректные claims ID токена для nonce
+func (m *mockIdP) claims(nonce string) *IDTokenClaims {
+	now := time.Now()
+	return &IDTokenClaims{
+		Nonce:         nonce,
+		Email:         "alice@example.com",
+		EmailVerified: true,
+		Name:          "Alice Example",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    m.issuer(),
+			Subject:   "idp-user-1",
+			Audience:  jwt.ClaimStrings{m.clientID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(5 * time.Minute)),
+		},
+	}
+}
+
+// sign подписывает claims ключом провайдера (ES256)
+func (m *mockIdP) sign(claims *IDTokenClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = m.kid
+	signed, err := token.SignedString(m.key)
+	if err != nil {
+		m.t.Fatalf("sign ID token: %v", err)
+	}
+	return signed
+}
+
+func TestOIDCVerifyIDToken(t *testing.T) {
+	idp := newMockIdP(t)
+	const nonce = "expected-nonce"
+
+	cases := []struct {
+		name    string
+		token   func() string
+		wantErr bool
+	}{
+		{"valid", func() string {
+			return idp.sign(idp.claims(nonce))
+		}, false},
+		{"nonce mismatch", func() string {
+			return idp.sign(idp.claims("other-nonce"))
+		}, true},
+		{"wrong audience", func() string {
+			claims := idp.claims(nonce)
+			claims.Audience = jwt.ClaimStrings{"someone-else"}
+			return idp.sign(claims)
+		}, true},
+		{"azp is another client", func() string {
+			claims := idp.claims(nonce)
+			claims.Audience = jwt.ClaimStrings{idp.clientID, "someone-else"}
+			claims.AuthorizedParty = "someone-else"
+			return idp.sign(claims)
+		}, true},
+		{"multiple audiences without azp", func() string {
+			claims := idp.claims(nonce)
+			claims.Audience = jwt.ClaimStrings{idp.clientID, "someone-else"}
+			return idp.sign(claims)
+		}, true},
+		{"multiple audiences with our azp", func() string {
+			claims := idp.claims(nonce)
+			claims.Audience = jwt.ClaimStrings{idp.clientID, "someone-else"}
+			claims.AuthorizedParty = idp.clientID
+			return idp.sign(claims)
+		}, false},
+		{"wrong issuer", func() string {
+			claims := idp.claims(nonce)
+			claims.Issuer = "https://evil.example.com"
+			return idp.sign(claims)
+		}, true},
+		{"expired", func() string {
+			claims := idp.claims(nonce)
+			claims.IssuedAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+			claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-10 * time.Minute))
+			return idp.sign(claims)
+		}, true},
+		{"no subject", func() string {
+			claims := idp.claims(nonce)
+			claims.Subject = ""
+			return idp.sign(claims)
+		}, true},
+		{"alg none", func() string {
+			token := jwt.NewWithClaims(jwt.SigningMethodNone, idp.claims(nonce))
+			token.Header["kid"] = idp.kid
+			signed, err := token.SignedString(jwt.UnsafeAllowNoneSignatureType)
+			if err != nil {
+				t.Fatalf("sign: %v", err)
+			}
+			return signed
+		}, true},
+		{"HS256 signed with client secret", func() string {
+			token := jwt.NewWithClaims(jwt.SigningMethodHS256, idp.claims(nonce))
+			token.Header["kid"] = idp.kid
+			signed, err := token.SignedString([]byte(idp.clientSecret))
+			if err != nil {
+				t.Fatalf("sign: %v", err)
+			}
+			return signed
+		}, true},
+		{"unknown key", func() string {
+			other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			if err != nil {
+				t.Fatalf("generate key: %v", err)
+			}
+			token := jwt.NewWithClaims(jwt.SigningMethodES256, idp.claims(nonce))
+			token.Header["kid"] = idp.kid
+			signed, err := token.SignedString(other)
+			if err != nil {
+				t.Fatalf("sign: %v", err)
+			}
+			return signed
+		}, true},
+	}
+
+	provider := idp.provider()
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			claims, err := provider.VerifyIDToken(tc.token(), nonce)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("VerifyIDToken accepted the token: %+v", claims)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("VerifyIDToken: %v", err)
+			}
+			if claims.Subject != "idp-user-1" || claims.Email != "alice@example.com" {
+				t.Fatalf("claims = %+v", claims)
+			}
+		})
+	}
+}
+
+func TestOIDCExchange(t *testing.T) {
+	idp := newMockIdP(t)
+	provider := idp.provider()
+	const verifier = "code-verifier-0123456789-0123456789-0123456789"
+
+	code := idp.issueCode(pkceChallenge(verifier), "id-token")
+	tokens, err := provider.Exchange(code, verifier)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if tokens.IDToken != "id-token" || tokens.AccessToken == "" {
+		t.Fatalf("tokens = %+v", tokens)
+	}
+
+	// Код одноразовый, а без верного code_verifier обмен невозможен (PKCE)
+	if _, err := provider.Exchange(code, verifier); err == nil {
+		t.Fatal("code was exchanged twice")
+	}
+	code = idp.issueCode(pkceChallenge(verifier), "id-token")
+	if _, err := provider.Exchange(code, "another-verifier"); err == nil {
+		t.Fatal("code was exchanged with a wrong code_verifier")
+	}
+}
+
+// registerOIDCProvider делает провайдера доступным OIDCHandler на время теста
+func registerOIDCProvider(t *testing.T, provider *OIDCProvider) {
+	t.Helper()
+	oidcProviders[provider.Name] = provider
+	t.Cleanup(func() { delete(oidcProviders, provider.Name) })
+}
+
+func TestOIDCCallbackRejectsStateMismatch(t *testing.T) {
+	ts := newTestServer(t)
+	idp := newMockIdP(t)
+	registerOIDCProvider(t, idp.provider())
+
+	cases := []struct {
+		name   string
+		cookie string
+	}{
+		{"no cookie", ""},
+		{"cookie from another login", "other-state"},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/oidc/mock/callback?state=attacker-state&code=attacker-code", nil)
+			if tc.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: tc.cookie})
+			}
+			rec := httptest.NewRecorder()
+			ts.OIDCHandler(rec, req)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("callback = %d %s, want 400", rec.Code, rec.Body.String())
+			}
+			if e, _ := ts.auditor.last(auditLoginOIDC); e.Outcome != auditFailure || e.Details["reason"] != "state_mismatch" {
+				t.Fatalf("audit event = %+v", e)
+			}
+		})
+	}
+}
+
+func TestResolveOIDCUserLinkByEmail(t *testing.T) {
+	ctx := context.Background()
+	idp := newMockIdP(t)
+
+	cases := []struct {
+		name string
+		// existingVerified: nil - аккаунта с этим email нет, иначе - подтвержден ли его адрес
+		existingVerified *bool
+		linkByEmail      bool
+		claimsVerified   bool
+		noEmail          bool
+		wantErr          error
+		wantLinked       bool
+		wantCreated      bool
+	}{
+		{name: "new user is created", linkByEmail: true, claimsVerified: true, wantCreated: true},
+		{name: "no email", linkByEmail: true, claimsVerified: true, noEmail: true, wantErr: errOIDCEmailRequired},
+		{name: "both verified are linked", existingVerified: boolPtr(true), linkByEmail: true, claimsVerified: true, wantLinked: true},
+		{name: "provider did not verify email", existingVerified: boolPtr(true), linkByEmail: true, claimsVerified: false, wantErr: errOIDCEmailTaken},
+		{name: "local email is not verified", existingVerified: boolPtr(false), linkByEmail: true, claimsVerified: true, wantErr: errOIDCEmailTaken},
+		{name: "linking by email is disabled", existingVerified: boolPtr(true), linkByEmail: false, claimsVerified: true, wantErr: errOIDCEmailTaken},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			provider := idp.provider()
+			provider.LinkByEmail = tc.linkByEmail
+
+			var existing *User
+			if tc.existingVerified != nil {
+				var err error
+				existing, err = ts.users.CreateUserWithIdentity(ctx, "alice@example.com", "alice", "hash", *tc.existingVerified, "other", "other-subject")
+				if err != nil {
+					t.Fatalf("CreateUserWithIdentity: %v", err)
+				}
+			}
+
+			claims := idp.claims("")
+			claims.EmailVerified = oidcBool(tc.claimsVerified)
+			if tc.noEmail {
+				claims.Email = ""
+			}
+
+			user, created, err := ts.resolveOIDCUser(ctx, provider, claims)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("resolveOIDCUser error = %v, want %v", err, tc.wantErr)
+				}
+				if _, err := ts.users.GetIdentityUserID(ctx, provider.Name, claims.Subject); !errors.Is(err, ErrNotFound) {
+					t.Fatalf("identity was linked after a refused login: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveOIDCUser: %v", err)
+			}
+			if created != tc.wantCreated || (tc.wantLinked && user.ID != existing.ID) {
+				t.Fatalf("user = %+v, created = %v", user, created)
+			}
+
+			// Повторный вход находит привязанного пользователя
+			again, created, err := ts.resolveOIDCUser(ctx, provider, claims)
+			if err != nil || created || again.ID != user.ID {
+				t.Fatalf("second login = %+v, %v, %v; want user %d", again, created, err, user.ID)
+			}
+		})
+	}
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+// TestOIDCLoginFlow проходит весь вход через провайдера. Незавершенные входы хранятся
+// в таблице oidc_auth_requests, поэтому нужна тестовая БД
+func TestOIDCLoginFlow(t *testing.T) {
+	requireTestDB(t)
+	ts := newTestServer(t)
+	idp := newMockIdP(t)
+	registerOIDCProvider(t, idp.provider())
+
+	// 1. Редирект на провайдера с state, nonce и PKCE challenge
+	rec := httptest.NewRecorder()
+	ts.OIDCHandler(rec, httptest.NewRequest(http.MethodGet, "/oidc/mock/login", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login = %d %s, want 302", rec.Code, rec.Body.String())
+	}
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil || !strings.HasPrefix(location.String(), idp.issuer()+"/authorize") {
+		t.Fatalf("redirect to %q", rec.Header().Get("Location"))
+	}
+	query := location.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("client_id") != idp.clientID {
+		t.Fatalf("authorization request = %v", query)
+	}
+	var stateCookie *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == oidcStateCookie {
+			stateCookie = c
+		}
+	}
+	if stateCookie == nil || stateCookie.Value != query.Get("state") || !stateCookie.HttpOnly {
+		t.Fatalf("state cookie = %+v", stateCookie)
+	}
+
+	callback := func(idToken string) *httptest.ResponseRecorder {
+		code := idp.issueCode(query.Get("code_challenge"), idToken)
+		req := httptest.NewRequest(http.MethodGet, "/oidc/mock/callback?"+url.Values{"state": {query.Get("state")}, "code": {code}}.Encode(), nil)
+		req.AddCookie(stateCookie)
+		rec := httptest.NewRecorder()
+		ts.OIDCHandler(rec, req)
+		return rec
+	}
+
+	// 2. Провайдер вернул пользователя: создается аккаунт и выдаются наши токены
+	rec = callback(idp.sign(idp.claims(query.Get("nonce"))))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("callback = %d %s, want 200", rec.Code, rec.Body.String())
+	}
+	var response map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || response["token"] == nil {
+		t.Fatalf("callback response = %s", rec.Body.String())
+	}
+	if _, err := ts.users.GetUserByEmail(context.Background(), "alice@example.com"); err != nil {
+		t.Fatalf("user was not created: %v", err)
+	}
+
+	// 3. state одноразовый
+	rec = callback(idp.sign(idp.claims(query.Get("nonce"))))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("reused state = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ForgotPasswordHandler отправляет письмо со ссылкой сброса пароля.
+// Всегда отвечает 202, чтобы не раскрывать существование аккаунта
+func (s *Server) ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req EmailRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		sendErrorResponse(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := ValidateEmail(req.Email); err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Поиск пользователя и отправка письма выполняются в фоне,
+	// поэтому время ответа не зависит от существования аккаунта
+	go func() {
+		if err := s.requestPasswordReset(context.Background(), req.Email); err != nil {
+			log.Printf("Password reset request error: %v", err)
+		}
+	}()
+
+	response := map[string]string{
+		"message": "If the account exists, a password reset email has been sent",
+	}
+	sendJSONResponse(w, response, http.StatusAccepted)
+}
+
+// requestPasswordReset создает токен сброса и отправляет его пользователю
+func (s *Server) requestPasswordReset(ctx context.Context, email string) error {
+	user, err := s.users.GetUserByEmail(ctx, email)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+	if err := s.users.CreatePasswordResetToken(ctx, user.ID, hashToken(token), time.Now().Add(passwordResetTTL)); err != nil {
+		return err
+	}
+
+	link := accountConfig.PasswordResetURL + "?token=" + url.QueryEscape(token)
+	return mailer.Send(Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: "Hello, " + user.Username + "!\n\n" +
+			"To set a new password, open the link below:\n\n" +
+			link + "\n\n" +
+			"Or send this token to POST /password/reset: " + token + "\n\n" +
+			"The link expires in 1 hour. If you did not request a password reset, ignore this email.\n",
+	})
+}
+
+// ResetPasswordHandler устанавливает новый пароль по одноразовому токену
+// и завершает все сессии пользователя
+func (s *Server) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 1. Парсим JSON
+	var req PasswordResetRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		sendErrorResponse(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Token == "" {
+		sendErrorResponse(w, "token is required", http.StatusBadRequest)
+		return
+	}
+
+	// 2. Проверяем и хешируем новый пароль
+	if err := ValidatePassword(req.NewPassword); err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	passwordHash, err := HashPassword(req.NewPassword)
+	if err != nil {
+		log.Printf("Hash password error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+
+	// 3. Используем токен и меняем пароль
+	userID, err := s.users.ResetPasswordWithToken(r.Context(), hashToken(req.Token), passwordHash)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	if userID == 0 {
+		s.audit(r, auditPasswordReset, 0, auditFailure, map[string]interface{}{"reason": "invalid_token"})
+		sendErrorResponse(w, "Invalid or expired reset token", http.StatusBadRequest)
+		return
+	}
+
+	// 4. Завершаем все сессии: старые токены могли оказаться у злоумышленника
+	if err := s.revokeAllUserSessions(r.Context(), userID); err != nil {
+		log.Printf("Revoke user sessions error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+
+	s.audit(r, auditPasswordReset, userID, auditSuccess, nil)
+	sendJSONResponse(w, map[string]string{"message": "Password has been reset"}, http.StatusOK)
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// UpdateProfileHandler меняет email и/или имя пользователя (PATCH /profile).
+// Новый email нужно подтвердить заново
+func (s *Server) UpdateProfileHandler(w http.ResponseWriter, r *http.Request) {
+	// 1. Загружаем текущего пользователя
+	user, ok := s.loadCurrentUser(w, r)
+	if !ok {
+		return
+	}
+
+	// 2. Парсим JSON
+	var req UpdateProfileRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		sendErrorResponse(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Email == nil && req.Username == nil {
+		sendErrorResponse(w, "email or username is required", http.StatusBadRequest)
+		return
+	}
+
+	// 3. Валидация
+	email, username := user.Email, user.Username
+	if req.Email != nil {
+		email = strings.TrimSpace(*req.Email)
+		if err := ValidateEmail(email); err != nil {
+			sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Username != nil {
+		username = *req.Username
+		if err := validateUsername(username); err != nil {
+			sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	emailChanged := email != user.Email
+
+	// 4. Проверяем уникальность email так же, как при регистрации
+	if emailChanged {
+		if exists, err := s.users.UserExistsByEmail(r.Context(), email); err != nil {
+			log.Printf("Database error: %v", err)
+			sendInternalError(w, err)
+			return
+		} else if exists {
+			sendErrorResponse(w, "User with this email already exists", http.StatusConflict)
+			return
+		}
+	}
+
+	// 5. Сохраняем изменения (UNIQUE ограничения - последняя линия защиты от гонок)
+	updated, err := s.users.UpdateUserProfile(r.Context(), user.ID, email, username)
+	var dup *DuplicateError
+	if errors.As(err, &dup) {
+		sendErrorResponse(w, fmt.Sprintf("User with this %s already exists", dup.Field), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, ErrNotFound) {
+		sendErrorResponse(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Update user error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+
+	// 6. Новый адрес нужно подтвердить
+	if emailChanged {
+		if err := sendVerificationEmail(*updated); err != nil {
+			log.Printf("Send verification email error: %v", err)
+		}
+	}
+
+	sendJSONResponse(w, profileResponse(updated), http.StatusOK)
+}
+
+// ChangePasswordHandler меняет пароль после проверки текущего.
+// Остальные сессии завершаются, текущий клиент получает новую пару токенов
+func (s *Server) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 1. Загружаем пользователя и хеш его пароля
+	user, ok := s.loadCurrentUser(w, r)
+	if !ok {
+		return
+	}
+	currentHash, err := s.users.GetPasswordHashByUserID(r.Context(), user.ID)
+	if errors.Is(err, ErrNotFound) {
+		sendErrorResponse(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+
+	// 2. Парсим JSON
+	var req ChangePasswordRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		sendErrorResponse(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.CurrentPassword == "" {
+		sendErrorResponse(w, "current_password is required", http.StatusBadRequest)
+		return
+	}
+
+	// 3. Проверяем текущий пароль
+	if !CheckPassword(req.CurrentPassword, currentHash) {
+		s.audit(r, auditPasswordChange, user.ID, auditFailure, map[string]interface{}{"reason": "invalid_current_password"})
+		sendErrorResponse(w, "Current password is incorrect", http.StatusForbidden)
+		return
+	}
+
+	// 4. Проверяем и хешируем новый пароль
+	if err := ValidatePassword(req.NewPassword); err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	passwordHash, err := HashPassword(req.NewPassword)
+	if err != nil {
+		log.Printf("Hash password error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+
+	// 5. Сохраняем пароль и завершаем все сессии
+	if err := s.users.UpdateUserPassword(r.Context(), user.ID, passwordHash); err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	if err := s.revokeAllUserSessions(r.Context(), user.ID); err != nil {
+		log.Printf("Revoke user sessions error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+
+	s.audit(r, auditPasswordChange, user.ID, auditSuccess, nil)
+
+	// 6. Текущий клиент продолжает работу с новыми токенами
+	s.sendTokenResponse(w, r, user, "Password changed", http.StatusOK)
+}
+
+// DeleteAccountHandler удаляет аккаунт после повторного ввода пароля (DELETE /profile).
+// ACCOUNT_DELETION_MODE выбирает мягкое удаление (soft, по умолчанию) или полное (hard)
+func (s *Server) DeleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	// 1. Загружаем пользователя и хеш его пароля
+	user, ok := s.loadCurrentUser(w, r)
+	if !ok {
+		return
+	}
+	currentHash, err := s.users.GetPasswordHashByUserID(r.Context(), user.ID)
+	if errors.Is(err, ErrNotFound) {
+		sendErrorResponse(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+
+	// 2. Проверяем пароль
+	var req DeleteAccountRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		sendErrorResponse(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Password == "" {
+		sendErrorResponse(w, "password is required", http.StatusBadRequest)
+		return
+	}
+	if !CheckPassword(req.Password, currentHash) {
+		s.audit(r, auditAccountDelete, user.ID, auditFailure, map[string]interface{}{"reason": "invalid_password"})
+		sendErrorResponse(w, "Password is incorrect", http.StatusForbidden)
+		return
+	}
+
+	// 3. Удаляем аккаунт
+	// ErrNotFound - аккаунт уже удален параллельным запросом
+	if err := s.deleteAccount(r.Context(), user.ID); errors.Is(err, ErrNotFound) {
+		sendErrorResponse(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Delete user error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+
+	s.audit(r, auditAccountDelete, user.ID, auditSuccess, nil)
+	sendJSONResponse(w, map[string]string{"message": "Account deleted"}, http.StatusOK)
+}
+
+// deleteAccount завершает все сессии пользователя и удаляет аккаунт
+// в режиме ACCOUNT_DELETION_MODE (accountConfig.DeletionMode)
+func (s *Server) deleteAccount(ctx context.Context, userID int) error {
+	// Отзываем все токены до удаления: отметка об отзыве переживает удаление строки
+	if err := s.revokeAllUserSessions(ctx, userID); err != nil {
+		return err
+	}
+
+	if accountConfig.DeletionMode == "hard" {
+		return s.users.DeleteUser(ctx, userID)
+	}
+	return s.users.SoftDeleteUser(ctx, userID)
+}
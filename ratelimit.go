@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimitCleanupInterval период удаления устаревших счетчиков
+const rateLimitCleanupInterval = time.Minute
+
+// rateLimitKeyIP, rateLimitKeyUser, rateLimitKeyRoute по чему считаются запросы:
+// по IP клиента, по ID пользователя (после AuthMiddleware) или общий счетчик маршрута
+const (
+	rateLimitKeyIP    = "ip"
+	rateLimitKeyUser  = "user"
+	rateLimitKeyRoute = "route"
+)
+
+// defaultRateLimits лимиты маршрутов по умолчанию в формате RATE_LIMIT_<ROUTE>
+var defaultRateLimits = map[string]string{
+	"register":            "5/1h:ip",
+	"login":               "10/1m:ip",
+	"login_mfa":           "10/1m:ip",
+	"login_magic":         "10/1h:ip",
+	"oidc":                "20/1m:ip",
+	"webauthn":            "30/1m:ip",
+	"oauth_token":         "30/1m:ip",
+	"password_forgot":     "5/1h:ip",
+	"verify_email_resend": "5/1h:ip",
+	"profile":             "60/1m:user",
+
+	// Не маршрут: лимит писем со ссылкой входа на один адрес (см. requestMagicLink)
+	"login_magic_email": "3/15m",
+}
+
+// rateLimitFailClosed маршруты, которые при ошибке хранилища счетчиков отвечают 503,
+// а не пропускают запрос: без лимита на них можно перебирать пароли и коды второго фактора
+// или рассылать письма сброса пароля
+var rateLimitFailClosed = map[string]bool{
+	"login":           true,
+	"login_mfa":       true,
+	"password_forgot": true,
+}
+
+// rateLimiter глобальное хранилище счетчиков запросов
+var rateLimiter RateLimitStore
+
+// rateLimitRules разобранные лимиты по имени маршрута (nil - лимит отключен)
+var rateLimitRules map[string]*RateLimitRule
+
+// RateLimitStore хранилище счетчиков скользящего окна
+type RateLimitStore interface {
+	// Allow учитывает запрос по ключу, если он укладывается в limit запросов за window
+	Allow(ctx context.Context, key string, limit int, window time.Duration) (RateLimitResult, error)
+}
+
+// RateLimitResult результат проверки лимита
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	Reset     time.Duration // когда закончится текущее окно
+}
+
+// RateLimitRule лимит маршрута: не больше Limit запросов за Window на ключ KeyBy
+type RateLimitRule struct {
+	Limit  int
+	Window time.Duration
+	KeyBy  string
+}
+
+// InitRateLimiter выбирает хранилище счетчиков (RATE_LIMIT_STORE=memory|postgres) и разбирает
+// лимиты маршрутов. Хранилище в памяти подходит для одной реплики, Postgres - для нескольких.
+// Вызывается до регистрации маршрутов (см. RateLimit)
+func InitRateLimiter(cfg RateLimitConfig) error {
+	rules := make(map[string]*RateLimitRule, len(cfg.Routes))
+	var errs []error
+	for route, value := range cfg.Routes {
+		rule, err := parseRateLimitRule(*value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", rateLimitEnv(route), err))
+			continue
+		}
+		rules[route] = rule
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	switch cfg.Store {
+	case "memory":
+		rateLimiter = NewMemoryRateLimitStore(rateLimitCleanupInterval)
+	case "postgres":
+		rateLimiter = NewPostgresRateLimitStore(rateLimitCleanupInterval)
+	default:
+		return fmt.Errorf("unknown RATE_LIMIT_STORE %q", cfg.Store)
+	}
+	rateLimitRules = rules
+	magicLinkEmailLimit = rules["login_magic_email"]
+	return nil
+}
+
+// parseRateLimitRule разбирает лимит вида "10/1m" или "60/1m:user".
+// Возвращает nil для "off"
+func parseRateLimitRule(value string) (*RateLimitRule, error) {
+	value = strings.TrimSpace(value)
+	if value == "off" {
+		return nil, nil
+	}
+
+	rule := &RateLimitRule{KeyBy: rateLimitKeyIP}
+	if i := strings.LastIndex(value, ":"); i >= 0 {
+		rule.KeyBy = value[i+1:]
+		value = value[:i]
+	}
+	switch rule.KeyBy {
+	case rateLimitKeyIP, rateLimitKeyUser, rateLimitKeyRoute:
+	default:
+		return nil, fmt.Errorf("unknown rate limit key %q", rule.KeyBy)
+	}
+
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("expected <limit>/<window>, got %q", value)
+	}
+	limit, err := strconv.Atoi(parts[0])
+	if err != nil || limit <= 0 {
+		return nil, fmt.Errorf("invalid limit %q", parts[0])
+	}
+	window, err := time.ParseDuration(parts[1])
+	if err != nil || window <= 0 {
+		return nil, fmt.Errorf("invalid window %q", parts[1])
+	}
+	rule.Limit, rule.Window = limit, window
+	return rule, nil
+}
+
+// RateLimit ограничивает частоту запросов к маршруту. Лимит разобран в InitRateLimiter
+// из RATE_LIMIT_<ROUTE> (например, RATE_LIMIT_LOGIN=10/1m) или из defaultRateLimits,
+// поэтому неверное значение обнаруживается при загрузке настроек, а не здесь.
+// Маршрут без записи в defaultRateLimits - ошибка программы.
+// Для лимита по пользователю оборачивается в AuthMiddleware:
+// AuthMiddleware(RateLimit("profile", ProfileHandler))
+func RateLimit(route string, next http.HandlerFunc) http.HandlerFunc {
+	rule, ok := rateLimitRules[route]
+	if !ok {
+		panic(fmt.Sprintf("RateLimit: unknown route %q (missing in defaultRateLimits or InitRateLimiter not called)", route))
+	}
+	if rule == nil {
+		return next
+	}
+	policy := fmt.Sprintf("%d;w=%d", rule.Limit, int(rule.Window.Seconds()))
+	failClosed := rateLimitFailClosed[route]
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		// 1. Ключ счетчика
+		key := route + ":route"
+		switch rule.KeyBy {
+		case rateLimitKeyIP:
+			key = route + ":ip:" + clientIP(r)
+		case rateLimitKeyUser:
+			if userID, ok := GetUserIDFromContext(r); ok {
+				key = route + ":user:" + strconv.Itoa(userID)
+			} else {
+				key = route + ":ip:" + clientIP(r)
+			}
+		}
+
+		// 2. Проверяем лимит. Недоступность хранилища не должна останавливать сервис,
+		// кроме маршрутов из rateLimitFailClosed
+		result, err := rateLimiter.Allow(r.Context(), key, rule.Limit, rule.Window)
+		if err != nil {
+			log.Printf("Rate limit error: %v", err)
+			if failClosed {
+				w.Header().Set("Retry-After", "5")
+				sendErrorResponse(w, "Service temporarily unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// 3. Заголовки RateLimit-* (draft-ietf-httpapi-ratelimit-headers)
+		reset := int(math.Ceil(result.Reset.Seconds()))
+		w.Header().Set("RateLimit-Policy", policy)
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(reset))
+
+		if !result.Allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(reset))
+			sendErrorResponse(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// slidingWindow оценивает число запросов за последние window: счетчик текущего
+// фиксированного окна плюс доля счетчика предыдущего, пропорциональная перекрытию
+func slidingWindow(now time.Time, window time.Duration, previous, current int) (windowStart time.Time, estimate float64) {
+	windowStart = now.Truncate(window)
+	elapsed := now.Sub(windowStart)
+	weight := 1 - float64(elapsed)/float64(window)
+	return windowStart, float64(previous)*weight + float64(current)
+}
+
+// rateLimitResult собирает результат проверки по оценке числа запросов в момент now
+func rateLimitResult(now time.Time, allowed bool, limit int, estimate float64, windowEnd time.Time) RateLimitResult {
+	remaining := limit - int(math.Ceil(estimate))
+	if remaining < 0 || !allowed {
+		remaining = 0
+	}
+	return RateLimitResult{
+		Allowed:   allowed,
+		Limit:     limit,
+		Remaining: remaining,
+		Reset:     windowEnd.Sub(now),
+	}
+}
+
+// memoryRateLimitEntry счетчики текущего и предыдущего окна одного ключа
+type memoryRateLimitEntry struct {
+	windowStart time.Time
+	window      time.Duration
+	previous    int
+	current     int
+}
+
+// MemoryRateLimitStore хранит счетчики в памяти процесса
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	entries map[string]*memoryRateLimitEntry
+	now     func() time.Time // часы; тесты подменяют их, чтобы переходить между окнами
+}
+
+// NewMemoryRateLimitStore создает хранилище и запускает очистку устаревших ключей
+func NewMemoryRateLimitStore(cleanupInterval time.Duration) *MemoryRateLimitStore {
+	s := &MemoryRateLimitStore{entries: make(map[string]*memoryRateLimitEntry), now: time.Now}
+	go func() {
+		ticker := time.NewTicker(cleanupInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.cleanup()
+		}
+	}()
+	return s
+}
+
+// Allow учитывает запрос, если оценка скользящего окна не превышает limit
+func (s *MemoryRateLimitStore) Allow(ctx context.Context, key string, limit int, window time.Duration) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	windowStart := now.Truncate(window)
+
+	// Сдвигаем окна: текущее становится предыдущим, более старые забываются
+	entry, ok := s.entries[key]
+	if !ok {
+		entry = &memoryRateLimitEntry{windowStart: windowStart, window: window}
+		s.entries[key] = entry
+	}
+	switch {
+	case entry.windowStart.Equal(windowStart):
+	case entry.windowStart.Equal(windowStart.Add(-window)):
+		entry.previous, entry.current = entry.current, 0
+	default:
+		entry.previous, entry.current = 0, 0
+	}
+	entry.windowStart, entry.window = windowStart, window
+
+	_, estimate := slidingWindow(now, window, entry.previous, entry.current)
+	allowed := estimate+1 <= float64(limit)
+	if allowed {
+		entry.current++
+		estimate++
+	}
+	return rateLimitResult(now, allowed, limit, estimate, windowStart.Add(window)), nil
+}
+
+// cleanup удаляет ключи, чьи окна уже не влияют на оценку
+func (s *MemoryRateLimitStore) cleanup() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for key, entry := range s.entries {
+		if now.Sub(entry.windowStart) >= 2*entry.window {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// PostgresRateLimitStore хранит счетчики в таблице rate_limits, общей для всех реплик
+type PostgresRateLimitStore struct{}
+
+// NewPostgresRateLimitStore создает хранилище и запускает очистку устаревших окон
+func NewPostgresRateLimitStore(cleanupInterval time.Duration) *PostgresRateLimitStore {
+	go func() {
+		ticker := time.NewTicker(cleanupInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := DeleteExpiredRateLimits(context.Background()); err != nil {
+				log.Printf("Rate limit prune error: %v", err)
+			}
+		}
+	}()
+	return &PostgresRateLimitStore{}
+}
+
+// Allow учитывает запрос, если оценка скользящего окна не превышает limit.
+// Увеличение счетчика текущего окна и проверка выполняются одним запросом
+func (s *PostgresRateLimitStore) Allow(ctx context.Context, key string, limit int, window time.Duration) (RateLimitResult, error) {
+	now := time.Now()
+	windowStart := now.Truncate(window)
+
+	previous, err := GetRateLimitCount(ctx, key, windowStart.Add(-window))
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	_, previousWeighted := slidingWindow(now, window, previous, 0)
+
+	current, allowed, err := IncrementRateLimit(ctx, key, windowStart, windowStart.Add(2*window), previousWeighted, limit)
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	return rateLimitResult(now, allowed, limit, previousWeighted+float64(current), windowStart.Add(window)), nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// rateLimitTestStart начало окна, от которого отсчитываются часы тестов
+var rateLimitTestStart = time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+
+// useRateLimitClock создает MemoryRateLimitStore с часами, которые показывают *now
+func useRateLimitClock(now *time.Time) *MemoryRateLimitStore {
+	store := NewMemoryRateLimitStore(time.Minute)
+	store.now = func() time.Time { return *now }
+	return store
+}
+
+// useRateLimit на время теста задает лимит маршрута route (формат RATE_LIMIT_<ROUTE>) и хранилище счетчиков
+func useRateLimit(t *testing.T, route, value string, store RateLimitStore) {
+	t.Helper()
+	previousLimiter, previousRules := rateLimiter, rateLimitRules
+	t.Cleanup(func() { rateLimiter, rateLimitRules = previousLimiter, previousRules })
+
+	rule, err := parseRateLimitRule(value)
+	if err != nil {
+		t.Fatalf("parseRateLimitRule(%q): %v", value, err)
+	}
+	rateLimiter = store
+	rateLimitRules = map[string]*RateLimitRule{route: rule}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	tests := []struct {
+		name              string
+		offset            time.Duration // от начала окна
+		previous, current int
+		want              float64
+	}{
+		{"start of window counts the whole previous window", 0, 8, 0, 8},
+		{"quarter into window", 15 * time.Second, 8, 2, 8},
+		{"half into window", 30 * time.Second, 8, 3, 7},
+		{"end of window", 45 * time.Second, 8, 5, 7},
+		{"no previous window", 50 * time.Second, 0, 4, 4},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			now := rateLimitTestStart.Add(time.Minute + tc.offset)
+			windowStart, estimate := slidingWindow(now, time.Minute, tc.previous, tc.current)
+			if want := rateLimitTestStart.Add(time.Minute); !windowStart.Equal(want) {
+				t.Errorf("window start = %v, want %v", windowStart, want)
+			}
+			if estimate != tc.want {
+				t.Errorf("estimate = %v, want %v", estimate, tc.want)
+			}
+		})
+	}
+}
+
+func TestMemoryRateLimitStoreSlidingWindow(t *testing.T) {
+	now := rateLimitTestStart.Add(10 * time.Second)
+	store := useRateLimitClock(&now)
+	ctx := context.Background()
+
+	allow := func(key string, wantAllowed bool, wantRemaining int) RateLimitResult {
+		t.Helper()
+		result, err := store.Allow(ctx, key, 4, time.Minute)
+		if err != nil {
+			t.Fatalf("Allow: %v", err)
+		}
+		if result.Allowed != wantAllowed || result.Remaining != wantRemaining || result.Limit != 4 {
+			t.Fatalf("Allow(%s) at %s = %+v, want allowed %v, remaining %d",
+				key, now.Format("15:04:05"), result, wantAllowed, wantRemaining)
+		}
+		return result
+	}
+
+	// Первое окно: 4 запроса проходят, пятый отклоняется до конца окна
+	for remaining := 3; remaining >= 0; remaining-- {
+		allow("a", true, remaining)
+	}
+	if result := allow("a", false, 0); result.Reset != 50*time.Second {
+		t.Errorf("Reset = %v, want 50s", result.Reset)
+	}
+	// Счетчики ключей независимы
+	allow("b", true, 3)
+
+	// Середина следующего окна: предыдущее окно весит половину (2 из 4), остается 2 запроса
+	now = rateLimitTestStart.Add(90 * time.Second)
+	allow("a", true, 1)
+	allow("a", true, 0)
+	allow("a", false, 0)
+
+	// Через окно без запросов старые счетчики не учитываются
+	now = rateLimitTestStart.Add(3 * time.Minute)
+	allow("a", true, 3)
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	now := rateLimitTestStart.Add(15 * time.Second)
+	useRateLimit(t, "register", "2/1m:ip", useRateLimitClock(&now))
+
+	calls := 0
+	handler := RateLimit("register", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusOK)
+	})
+	request := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/register", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	for i, wantRemaining := range []string{"1", "0"} {
+		rec := request("192.0.2.1:1234")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d, want 200", i+1, rec.Code)
+		}
+		want := map[string]string{
+			"RateLimit-Policy":    "2;w=60",
+			"RateLimit-Limit":     "2",
+			"RateLimit-Remaining": wantRemaining,
+			"RateLimit-Reset":     "45",
+		}
+		for name, value := range want {
+			if got := rec.Header().Get(name); got != value {
+				t.Errorf("request %d: %s = %q, want %q", i+1, name, got, value)
+			}
+		}
+		if got := rec.Header().Get("Retry-After"); got != "" {
+			t.Errorf("request %d: Retry-After = %q on an allowed request", i+1, got)
+		}
+	}
+
+	rec := request("192.0.2.1:1234")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("third request: status %d, want 429", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "45" {
+		t.Errorf("Retry-After = %q, want 45", got)
+	}
+	if got := rec.Header().Get("RateLimit-Remaining"); got != "0" {
+		t.Errorf("RateLimit-Remaining = %q, want 0", got)
+	}
+	if calls != 2 {
+		t.Errorf("handler called %d times, want 2", calls)
+	}
+
+	// Лимит считается по IP: другой клиент не затронут
+	if rec := request("198.51.100.7:1234"); rec.Code != http.StatusOK {
+		t.Errorf("other IP: status %d, want 200", rec.Code)
+	}
+}
+
+// failingRateLimitStore хранилище счетчиков, которое всегда недоступно
+type failingRateLimitStore struct{}
+
+func (failingRateLimitStore) Allow(ctx context.Context, key string, limit int, window time.Duration) (RateLimitResult, error) {
+	return RateLimitResult{}, errors.New("rate limit store is down")
+}
+
+func TestRateLimitStoreError(t *testing.T) {
+	tests := []struct {
+		route      string
+		wantStatus int
+	}{
+		{"login", http.StatusServiceUnavailable},
+		{"login_mfa", http.StatusServiceUnavailable},
+		{"password_forgot", http.StatusServiceUnavailable},
+		{"register", http.StatusOK},
+		{"profile", http.StatusOK},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.route, func(t *testing.T) {
+			useRateLimit(t, tc.route, defaultRateLimits[tc.route], failingRateLimitStore{})
+
+			called := false
+			handler := RateLimit(tc.route, func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				w.WriteHeader(http.StatusOK)
+			})
+			rec := httptest.NewRecorder()
+			handler(rec, httptest.NewRequest(http.MethodPost, "/"+tc.route, nil))
+
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("status %d, want %d", rec.Code, tc.wantStatus)
+			}
+			if failClosed := tc.wantStatus == http.StatusServiceUnavailable; called == failClosed {
+				t.Errorf("handler called = %v with fail-closed = %v", called, failClosed)
+			}
+			if tc.wantStatus == http.StatusServiceUnavailable {
+				if _, err := strconv.Atoi(rec.Header().Get("Retry-After")); err != nil {
+					t.Errorf("Retry-After = %q, want seconds", rec.Header().Get("Retry-After"))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// roleAdmin встроенная роль администратора (создается базовой миграцией)
+	roleAdmin = "admin"
+	// permissionCacheTTL как долго кешируется соответствие ролей и разрешений
+	permissionCacheTTL = time.Minute
+)
+
+// RoleStore роли пользователей, попадающие в claims токена
+type RoleStore interface {
+	// GetUserRoles возвращает названия ролей пользователя в алфавитном порядке
+	GetUserRoles(ctx context.Context, userID int) ([]string, error)
+}
+
+const (
+	permUsersRead   = "users:read"
+	permUsersWrite  = "users:write"
+	permRolesManage = "roles:manage"
+	permAuditRead   = "audit:read"
+	// permOAuthClientsManage регистрация и удаление OAuth клиентов
+	permOAuthClientsManage = "oauth_clients:manage"
+	// permSystemRead просмотр состояния сервиса (статистика пула соединений с БД)
+	permSystemRead = "system:read"
+)
+
+// permissionCache соответствие "роль -> разрешения", загруженное из БД.
+// Роли приходят в токене, а разрешения ролей меняются редко, поэтому
+// их не нужно читать из БД на каждый запрос
+type permissionCache struct {
+	mu       sync.RWMutex
+	byRole   map[string]map[string]bool
+	loadedAt time.Time
+}
+
+// permissions глобальный кеш разрешений ролей
+var permissions = &permissionCache{}
+
+// HasPermission проверяет, дает ли хотя бы одна из ролей указанное разрешение
+func (c *permissionCache) HasPermission(ctx context.Context, roles []string, permission string) (bool, error) {
+	byRole, err := c.load(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, role := range roles {
+		if byRole[role][permission] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// load возвращает кешированное соответствие, перечитывая его из БД после истечения TTL
+func (c *permissionCache) load(ctx context.Context) (map[string]map[string]bool, error) {
+	c.mu.RLock()
+	byRole, loadedAt := c.byRole, c.loadedAt
+	c.mu.RUnlock()
+	if byRole != nil && time.Since(loadedAt) < permissionCacheTTL {
+		return byRole, nil
+	}
+
+	roles, err := ListRoles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byRole = make(map[string]map[string]bool, len(roles))
+	for _, role := range roles {
+		set := make(map[string]bool, len(role.Permissions))
+		for _, permission := range role.Permissions {
+			set[permission] = true
+		}
+		byRole[role.Name] = set
+	}
+
+	c.mu.Lock()
+	c.byRole, c.loadedAt = byRole, time.Now()
+	c.mu.Unlock()
+	return byRole, nil
+}
+
+// RequireRole пропускает запрос, только если в токене есть указанная роль.
+// Используется вместе с AuthMiddleware: AuthMiddleware(RequireRole("admin")(handler))
+func RequireRole(role string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := GetClaimsFromContext(r)
+			if !ok {
+				sendErrorResponse(w, "Claims not found in context", http.StatusInternalServerError)
+				return
+			}
+			if !hasRole(claims.Roles, role) {
+				sendErrorResponse(w, "Insufficient permissions", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+	}
+}
+
+// RequirePermission пропускает запрос, только если одна из ролей в токене дает разрешение.
+// Используется вместе с AuthMiddleware: AuthMiddleware(RequirePermission("users:read")(handler))
+func RequirePermission(permission string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := GetClaimsFromContext(r)
+			if !ok {
+				sendErrorResponse(w, "Claims not found in context", http.StatusInternalServerError)
+				return
+			}
+			allowed, err := permissions.HasPermission(r.Context(), claims.Roles, permission)
+			if err != nil {
+				log.Printf("Permission check error: %v", err)
+				sendInternalError(w, err)
+				return
+			}
+			if !allowed {
+				sendErrorResponse(w, "Insufficient permissions", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+	}
+}
+
+// hasRole проверяет наличие роли в списке
+func hasRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// BootstrapAdmin назначает роль администратора пользователю с адресом email (ADMIN_EMAIL).
+// Аккаунт должен быть уже зарегистрирован (и подтвержден, если подтверждение обязательно)
+func BootstrapAdmin(ctx context.Context, users UserStore, email string) error {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return nil
+	}
+
+	user, err := users.GetUserByEmail(ctx, email)
+	if errors.Is(err, ErrNotFound) {
+		log.Printf("Warning: ADMIN_EMAIL user %s is not registered yet; restart after registration", email)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if emailVerificationRequired() && user.EmailVerifiedAt == nil {
+		log.Printf("Warning: ADMIN_EMAIL user %s has not verified the email; admin role not granted", email)
+		return nil
+	}
+
+	found, err := GrantRole(ctx, user.ID, roleAdmin, 0)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("role %q does not exist", roleAdmin)
+	}
+	log.Printf("Admin role granted to %s", email)
+	return nil
+}
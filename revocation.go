@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// revocationCacheTTL сколько кешируется отрицательный результат проверки.
+	// Отзыв, сделанный на другой реплике, станет виден не позже этого времени
+	revocationCacheTTL = 30 * time.Second
+	// revocationPruneInterval период очистки истекших записей об отзыве
+	revocationPruneInterval = 10 * time.Minute
+)
+
+// TokenRevoker отзыв access токенов и одноразовых токенов (mfa_token, ссылки из писем).
+// Реализация - RevocationStore
+type TokenRevoker interface {
+	// RevokeToken отзывает один токен до момента его истечения
+	RevokeToken(ctx context.Context, jti string, userID int, expiresAt time.Time) error
+	// Consume помечает одноразовый токен использованным; false - он уже использован или отозван
+	Consume(ctx context.Context, jti string, userID int, expiresAt time.Time) (bool, error)
+	// RevokeAllForUser отзывает все токены пользователя, выданные до текущего момента
+	RevokeAllForUser(ctx context.Context, userID int) error
+	// IsRevoked проверяет, отозван ли токен
+	IsRevoked(ctx context.Context, claims *Claims) (bool, error)
+}
+
+// revocationEntry запись кеша для одного ключа (jti или пользователя)
+type revocationEntry struct {
+	revoked   bool
+	cutoff    time.Time // только для пользователей: токены, выданные раньше, отозваны
+	expiresAt time.Time // когда запись кеша перестает быть актуальной
+}
+
+// RevocationStore хранит отозванные access токены.
+// Источник истины - таблицы revoked_tokens и user_token_revocations,
+// перед ними стоит in-memory кеш, чтобы не делать запрос на каждый вызов API
+type RevocationStore struct {
+	mu     sync.RWMutex
+	tokens map[string]revocationEntry
+	users  map[int]revocationEntry
+}
+
+// NewRevocationStore создает пустое хранилище отзывов
+func NewRevocationStore() *RevocationStore {
+	return &RevocationStore{
+		tokens: make(map[string]revocationEntry),
+		users:  make(map[int]revocationEntry),
+	}
+}
+
+// RevokeToken отзывает один access токен до момента его истечения
+func (s *RevocationStore) RevokeToken(ctx context.Context, jti string, userID int, expiresAt time.Time) error {
+	_, err := s.Consume(ctx, jti, userID, expiresAt)
+	return err
+}
+
+// Consume помечает одноразовый токен использованным.
+// Возвращает false, если токен уже был использован или отозван (в том числе параллельным запросом)
+func (s *RevocationStore) Consume(ctx context.Context, jti string, userID int, expiresAt time.Time) (bool, error) {
+	inserted, err := InsertRevokedToken(ctx, jti, userID, expiresAt)
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	s.tokens[jti] = revocationEntry{revoked: true, expiresAt: expiresAt}
+	s.mu.Unlock()
+	return inserted, nil
+}
+
+// RevokeAllForUser отзывает все access токены пользователя, выданные до текущего момента
+func (s *RevocationStore) RevokeAllForUser(ctx context.Context, userID int) error {
+	cutoff := time.Now()
+	if err := SetUserTokensRevokedBefore(ctx, userID, cutoff); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.users[userID] = revocationEntry{revoked: true, cutoff: cutoff, expiresAt: cutoff.Add(maxTokenLifetime)}
+	s.mu.Unlock()
+	return nil
+}
+
+// IsRevoked проверяет, отозван ли токен (по jti или через "выйти везде")
+func (s *RevocationStore) IsRevoked(ctx context.Context, claims *Claims) (bool, error) {
+	if claims.ID != "" {
+		revoked, err := s.isTokenRevoked(ctx, claims.ID)
+		if err != nil || revoked {
+			return revoked, err
+		}
+	}
+
+	cutoff, err := s.userCutoff(ctx, claims.UserID)
+	if err != nil {
+		return false, err
+	}
+	if cutoff.IsZero() || claims.IssuedAt == nil {
+		return false, nil
+	}
+
+	// iat выдается с точностью до микросекунды (см. InitAuth), поэтому отозваны и токены,
+	// выданные в ту же секунду до отзыва, а новые токены (например, после смены пароля) - нет.
+	// У токенов с iat в целых секундах он округлен вниз и тоже оказывается раньше отметки
+	return claims.IssuedAt.Time.Before(cutoff), nil
+}
+
+// isTokenRevoked проверяет jti сначала в кеше, затем в БД
+func (s *RevocationStore) isTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	now := time.Now()
+
+	s.mu.RLock()
+	entry, ok := s.tokens[jti]
+	s.mu.RUnlock()
+	if ok && now.Before(entry.expiresAt) {
+		return entry.revoked, nil
+	}
+
+	revoked, err := IsTokenRevoked(ctx, jti)
+	if err != nil {
+		return false, err
+	}
+
+	// Отрицательный результат кешируем ненадолго, положительный - до истечения токена
+	entry = revocationEntry{revoked: revoked, expiresAt: now.Add(revocationCacheTTL)}
+	if revoked {
+		entry.expiresAt = now.Add(maxTokenLifetime)
+	}
+	s.mu.Lock()
+	s.tokens[jti] = entry
+	s.mu.Unlock()
+
+	return revoked, nil
+}
+
+// userCutoff возвращает момент последнего "выйти везде" для пользователя
+func (s *RevocationStore) userCutoff(ctx context.Context, userID int) (time.Time, error) {
+	now := time.Now()
+
+	s.mu.RLock()
+	entry, ok := s.users[userID]
+	s.mu.RUnlock()
+	if ok && now.Before(entry.expiresAt) {
+		return entry.cutoff, nil
+	}
+
+	cutoff, err := GetUserTokensRevokedBefore(ctx, userID)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	s.mu.Lock()
+	s.users[userID] = revocationEntry{revoked: !cutoff.IsZero(), cutoff: cutoff, expiresAt: now.Add(revocationCacheTTL)}
+	s.mu.Unlock()
+
+	return cutoff, nil
+}
+
+// Prune удаляет истекшие записи из БД и из кеша
+func (s *RevocationStore) Prune(ctx context.Context) error {
+	now := time.Now()
+
+	s.mu.Lock()
+	for jti, entry := range s.tokens {
+		if !now.Before(entry.expiresAt) {
+			delete(s.tokens, jti)
+		}
+	}
+	for userID, entry := range s.users {
+		if !now.Before(entry.expiresAt) {
+			delete(s.users, userID)
+		}
+	}
+	s.mu.Unlock()
+
+	// Отметка "выйти везде" больше не нужна, когда все токены, выданные до нее, истекли
+	return DeleteExpiredRevocations(ctx, now.Add(-maxTokenLifetime))
+}
+
+// StartPruner запускает фоновую очистку истекших записей
+func (s *RevocationStore) StartPruner(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := s.Prune(context.Background()); err != nil {
+				log.Printf("Revocation prune error: %v", err)
+			}
+		}
+	}()
+}
+
+// revokeAllUserSessions завершает все сессии пользователя: отзывает access токены,
+// все refresh токены, помечает сессии завершенными и отзывает API ключи.
+// Вызывается, когда старые учетные данные могли утечь (смена и сброс пароля, выход везде),
+// поэтому ключи, выпущенные по ним, тоже перестают действовать
+func (s *Server) revokeAllUserSessions(ctx context.Context, userID int) error {
+	if err := s.revocations.RevokeAllForUser(ctx, userID); err != nil {
+		return err
+	}
+	if err := RevokeUserRefreshTokens(ctx, userID); err != nil {
+		return err
+	}
+	if err := RevokeUserSessions(ctx, userID); err != nil {
+		return err
+	}
+	return RevokeUserAPIKeys(ctx, userID)
+}
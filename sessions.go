@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// sessionCheckInterval как долго кешируется результат проверки сессии.
+	// Заодно это минимальный интервал обновления last_seen_at
+	sessionCheckInterval = 30 * time.Second
+	// sessionPruneInterval период удаления истекших сессий
+	sessionPruneInterval = time.Hour
+	// maxDeviceLabelLength ограничение длины названия устройства в символах
+	maxDeviceLabelLength = 100
+)
+
+// SessionManager сессии (устройства) пользователей и их refresh токены.
+// Реализация - SessionStore поверх таблиц sessions и refresh_tokens
+type SessionManager interface {
+	// Start создает сессию для нового входа с устройства, с которого пришел запрос
+	Start(r *http.Request, userID int) (string, error)
+	// IssueRefreshToken выдает новый refresh токен сессии и продлевает ее
+	IssueRefreshToken(ctx context.Context, userID int, sessionID string) (string, error)
+	// IsActive проверяет, что сессия не отозвана и не истекла
+	IsActive(ctx context.Context, sessionID string) (bool, error)
+	// Revoke завершает сессию пользователя; false - такой активной сессии нет
+	Revoke(ctx context.Context, userID int, sessionID string) (bool, error)
+}
+
+// sessionEntry запись кеша проверки одной сессии
+type sessionEntry struct {
+	active    bool
+	checkedAt time.Time
+}
+
+// SessionStore проверяет, что сессия токена не завершена.
+// Источник истины - таблица sessions; перед ней стоит кеш, чтобы не обращаться
+// к БД на каждый запрос. Отзыв на другой реплике виден не позже sessionCheckInterval
+type SessionStore struct {
+	mu      sync.RWMutex
+	entries map[string]sessionEntry
+}
+
+// NewSessionStore создает пустое хранилище
+func NewSessionStore() *SessionStore {
+	return &SessionStore{entries: make(map[string]sessionEntry)}
+}
+
+// Start создает сессию для нового входа с устройства, с которого пришел запрос
+func (s *SessionStore) Start(r *http.Request, userID int) (string, error) {
+	sessionID, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+	userAgent := r.UserAgent()
+	if err := CreateSession(r.Context(), sessionID, userID, deviceLabel(userAgent), userAgent, clientIP(r), time.Now().Add(refreshTokenTTL)); err != nil {
+		return "", err
+	}
+	return sessionID, nil
+}
+
+// IssueRefreshToken создает refresh токен сессии sessionID.
+// ID сессии одновременно служит family_id ее refresh токенов
+func (s *SessionStore) IssueRefreshToken(ctx context.Context, userID int, sessionID string) (string, error) {
+	refreshToken, err := GenerateRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	expiresAt := time.Now().Add(refreshTokenTTL)
+	if err := CreateRefreshToken(ctx, userID, hashToken(refreshToken), sessionID, expiresAt); err != nil {
+		return "", err
+	}
+
+	// Сессия живет, пока жив ее последний refresh токен
+	if err := ExtendSession(ctx, sessionID, userID, expiresAt); err != nil {
+		return "", err
+	}
+	return refreshToken, nil
+}
+
+// IsActive проверяет, что сессия не отозвана и не истекла.
+// Проверка в БД заодно обновляет last_seen_at
+func (s *SessionStore) IsActive(ctx context.Context, sessionID string) (bool, error) {
+	now := time.Now()
+
+	s.mu.RLock()
+	entry, ok := s.entries[sessionID]
+	s.mu.RUnlock()
+	if ok && now.Sub(entry.checkedAt) < sessionCheckInterval {
+		return entry.active, nil
+	}
+
+	active, err := TouchSession(ctx, sessionID)
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	s.entries[sessionID] = sessionEntry{active: active, checkedAt: now}
+	s.mu.Unlock()
+	return active, nil
+}
+
+// Revoke завершает сессию пользователя вместе с ее refresh токенами.
+// Возвращает false, если у пользователя нет такой активной сессии
+func (s *SessionStore) Revoke(ctx context.Context, userID int, sessionID string) (bool, error) {
+	revoked, err := RevokeSession(ctx, userID, sessionID)
+	if err != nil || !revoked {
+		return revoked, err
+	}
+
+	s.mu.Lock()
+	s.entries[sessionID] = sessionEntry{active: false, checkedAt: time.Now()}
+	s.mu.Unlock()
+	return true, nil
+}
+
+// StartPruner запускает фоновую очистку кеша и истекших сессий
+func (s *SessionStore) StartPruner(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			now := time.Now()
+			s.mu.Lock()
+			for id, entry := range s.entries {
+				if now.Sub(entry.checkedAt) >= sessionCheckInterval {
+					delete(s.entries, id)
+				}
+			}
+			s.mu.Unlock()
+
+			if err := DeleteExpiredSessions(context.Background()); err != nil {
+				log.Printf("Session prune error: %v", err)
+			}
+		}
+	}()
+}
+
+// deviceLabel строит понятное название устройства по User-Agent, например "Firefox on Linux"
+func deviceLabel(userAgent string) string {
+	if userAgent == "" {
+		return "Unknown device"
+	}
+
+	// Порядок важен: Edge и Chrome тоже содержат "Safari", Android - "Linux"
+	browsers := []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	}
+	systems := []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"Linux", "Linux"},
+	}
+
+	browser, system := "", ""
+	for _, b := range browsers {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	for _, o := range systems {
+		if strings.Contains(userAgent, o.token) {
+			system = o.name
+			break
+		}
+	}
+
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return system
+	}
+
+	// Неизвестный клиент: показываем начало User-Agent, не разрезая многобайтовые символы
+	if utf8.RuneCountInString(userAgent) > maxDeviceLabelLength {
+		return string([]rune(userAgent)[:maxDeviceLabelLength])
+	}
+	return userAgent
+}
+
+// SessionsHandler возвращает активные сессии пользователя (GET /sessions)
+func SessionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims, ok := GetClaimsFromContext(r)
+	if !ok {
+		sendErrorResponse(w, "Token claims not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	list, err := ListUserSessions(r.Context(), claims.UserID)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	for i := range list {
+		list[i].Current = list[i].ID == claims.SessionID
+	}
+
+	sendJSONResponse(w, map[string]interface{}{"sessions": list}, http.StatusOK)
+}
+
+// RevokeSessionHandler завершает одну сессию пользователя (DELETE /sessions/{id})
+func (s *Server) RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID := strings.Trim(strings.TrimPrefix(r.URL.Path, "/sessions/"), "/")
+	if sessionID == "" || strings.Contains(sessionID, "/") {
+		sendErrorResponse(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	userID, ok := GetUserIDFromContext(r)
+	if !ok {
+		sendErrorResponse(w, "User ID not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	revoked, err := s.sessions.Revoke(r.Context(), userID, sessionID)
+	if err != nil {
+		log.Printf("Revoke session error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	if !revoked {
+		sendErrorResponse(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	s.audit(r, auditSessionRevoke, userID, auditSuccess, map[string]interface{}{"session_id": sessionID})
+	sendJSONResponse(w, map[string]string{"message": "Session revoked"}, http.StatusOK)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestDeviceLabel(t *testing.T) {
+	long := strings.Repeat("Клиент-", 20) // 140 символов, 260 байт
+	tests := []struct {
+		name, userAgent, want string
+	}{
+		{"empty", "", "Unknown device"},
+		{"browser and system", "Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0", "Firefox on Linux"},
+		{"edge before chrome", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0 Safari/537.36 Edg/126.0", "Edge on Windows"},
+		{"browser only", "curl/8.5.0", "curl"},
+		{"short unknown client", "Приложение/1.0", "Приложение/1.0"},
+		{"long unknown client is cut by characters", long, string([]rune(long)[:maxDeviceLabelLength])},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			got := deviceLabel(tc.userAgent)
+			if got != tc.want {
+				t.Errorf("deviceLabel(%q) = %q, want %q", tc.userAgent, got, tc.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("deviceLabel(%q) = %q is not valid UTF-8", tc.userAgent, got)
+			}
+			if n := utf8.RuneCountInString(got); n > maxDeviceLabelLength {
+				t.Errorf("deviceLabel(%q) has %d characters, want at most %d", tc.userAgent, n, maxDeviceLabelLength)
+			}
+		})
+	}
+}
@@ -0,0 +1,418 @@
+-- Последняя версия init.sql до появления миграций.
+-- Используется migrate_test.go: базы, созданные этим скриптом, должны переходить на миграции
+
+-- Создание таблицы пользователей
+CREATE TABLE IF NOT EXISTS users (
+    id SERIAL PRIMARY KEY,
+    email VARCHAR(255) UNIQUE NOT NULL,
+    username VARCHAR(30) UNIQUE NOT NULL,
+    password_hash VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Индексы для оптимизации поиска
+CREATE INDEX IF NOT EXISTS idx_users_email ON users(email);
+CREATE INDEX IF NOT EXISTS idx_users_username ON users(username);
+
+-- Добавим комментарии к таблице для документации
+COMMENT ON TABLE users IS 'Таблица пользователей системы';
+COMMENT ON COLUMN users.email IS 'Email пользователя (уникальный)';
+COMMENT ON COLUMN users.username IS 'Имя пользователя (уникальное)';
+COMMENT ON COLUMN users.password_hash IS 'Хеш пароля (bcrypt)';
+COMMENT ON COLUMN users.created_at IS 'Дата и время регистрации';
+
+-- Таблица refresh токенов (хранятся только хеши)
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash CHAR(64) UNIQUE NOT NULL,
+    family_id VARCHAR(64) NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    rotated_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens(family_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens(user_id);
+
+COMMENT ON TABLE refresh_tokens IS 'Refresh токены с ротацией';
+COMMENT ON COLUMN refresh_tokens.token_hash IS 'SHA-256 хеш токена (hex)';
+COMMENT ON COLUMN refresh_tokens.family_id IS 'Идентификатор цепочки ротации';
+COMMENT ON COLUMN refresh_tokens.rotated_at IS 'Время обмена токена на новый';
+COMMENT ON COLUMN refresh_tokens.revoked_at IS 'Время отзыва семейства токенов';
+
+-- Отозванные access токены (по jti) до момента их истечения
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    jti VARCHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires_at ON revoked_tokens(expires_at);
+
+-- Отметки "выйти везде": токены пользователя, выданные раньше revoked_before, отозваны.
+-- Внешнего ключа нет намеренно, чтобы отметка пережила удаление пользователя
+CREATE TABLE IF NOT EXISTS user_token_revocations (
+    user_id INTEGER PRIMARY KEY,
+    revoked_before TIMESTAMPTZ NOT NULL
+);
+
+COMMENT ON TABLE revoked_tokens IS 'Список отозванных access токенов';
+COMMENT ON TABLE user_token_revocations IS 'Отзыв всех токенов пользователя';
+
+-- Двухфакторная аутентификация (TOTP)
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret_encrypted TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+COMMENT ON COLUMN users.totp_secret_encrypted IS 'Секрет TOTP, зашифрованный AES-256-GCM';
+COMMENT ON COLUMN users.totp_enabled IS 'TOTP подтвержден и требуется при входе';
+COMMENT ON COLUMN users.totp_last_step IS 'Последний использованный временной шаг TOTP';
+
+-- Одноразовые коды восстановления для входа без приложения-аутентификатора
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash VARCHAR(255) NOT NULL,
+    used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_mfa_recovery_codes_user_id ON mfa_recovery_codes(user_id);
+
+COMMENT ON TABLE mfa_recovery_codes IS 'Коды восстановления 2FA';
+COMMENT ON COLUMN mfa_recovery_codes.code_hash IS 'Хеш кода (bcrypt)';
+
+-- Подтверждение email
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMPTZ;
+
+COMMENT ON COLUMN users.email_verified_at IS 'Дата подтверждения email (NULL - не подтвержден)';
+
+-- Токены сброса пароля (хранятся только хеши)
+CREATE TABLE IF NOT EXISTS password_reset_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash CHAR(64) UNIQUE NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_password_reset_tokens_user_id ON password_reset_tokens(user_id);
+
+COMMENT ON TABLE password_reset_tokens IS 'Одноразовые токены сброса пароля';
+COMMENT ON COLUMN password_reset_tokens.token_hash IS 'SHA-256 хеш токена (hex)';
+
+-- Мягкое удаление аккаунтов
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+
+COMMENT ON COLUMN users.deleted_at IS 'Дата удаления аккаунта (NULL - активен)';
+
+-- Роли и разрешения (RBAC)
+CREATE TABLE IF NOT EXISTS roles (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(50) UNIQUE NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS permissions (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) UNIQUE NOT NULL,
+    description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role_id INTEGER NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    permission_id INTEGER NOT NULL REFERENCES permissions(id) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role_id INTEGER NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    granted_by INTEGER,
+    granted_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, role_id)
+);
+
+COMMENT ON TABLE roles IS 'Роли пользователей';
+COMMENT ON TABLE permissions IS 'Разрешения, проверяемые RequirePermission';
+COMMENT ON TABLE user_roles IS 'Назначенные пользователям роли';
+COMMENT ON COLUMN user_roles.granted_by IS 'ID администратора, назначившего роль (NULL - из ADMIN_EMAIL)';
+
+-- Встроенная роль администратора со всеми разрешениями
+INSERT INTO roles (name, description) VALUES
+    ('admin', 'Full administrative access')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO permissions (name, description) VALUES
+    ('users:read', 'View user accounts'),
+    ('users:write', 'Modify user accounts'),
+    ('roles:manage', 'Grant and revoke roles')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r CROSS JOIN permissions p WHERE r.name = 'admin'
+ON CONFLICT DO NOTHING;
+
+-- Управление пользователями администратором
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locked_at TIMESTAMPTZ;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS password_reset_required BOOLEAN NOT NULL DEFAULT FALSE;
+
+COMMENT ON COLUMN users.locked_at IS 'Время блокировки администратором (NULL - не заблокирован)';
+COMMENT ON COLUMN users.password_reset_required IS 'Вход запрещен до сброса пароля';
+
+-- Счетчики неудачных попыток входа (защита от перебора паролей)
+CREATE TABLE IF NOT EXISTS login_failures (
+    key VARCHAR(300) PRIMARY KEY,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMPTZ NOT NULL,
+    locked_until TIMESTAMPTZ
+);
+
+COMMENT ON TABLE login_failures IS 'Неудачные попытки входа по email (email:...) и по IP (ip:...)';
+COMMENT ON COLUMN login_failures.locked_until IS 'До какого момента вход по этому ключу заблокирован';
+
+-- Счетчики ограничения частоты запросов (RATE_LIMIT_STORE=postgres)
+CREATE TABLE IF NOT EXISTS rate_limits (
+    key VARCHAR(300) NOT NULL,
+    window_start TIMESTAMPTZ NOT NULL,
+    count INTEGER NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (key, window_start)
+);
+
+CREATE INDEX IF NOT EXISTS idx_rate_limits_expires_at ON rate_limits(expires_at);
+
+COMMENT ON TABLE rate_limits IS 'Счетчики запросов по фиксированным окнам для скользящего лимита';
+
+-- Журнал аудита событий безопасности (только добавление)
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    event VARCHAR(50) NOT NULL,
+    user_id INTEGER,
+    target_user_id INTEGER,
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    outcome VARCHAR(20) NOT NULL,
+    details JSONB NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_user_id ON audit_log(user_id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_target_user_id ON audit_log(target_user_id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_event_created_at ON audit_log(event, created_at);
+
+COMMENT ON TABLE audit_log IS 'Журнал событий безопасности; изменение и удаление записей запрещены';
+COMMENT ON COLUMN audit_log.user_id IS 'Кто выполнил действие (без внешнего ключа: записи переживают удаление пользователя)';
+COMMENT ON COLUMN audit_log.target_user_id IS 'Над кем выполнено действие администратора';
+
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+CREATE TRIGGER audit_log_append_only
+    BEFORE UPDATE OR DELETE ON audit_log
+    FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+
+DROP TRIGGER IF EXISTS audit_log_no_truncate ON audit_log;
+CREATE TRIGGER audit_log_no_truncate
+    BEFORE TRUNCATE ON audit_log
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();
+
+-- Журнал действий администраторов (admin_actions) заменен событиями admin.* в audit_log:
+-- существующие записи переносятся в журнал, таблица удаляется
+DO $$
+BEGIN
+    IF to_regclass('admin_actions') IS NOT NULL THEN
+        INSERT INTO audit_log (created_at, event, user_id, target_user_id, outcome, details)
+        SELECT COALESCE(created_at, CURRENT_TIMESTAMP), left('admin.' || action, 50), admin_id, target_user_id,
+               'success', details
+        FROM admin_actions
+        ORDER BY id;
+        DROP TABLE admin_actions;
+    END IF;
+END
+$$;
+
+-- Разрешение на чтение журнала аудита
+INSERT INTO permissions (name, description) VALUES
+    ('audit:read', 'View the audit log')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r CROSS JOIN permissions p WHERE r.name = 'admin'
+ON CONFLICT DO NOTHING;
+
+-- Сессии (устройства), на которых выполнен вход
+CREATE TABLE IF NOT EXISTS sessions (
+    id VARCHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    device VARCHAR(200) NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
+
+COMMENT ON TABLE sessions IS 'Сессии пользователей; id совпадает с refresh_tokens.family_id и claim sid';
+COMMENT ON COLUMN sessions.device IS 'Название устройства, построенное по User-Agent';
+COMMENT ON COLUMN sessions.last_seen_at IS 'Последнее использование (обновляется не чаще раза в 30 секунд)';
+COMMENT ON COLUMN sessions.expires_at IS 'Срок последнего refresh токена сессии';
+
+-- Незавершенные входы через внешних OIDC провайдеров (state, nonce и PKCE verifier)
+CREATE TABLE IF NOT EXISTS oidc_auth_requests (
+    state_hash CHAR(64) PRIMARY KEY,
+    provider VARCHAR(50) NOT NULL,
+    nonce VARCHAR(100) NOT NULL,
+    code_verifier VARCHAR(128) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_oidc_auth_requests_expires_at ON oidc_auth_requests(expires_at);
+
+COMMENT ON TABLE oidc_auth_requests IS 'Одноразовые запросы авторизации у внешних провайдеров; удаляются при возврате в callback';
+COMMENT ON COLUMN oidc_auth_requests.state_hash IS 'SHA-256 хеш параметра state';
+
+-- Внешние учетные записи (Google, корпоративный IdP и т.п.), привязанные к пользователям
+CREATE TABLE IF NOT EXISTS identities (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    provider VARCHAR(50) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_login_at TIMESTAMPTZ,
+    UNIQUE (provider, subject)
+);
+
+CREATE INDEX IF NOT EXISTS idx_identities_user_id ON identities(user_id);
+
+COMMENT ON TABLE identities IS 'Привязка учетных записей внешних OIDC провайдеров к пользователям';
+COMMENT ON COLUMN identities.subject IS 'Claim sub ID токена: постоянный идентификатор пользователя у провайдера';
+COMMENT ON COLUMN identities.email IS 'Email от провайдера при последнем входе (только для информации)';
+
+-- OAuth 2.1: приложения, которые делегируют вход сервису
+CREATE TABLE IF NOT EXISTS oauth_clients (
+    client_id VARCHAR(64) PRIMARY KEY,
+    client_secret_hash CHAR(64),
+    name VARCHAR(100) NOT NULL,
+    redirect_uris TEXT[] NOT NULL DEFAULT '{}',
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    grant_types TEXT[] NOT NULL DEFAULT '{}',
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+COMMENT ON TABLE oauth_clients IS 'Зарегистрированные OAuth клиенты';
+COMMENT ON COLUMN oauth_clients.client_secret_hash IS 'SHA-256 хеш секрета клиента; NULL у публичного клиента';
+COMMENT ON COLUMN oauth_clients.redirect_uris IS 'Разрешенные адреса возврата (сравниваются точно)';
+COMMENT ON COLUMN oauth_clients.scopes IS 'Scope, которые клиент может запрашивать';
+
+CREATE TABLE IF NOT EXISTS oauth_authorization_codes (
+    code_hash CHAR(64) PRIMARY KEY,
+    client_id VARCHAR(64) NOT NULL REFERENCES oauth_clients(client_id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    redirect_uri TEXT NOT NULL,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    code_challenge VARCHAR(128) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_oauth_authorization_codes_expires_at ON oauth_authorization_codes(expires_at);
+
+COMMENT ON TABLE oauth_authorization_codes IS 'Одноразовые коды авторизации; удаляются при обмене на токен';
+COMMENT ON COLUMN oauth_authorization_codes.code_challenge IS 'PKCE code_challenge (S256)';
+
+CREATE TABLE IF NOT EXISTS oauth_consents (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    client_id VARCHAR(64) NOT NULL REFERENCES oauth_clients(client_id) ON DELETE CASCADE,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    granted_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, client_id)
+);
+
+COMMENT ON TABLE oauth_consents IS 'Согласия пользователей на доступ клиентов к scope';
+
+-- Разрешение на регистрацию OAuth клиентов
+INSERT INTO permissions (name, description) VALUES
+    ('oauth_clients:manage', 'Register and delete OAuth clients')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r CROSS JOIN permissions p WHERE r.name = 'admin'
+ON CONFLICT DO NOTHING;
+
+-- Персональные API ключи для доступа скриптов и сервисов
+CREATE TABLE IF NOT EXISTS api_keys (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    prefix VARCHAR(20) NOT NULL UNIQUE,
+    key_hash CHAR(64) NOT NULL,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON api_keys(user_id);
+
+COMMENT ON TABLE api_keys IS 'Персональные API ключи пользователей (Authorization: ApiKey <key>)';
+COMMENT ON COLUMN api_keys.prefix IS 'Видимая часть ключа, по которой он находится и отображается в списке';
+COMMENT ON COLUMN api_keys.key_hash IS 'SHA-256 хеш ключа целиком; сам ключ не хранится';
+COMMENT ON COLUMN api_keys.expires_at IS 'Срок действия; NULL - бессрочный';
+COMMENT ON COLUMN api_keys.last_used_at IS 'Последнее использование (обновляется не чаще раза в минуту)';
+
+-- WebAuthn: challenge незавершенных церемоний регистрации и входа по ключу доступа
+CREATE TABLE IF NOT EXISTS webauthn_challenges (
+    challenge_hash CHAR(64) PRIMARY KEY,
+    ceremony VARCHAR(20) NOT NULL,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    user_handle BYTEA,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_webauthn_challenges_expires_at ON webauthn_challenges(expires_at);
+
+COMMENT ON TABLE webauthn_challenges IS 'Одноразовые challenge WebAuthn; удаляются при завершении церемонии';
+COMMENT ON COLUMN webauthn_challenges.user_id IS 'Пользователь, регистрирующий ключ; NULL при входе';
+
+-- Ключи доступа (passkeys)
+CREATE TABLE IF NOT EXISTS webauthn_credentials (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    credential_id BYTEA NOT NULL UNIQUE,
+    user_handle BYTEA NOT NULL,
+    public_key BYTEA NOT NULL,
+    algorithm INTEGER NOT NULL,
+    sign_count BIGINT NOT NULL DEFAULT 0,
+    aaguid BYTEA,
+    name VARCHAR(100) NOT NULL,
+    attestation_format VARCHAR(20) NOT NULL,
+    transports TEXT[] NOT NULL DEFAULT '{}',
+    backup_eligible BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_webauthn_credentials_user_id ON webauthn_credentials(user_id);
+
+COMMENT ON TABLE webauthn_credentials IS 'Ключи доступа WebAuthn для входа без пароля';
+COMMENT ON COLUMN webauthn_credentials.user_handle IS 'Случайный user.id, который аутентификатор возвращает при входе';
+COMMENT ON COLUMN webauthn_credentials.public_key IS 'Открытый ключ в формате COSE';
+COMMENT ON COLUMN webauthn_credentials.sign_count IS 'Счетчик подписей: уменьшение или повтор означает клонированный ключ';
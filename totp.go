@@ -0,0 +1,220 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Параметры TOTP (RFC 6238) - значения по умолчанию для приложений-аутентификаторов
+	totpPeriod     = 30
+	totpDigits     = 6
+	totpSecretSize = 20
+
+	// recoveryCodeCount количество одноразовых кодов восстановления
+	recoveryCodeCount = 10
+	// recoveryCodeLength длина кода восстановления без дефиса
+	recoveryCodeLength = 10
+)
+
+// errMFANotConfigured возвращается, если не задан ключ шифрования TOTP секретов
+var errMFANotConfigured = errors.New("MFA is not configured")
+
+// mfaEncryptionKey ключ AES-256 для шифрования TOTP секретов в БД
+var mfaEncryptionKey []byte
+
+// totpIssuer издатель в otpauth:// URI (Config.MFA.TOTPIssuer)
+var totpIssuer = "secure-service"
+
+// b32 кодировка секретов TOTP без дополнения, как в otpauth:// URI
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// InitMFA загружает ключ шифрования TOTP секретов (MFA_ENCRYPTION_KEY, 32 байта в base64).
+// Без ключа двухфакторная аутентификация недоступна
+func InitMFA(cfg MFAConfig) error {
+	totpIssuer = cfg.TOTPIssuer
+	if cfg.EncryptionKey == "" {
+		log.Println("Warning: MFA_ENCRYPTION_KEY is not set, TOTP is disabled")
+		return nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(cfg.EncryptionKey)
+	if err != nil {
+		return fmt.Errorf("MFA_ENCRYPTION_KEY must be base64: %w", err)
+	}
+	if len(key) != 32 {
+		return fmt.Errorf("MFA_ENCRYPTION_KEY must be 32 bytes long")
+	}
+	mfaEncryptionKey = key
+	return nil
+}
+
+// generateTOTPSecret создает случайный секрет TOTP в base32
+func generateTOTPSecret() (string, error) {
+	b := make([]byte, totpSecretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate TOTP secret: %w", err)
+	}
+	return b32.EncodeToString(b), nil
+}
+
+// totpURI формирует otpauth:// URI для QR-кода приложения-аутентификатора
+func totpURI(secret, accountName string) string {
+	issuer := totpIssuer
+
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", strconv.Itoa(totpDigits))
+	params.Set("period", strconv.Itoa(totpPeriod))
+
+	label := url.PathEscape(issuer + ":" + accountName)
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// totpCode вычисляет код HOTP (RFC 4226) для номера временного шага
+func totpCode(secret []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	// Динамическое усечение
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod)
+}
+
+// verifyTOTP проверяет код с допуском в один шаг в обе стороны.
+// Шаги не новее lastStep отклоняются, чтобы код нельзя было использовать повторно.
+// Возвращает номер шага, которому соответствует код
+func verifyTOTP(secret, code string, lastStep int64, now time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	key, err := b32.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+	for step := current - 1; step <= current+1; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if hmac.Equal([]byte(totpCode(key, step)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// encryptTOTPSecret шифрует секрет AES-256-GCM. ID пользователя входит
+// в дополнительные данные, чтобы шифротекст нельзя было перенести другому пользователю
+func encryptTOTPSecret(userID int, secret string) (string, error) {
+	gcm, err := newMFACipher()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(secret), totpAAD(userID))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptTOTPSecret расшифровывает секрет, зашифрованный encryptTOTPSecret
+func decryptTOTPSecret(userID int, encrypted string) (string, error) {
+	gcm, err := newMFACipher()
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil || len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("malformed TOTP secret")
+	}
+
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, ciphertext, totpAAD(userID))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt TOTP secret: %w", err)
+	}
+	return string(plain), nil
+}
+
+// newMFACipher создает AES-GCM с ключом MFA_ENCRYPTION_KEY
+func newMFACipher() (cipher.AEAD, error) {
+	if mfaEncryptionKey == nil {
+		return nil, errMFANotConfigured
+	}
+	block, err := aes.NewCipher(mfaEncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// totpAAD дополнительные данные для шифрования секрета пользователя
+func totpAAD(userID int) []byte {
+	return []byte("totp:" + strconv.Itoa(userID))
+}
+
+// generateRecoveryCodes создает одноразовые коды восстановления вида xxxxx-xxxxx
+func generateRecoveryCodes() ([]string, error) {
+	const alphabet = "abcdefghijkmnpqrstuvwxyz23456789" // без похожих символов l, o, 0, 1
+
+	codes := make([]string, recoveryCodeCount)
+	buf := make([]byte, recoveryCodeLength)
+	for i := range codes {
+		if _, err := rand.Read(buf); err != nil {
+			return nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		var sb strings.Builder
+		for j, b := range buf {
+			if j == recoveryCodeLength/2 {
+				sb.WriteByte('-')
+			}
+			// 256 делится на 32 нацело, поэтому распределение равномерное
+			sb.WriteByte(alphabet[int(b)%len(alphabet)])
+		}
+		codes[i] = sb.String()
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode приводит введенный код восстановления к виду, в котором он хешировался
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	if len(code) != recoveryCodeLength {
+		return code
+	}
+	return code[:recoveryCodeLength/2] + "-" + code[recoveryCodeLength/2:]
+}
@@ -0,0 +1,721 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotFound запись не найдена (или мягко удалена)
+	ErrNotFound = errors.New("not found")
+	// ErrDuplicate нарушено ограничение уникальности; подробности - в DuplicateError
+	ErrDuplicate = errors.New("already exists")
+)
+
+// DuplicateError сообщает, что уже занято: "email", "username" или "identity"
+// (внешняя учетная запись привязана к другому пользователю).
+// errors.Is(err, ErrDuplicate) для нее возвращает true
+type DuplicateError struct {
+	Field string
+}
+
+func (e *DuplicateError) Error() string {
+	return e.Field + " already exists"
+}
+
+// Is позволяет сравнивать ошибку с ErrDuplicate
+func (e *DuplicateError) Is(target error) bool {
+	return target == ErrDuplicate
+}
+
+// UserStore хранилище аккаунтов пользователей и привязанных к ним внешних учетных записей.
+// Отсутствующий или мягко удаленный пользователь - ErrNotFound, занятый email или username - *DuplicateError
+type UserStore interface {
+	// CreateUser создает пользователя и возвращает его с ID и датой создания
+	CreateUser(ctx context.Context, email, username, passwordHash string) (*User, error)
+	// GetUserByEmail находит пользователя по email вместе с хешем пароля
+	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	// GetUserByID находит пользователя по ID (без хеша пароля)
+	GetUserByID(ctx context.Context, userID int) (*User, error)
+	// UserExistsByEmail проверяет, занят ли email
+	UserExistsByEmail(ctx context.Context, email string) (bool, error)
+	// GetPasswordHashByUserID возвращает хеш пароля пользователя (для проверки текущего пароля)
+	GetPasswordHashByUserID(ctx context.Context, userID int) (string, error)
+	// UpdateUserProfile меняет email и имя; при смене email отметка о подтверждении сбрасывается
+	UpdateUserProfile(ctx context.Context, userID int, email, username string) (*User, error)
+	// UpdateUserPassword сохраняет новый хеш пароля и снимает требование его сменить
+	UpdateUserPassword(ctx context.Context, userID int, passwordHash string) error
+	// SetUserLocked блокирует (повторная блокировка сохраняет время первой) или разблокирует пользователя
+	SetUserLocked(ctx context.Context, userID int, locked bool) error
+	// SetPasswordResetRequired запрещает вход пользователя до смены пароля
+	SetPasswordResetRequired(ctx context.Context, userID int) error
+	// ListUsers возвращает страницу пользователей (без удаленных), отфильтрованных по началу
+	// email и/или имени без учета регистра, и общее число подходящих пользователей
+	ListUsers(ctx context.Context, filter UserFilter) ([]User, int, error)
+	// SoftDeleteUser помечает пользователя удаленным и обезличивает его (см. PostgresUserStore)
+	SoftDeleteUser(ctx context.Context, userID int) error
+	// DeleteUser безвозвратно удаляет пользователя (в том числе мягко удаленного)
+	DeleteUser(ctx context.Context, userID int) error
+	// CreateUserWithIdentity создает пользователя и сразу привязывает к нему внешнюю учетную запись.
+	// emailVerified - провайдер подтвердил адрес, повторная проверка не нужна
+	CreateUserWithIdentity(ctx context.Context, email, username, passwordHash string, emailVerified bool, provider, subject string) (*User, error)
+	// GetIdentityUserID возвращает ID пользователя, к которому привязана внешняя учетная запись
+	GetIdentityUserID(ctx context.Context, provider, subject string) (int, error)
+	// LinkIdentity привязывает внешнюю учетную запись к пользователю
+	LinkIdentity(ctx context.Context, userID int, provider, subject, email string) error
+	// TouchIdentity запоминает email от провайдера и время последнего входа
+	TouchIdentity(ctx context.Context, provider, subject, email string) error
+	// MarkEmailVerified отмечает email подтвержденным; false - адрес пользователя уже другой
+	MarkEmailVerified(ctx context.Context, userID int, email string) (bool, error)
+	// CreatePasswordResetToken сохраняет хеш токена сброса пароля
+	CreatePasswordResetToken(ctx context.Context, userID int, tokenHash string, expiresAt time.Time) error
+	// ResetPasswordWithToken использует токен сброса, меняет пароль и аннулирует остальные токены.
+	// Возвращает ID пользователя или 0, если токен не найден, истек или уже использован
+	ResetPasswordWithToken(ctx context.Context, tokenHash, passwordHash string) (int, error)
+	// GetTOTPState возвращает секрет, признак включения и последний использованный шаг TOTP
+	GetTOTPState(ctx context.Context, userID int) (*TOTPState, error)
+	// SetPendingTOTPSecret сохраняет секрет до подтверждения первым кодом; false - TOTP уже подключен
+	SetPendingTOTPSecret(ctx context.Context, userID int, secretEncrypted string) (bool, error)
+	// EnableTOTP включает TOTP с начатым подключением и заменяет коды восстановления
+	EnableTOTP(ctx context.Context, userID int, step int64, recoveryCodeHashes []string) error
+	// AdvanceTOTPStep запоминает использованный шаг; false - этот или более поздний шаг уже использован
+	AdvanceTOTPStep(ctx context.Context, userID int, step int64) (bool, error)
+	// GetUnusedRecoveryCodes возвращает хеши неиспользованных кодов восстановления
+	GetUnusedRecoveryCodes(ctx context.Context, userID int) ([]RecoveryCode, error)
+	// MarkRecoveryCodeUsed помечает код восстановления использованным; false - код уже использован
+	MarkRecoveryCodeUsed(ctx context.Context, id int) (bool, error)
+}
+
+// MemoryUserStore хранит пользователей в памяти процесса.
+// Подходит для тестов обработчиков без PostgreSQL. Ведет себя как PostgresUserStore:
+// мягко удаленные пользователи обезличиваются и не находятся, email и username уникальны
+type MemoryUserStore struct {
+	mu            sync.RWMutex
+	nextID        int
+	users         map[int]*memoryUser
+	identities    map[memoryIdentityKey]*memoryIdentity
+	resetTokens   map[string]*memoryResetToken // по хешу токена
+	nextCodeID    int
+	recoveryCodes map[int]*memoryRecoveryCode
+}
+
+// memoryUser строка таблицы users
+type memoryUser struct {
+	User
+	deleted             bool
+	totpSecretEncrypted string
+	totpLastStep        int64
+}
+
+// memoryResetToken строка таблицы password_reset_tokens
+type memoryResetToken struct {
+	userID    int
+	expiresAt time.Time
+	used      bool
+}
+
+// memoryRecoveryCode строка таблицы mfa_recovery_codes
+type memoryRecoveryCode struct {
+	userID   int
+	codeHash string
+	used     bool
+}
+
+// memoryIdentityKey ключ строки таблицы identities
+type memoryIdentityKey struct {
+	provider, subject string
+}
+
+// memoryIdentity строка таблицы identities
+type memoryIdentity struct {
+	userID      int
+	email       string
+	lastLoginAt time.Time
+}
+
+// NewMemoryUserStore создает пустое хранилище
+func NewMemoryUserStore() *MemoryUserStore {
+	return &MemoryUserStore{
+		nextID:        1,
+		users:         make(map[int]*memoryUser),
+		identities:    make(map[memoryIdentityKey]*memoryIdentity),
+		resetTokens:   make(map[string]*memoryResetToken),
+		nextCodeID:    1,
+		recoveryCodes: make(map[int]*memoryRecoveryCode),
+	}
+}
+
+// CreateUser создает пользователя, проверяя уникальность email и username, как UNIQUE в users
+func (s *MemoryUserStore) CreateUser(ctx context.Context, email, username, passwordHash string) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, err := s.insert(email, username, passwordHash, nil)
+	if err != nil {
+		return nil, err
+	}
+	user := u.User
+	user.PasswordHash = ""
+	return &user, nil
+}
+
+// insert добавляет строку в users. Вызывается под s.mu
+func (s *MemoryUserStore) insert(email, username, passwordHash string, emailVerifiedAt *time.Time) (*memoryUser, error) {
+	if err := s.checkUnique(0, email, username); err != nil {
+		return nil, err
+	}
+
+	u := &memoryUser{User: User{
+		ID:              s.nextID,
+		Email:           email,
+		Username:        username,
+		PasswordHash:    passwordHash,
+		EmailVerifiedAt: emailVerifiedAt,
+		CreatedAt:       time.Now(),
+	}}
+	s.users[u.ID] = u
+	s.nextID++
+	return u, nil
+}
+
+// checkUnique проверяет, что email и username не заняты другими строками (кроме exceptID).
+// Как и в PostgreSQL, строки удаленных пользователей тоже учитываются, но их email обезличен,
+// а username пуст (NULL). Вызывается под s.mu
+func (s *MemoryUserStore) checkUnique(exceptID int, email, username string) error {
+	for id, u := range s.users {
+		if id == exceptID {
+			continue
+		}
+		if u.Email == email {
+			return &DuplicateError{Field: "email"}
+		}
+		if u.Username != "" && u.Username == username {
+			return &DuplicateError{Field: "username"}
+		}
+	}
+	return nil
+}
+
+// active возвращает неудаленного пользователя по ID. Вызывается под s.mu
+func (s *MemoryUserStore) active(userID int) (*memoryUser, error) {
+	u, ok := s.users[userID]
+	if !ok || u.deleted {
+		return nil, ErrNotFound
+	}
+	return u, nil
+}
+
+// GetUserByEmail находит пользователя по email вместе с хешем пароля
+func (s *MemoryUserStore) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, u := range s.users {
+		if u.Email == email && !u.deleted {
+			user := u.User
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// GetUserByID находит пользователя по ID (без хеша пароля)
+func (s *MemoryUserStore) GetUserByID(ctx context.Context, userID int) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u, err := s.active(userID)
+	if err != nil {
+		return nil, err
+	}
+	user := u.User
+	user.PasswordHash = ""
+	return &user, nil
+}
+
+// UserExistsByEmail проверяет, занят ли email
+func (s *MemoryUserStore) UserExistsByEmail(ctx context.Context, email string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, u := range s.users {
+		if u.Email == email {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// GetPasswordHashByUserID возвращает хеш пароля пользователя
+func (s *MemoryUserStore) GetPasswordHashByUserID(ctx context.Context, userID int) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u, err := s.active(userID)
+	if err != nil {
+		return "", err
+	}
+	return u.PasswordHash, nil
+}
+
+// UpdateUserProfile меняет email и имя пользователя
+func (s *MemoryUserStore) UpdateUserProfile(ctx context.Context, userID int, email, username string) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, err := s.active(userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkUnique(userID, email, username); err != nil {
+		return nil, err
+	}
+
+	if u.Email != email {
+		u.EmailVerifiedAt = nil
+	}
+	u.Email = email
+	u.Username = username
+
+	user := u.User
+	user.PasswordHash = ""
+	return &user, nil
+}
+
+// UpdateUserPassword сохраняет новый хеш пароля
+func (s *MemoryUserStore) UpdateUserPassword(ctx context.Context, userID int, passwordHash string) error {
+	return s.update(ctx, userID, func(u *memoryUser) {
+		u.PasswordHash = passwordHash
+		u.PasswordResetRequired = false
+	})
+}
+
+// SetUserLocked блокирует или разблокирует пользователя
+func (s *MemoryUserStore) SetUserLocked(ctx context.Context, userID int, locked bool) error {
+	return s.update(ctx, userID, func(u *memoryUser) {
+		switch {
+		case !locked:
+			u.LockedAt = nil
+		case u.LockedAt == nil:
+			now := time.Now()
+			u.LockedAt = &now
+		}
+	})
+}
+
+// SetPasswordResetRequired запрещает вход пользователя до смены пароля
+func (s *MemoryUserStore) SetPasswordResetRequired(ctx context.Context, userID int) error {
+	return s.update(ctx, userID, func(u *memoryUser) {
+		u.PasswordResetRequired = true
+	})
+}
+
+// update изменяет неудаленного пользователя под блокировкой
+func (s *MemoryUserStore) update(ctx context.Context, userID int, apply func(u *memoryUser)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, err := s.active(userID)
+	if err != nil {
+		return err
+	}
+	apply(u)
+	return nil
+}
+
+// ListUsers возвращает страницу пользователей в порядке ID
+func (s *MemoryUserStore) ListUsers(ctx context.Context, filter UserFilter) ([]User, int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	emailPrefix := strings.ToLower(filter.EmailPrefix)
+	usernamePrefix := strings.ToLower(filter.UsernamePrefix)
+	matched := []User{}
+	for _, u := range s.users {
+		if u.deleted ||
+			!strings.HasPrefix(strings.ToLower(u.Email), emailPrefix) ||
+			!strings.HasPrefix(strings.ToLower(u.Username), usernamePrefix) {
+			continue
+		}
+		user := u.User
+		user.PasswordHash = ""
+		matched = append(matched, user)
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].ID < matched[j].ID })
+
+	total := len(matched)
+	if filter.Offset >= total {
+		return []User{}, total, nil
+	}
+	end := total
+	if filter.Limit > 0 && filter.Offset+filter.Limit < total {
+		end = filter.Offset + filter.Limit
+	}
+	return matched[filter.Offset:end], total, nil
+}
+
+// SoftDeleteUser помечает пользователя удаленным, обезличивает его и отвязывает внешние учетные записи
+func (s *MemoryUserStore) SoftDeleteUser(ctx context.Context, userID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, err := s.active(userID)
+	if err != nil {
+		return err
+	}
+	u.deleted = true
+	u.Email = "deleted-" + strconv.Itoa(userID) + "@invalid"
+	u.Username = ""
+	u.PasswordHash = ""
+	u.TOTPEnabled = false
+	u.totpSecretEncrypted = ""
+	u.EmailVerifiedAt = nil
+	s.unlinkIdentities(userID)
+	s.deleteRecoveryCodes(userID)
+	for _, token := range s.resetTokens {
+		if token.userID == userID {
+			token.used = true
+		}
+	}
+	return nil
+}
+
+// DeleteUser безвозвратно удаляет пользователя вместе с его внешними учетными записями
+func (s *MemoryUserStore) DeleteUser(ctx context.Context, userID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[userID]; !ok {
+		return ErrNotFound
+	}
+	delete(s.users, userID)
+	s.unlinkIdentities(userID)
+	s.deleteRecoveryCodes(userID)
+	for hash, token := range s.resetTokens {
+		if token.userID == userID {
+			delete(s.resetTokens, hash)
+		}
+	}
+	return nil
+}
+
+// unlinkIdentities удаляет внешние учетные записи пользователя. Вызывается под s.mu
+func (s *MemoryUserStore) unlinkIdentities(userID int) {
+	for key, identity := range s.identities {
+		if identity.userID == userID {
+			delete(s.identities, key)
+		}
+	}
+}
+
+// CreateUserWithIdentity создает пользователя и привязывает к нему внешнюю учетную запись
+func (s *MemoryUserStore) CreateUserWithIdentity(ctx context.Context, email, username, passwordHash string, emailVerified bool, provider, subject string) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := memoryIdentityKey{provider, subject}
+	if _, ok := s.identities[key]; ok {
+		return nil, &DuplicateError{Field: "identity"}
+	}
+
+	var verifiedAt *time.Time
+	if emailVerified {
+		now := time.Now()
+		verifiedAt = &now
+	}
+	u, err := s.insert(email, username, passwordHash, verifiedAt)
+	if err != nil {
+		return nil, err
+	}
+	s.identities[key] = &memoryIdentity{userID: u.ID, email: email, lastLoginAt: time.Now()}
+
+	user := u.User
+	user.PasswordHash = ""
+	return &user, nil
+}
+
+// GetIdentityUserID возвращает ID пользователя, к которому привязана внешняя учетная запись
+func (s *MemoryUserStore) GetIdentityUserID(ctx context.Context, provider, subject string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	identity, ok := s.identities[memoryIdentityKey{provider, subject}]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	return identity.userID, nil
+}
+
+// LinkIdentity привязывает внешнюю учетную запись к пользователю
+func (s *MemoryUserStore) LinkIdentity(ctx context.Context, userID int, provider, subject, email string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.active(userID); err != nil {
+		return err
+	}
+	key := memoryIdentityKey{provider, subject}
+	if _, ok := s.identities[key]; ok {
+		return &DuplicateError{Field: "identity"}
+	}
+	s.identities[key] = &memoryIdentity{userID: userID, email: email, lastLoginAt: time.Now()}
+	return nil
+}
+
+// TouchIdentity запоминает email от провайдера и время последнего входа
+func (s *MemoryUserStore) TouchIdentity(ctx context.Context, provider, subject, email string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	identity, ok := s.identities[memoryIdentityKey{provider, subject}]
+	if !ok {
+		return ErrNotFound
+	}
+	identity.email = email
+	identity.lastLoginAt = time.Now()
+	return nil
+}
+
+// MarkEmailVerified отмечает email подтвержденным, если адрес пользователя все еще email
+func (s *MemoryUserStore) MarkEmailVerified(ctx context.Context, userID int, email string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, err := s.active(userID)
+	if err != nil || u.Email != email {
+		return false, nil
+	}
+	if u.EmailVerifiedAt == nil {
+		now := time.Now()
+		u.EmailVerifiedAt = &now
+	}
+	return true, nil
+}
+
+// CreatePasswordResetToken сохраняет хеш токена сброса пароля
+func (s *MemoryUserStore) CreatePasswordResetToken(ctx context.Context, userID int, tokenHash string, expiresAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.active(userID); err != nil {
+		return err
+	}
+	s.resetTokens[tokenHash] = &memoryResetToken{userID: userID, expiresAt: expiresAt}
+	return nil
+}
+
+// ResetPasswordWithToken использует токен сброса, меняет пароль и аннулирует остальные токены пользователя
+func (s *MemoryUserStore) ResetPasswordWithToken(ctx context.Context, tokenHash, passwordHash string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.resetTokens[tokenHash]
+	if !ok || token.used || !time.Now().Before(token.expiresAt) {
+		return 0, nil
+	}
+	u, ok := s.users[token.userID]
+	if !ok {
+		return 0, nil
+	}
+	u.PasswordHash = passwordHash
+	u.PasswordResetRequired = false
+	for _, t := range s.resetTokens {
+		if t.userID == token.userID {
+			t.used = true
+		}
+	}
+	return token.userID, nil
+}
+
+// GetTOTPState возвращает состояние TOTP пользователя
+func (s *MemoryUserStore) GetTOTPState(ctx context.Context, userID int) (*TOTPState, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u, err := s.active(userID)
+	if err != nil {
+		return nil, err
+	}
+	return &TOTPState{SecretEncrypted: u.totpSecretEncrypted, Enabled: u.TOTPEnabled, LastStep: u.totpLastStep}, nil
+}
+
+// SetPendingTOTPSecret сохраняет секрет до подтверждения первым кодом; false - TOTP уже подключен
+func (s *MemoryUserStore) SetPendingTOTPSecret(ctx context.Context, userID int, secretEncrypted string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[userID]
+	if !ok || u.TOTPEnabled {
+		return false, nil
+	}
+	u.totpSecretEncrypted = secretEncrypted
+	u.totpLastStep = 0
+	return true, nil
+}
+
+// EnableTOTP включает TOTP с начатым подключением и заменяет коды восстановления
+func (s *MemoryUserStore) EnableTOTP(ctx context.Context, userID int, step int64, recoveryCodeHashes []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[userID]
+	if !ok || u.TOTPEnabled || u.totpSecretEncrypted == "" {
+		return errors.New("failed to enable TOTP: enrollment is not pending")
+	}
+	u.TOTPEnabled = true
+	u.totpLastStep = step
+
+	s.deleteRecoveryCodes(userID)
+	for _, hash := range recoveryCodeHashes {
+		s.recoveryCodes[s.nextCodeID] = &memoryRecoveryCode{userID: userID, codeHash: hash}
+		s.nextCodeID++
+	}
+	return nil
+}
+
+// deleteRecoveryCodes удаляет коды восстановления пользователя. Вызывается под s.mu
+func (s *MemoryUserStore) deleteRecoveryCodes(userID int) {
+	for id, code := range s.recoveryCodes {
+		if code.userID == userID {
+			delete(s.recoveryCodes, id)
+		}
+	}
+}
+
+// AdvanceTOTPStep запоминает использованный временной шаг; false - этот или более поздний шаг уже использован
+func (s *MemoryUserStore) AdvanceTOTPStep(ctx context.Context, userID int, step int64) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[userID]
+	if !ok || u.totpLastStep >= step {
+		return false, nil
+	}
+	u.totpLastStep = step
+	return true, nil
+}
+
+// GetUnusedRecoveryCodes возвращает хеши неиспользованных кодов восстановления в порядке ID
+func (s *MemoryUserStore) GetUnusedRecoveryCodes(ctx context.Context, userID int) ([]RecoveryCode, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var codes []RecoveryCode
+	for id, code := range s.recoveryCodes {
+		if code.userID == userID && !code.used {
+			codes = append(codes, RecoveryCode{ID: id, CodeHash: code.codeHash})
+		}
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i].ID < codes[j].ID })
+	return codes, nil
+}
+
+// MarkRecoveryCodeUsed помечает код восстановления использованным; false - код уже использован
+func (s *MemoryUserStore) MarkRecoveryCodeUsed(ctx context.Context, id int) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	code, ok := s.recoveryCodes[id]
+	if !ok || code.used {
+		return false, nil
+	}
+	code.used = true
+	return true, nil
+}
@@ -0,0 +1,580 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testDatabaseURLEnv строка подключения к PostgreSQL для тестов с базой.
+// Тесты очищают таблицы, поэтому база должна быть выделена только под них
+const testDatabaseURLEnv = "TEST_DATABASE_URL"
+
+// requireTestDB подключает глобальный db к тестовой базе и применяет миграции.
+// Без TEST_DATABASE_URL тест пропускается
+func requireTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv(testDatabaseURLEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", testDatabaseURLEnv)
+	}
+	if db == nil {
+		conn, err := sql.Open("postgres", dsn)
+		if err != nil {
+			t.Fatalf("open test database: %v", err)
+		}
+		if err := conn.Ping(); err != nil {
+			t.Fatalf("connect to test database: %v", err)
+		}
+		db = conn
+	}
+	if _, err := MigrateUp(context.Background()); err != nil {
+		t.Fatalf("migrate test database: %v", err)
+	}
+	return db
+}
+
+// userStoreFactories реализации UserStore, на которых выполняются одни и те же проверки.
+// Каждый вызов возвращает пустое хранилище
+func userStoreFactories() map[string]func(t *testing.T) UserStore {
+	return map[string]func(t *testing.T) UserStore{
+		"memory": func(t *testing.T) UserStore {
+			return NewMemoryUserStore()
+		},
+		"postgres": func(t *testing.T) UserStore {
+			conn := requireTestDB(t)
+			if _, err := conn.Exec(`TRUNCATE users RESTART IDENTITY CASCADE`); err != nil {
+				t.Fatalf("truncate users: %v", err)
+			}
+			return NewPostgresUserStore(conn)
+		},
+	}
+}
+
+// mustCreateUser создает пользователя с именем и email по номеру n
+func mustCreateUser(t *testing.T, store UserStore, n int) *User {
+	t.Helper()
+	user, err := store.CreateUser(context.Background(), "user"+strconv.Itoa(n)+"@example.com", "user"+strconv.Itoa(n), "hash"+strconv.Itoa(n))
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	return user
+}
+
+// wantDuplicate проверяет, что err - *DuplicateError для field
+func wantDuplicate(t *testing.T, err error, field string) {
+	t.Helper()
+	var dup *DuplicateError
+	if !errors.As(err, &dup) || dup.Field != field {
+		t.Fatalf("got error %v, want DuplicateError for %q", err, field)
+	}
+	if !errors.Is(err, ErrDuplicate) {
+		t.Fatalf("errors.Is(%v, ErrDuplicate) = false", err)
+	}
+}
+
+// wantNotFound проверяет, что err - ErrNotFound
+func wantNotFound(t *testing.T, name string, err error) {
+	t.Helper()
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("%s: got error %v, want ErrNotFound", name, err)
+	}
+}
+
+func TestUserStoreConformance(t *testing.T) {
+	ctx := context.Background()
+
+	cases := []struct {
+		name string
+		run  func(t *testing.T, store UserStore)
+	}{
+		{"missing user is ErrNotFound", func(t *testing.T, store UserStore) {
+			const missing = 4242
+			_, err := store.GetUserByID(ctx, missing)
+			wantNotFound(t, "GetUserByID", err)
+			_, err = store.GetUserByEmail(ctx, "nobody@example.com")
+			wantNotFound(t, "GetUserByEmail", err)
+			_, err = store.GetPasswordHashByUserID(ctx, missing)
+			wantNotFound(t, "GetPasswordHashByUserID", err)
+			_, err = store.UpdateUserProfile(ctx, missing, "nobody@example.com", "nobody")
+			wantNotFound(t, "UpdateUserProfile", err)
+			wantNotFound(t, "UpdateUserPassword", store.UpdateUserPassword(ctx, missing, "hash"))
+			wantNotFound(t, "SetUserLocked", store.SetUserLocked(ctx, missing, true))
+			wantNotFound(t, "SetPasswordResetRequired", store.SetPasswordResetRequired(ctx, missing))
+			wantNotFound(t, "SoftDeleteUser", store.SoftDeleteUser(ctx, missing))
+			wantNotFound(t, "DeleteUser", store.DeleteUser(ctx, missing))
+			_, err = store.GetIdentityUserID(ctx, "idp", "subject")
+			wantNotFound(t, "GetIdentityUserID", err)
+			wantNotFound(t, "TouchIdentity", store.TouchIdentity(ctx, "idp", "subject", "nobody@example.com"))
+			wantNotFound(t, "LinkIdentity", store.LinkIdentity(ctx, missing, "idp", "subject", "nobody@example.com"))
+			_, err = store.GetTOTPState(ctx, missing)
+			wantNotFound(t, "GetTOTPState", err)
+
+			exists, err := store.UserExistsByEmail(ctx, "nobody@example.com")
+			if err != nil || exists {
+				t.Fatalf("UserExistsByEmail = %v, %v; want false, nil", exists, err)
+			}
+		}},
+
+		{"duplicate email", func(t *testing.T, store UserStore) {
+			user := mustCreateUser(t, store, 1)
+			_, err := store.CreateUser(ctx, user.Email, "other", "hash")
+			wantDuplicate(t, err, "email")
+
+			other := mustCreateUser(t, store, 2)
+			_, err = store.UpdateUserProfile(ctx, other.ID, user.Email, other.Username)
+			wantDuplicate(t, err, "email")
+
+			_, err = store.CreateUserWithIdentity(ctx, user.Email, "fromidp", "hash", true, "idp", "subject")
+			wantDuplicate(t, err, "email")
+		}},
+
+		{"duplicate username", func(t *testing.T, store UserStore) {
+			user := mustCreateUser(t, store, 1)
+			_, err := store.CreateUser(ctx, "other@example.com", user.Username, "hash")
+			wantDuplicate(t, err, "username")
+
+			other := mustCreateUser(t, store, 2)
+			_, err = store.UpdateUserProfile(ctx, other.ID, other.Email, user.Username)
+			wantDuplicate(t, err, "username")
+		}},
+
+		{"duplicate identity", func(t *testing.T, store UserStore) {
+			user, err := store.CreateUserWithIdentity(ctx, "idp@example.com", "fromidp", "hash", true, "idp", "subject")
+			if err != nil {
+				t.Fatalf("CreateUserWithIdentity: %v", err)
+			}
+			other := mustCreateUser(t, store, 1)
+			wantDuplicate(t, store.LinkIdentity(ctx, other.ID, "idp", "subject", other.Email), "identity")
+
+			linked, err := store.GetIdentityUserID(ctx, "idp", "subject")
+			if err != nil || linked != user.ID {
+				t.Fatalf("GetIdentityUserID = %d, %v; want %d", linked, err, user.ID)
+			}
+		}},
+
+		{"GetUserByID does not return the password hash", func(t *testing.T, store UserStore) {
+			created := mustCreateUser(t, store, 1)
+			if created.PasswordHash != "" {
+				t.Fatalf("CreateUser returned password hash %q", created.PasswordHash)
+			}
+
+			user, err := store.GetUserByID(ctx, created.ID)
+			if err != nil {
+				t.Fatalf("GetUserByID: %v", err)
+			}
+			if user.PasswordHash != "" {
+				t.Fatalf("GetUserByID returned password hash %q", user.PasswordHash)
+			}
+			if user.Email != created.Email || user.Username != created.Username {
+				t.Fatalf("GetUserByID = %+v, want %+v", user, created)
+			}
+
+			// Хеш доступен только там, где он нужен для проверки пароля
+			byEmail, err := store.GetUserByEmail(ctx, created.Email)
+			if err != nil || byEmail.PasswordHash != "hash1" {
+				t.Fatalf("GetUserByEmail hash = %q, %v; want hash1", byEmail.PasswordHash, err)
+			}
+			hash, err := store.GetPasswordHashByUserID(ctx, created.ID)
+			if err != nil || hash != "hash1" {
+				t.Fatalf("GetPasswordHashByUserID = %q, %v; want hash1", hash, err)
+			}
+
+			users, _, err := store.ListUsers(ctx, UserFilter{Limit: 10})
+			if err != nil || len(users) != 1 || users[0].PasswordHash != "" {
+				t.Fatalf("ListUsers = %+v, %v; want one user without hash", users, err)
+			}
+		}},
+
+		{"soft-deleted user is hidden and anonymized", func(t *testing.T, store UserStore) {
+			user, err := store.CreateUserWithIdentity(ctx, "gone@example.com", "gone", "hash", true, "idp", "subject")
+			if err != nil {
+				t.Fatalf("CreateUserWithIdentity: %v", err)
+			}
+			if err := store.SoftDeleteUser(ctx, user.ID); err != nil {
+				t.Fatalf("SoftDeleteUser: %v", err)
+			}
+
+			_, err = store.GetUserByID(ctx, user.ID)
+			wantNotFound(t, "GetUserByID", err)
+			_, err = store.GetUserByEmail(ctx, "gone@example.com")
+			wantNotFound(t, "GetUserByEmail", err)
+			_, err = store.GetPasswordHashByUserID(ctx, user.ID)
+			wantNotFound(t, "GetPasswordHashByUserID", err)
+			_, err = store.UpdateUserProfile(ctx, user.ID, "back@example.com", "back")
+			wantNotFound(t, "UpdateUserProfile", err)
+			wantNotFound(t, "SetUserLocked", store.SetUserLocked(ctx, user.ID, true))
+			wantNotFound(t, "SetPasswordResetRequired", store.SetPasswordResetRequired(ctx, user.ID))
+			wantNotFound(t, "SoftDeleteUser twice", store.SoftDeleteUser(ctx, user.ID))
+			_, err = store.GetIdentityUserID(ctx, "idp", "subject")
+			wantNotFound(t, "GetIdentityUserID", err)
+
+			users, total, err := store.ListUsers(ctx, UserFilter{Limit: 10})
+			if err != nil || len(users) != 0 || total != 0 {
+				t.Fatalf("ListUsers = %+v, %d, %v; want none", users, total, err)
+			}
+
+			// Email и имя освобождены для новой регистрации
+			exists, err := store.UserExistsByEmail(ctx, "gone@example.com")
+			if err != nil || exists {
+				t.Fatalf("UserExistsByEmail = %v, %v; want false, nil", exists, err)
+			}
+			again, err := store.CreateUser(ctx, "gone@example.com", "gone", "hash")
+			if err != nil {
+				t.Fatalf("CreateUser after soft delete: %v", err)
+			}
+			if again.ID == user.ID {
+				t.Fatalf("new user reused ID %d", user.ID)
+			}
+
+			// Полное удаление работает и для мягко удаленного
+			if err := store.DeleteUser(ctx, user.ID); err != nil {
+				t.Fatalf("DeleteUser: %v", err)
+			}
+			wantNotFound(t, "DeleteUser twice", store.DeleteUser(ctx, user.ID))
+		}},
+
+		{"lock and password reset flags", func(t *testing.T, store UserStore) {
+			user := mustCreateUser(t, store, 1)
+			if err := store.SetUserLocked(ctx, user.ID, true); err != nil {
+				t.Fatalf("SetUserLocked: %v", err)
+			}
+			// Заблокированный пользователь находится: обработчик входа сам решает, что ответить
+			locked, err := store.GetUserByEmail(ctx, user.Email)
+			if err != nil || locked.LockedAt == nil {
+				t.Fatalf("GetUserByEmail = %+v, %v; want locked user", locked, err)
+			}
+			if err := store.SetUserLocked(ctx, user.ID, true); err != nil {
+				t.Fatalf("SetUserLocked again: %v", err)
+			}
+			relocked, err := store.GetUserByID(ctx, user.ID)
+			if err != nil || relocked.LockedAt == nil || !relocked.LockedAt.Equal(*locked.LockedAt) {
+				t.Fatalf("second lock changed locked_at: %v -> %v (%v)", locked.LockedAt, relocked.LockedAt, err)
+			}
+			if err := store.SetUserLocked(ctx, user.ID, false); err != nil {
+				t.Fatalf("SetUserLocked(false): %v", err)
+			}
+			if unlocked, err := store.GetUserByID(ctx, user.ID); err != nil || unlocked.LockedAt != nil {
+				t.Fatalf("GetUserByID after unlock = %+v, %v", unlocked, err)
+			}
+
+			if err := store.SetPasswordResetRequired(ctx, user.ID); err != nil {
+				t.Fatalf("SetPasswordResetRequired: %v", err)
+			}
+			if u, err := store.GetUserByID(ctx, user.ID); err != nil || !u.PasswordResetRequired {
+				t.Fatalf("PasswordResetRequired not set: %+v, %v", u, err)
+			}
+			if err := store.UpdateUserPassword(ctx, user.ID, "newhash"); err != nil {
+				t.Fatalf("UpdateUserPassword: %v", err)
+			}
+			if u, err := store.GetUserByEmail(ctx, user.Email); err != nil || u.PasswordResetRequired || u.PasswordHash != "newhash" {
+				t.Fatalf("after UpdateUserPassword = %+v, %v", u, err)
+			}
+		}},
+
+		{"profile update resets verification only for a new email", func(t *testing.T, store UserStore) {
+			user, err := store.CreateUserWithIdentity(ctx, "verified@example.com", "verified", "hash", true, "idp", "subject")
+			if err != nil || user.EmailVerifiedAt == nil {
+				t.Fatalf("CreateUserWithIdentity = %+v, %v; want verified user", user, err)
+			}
+
+			renamed, err := store.UpdateUserProfile(ctx, user.ID, user.Email, "renamed")
+			if err != nil || renamed.Username != "renamed" || renamed.EmailVerifiedAt == nil {
+				t.Fatalf("rename = %+v, %v; want verified user renamed", renamed, err)
+			}
+			moved, err := store.UpdateUserProfile(ctx, user.ID, "moved@example.com", "renamed")
+			if err != nil || moved.Email != "moved@example.com" || moved.EmailVerifiedAt != nil {
+				t.Fatalf("email change = %+v, %v; want unverified new email", moved, err)
+			}
+			if err := store.TouchIdentity(ctx, "idp", "subject", "moved@example.com"); err != nil {
+				t.Fatalf("TouchIdentity: %v", err)
+			}
+		}},
+
+		{"email is verified only while it is the user's address", func(t *testing.T, store UserStore) {
+			user := mustCreateUser(t, store, 1)
+			if verified, err := store.MarkEmailVerified(ctx, user.ID, "old@example.com"); err != nil || verified {
+				t.Fatalf("MarkEmailVerified(other address) = %v, %v; want false", verified, err)
+			}
+			if verified, err := store.MarkEmailVerified(ctx, 4242, user.Email); err != nil || verified {
+				t.Fatalf("MarkEmailVerified(missing user) = %v, %v; want false", verified, err)
+			}
+			if u, err := store.GetUserByID(ctx, user.ID); err != nil || u.EmailVerifiedAt != nil {
+				t.Fatalf("user verified by another address: %+v, %v", u, err)
+			}
+
+			if verified, err := store.MarkEmailVerified(ctx, user.ID, user.Email); err != nil || !verified {
+				t.Fatalf("MarkEmailVerified = %v, %v; want true", verified, err)
+			}
+			first, err := store.GetUserByID(ctx, user.ID)
+			if err != nil || first.EmailVerifiedAt == nil {
+				t.Fatalf("email not verified: %+v, %v", first, err)
+			}
+			// Повторное подтверждение не меняет время первого
+			if verified, err := store.MarkEmailVerified(ctx, user.ID, user.Email); err != nil || !verified {
+				t.Fatalf("MarkEmailVerified again = %v, %v; want true", verified, err)
+			}
+			if again, err := store.GetUserByID(ctx, user.ID); err != nil || !again.EmailVerifiedAt.Equal(*first.EmailVerifiedAt) {
+				t.Fatalf("second verification changed email_verified_at: %v -> %v (%v)", first.EmailVerifiedAt, again.EmailVerifiedAt, err)
+			}
+		}},
+
+		{"password reset token is single use", func(t *testing.T, store UserStore) {
+			user := mustCreateUser(t, store, 1)
+			hour := time.Now().Add(time.Hour)
+			for name, expiresAt := range map[string]time.Time{"a": hour, "b": hour, "expired": time.Now().Add(-time.Minute)} {
+				if err := store.CreatePasswordResetToken(ctx, user.ID, hashToken(name), expiresAt); err != nil {
+					t.Fatalf("CreatePasswordResetToken(%s): %v", name, err)
+				}
+			}
+			for _, name := range []string{"unknown", "expired"} {
+				if id, err := store.ResetPasswordWithToken(ctx, hashToken(name), "newhash"); err != nil || id != 0 {
+					t.Fatalf("ResetPasswordWithToken(%s) = %d, %v; want 0", name, id, err)
+				}
+			}
+
+			if err := store.SetPasswordResetRequired(ctx, user.ID); err != nil {
+				t.Fatalf("SetPasswordResetRequired: %v", err)
+			}
+			if id, err := store.ResetPasswordWithToken(ctx, hashToken("a"), "newhash"); err != nil || id != user.ID {
+				t.Fatalf("ResetPasswordWithToken = %d, %v; want %d", id, err, user.ID)
+			}
+			if u, err := store.GetUserByEmail(ctx, user.Email); err != nil || u.PasswordHash != "newhash" || u.PasswordResetRequired {
+				t.Fatalf("after reset = %+v, %v", u, err)
+			}
+			// Использованный и остальные токены пользователя больше не действуют
+			for _, name := range []string{"a", "b"} {
+				if id, err := store.ResetPasswordWithToken(ctx, hashToken(name), "otherhash"); err != nil || id != 0 {
+					t.Fatalf("ResetPasswordWithToken(%s) after reset = %d, %v; want 0", name, id, err)
+				}
+			}
+
+			// Мягкое удаление аннулирует токены
+			if err := store.CreatePasswordResetToken(ctx, user.ID, hashToken("c"), hour); err != nil {
+				t.Fatalf("CreatePasswordResetToken: %v", err)
+			}
+			if err := store.SoftDeleteUser(ctx, user.ID); err != nil {
+				t.Fatalf("SoftDeleteUser: %v", err)
+			}
+			if id, err := store.ResetPasswordWithToken(ctx, hashToken("c"), "otherhash"); err != nil || id != 0 {
+				t.Fatalf("ResetPasswordWithToken after soft delete = %d, %v; want 0", id, err)
+			}
+		}},
+
+		{"TOTP enrollment, replay protection and recovery codes", func(t *testing.T, store UserStore) {
+			user := mustCreateUser(t, store, 1)
+			wantState := func(secret string, enabled bool, lastStep int64) {
+				t.Helper()
+				state, err := store.GetTOTPState(ctx, user.ID)
+				if err != nil || state.SecretEncrypted != secret || state.Enabled != enabled || state.LastStep != lastStep {
+					t.Fatalf("GetTOTPState = %+v, %v; want {%q %v %d}", state, err, secret, enabled, lastStep)
+				}
+			}
+			wantState("", false, 0)
+			if err := store.EnableTOTP(ctx, user.ID, 100, nil); err == nil {
+				t.Fatal("EnableTOTP without a pending secret succeeded")
+			}
+
+			if stored, err := store.SetPendingTOTPSecret(ctx, user.ID, "secret"); err != nil || !stored {
+				t.Fatalf("SetPendingTOTPSecret = %v, %v; want true", stored, err)
+			}
+			wantState("secret", false, 0)
+			if err := store.EnableTOTP(ctx, user.ID, 100, []string{"code1", "code2"}); err != nil {
+				t.Fatalf("EnableTOTP: %v", err)
+			}
+			wantState("secret", true, 100)
+			if u, err := store.GetUserByID(ctx, user.ID); err != nil || !u.TOTPEnabled {
+				t.Fatalf("GetUserByID after EnableTOTP = %+v, %v", u, err)
+			}
+			if err := store.EnableTOTP(ctx, user.ID, 101, nil); err == nil {
+				t.Fatal("EnableTOTP succeeded twice")
+			}
+			if stored, err := store.SetPendingTOTPSecret(ctx, user.ID, "other"); err != nil || stored {
+				t.Fatalf("SetPendingTOTPSecret with TOTP enabled = %v, %v; want false", stored, err)
+			}
+
+			// Шаг принимается только больше последнего использованного
+			for _, tc := range []struct {
+				step int64
+				want bool
+			}{{100, false}, {101, true}, {99, false}, {101, false}} {
+				if advanced, err := store.AdvanceTOTPStep(ctx, user.ID, tc.step); err != nil || advanced != tc.want {
+					t.Fatalf("AdvanceTOTPStep(%d) = %v, %v; want %v", tc.step, advanced, err, tc.want)
+				}
+			}
+			wantState("secret", true, 101)
+
+			codes, err := store.GetUnusedRecoveryCodes(ctx, user.ID)
+			if err != nil || len(codes) != 2 {
+				t.Fatalf("GetUnusedRecoveryCodes = %+v, %v; want 2 codes", codes, err)
+			}
+			if hashes := map[string]bool{codes[0].CodeHash: true, codes[1].CodeHash: true}; !hashes["code1"] || !hashes["code2"] {
+				t.Fatalf("recovery code hashes = %+v", codes)
+			}
+			if used, err := store.MarkRecoveryCodeUsed(ctx, codes[0].ID); err != nil || !used {
+				t.Fatalf("MarkRecoveryCodeUsed = %v, %v; want true", used, err)
+			}
+			if used, err := store.MarkRecoveryCodeUsed(ctx, codes[0].ID); err != nil || used {
+				t.Fatalf("MarkRecoveryCodeUsed twice = %v, %v; want false", used, err)
+			}
+			if left, err := store.GetUnusedRecoveryCodes(ctx, user.ID); err != nil || len(left) != 1 || left[0].ID != codes[1].ID {
+				t.Fatalf("GetUnusedRecoveryCodes after use = %+v, %v", left, err)
+			}
+
+			// Мягкое удаление стирает TOTP и коды восстановления
+			if err := store.SoftDeleteUser(ctx, user.ID); err != nil {
+				t.Fatalf("SoftDeleteUser: %v", err)
+			}
+			_, err = store.GetTOTPState(ctx, user.ID)
+			wantNotFound(t, "GetTOTPState after soft delete", err)
+			if left, err := store.GetUnusedRecoveryCodes(ctx, user.ID); err != nil || len(left) != 0 {
+				t.Fatalf("GetUnusedRecoveryCodes after soft delete = %+v, %v", left, err)
+			}
+		}},
+
+		{"ListUsers filters and pages", func(t *testing.T, store UserStore) {
+			for n := 1; n <= 5; n++ {
+				mustCreateUser(t, store, n)
+			}
+			if _, err := store.CreateUser(ctx, "admin@example.com", "Admin", "hash"); err != nil {
+				t.Fatalf("CreateUser: %v", err)
+			}
+
+			page, total, err := store.ListUsers(ctx, UserFilter{EmailPrefix: "USER", Limit: 2, Offset: 2})
+			if err != nil {
+				t.Fatalf("ListUsers: %v", err)
+			}
+			if total != 5 || len(page) != 2 || page[0].Username != "user3" || page[1].Username != "user4" {
+				t.Fatalf("ListUsers page = %+v, total %d", page, total)
+			}
+
+			page, total, err = store.ListUsers(ctx, UserFilter{UsernamePrefix: "adm", Limit: 10})
+			if err != nil || total != 1 || len(page) != 1 || page[0].Email != "admin@example.com" {
+				t.Fatalf("ListUsers by username = %+v, %d, %v", page, total, err)
+			}
+
+			// Префикс с символами шаблона LIKE ищется буквально
+			page, total, err = store.ListUsers(ctx, UserFilter{EmailPrefix: "%", Limit: 10})
+			if err != nil || total != 0 || len(page) != 0 {
+				t.Fatalf("ListUsers with %% = %+v, %d, %v", page, total, err)
+			}
+
+			page, total, err = store.ListUsers(ctx, UserFilter{Limit: 10, Offset: 100})
+			if err != nil || total != 6 || len(page) != 0 {
+				t.Fatalf("ListUsers past the end = %+v, %d, %v", page, total, err)
+			}
+		}},
+
+		{"cancelled context", func(t *testing.T, store UserStore) {
+			user := mustCreateUser(t, store, 1)
+			cancelled, cancel := context.WithCancel(ctx)
+			cancel()
+
+			calls := map[string]func() error{
+				"CreateUser": func() error {
+					_, err := store.CreateUser(cancelled, "new@example.com", "new", "hash")
+					return err
+				},
+				"GetUserByEmail": func() error {
+					_, err := store.GetUserByEmail(cancelled, user.Email)
+					return err
+				},
+				"GetUserByID": func() error {
+					_, err := store.GetUserByID(cancelled, user.ID)
+					return err
+				},
+				"UserExistsByEmail": func() error {
+					_, err := store.UserExistsByEmail(cancelled, user.Email)
+					return err
+				},
+				"GetPasswordHashByUserID": func() error {
+					_, err := store.GetPasswordHashByUserID(cancelled, user.ID)
+					return err
+				},
+				"UpdateUserProfile": func() error {
+					_, err := store.UpdateUserProfile(cancelled, user.ID, user.Email, "renamed")
+					return err
+				},
+				"UpdateUserPassword":       func() error { return store.UpdateUserPassword(cancelled, user.ID, "hash") },
+				"SetUserLocked":            func() error { return store.SetUserLocked(cancelled, user.ID, true) },
+				"SetPasswordResetRequired": func() error { return store.SetPasswordResetRequired(cancelled, user.ID) },
+				"ListUsers": func() error {
+					_, _, err := store.ListUsers(cancelled, UserFilter{Limit: 10})
+					return err
+				},
+				"SoftDeleteUser": func() error { return store.SoftDeleteUser(cancelled, user.ID) },
+				"DeleteUser":     func() error { return store.DeleteUser(cancelled, user.ID) },
+				"CreateUserWithIdentity": func() error {
+					_, err := store.CreateUserWithIdentity(cancelled, "idp@example.com", "fromidp", "hash", true, "idp", "subject")
+					return err
+				},
+				"GetIdentityUserID": func() error {
+					_, err := store.GetIdentityUserID(cancelled, "idp", "subject")
+					return err
+				},
+				"LinkIdentity":  func() error { return store.LinkIdentity(cancelled, user.ID, "idp", "subject", user.Email) },
+				"TouchIdentity": func() error { return store.TouchIdentity(cancelled, "idp", "subject", user.Email) },
+				"MarkEmailVerified": func() error {
+					_, err := store.MarkEmailVerified(cancelled, user.ID, user.Email)
+					return err
+				},
+				"CreatePasswordResetToken": func() error {
+					return store.CreatePasswordResetToken(cancelled, user.ID, hashToken("token"), time.Now().Add(time.Hour))
+				},
+				"ResetPasswordWithToken": func() error {
+					_, err := store.ResetPasswordWithToken(cancelled, hashToken("token"), "hash")
+					return err
+				},
+				"GetTOTPState": func() error {
+					_, err := store.GetTOTPState(cancelled, user.ID)
+					return err
+				},
+				"SetPendingTOTPSecret": func() error {
+					_, err := store.SetPendingTOTPSecret(cancelled, user.ID, "secret")
+					return err
+				},
+				"EnableTOTP": func() error { return store.EnableTOTP(cancelled, user.ID, 1, nil) },
+				"AdvanceTOTPStep": func() error {
+					_, err := store.AdvanceTOTPStep(cancelled, user.ID, 1)
+					return err
+				},
+				"GetUnusedRecoveryCodes": func() error {
+					_, err := store.GetUnusedRecoveryCodes(cancelled, user.ID)
+					return err
+				},
+				"MarkRecoveryCodeUsed": func() error {
+					_, err := store.MarkRecoveryCodeUsed(cancelled, 1)
+					return err
+				},
+			}
+			for name, call := range calls {
+				if err := call(); !errors.Is(err, context.Canceled) {
+					t.Errorf("%s: got error %v, want context.Canceled", name, err)
+				}
+			}
+
+			// Ни один вызов не изменил данные
+			got, err := store.GetUserByID(ctx, user.ID)
+			if err != nil || got.Username != user.Username || got.LockedAt != nil {
+				t.Fatalf("user changed by cancelled calls: %+v, %v", got, err)
+			}
+		}},
+	}
+
+	for storeName, newStore := range userStoreFactories() {
+		newStore := newStore
+		t.Run(storeName, func(t *testing.T) {
+			for _, tc := range cases {
+				tc := tc
+				t.Run(tc.name, func(t *testing.T) {
+					tc.run(t, newStore(t))
+				})
+			}
+		})
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	// webauthnChallengeTTL сколько действует challenge (и timeout церемонии в браузере)
+	webauthnChallengeTTL = 5 * time.Minute
+	// webauthnPruneInterval период удаления неиспользованных challenge
+	webauthnPruneInterval = 10 * time.Minute
+	// maxWebAuthnCredentialsPerUser ограничение числа ключей доступа пользователя
+	maxWebAuthnCredentialsPerUser = 20
+	// maxWebAuthnCredentialIDLength максимальная длина ID ключа (WebAuthn, 6.5.1)
+	maxWebAuthnCredentialIDLength = 1023
+	// maxWebAuthnNameLength ограничение длины названия ключа (колонка webauthn_credentials.name)
+	maxWebAuthnNameLength = 100
+)
+
+// Церемонии, для которых выдается challenge
+const (
+	webauthnCeremonyRegister = "register"
+	webauthnCeremonyLogin    = "login"
+)
+
+// Флаги authenticator data (WebAuthn, 6.1)
+const (
+	authDataUserPresent    = 0x01
+	authDataUserVerified   = 0x04
+	authDataBackupEligible = 0x08
+	authDataAttested       = 0x40
+	authDataExtensions     = 0x80
+)
+
+// COSE алгоритмы подписи (RFC 9053), которые принимает сервис
+const (
+	coseAlgES256 = -7
+	coseAlgEdDSA = -8
+	coseAlgRS256 = -257
+)
+
+// webauthnAlgorithms алгоритмы в порядке предпочтения (pubKeyCredParams)
+var webauthnAlgorithms = []int64{coseAlgES256, coseAlgEdDSA, coseAlgRS256}
+
+// oidFIDOGenCeAAGUID расширение сертификата аттестации с AAGUID аутентификатора
+var oidFIDOGenCeAAGUID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4}
+
+// WebAuthnConfig параметры relying party
+type WebAuthnConfig struct {
+	RPID        string   // домен, к которому привязаны ключи
+	RPName      string   // название сервиса, которое показывает браузер
+	Origins     []string // origin страниц, с которых разрешены церемонии
+	Attestation string   // запрашиваемая аттестация: none или direct
+}
+
+// webauthnConfig параметры WebAuthn (Config.WebAuthn)
+var webauthnConfig WebAuthnConfig
+
+// InitWebAuthn задает параметры relying party и запускает очистку неиспользованных challenge.
+// RP ID и origin по умолчанию берутся из APP_BASE_URL и проверены в Config.Validate
+func InitWebAuthn(cfg WebAuthnConfig) {
+	webauthnConfig = cfg
+
+	go func() {
+		ticker := time.NewTicker(webauthnPruneInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := DeleteExpiredWebAuthnChallenges(context.Background()); err != nil {
+				log.Printf("WebAuthn prune error: %v", err)
+			}
+		}
+	}()
+}
+
+// collectedClientData clientDataJSON, подписанный аутентификатором вместе с authenticator data
+type collectedClientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// parseClientData проверяет clientDataJSON церемонии ceremonyType ("webauthn.create"
+// или "webauthn.get"). Challenge проверяет вызывающий
+func parseClientData(raw []byte, ceremonyType string) (*collectedClientData, error) {
+	var clientData collectedClientData
+	if err := json.Unmarshal(raw, &clientData); err != nil {
+		return nil, fmt.Errorf("invalid clientDataJSON: %w", err)
+	}
+	if clientData.Type != ceremonyType {
+		return nil, fmt.Errorf("unexpected client data type %q", clientData.Type)
+	}
+	if !containsString(webauthnConfig.Origins, clientData.Origin) {
+		return nil, fmt.Errorf("origin %q is not allowed", clientData.Origin)
+	}
+	if clientData.CrossOrigin {
+		return nil, errors.New("cross-origin ceremonies are not allowed")
+	}
+	if clientData.Challenge == "" {
+		return nil, errors.New("challenge is missing")
+	}
+	return &clientData, nil
+}
+
+// authenticatorData разобранные данные аутентификатора (WebAuthn, 6.1)
+type authenticatorData struct {
+	RPIDHash  []byte
+	Flags     byte
+	SignCount uint32
+	// Заполнены только при регистрации (флаг AT)
+	AAGUID              []byte
+	CredentialID        []byte
+	CredentialPublicKey []byte
+}
+
+// parseAuthenticatorData разбирает authenticator data; лишние байты в конце - ошибка
+func parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, errors.New("authenticator data is too short")
+	}
+	ad := &authenticatorData{
+		RPIDHash:  data[:32],
+		Flags:     data[32],
+		SignCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	rest := data[37:]
+
+	if ad.Flags&authDataAttested != 0 {
+		if len(rest) < 18 {
+			return nil, errors.New("attested credential data is too short")
+		}
+		ad.AAGUID = rest[:16]
+		idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if idLength == 0 || idLength > maxWebAuthnCredentialIDLength || len(rest) < idLength {
+			return nil, errors.New("invalid credential ID length")
+		}
+		ad.CredentialID = rest[:idLength]
+		rest = rest[idLength:]
+
+		// Длину ключа COSE знает только разбор CBOR
+		_, n, err := cborDecode(rest)
+		if err != nil {
+			return nil, fmt.Errorf("invalid credential public key: %w", err)
+		}
+		ad.CredentialPublicKey = rest[:n]
+		rest = rest[n:]
+	}
+
+	if ad.Flags&authDataExtensions != 0 {
+		_, n, err := cborDecode(rest)
+		if err != nil {
+			return nil, fmt.Errorf("invalid extensions: %w", err)
+		}
+		rest = rest[n:]
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("unexpected trailing authenticator data")
+	}
+	return ad, nil
+}
+
+// verifyAuthenticatorData проверяет RP ID и флаги: пользователь присутствовал и подтвердил
+// личность (PIN, биометрия) - ключ доступа заменяет пароль, а не дополняет его
+func verifyAuthenticatorData(ad *authenticatorData) error {
+	rpIDHash := sha256.Sum256([]byte(webauthnConfig.RPID))
+	if !bytes.Equal(ad.RPIDHash, rpIDHash[:]) {
+		return errors.New("RP ID hash mismatch")
+	}
+	if ad.Flags&authDataUserPresent == 0 {
+		return errors.New("user presence flag is not set")
+	}
+	if ad.Flags&authDataUserVerified == 0 {
+		return errors.New("user verification flag is not set")
+	}
+	return nil
+}
+
+// parseCOSEKey разбирает открытый ключ в формате COSE_Key (RFC 9052, 7) и возвращает
+// его алгоритм. Принимаются ES256 (P-256), EdDSA (Ed25519) и RS256 (от 2048 бит)
+func parseCOSEKey(raw []byte) (crypto.PublicKey, int64, error) {
+	decoded, err := cborDecodeAll(raw)
+	if err != nil {
+		return nil, 0, err
+	}
+	m, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, 0, errors.New("COSE key is not a map")
+	}
+	kty, _ := m[int64(1)].(int64)
+	alg, _ := m[int64(3)].(int64)
+	bytesParam := func(label int64) []byte {
+		b, _ := m[label].([]byte)
+		return b
+	}
+
+	switch alg {
+	case coseAlgES256:
+		crv, _ := m[int64(-1)].(int64)
+		x, y := bytesParam(-2), bytesParam(-3)
+		if kty != 2 || crv != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, 0, errors.New("invalid ES256 COSE key")
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, 0, errors.New("ES256 COSE key point is not on the curve")
+		}
+		return key, alg, nil
+
+	case coseAlgEdDSA:
+		crv, _ := m[int64(-1)].(int64)
+		x := bytesParam(-2)
+		if kty != 1 || crv != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, 0, errors.New("invalid EdDSA COSE key")
+		}
+		return ed25519.PublicKey(x), alg, nil
+
+	case coseAlgRS256:
+		n, e := bytesParam(-1), bytesParam(-2)
+		if kty != 3 || len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return nil, 0, errors.New("invalid RS256 COSE key")
+		}
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if key.N.BitLen() < 2048 || key.E < 3 || key.E%2 == 0 {
+			return nil, 0, errors.New("RS256 COSE key is too weak")
+		}
+		return key, alg, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported COSE algorithm %d", alg)
+}
+
+// verifyCOSESignature проверяет подпись алгоритмом alg. Подписи ECDSA в WebAuthn в DER
+func verifyCOSESignature(publicKey crypto.PublicKey, alg int64, data, signature []byte) error {
+	digest := sha256.Sum256(data)
+	switch alg {
+	case coseAlgES256:
+		key, ok := publicKey.(*ecdsa.PublicKey)
+		if ok && key.Curve == elliptic.P256() && ecdsa.VerifyASN1(key, digest[:], signature) {
+			return nil
+		}
+	case coseAlgEdDSA:
+		key, ok := publicKey.(ed25519.PublicKey)
+		if ok && ed25519.Verify(key, data, signature) {
+			return nil
+		}
+	case coseAlgRS256:
+		key, ok := publicKey.(*rsa.PublicKey)
+		if ok && rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil {
+			return nil
+		}
+	default:
+		return fmt.Errorf("unsupported COSE algorithm %d", alg)
+	}
+	return errors.New("invalid signature")
+}
+
+// attestationObject объект аттестации из ответа navigator.credentials.create()
+type attestationObject struct {
+	Format    string
+	Statement map[interface{}]interface{}
+	AuthData  []byte
+}
+
+// parseAttestationObject разбирает CBOR карту {fmt, attStmt, authData}
+func parseAttestationObject(raw []byte) (*attestationObject, error) {
+	decoded, err := cborDecodeAll(raw)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("attestation object is not a map")
+	}
+	obj := &attestationObject{}
+	obj.Format, _ = m["fmt"].(string)
+	obj.Statement, _ = m["attStmt"].(map[interface{}]interface{})
+	obj.AuthData, _ = m["authData"].([]byte)
+	if obj.Format == "" || obj.Statement == nil || obj.AuthData == nil {
+		return nil, errors.New("attestation object is incomplete")
+	}
+	return obj, nil
+}
+
+// verifyAttestation проверяет аттестацию форматов none и packed (WebAuthn, 8.2 и 8.7).
+// Для packed с сертификатом проверяются подпись и требования к сертификату; цепочка до
+// корня производителя не проверяется (нет списка доверенных корней, например FIDO MDS),
+// поэтому аттестация подтверждает целостность ответа, а не модель аутентификатора
+func verifyAttestation(obj *attestationObject, clientDataHash []byte, ad *authenticatorData, credentialKey crypto.PublicKey, credentialAlg int64) error {
+	switch obj.Format {
+	case "none":
+		if len(obj.Statement) != 0 {
+			return errors.New("none attestation must have an empty statement")
+		}
+		return nil
+
+	case "packed":
+		alg, _ := obj.Statement["alg"].(int64)
+		signature, _ := obj.Statement["sig"].([]byte)
+		if len(signature) == 0 {
+			return errors.New("packed attestation signature is missing")
+		}
+		signed := append(append([]byte(nil), obj.AuthData...), clientDataHash...)
+
+		x5c, hasCertificates := obj.Statement["x5c"].([]interface{})
+		if !hasCertificates {
+			// Самоаттестация: подписано самим создаваемым ключом
+			if alg != credentialAlg {
+				return errors.New("self attestation algorithm does not match the credential")
+			}
+			return verifyCOSESignature(credentialKey, alg, signed, signature)
+		}
+
+		if len(x5c) == 0 {
+			return errors.New("packed attestation certificate chain is empty")
+		}
+		der, _ := x5c[0].([]byte)
+		certificate, err := x509.ParseCertificate(der)
+		if err != nil {
+			return fmt.Errorf("invalid attestation certificate: %w", err)
+		}
+		if err := verifyPackedCertificate(certificate, ad.AAGUID); err != nil {
+			return err
+		}
+		return verifyCOSESignature(certificate.PublicKey, alg, signed, signature)
+	}
+	return fmt.Errorf("unsupported attestation format %q", obj.Format)
+}
+
+// verifyPackedCertificate проверяет требования к сертификату packed аттестации (WebAuthn, 8.2.1)
+func verifyPackedCertificate(certificate *x509.Certificate, aaguid []byte) error {
+	if certificate.Version != 3 {
+		return errors.New("attestation certificate must be X.509 v3")
+	}
+	subject := certificate.Subject
+	if len(subject.Country) == 0 || len(subject.Organization) == 0 || subject.CommonName == "" ||
+		!containsString(subject.OrganizationalUnit, "Authenticator Attestation") {
+		return errors.New("attestation certificate subject does not meet the requirements")
+	}
+	if certificate.IsCA {
+		return errors.New("attestation certificate must not be a CA certificate")
+	}
+	for _, ext := range certificate.Extensions {
+		if !ext.Id.Equal(oidFIDOGenCeAAGUID) {
+			continue
+		}
+		var value []byte
+		if _, err := asn1.Unmarshal(ext.Value, &value); err != nil || ext.Critical || !bytes.Equal(value, aaguid) {
+			return errors.New("attestation certificate AAGUID does not match the authenticator")
+		}
+	}
+	return nil
+}
+
+// decodeBase64URL декодирует base64url с дополнением или без
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
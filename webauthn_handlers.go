@@ -0,0 +1,489 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// webauthnTransports значения transports, которые сохраняются для excludeCredentials
+var webauthnTransports = []string{"ble", "hybrid", "internal", "nfc", "smart-card", "usb"}
+
+// WebAuthnRegisterBeginHandler выдает параметры для navigator.credentials.create()
+// (POST /webauthn/register/begin). Ключ доступа добавляется к аккаунту, в который выполнен вход
+func (s *Server) WebAuthnRegisterBeginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 1. Пользователь и его ключи
+	user, ok := s.loadCurrentUser(w, r)
+	if !ok {
+		return
+	}
+	existing, err := ListWebAuthnCredentials(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	if len(existing) >= maxWebAuthnCredentialsPerUser {
+		sendErrorResponse(w, fmt.Sprintf("Passkey limit reached (%d). Delete unused passkeys first", maxWebAuthnCredentialsPerUser), http.StatusConflict)
+		return
+	}
+
+	// 2. user handle один для всех ключей пользователя: случайный, без email и ID,
+	//    чтобы аутентификатор не хранил персональные данные
+	var userHandle []byte
+	if len(existing) > 0 {
+		userHandle = existing[0].UserHandle
+	} else {
+		userHandle = make([]byte, 32)
+		if _, err := rand.Read(userHandle); err != nil {
+			log.Printf("Generate user handle error: %v", err)
+			sendInternalError(w, err)
+			return
+		}
+	}
+
+	// 3. Одноразовый challenge
+	challenge, ok := createWebAuthnChallenge(r.Context(), w, webauthnCeremonyRegister, user.ID, userHandle)
+	if !ok {
+		return
+	}
+
+	// 4. Параметры в формате PublicKeyCredentialCreationOptionsJSON
+	excludeCredentials := make([]map[string]interface{}, 0, len(existing))
+	for _, c := range existing {
+		excludeCredentials = append(excludeCredentials, map[string]interface{}{
+			"type":       "public-key",
+			"id":         b64(c.CredentialID),
+			"transports": c.Transports,
+		})
+	}
+	params := make([]map[string]interface{}, 0, len(webauthnAlgorithms))
+	for _, alg := range webauthnAlgorithms {
+		params = append(params, map[string]interface{}{"type": "public-key", "alg": alg})
+	}
+
+	sendJSONResponse(w, map[string]interface{}{
+		"publicKey": map[string]interface{}{
+			"challenge": challenge,
+			"rp":        map[string]string{"id": webauthnConfig.RPID, "name": webauthnConfig.RPName},
+			"user": map[string]string{
+				"id":          b64(userHandle),
+				"name":        user.Email,
+				"displayName": user.Username,
+			},
+			"pubKeyCredParams":   params,
+			"timeout":            webauthnChallengeTTL.Milliseconds(),
+			"excludeCredentials": excludeCredentials,
+			// Ключ должен храниться на аутентификаторе (вход без ввода email) и требовать PIN или биометрию
+			"authenticatorSelection": map[string]interface{}{
+				"residentKey":        "required",
+				"requireResidentKey": true,
+				"userVerification":   "required",
+			},
+			"attestation": webauthnConfig.Attestation,
+		},
+	}, http.StatusOK)
+}
+
+// WebAuthnRegisterFinishHandler проверяет ответ аутентификатора и сохраняет ключ доступа
+// (POST /webauthn/register/finish)
+func (s *Server) WebAuthnRegisterFinishHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := GetUserIDFromContext(r)
+	if !ok {
+		sendErrorResponse(w, "User ID not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	// 1. Парсим запрос
+	var req WebAuthnRegisterRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		sendErrorResponse(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = "Passkey"
+	}
+	if utf8.RuneCountInString(name) > maxWebAuthnNameLength {
+		sendErrorResponse(w, fmt.Sprintf("name must be at most %d characters long", maxWebAuthnNameLength), http.StatusBadRequest)
+		return
+	}
+	credential := &req.Credential
+
+	fail := func(reason string, err error) {
+		log.Printf("WebAuthn registration rejected (%s): %v", reason, err)
+		s.audit(r, auditWebAuthnRegister, userID, auditFailure, map[string]interface{}{"reason": reason})
+		sendErrorResponse(w, "Passkey registration failed", http.StatusBadRequest)
+	}
+
+	// 2. Данные клиента и одноразовый challenge этого пользователя
+	clientDataJSON, err := decodeBase64URL(credential.Response.ClientDataJSON)
+	if err != nil {
+		fail("invalid_client_data", err)
+		return
+	}
+	clientData, err := parseClientData(clientDataJSON, "webauthn.create")
+	if err != nil {
+		fail("invalid_client_data", err)
+		return
+	}
+	challenge, err := ConsumeWebAuthnChallenge(r.Context(), hashToken(clientData.Challenge), webauthnCeremonyRegister)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	if challenge == nil || challenge.UserID != userID {
+		fail("invalid_challenge", fmt.Errorf("unknown or expired challenge"))
+		return
+	}
+
+	// 3. Объект аттестации и данные аутентификатора
+	rawAttestation, err := decodeBase64URL(credential.Response.AttestationObject)
+	if err != nil {
+		fail("invalid_attestation", err)
+		return
+	}
+	attestation, err := parseAttestationObject(rawAttestation)
+	if err != nil {
+		fail("invalid_attestation", err)
+		return
+	}
+	authData, err := parseAuthenticatorData(attestation.AuthData)
+	if err == nil {
+		err = verifyAuthenticatorData(authData)
+	}
+	if err == nil && authData.CredentialID == nil {
+		err = fmt.Errorf("attested credential data is missing")
+	}
+	if err != nil {
+		fail("invalid_authenticator_data", err)
+		return
+	}
+	if rawID, err := decodeBase64URL(credential.RawID); err != nil || !bytes.Equal(rawID, authData.CredentialID) {
+		fail("credential_id_mismatch", fmt.Errorf("rawId does not match authenticator data"))
+		return
+	}
+
+	// 4. Открытый ключ и аттестация
+	publicKey, alg, err := parseCOSEKey(authData.CredentialPublicKey)
+	if err != nil {
+		fail("unsupported_public_key", err)
+		return
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	if err := verifyAttestation(attestation, clientDataHash[:], authData, publicKey, alg); err != nil {
+		fail("invalid_attestation", err)
+		return
+	}
+
+	// 5. Ключ не должен быть уже зарегистрирован (в том числе другим пользователем)
+	if existing, err := GetWebAuthnCredential(r.Context(), authData.CredentialID); err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return
+	} else if existing != nil {
+		sendErrorResponse(w, "Passkey is already registered", http.StatusConflict)
+		return
+	}
+
+	// 6. Сохраняем ключ
+	transports := []string{}
+	for _, t := range credential.Response.Transports {
+		if containsString(webauthnTransports, t) && !containsString(transports, t) {
+			transports = append(transports, t)
+		}
+	}
+	stored := &WebAuthnCredential{
+		UserID:            userID,
+		CredentialID:      authData.CredentialID,
+		UserHandle:        challenge.UserHandle,
+		PublicKey:         authData.CredentialPublicKey,
+		Algorithm:         alg,
+		SignCount:         authData.SignCount,
+		AAGUID:            authData.AAGUID,
+		Name:              name,
+		AttestationFormat: attestation.Format,
+		Transports:        transports,
+		BackupEligible:    authData.Flags&authDataBackupEligible != 0,
+	}
+	if err := CreateWebAuthnCredential(r.Context(), stored); err != nil {
+		log.Printf("Create WebAuthn credential error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+
+	s.audit(r, auditWebAuthnRegister, userID, auditSuccess, map[string]interface{}{
+		"credential_id":      stored.ID,
+		"attestation_format": stored.AttestationFormat,
+	})
+	sendJSONResponse(w, map[string]interface{}{
+		"message":    "Passkey registered",
+		"credential": stored,
+	}, http.StatusCreated)
+}
+
+// WebAuthnLoginBeginHandler выдает параметры для navigator.credentials.get()
+// (POST /webauthn/login/begin). allowCredentials пуст: браузер предлагает ключи,
+// сохраненные на аутентификаторе, и пользователь не вводит email
+func WebAuthnLoginBeginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	challenge, ok := createWebAuthnChallenge(r.Context(), w, webauthnCeremonyLogin, 0, nil)
+	if !ok {
+		return
+	}
+
+	sendJSONResponse(w, map[string]interface{}{
+		"publicKey": map[string]interface{}{
+			"challenge":        challenge,
+			"rpId":             webauthnConfig.RPID,
+			"timeout":          webauthnChallengeTTL.Milliseconds(),
+			"userVerification": "required",
+			"allowCredentials": []interface{}{},
+		},
+	}, http.StatusOK)
+}
+
+// WebAuthnLoginFinishHandler проверяет подпись аутентификатора и выдает токены
+// владельцу ключа (POST /webauthn/login/finish)
+func (s *Server) WebAuthnLoginFinishHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req WebAuthnLoginRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		sendErrorResponse(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	credential := &req.Credential
+
+	// Клиент получает одинаковый ответ, а в журнале видна настоящая причина
+	auditDetails := map[string]interface{}{}
+	fail := func(reason string, userID int, err error) {
+		log.Printf("WebAuthn login rejected (%s): %v", reason, err)
+		auditDetails["reason"] = reason
+		s.audit(r, auditLoginWebAuthn, userID, auditFailure, auditDetails)
+		sendErrorResponse(w, "Passkey authentication failed", http.StatusUnauthorized)
+	}
+
+	// 1. Данные клиента и одноразовый challenge
+	clientDataJSON, err := decodeBase64URL(credential.Response.ClientDataJSON)
+	if err != nil {
+		fail("invalid_client_data", 0, err)
+		return
+	}
+	clientData, err := parseClientData(clientDataJSON, "webauthn.get")
+	if err != nil {
+		fail("invalid_client_data", 0, err)
+		return
+	}
+	challenge, err := ConsumeWebAuthnChallenge(r.Context(), hashToken(clientData.Challenge), webauthnCeremonyLogin)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	if challenge == nil {
+		fail("invalid_challenge", 0, fmt.Errorf("unknown or expired challenge"))
+		return
+	}
+
+	// 2. Ключ и его владелец: user handle от аутентификатора должен совпасть с сохраненным
+	rawID, err := decodeBase64URL(credential.RawID)
+	if err != nil {
+		fail("unknown_credential", 0, err)
+		return
+	}
+	stored, err := GetWebAuthnCredential(r.Context(), rawID)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	if stored == nil {
+		fail("unknown_credential", 0, fmt.Errorf("credential is not registered"))
+		return
+	}
+	auditDetails["credential_id"] = stored.ID
+	if userHandle, err := decodeBase64URL(credential.Response.UserHandle); err != nil || !bytes.Equal(userHandle, stored.UserHandle) {
+		fail("user_handle_mismatch", stored.UserID, fmt.Errorf("user handle does not match the credential"))
+		return
+	}
+
+	// 3. Данные аутентификатора и подпись над authenticatorData || SHA-256(clientDataJSON)
+	rawAuthData, err := decodeBase64URL(credential.Response.AuthenticatorData)
+	if err != nil {
+		fail("invalid_authenticator_data", stored.UserID, err)
+		return
+	}
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err == nil {
+		err = verifyAuthenticatorData(authData)
+	}
+	if err != nil {
+		fail("invalid_authenticator_data", stored.UserID, err)
+		return
+	}
+
+	publicKey, alg, err := parseCOSEKey(stored.PublicKey)
+	if err != nil {
+		log.Printf("Stored WebAuthn key error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	signature, err := decodeBase64URL(credential.Response.Signature)
+	if err != nil {
+		fail("invalid_signature", stored.UserID, err)
+		return
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte(nil), rawAuthData...), clientDataHash[:]...)
+	if err := verifyCOSESignature(publicKey, alg, signed, signature); err != nil {
+		fail("invalid_signature", stored.UserID, err)
+		return
+	}
+
+	// 4. Счетчик подписей должен расти, иначе ключ мог быть скопирован
+	advanced, err := UpdateWebAuthnSignCount(r.Context(), stored.ID, authData.SignCount)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	if !advanced {
+		auditDetails["stored_sign_count"] = stored.SignCount
+		auditDetails["sign_count"] = authData.SignCount
+		fail("sign_count_not_increased", stored.UserID, fmt.Errorf("possible cloned authenticator"))
+		return
+	}
+
+	// 5. Владелец может входить
+	user, err := s.users.GetUserByID(r.Context(), stored.UserID)
+	if errors.Is(err, ErrNotFound) {
+		fail("user_not_found", 0, fmt.Errorf("credential owner not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	deny := func(reason, message string) {
+		auditDetails["reason"] = reason
+		s.audit(r, auditLoginWebAuthn, user.ID, auditFailure, auditDetails)
+		sendErrorResponse(w, message, http.StatusForbidden)
+	}
+	switch {
+	case user.LockedAt != nil:
+		deny("account_locked", "Account is locked")
+		return
+	case user.PasswordResetRequired:
+		deny("password_reset_required", "Password reset required")
+		return
+	case emailVerificationRequired() && user.EmailVerifiedAt == nil:
+		deny("email_not_verified", "Email address is not verified")
+		return
+	}
+
+	// 6. Ключ с проверкой пользователя - это уже два фактора (устройство и PIN/биометрия),
+	//    поэтому TOTP не запрашивается
+	s.audit(r, auditLoginWebAuthn, user.ID, auditSuccess, auditDetails)
+	s.sendTokenResponse(w, r, user, "Login successful", http.StatusOK)
+}
+
+// WebAuthnCredentialsHandler возвращает ключи доступа пользователя (GET /webauthn/credentials)
+func WebAuthnCredentialsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := GetUserIDFromContext(r)
+	if !ok {
+		sendErrorResponse(w, "User ID not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	list, err := ListWebAuthnCredentials(r.Context(), userID)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	sendJSONResponse(w, map[string]interface{}{"credentials": list}, http.StatusOK)
+}
+
+// DeleteWebAuthnCredentialHandler удаляет ключ доступа пользователя (DELETE /webauthn/credentials/{id})
+func (s *Server) DeleteWebAuthnCredentialHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	credentialID, err := parsePositiveInt(strings.Trim(strings.TrimPrefix(r.URL.Path, "/webauthn/credentials/"), "/"))
+	if err != nil {
+		sendErrorResponse(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	userID, ok := GetUserIDFromContext(r)
+	if !ok {
+		sendErrorResponse(w, "User ID not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	deleted, err := DeleteWebAuthnCredential(r.Context(), userID, credentialID)
+	if err != nil {
+		log.Printf("Delete WebAuthn credential error: %v", err)
+		sendInternalError(w, err)
+		return
+	}
+	if !deleted {
+		sendErrorResponse(w, "Passkey not found", http.StatusNotFound)
+		return
+	}
+
+	s.audit(r, auditWebAuthnDelete, userID, auditSuccess, map[string]interface{}{"credential_id": credentialID})
+	sendJSONResponse(w, map[string]string{"message": "Passkey deleted"}, http.StatusOK)
+}
+
+// createWebAuthnChallenge создает и сохраняет challenge церемонии.
+// При ошибке отправляет ответ 500 и возвращает false
+func createWebAuthnChallenge(ctx context.Context, w http.ResponseWriter, ceremony string, userID int, userHandle []byte) (string, bool) {
+	challenge, err := randomToken(32)
+	if err != nil {
+		log.Printf("Generate token error: %v", err)
+		sendInternalError(w, err)
+		return "", false
+	}
+	if err := CreateWebAuthnChallenge(ctx, hashToken(challenge), ceremony, userID, userHandle, time.Now().Add(webauthnChallengeTTL)); err != nil {
+		log.Printf("Database error: %v", err)
+		sendInternalError(w, err)
+		return "", false
+	}
+	return challenge, true
+}
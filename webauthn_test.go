@@ -0,0 +1,505 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testRPID   = "app.example.com"
+	testOrigin = "https://app.example.com"
+)
+
+// useTestWebAuthnConfig настраивает relying party на время теста
+func useTestWebAuthnConfig(t *testing.T) {
+	t.Helper()
+	previous := webauthnConfig
+	webauthnConfig = WebAuthnConfig{RPID: testRPID, RPName: "Test", Origins: []string{testOrigin}, Attestation: "none"}
+	t.Cleanup(func() { webauthnConfig = previous })
+}
+
+// cborPair элемент карты CBOR; порядок ключей задает тест
+type cborPair struct {
+	key, value interface{}
+}
+
+// cborMap карта CBOR с сохранением порядка ключей
+type cborMap []cborPair
+
+// cborEncode кодирует подмножество CBOR, которое разбирает cborDecode
+func cborEncode(v interface{}) []byte {
+	head := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n <= 0xff:
+			return []byte{major<<5 | 24, byte(n)}
+		case n <= 0xffff:
+			return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+		default:
+			return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+		}
+	}
+
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return head(1, uint64(-1-v))
+		}
+		return head(0, uint64(v))
+	case []byte:
+		return append(head(2, uint64(len(v))), v...)
+	case string:
+		return append(head(3, uint64(len(v))), v...)
+	case []interface{}:
+		out := head(4, uint64(len(v)))
+		for _, item := range v {
+			out = append(out, cborEncode(item)...)
+		}
+		return out
+	case cborMap:
+		out := head(5, uint64(len(v)))
+		for _, pair := range v {
+			out = append(out, cborEncode(pair.key)...)
+			out = append(out, cborEncode(pair.value)...)
+		}
+		return out
+	}
+	panic("cborEncode: unsupported type")
+}
+
+// softAuthenticator программный аутентификатор с ключом ES256
+type softAuthenticator struct {
+	t            *testing.T
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	aaguid       []byte
+	rpID         string
+	signCount    uint32
+	userHandle   []byte
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	credentialID := make([]byte, 16)
+	if _, err := rand.Read(credentialID); err != nil {
+		t.Fatalf("rand: %v", err)
+	}
+	return &softAuthenticator{
+		t:            t,
+		key:          key,
+		credentialID: credentialID,
+		aaguid:       bytes.Repeat([]byte{0xaa}, 16),
+		rpID:         testRPID,
+	}
+}
+
+// coseKey открытый ключ в формате COSE_Key
+func (a *softAuthenticator) coseKey() []byte {
+	return cborEncode(cborMap{
+		{1, 2},            // kty: EC2
+		{3, coseAlgES256}, // alg
+		{-1, 1},           // crv: P-256
+		{-2, a.key.X.FillBytes(make([]byte, 32))},
+		{-3, a.key.Y.FillBytes(make([]byte, 32))},
+	})
+}
+
+// authData собирает authenticator data; attested добавляет данные нового ключа
+func (a *softAuthenticator) authData(attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	flags := byte(authDataUserPresent | authDataUserVerified)
+	if attested {
+		flags |= authDataAttested
+	}
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	if attested {
+		data = append(data, a.aaguid...)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.credentialID)))
+		data = append(data, a.credentialID...)
+		data = append(data, a.coseKey()...)
+	}
+	return data
+}
+
+func (a *softAuthenticator) sign(key *ecdsa.PrivateKey, data []byte) []byte {
+	digest := sha256.Sum256(data)
+	signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		a.t.Fatalf("sign: %v", err)
+	}
+	return signature
+}
+
+// clientDataJSON данные клиента, которые формирует браузер
+func clientDataJSON(ceremonyType, challenge, origin string) []byte {
+	data, _ := json.Marshal(collectedClientData{Type: ceremonyType, Challenge: challenge, Origin: origin})
+	return data
+}
+
+// attestationCertificate сертификат packed аттестации, отвечающий требованиям WebAuthn 8.2.1
+func (a *softAuthenticator) attestationCertificate() (*ecdsa.PrivateKey, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		a.t.Fatalf("generate key: %v", err)
+	}
+	aaguid, err := asn1.Marshal(a.aaguid)
+	if err != nil {
+		a.t.Fatalf("marshal AAGUID: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			Country:            []string{"US"},
+			Organization:       []string{"Test Vendor"},
+			OrganizationalUnit: []string{"Authenticator Attestation"},
+			CommonName:         "Test Authenticator",
+		},
+		NotBefore:       time.Now().Add(-time.Hour),
+		NotAfter:        time.Now().Add(time.Hour),
+		ExtraExtensions: []pkix.Extension{{Id: oidFIDOGenCeAAGUID, Value: aaguid}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		a.t.Fatalf("create certificate: %v", err)
+	}
+	return key, der
+}
+
+// register отвечает на navigator.credentials.create(): format - none, packed (самоаттестация)
+// или packed-x5c (аттестация сертификатом)
+func (a *softAuthenticator) register(challenge, origin, format string) PublicKeyCredential {
+	clientData := clientDataJSON("webauthn.create", challenge, origin)
+	clientDataHash := sha256.Sum256(clientData)
+	authData := a.authData(true)
+	signed := append(append([]byte(nil), authData...), clientDataHash[:]...)
+
+	statement := cborMap{}
+	switch format {
+	case "packed":
+		statement = cborMap{{"alg", coseAlgES256}, {"sig", a.sign(a.key, signed)}}
+	case "packed-x5c":
+		key, der := a.attestationCertificate()
+		statement = cborMap{{"alg", coseAlgES256}, {"sig", a.sign(key, signed)}, {"x5c", []interface{}{der}}}
+		format = "packed"
+	}
+	attestation := cborEncode(cborMap{{"fmt", format}, {"attStmt", statement}, {"authData", authData}})
+
+	var credential PublicKeyCredential
+	credential.ID = b64(a.credentialID)
+	credential.RawID = b64(a.credentialID)
+	credential.Type = "public-key"
+	credential.Response.ClientDataJSON = b64(clientData)
+	credential.Response.AttestationObject = b64(attestation)
+	credential.Response.Transports = []string{"internal"}
+	return credential
+}
+
+// login отвечает на navigator.credentials.get(), увеличивая счетчик подписей
+func (a *softAuthenticator) login(challenge, origin string) PublicKeyCredential {
+	a.signCount++
+	clientData := clientDataJSON("webauthn.get", challenge, origin)
+	clientDataHash := sha256.Sum256(clientData)
+	authData := a.authData(false)
+
+	var credential PublicKeyCredential
+	credential.ID = b64(a.credentialID)
+	credential.RawID = b64(a.credentialID)
+	credential.Type = "public-key"
+	credential.Response.ClientDataJSON = b64(clientData)
+	credential.Response.AuthenticatorData = b64(authData)
+	credential.Response.Signature = b64(a.sign(a.key, append(append([]byte(nil), authData...), clientDataHash[:]...)))
+	credential.Response.UserHandle = b64(a.userHandle)
+	return credential
+}
+
+// verifyRegistrationResponse повторяет проверки WebAuthnRegisterFinishHandler после challenge
+func verifyRegistrationResponse(credential PublicKeyCredential) error {
+	clientData, err := decodeBase64URL(credential.Response.ClientDataJSON)
+	if err != nil {
+		return err
+	}
+	if _, err := parseClientData(clientData, "webauthn.create"); err != nil {
+		return err
+	}
+	rawAttestation, err := decodeBase64URL(credential.Response.AttestationObject)
+	if err != nil {
+		return err
+	}
+	attestation, err := parseAttestationObject(rawAttestation)
+	if err != nil {
+		return err
+	}
+	authData, err := parseAuthenticatorData(attestation.AuthData)
+	if err != nil {
+		return err
+	}
+	if err := verifyAuthenticatorData(authData); err != nil {
+		return err
+	}
+	publicKey, alg, err := parseCOSEKey(authData.CredentialPublicKey)
+	if err != nil {
+		return err
+	}
+	clientDataHash := sha256.Sum256(clientData)
+	return verifyAttestation(attestation, clientDataHash[:], authData, publicKey, alg)
+}
+
+func TestWebAuthnRegistrationVerification(t *testing.T) {
+	useTestWebAuthnConfig(t)
+
+	cases := []struct {
+		name    string
+		format  string
+		origin  string
+		rpID    string
+		wantErr string
+	}{
+		{name: "none attestation", format: "none", origin: testOrigin, rpID: testRPID},
+		{name: "packed self attestation", format: "packed", origin: testOrigin, rpID: testRPID},
+		{name: "packed certificate attestation", format: "packed-x5c", origin: testOrigin, rpID: testRPID},
+		{name: "wrong origin", format: "none", origin: "https://evil.example.com", rpID: testRPID, wantErr: "origin"},
+		{name: "rpIdHash mismatch", format: "none", origin: testOrigin, rpID: "evil.example.com", wantErr: "RP ID hash mismatch"},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			authenticator := newSoftAuthenticator(t)
+			authenticator.rpID = tc.rpID
+			err := verifyRegistrationResponse(authenticator.register("challenge", tc.origin, tc.format))
+			if tc.wantErr == "" && err != nil {
+				t.Fatalf("registration rejected: %v", err)
+			}
+			if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+				t.Fatalf("got error %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestWebAuthnPackedAttestationOverOtherData(t *testing.T) {
+	useTestWebAuthnConfig(t)
+	authenticator := newSoftAuthenticator(t)
+
+	// Подпись аттестации не покрывает подмененные данные клиента
+	credential := authenticator.register("challenge", testOrigin, "packed")
+	credential.Response.ClientDataJSON = b64(clientDataJSON("webauthn.create", "other-challenge", testOrigin))
+	if err := verifyRegistrationResponse(credential); err == nil {
+		t.Fatal("packed attestation accepted for different client data")
+	}
+}
+
+func TestWebAuthnAssertionSignature(t *testing.T) {
+	useTestWebAuthnConfig(t)
+	authenticator := newSoftAuthenticator(t)
+	publicKey, alg, err := parseCOSEKey(authenticator.coseKey())
+	if err != nil {
+		t.Fatalf("parseCOSEKey: %v", err)
+	}
+
+	credential := authenticator.login("challenge", testOrigin)
+	clientData, _ := decodeBase64URL(credential.Response.ClientDataJSON)
+	authData, _ := decodeBase64URL(credential.Response.AuthenticatorData)
+	signature, _ := decodeBase64URL(credential.Response.Signature)
+	clientDataHash := sha256.Sum256(clientData)
+	signed := append(append([]byte(nil), authData...), clientDataHash[:]...)
+
+	if err := verifyCOSESignature(publicKey, alg, signed, signature); err != nil {
+		t.Fatalf("valid assertion rejected: %v", err)
+	}
+	parsed, err := parseAuthenticatorData(authData)
+	if err != nil || parsed.SignCount != 1 {
+		t.Fatalf("authenticator data = %+v, %v", parsed, err)
+	}
+
+	// Подпись над другими данными клиента (другой challenge) не принимается
+	otherHash := sha256.Sum256(clientDataJSON("webauthn.get", "other-challenge", testOrigin))
+	other := append(append([]byte(nil), authData...), otherHash[:]...)
+	if err := verifyCOSESignature(publicKey, alg, other, signature); err == nil {
+		t.Fatal("signature accepted for different client data")
+	}
+}
+
+// webauthnRequest вызывает обработчик WebAuthn с JSON телом от имени userID (0 - без входа)
+func webauthnRequest(t *testing.T, handler http.HandlerFunc, userID int, body interface{}) (int, map[string]interface{}) {
+	t.Helper()
+	payload, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/webauthn", bytes.NewReader(payload))
+	if userID != 0 {
+		req = req.WithContext(context.WithValue(req.Context(), "userID", userID))
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+
+	var response map[string]interface{}
+	_ = json.Unmarshal(rec.Body.Bytes(), &response)
+	return rec.Code, response
+}
+
+func TestWebAuthnLoginRejectsWrongOrigin(t *testing.T) {
+	useTestWebAuthnConfig(t)
+	ts := newTestServer(t)
+	authenticator := newSoftAuthenticator(t)
+
+	// Origin проверяется до обращения к challenge, поэтому БД не нужна
+	code, _ := webauthnRequest(t, ts.WebAuthnLoginFinishHandler, 0, WebAuthnLoginRequest{Credential: authenticator.login("challenge", "https://evil.example.com")})
+	if code != http.StatusUnauthorized {
+		t.Fatalf("login finish = %d, want 401", code)
+	}
+	if e, _ := ts.auditor.last(auditLoginWebAuthn); e.Details["reason"] != "invalid_client_data" {
+		t.Fatalf("audit event = %+v", e)
+	}
+}
+
+// TestWebAuthnCeremonies регистрирует ключи и входит с ними через обработчики.
+// Challenge и ключи хранятся в PostgreSQL, поэтому нужна тестовая БД
+func TestWebAuthnCeremonies(t *testing.T) {
+	conn := requireTestDB(t)
+	useTestWebAuthnConfig(t)
+	ctx := context.Background()
+
+	if _, err := conn.Exec(`TRUNCATE users RESTART IDENTITY CASCADE`); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+	ts := newTestServer(t)
+	server := NewServer(NewPostgresUserStore(conn), ts.sessions, NewRevocationStore(), staticRoles{"user"}, ts.auditor, ts.throttle)
+	user, err := server.users.CreateUser(ctx, "alice@example.com", "alice", "hash")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	// begin возвращает challenge церемонии
+	begin := func(handler http.HandlerFunc, userID int) (string, map[string]interface{}) {
+		code, response := webauthnRequest(t, handler, userID, map[string]string{})
+		if code != http.StatusOK {
+			t.Fatalf("begin = %d %v", code, response)
+		}
+		options := response["publicKey"].(map[string]interface{})
+		return options["challenge"].(string), options
+	}
+	register := func(authenticator *softAuthenticator, format string) int {
+		challenge, options := begin(server.WebAuthnRegisterBeginHandler, user.ID)
+		handle, _ := decodeBase64URL(options["user"].(map[string]interface{})["id"].(string))
+		authenticator.userHandle = handle
+		code, response := webauthnRequest(t, server.WebAuthnRegisterFinishHandler, user.ID, WebAuthnRegisterRequest{
+			Name:       format,
+			Credential: authenticator.register(challenge, testOrigin, format),
+		})
+		if code != http.StatusCreated {
+			t.Logf("register finish response: %v", response)
+		}
+		return code
+	}
+	login := func(authenticator *softAuthenticator, challenge string) int {
+		code, _ := webauthnRequest(t, server.WebAuthnLoginFinishHandler, 0, WebAuthnLoginRequest{Credential: authenticator.login(challenge, testOrigin)})
+		return code
+	}
+
+	for _, format := range []string{"none", "packed", "packed-x5c"} {
+		format := format
+		t.Run(format+" registration and login", func(t *testing.T) {
+			authenticator := newSoftAuthenticator(t)
+			if code := register(authenticator, format); code != http.StatusCreated {
+				t.Fatalf("register = %d, want 201", code)
+			}
+			challenge, _ := begin(WebAuthnLoginBeginHandler, 0)
+			if code := login(authenticator, challenge); code != http.StatusOK {
+				t.Fatalf("login = %d, want 200", code)
+			}
+		})
+	}
+
+	t.Run("challenge reuse", func(t *testing.T) {
+		authenticator := newSoftAuthenticator(t)
+		if code := register(authenticator, "none"); code != http.StatusCreated {
+			t.Fatalf("register = %d, want 201", code)
+		}
+		challenge, _ := begin(WebAuthnLoginBeginHandler, 0)
+		if code := login(authenticator, challenge); code != http.StatusOK {
+			t.Fatalf("login = %d, want 200", code)
+		}
+		if code := login(authenticator, challenge); code != http.StatusUnauthorized {
+			t.Fatalf("login with a used challenge = %d, want 401", code)
+		}
+		if e, _ := ts.auditor.last(auditLoginWebAuthn); e.Details["reason"] != "invalid_challenge" {
+			t.Fatalf("audit event = %+v", e)
+		}
+	})
+
+	t.Run("sign counter regression", func(t *testing.T) {
+		authenticator := newSoftAuthenticator(t)
+		if code := register(authenticator, "none"); code != http.StatusCreated {
+			t.Fatalf("register = %d, want 201", code)
+		}
+		authenticator.signCount = 10
+		challenge, _ := begin(WebAuthnLoginBeginHandler, 0)
+		if code := login(authenticator, challenge); code != http.StatusOK {
+			t.Fatalf("login = %d, want 200", code)
+		}
+		// Копия ключа со старым счетчиком
+		authenticator.signCount = 5
+		challenge, _ = begin(WebAuthnLoginBeginHandler, 0)
+		if code := login(authenticator, challenge); code != http.StatusUnauthorized {
+			t.Fatalf("login with a lower sign count = %d, want 401", code)
+		}
+		if e, _ := ts.auditor.last(auditLoginWebAuthn); e.Details["reason"] != "sign_count_not_increased" {
+			t.Fatalf("audit event = %+v", e)
+		}
+	})
+
+	t.Run("rpIdHash mismatch", func(t *testing.T) {
+		authenticator := newSoftAuthenticator(t)
+		if code := register(authenticator, "none"); code != http.StatusCreated {
+			t.Fatalf("register = %d, want 201", code)
+		}
+		authenticator.rpID = "evil.example.com"
+		challenge, _ := begin(WebAuthnLoginBeginHandler, 0)
+		if code := login(authenticator, challenge); code != http.StatusUnauthorized {
+			t.Fatalf("login for another RP ID = %d, want 401", code)
+		}
+		if code := register(newSoftAuthenticatorFor(t, "evil.example.com"), "none"); code != http.StatusBadRequest {
+			t.Fatalf("registration for another RP ID = %d, want 400", code)
+		}
+	})
+
+	t.Run("wrong origin", func(t *testing.T) {
+		authenticator := newSoftAuthenticator(t)
+		challenge, _ := begin(server.WebAuthnRegisterBeginHandler, user.ID)
+		code, _ := webauthnRequest(t, server.WebAuthnRegisterFinishHandler, user.ID, WebAuthnRegisterRequest{
+			Credential: authenticator.register(challenge, "https://evil.example.com", "none"),
+		})
+		if code != http.StatusBadRequest {
+			t.Fatalf("registration from another origin = %d, want 400", code)
+		}
+	})
+}
+
+// newSoftAuthenticatorFor аутентификатор, который считает RP ID равным rpID
+func newSoftAuthenticatorFor(t *testing.T, rpID string) *softAuthenticator {
+	authenticator := newSoftAuthenticator(t)
+	authenticator.rpID = rpID
+	return authenticator
+}